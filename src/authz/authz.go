@@ -0,0 +1,103 @@
+package authz
+
+/***
+Permission checks per route. The permissions of a user come from its roles (see
+databases/banking/roles.go); they are cached for a short time, so a change of roles takes effect
+within permissionsTtl even if the cache of the user is not invalidated.
+***/
+
+import (
+  bank "finance/databases/banking"
+  "context"
+  "fmt"
+  "net/http"
+  "sync"
+  "time"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+  "github.com/juan-carlos-trimino/gpsessions"
+)
+
+const permissionsTtl = time.Minute
+
+type loaderFunc func(ctx context.Context, userName, correlationId string) ([]string, error)
+
+type cacheEntry struct {
+  perms map[string]bool
+  expiry time.Time
+}
+
+type permissionCache struct {
+  sync.Mutex
+  entries map[string]*cacheEntry  //key: username
+  load loaderFunc
+  now func() time.Time
+}
+
+func newPermissionCache(load loaderFunc) *permissionCache {
+  return &permissionCache{ entries: map[string]*cacheEntry{}, load: load, now: time.Now }
+}
+
+var cache = newPermissionCache(bank.DbGetUserPermissions)
+
+//A failure to load the permissions denies the access; it is not cached.
+func (c *permissionCache) has(ctx context.Context, userName, permission, correlationId string) bool {
+  c.Lock()
+  e, ok := c.entries[userName]
+  c.Unlock()
+  if !ok || c.now().After(e.expiry) {
+    perms, err := c.load(ctx, userName, correlationId)
+    if err != nil {
+      return false
+    }
+    e = &cacheEntry{ perms: make(map[string]bool, len(perms)), expiry: c.now().Add(permissionsTtl) }
+    for _, p := range perms {
+      e.perms[p] = true
+    }
+    c.Lock()
+    c.entries[userName] = e
+    c.Unlock()
+  }
+  return e.perms[permission]
+}
+
+func (c *permissionCache) invalidate(userName string) {
+  c.Lock()
+  delete(c.entries, userName)
+  c.Unlock()
+}
+
+func HasPermission(ctx context.Context, userName, permission, correlationId string) bool {
+  return userName != "" && cache.has(ctx, userName, permission, correlationId)
+}
+
+//Call it after the roles of the user changed.
+func InvalidatePermissions(userName string) {
+  cache.invalidate(userName)
+}
+
+/***
+Let the request through only if the user of the session has the permission. A request without a
+session is passed on; the handler sends it to the login page.
+***/
+func RequirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    ctxKey := middlewares.MwContextKey{}
+    sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+    if sessionToken == "" {
+      next(res, req)
+      return
+    }
+    correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+    userName := sessions.GetUserName(sessionToken)
+    if !HasPermission(req.Context(), userName, permission, correlationId) {
+      logger.LogWarning(fmt.Sprintf("User %s lacks the permission %s for %s.", userName, permission, req.URL.Path),
+        correlationId)
+      bank.DbWriteAuditEvent(AuditContext(req, userName), bank.AuditAccessDenied, req.URL.Path, bank.AuditDenied,
+        "missing permission " + permission, correlationId)
+      http.Error(res, "Forbidden.", http.StatusForbidden)
+      return
+    }
+    next(res, req)
+  }
+}
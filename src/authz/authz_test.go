@@ -0,0 +1,81 @@
+// Testing the functions in authz.go.
+package authz
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="PermissionCache"
+***/
+
+import (
+  "context"
+  "errors"
+  "testing"
+  "time"
+)
+
+func TestPermissionCache(t *testing.T) {
+  t.Parallel()
+  loads := 0
+  perms := map[string][]string{
+    "teller": { "banking.use", "admin.access", "admin.users" },
+    "jane": { "banking.use" },
+  }
+  c := newPermissionCache(func(ctx context.Context, userName, correlationId string) ([]string, error) {
+    loads++
+    if userName == "broken" {
+      return nil, errors.New("database down")
+    }
+    return perms[userName], nil
+  })
+  now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+  c.now = func() time.Time { return now }
+  ctx := context.Background()
+  type test struct {
+    user string
+    permission string
+    want bool
+  }
+  var tests = []test {
+    { user: "teller", permission: "admin.users", want: true },
+    { user: "teller", permission: "admin.settings", want: false },
+    { user: "jane", permission: "banking.use", want: true },
+    { user: "jane", permission: "admin.access", want: false },
+    { user: "unknown", permission: "banking.use", want: false },
+    { user: "broken", permission: "banking.use", want: false },
+  }
+  for _, tc := range tests {
+    if got := c.has(ctx, tc.user, tc.permission, ""); got != tc.want {
+      t.Errorf("has(%s, %s) = %v; want %v", tc.user, tc.permission, got, tc.want)
+    }
+  }
+  //One load per user: teller and jane were cached after their first check.
+  if loads != 4 {
+    t.Errorf("loads = %d; want 4", loads)
+  }
+  c.has(ctx, "jane", "banking.use", "")
+  c.has(ctx, "broken", "banking.use", "")
+  if loads != 5 {
+    t.Errorf("loads = %d; want 5 (jane cached, broken reloaded)", loads)
+  }
+  //A change of roles is seen after the invalidation or the expiry.
+  perms["jane"] = []string{ "banking.use", "admin.access" }
+  c.invalidate("jane")
+  if !c.has(ctx, "jane", "admin.access", "") {
+    t.Error("has(jane, admin.access) = false after the invalidation; want true")
+  }
+  perms["teller"] = nil
+  if !c.has(ctx, "teller", "admin.users", "") {
+    t.Error("has(teller, admin.users) = false before the expiry; want true (cached)")
+  }
+  now = now.Add(permissionsTtl + time.Second)
+  if c.has(ctx, "teller", "admin.users", "") {
+    t.Error("has(teller, admin.users) = true after the expiry; want false")
+  }
+}
@@ -0,0 +1,52 @@
+package authz
+
+import (
+  bank "finance/databases/banking"
+  "context"
+  "finance/config"
+  "finance/security"
+  "net"
+  "net/http"
+  "strings"
+)
+
+/***
+The IP address of the client. Behind trusted reverse proxies (TRUST_PROXY=true) it is the entry of
+X-Forwarded-For that the outermost proxy appended: every proxy appends the address it received the
+request from, so with TRUSTED_PROXIES=n it is the n-th entry from the right. The entries to its left
+are whatever the client sent, and are ignored. Otherwise (or if the header has fewer entries) it is
+the address of the connection.
+***/
+func ClientIp(req *http.Request) string {
+  if config.GetTrustProxy("") {
+    //A header can be sent more than once; the proxies append to the last one.
+    entries := strings.Split(strings.Join(req.Header.Values("X-Forwarded-For"), ","), ",")
+    if i := len(entries) - config.GetTrustedProxies(); i >= 0 {
+      if ip := net.ParseIP(strings.TrimSpace(entries[i])); ip != nil {
+        return ip.String()
+      }
+    }
+  }
+  host, _, err := net.SplitHostPort(req.RemoteAddr)
+  if err != nil {
+    return req.RemoteAddr
+  }
+  return host
+}
+
+//The context of the request with the actor and the client IP for the audit events.
+func AuditContext(req *http.Request, actor string) context.Context {
+  return bank.WithAuditInfo(req.Context(), actor, ClientIp(req))
+}
+
+/***
+The session of the request, for the rate limits; empty without a session. The pages renew the
+session token on every response, so the token itself would start a new bucket on every request.
+***/
+func SessionKey(req *http.Request) string {
+  cookie, err := req.Cookie("session_token")
+  if err != nil || cookie.Value == "" {
+    return ""
+  }
+  return security.SessionId(cookie.Value)
+}
@@ -0,0 +1,59 @@
+// Testing the functions in clientip.go.
+package authz
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="ClientIp"
+***/
+
+import (
+  "net/http/httptest"
+  "testing"
+)
+
+//It sets TRUST_PROXY, so it cannot run in parallel.
+func TestClientIp(t *testing.T) {
+  type test struct {
+    trustProxy string
+    proxies string
+    remoteAddr string
+    xff []string
+    want string
+  }
+  var tests = []test {
+    { trustProxy: "false", remoteAddr: "10.0.0.7:51234", want: "10.0.0.7" },
+    { trustProxy: "false", remoteAddr: "10.0.0.7:51234", xff: []string{ "203.0.113.9" }, want: "10.0.0.7" },
+    { trustProxy: "true", remoteAddr: "10.0.0.7:51234", xff: []string{ "203.0.113.9" }, want: "203.0.113.9" },
+    //The client sends its own header through the proxy, which appends the real address.
+    { trustProxy: "true", remoteAddr: "10.0.0.7:51234", xff: []string{ "198.51.100.1, 203.0.113.9" },
+      want: "203.0.113.9" },
+    { trustProxy: "true", remoteAddr: "10.0.0.7:51234", xff: []string{ "198.51.100.1", "203.0.113.9" },
+      want: "203.0.113.9" },
+    //A load balancer and the ingress.
+    { trustProxy: "true", proxies: "2", remoteAddr: "10.0.0.7:51234",
+      xff: []string{ "198.51.100.1, 203.0.113.9, 10.0.0.1" }, want: "203.0.113.9" },
+    { trustProxy: "true", proxies: "2", remoteAddr: "10.0.0.7:51234", xff: []string{ "10.0.0.1" }, want: "10.0.0.7" },
+    { trustProxy: "true", remoteAddr: "10.0.0.7:51234", xff: []string{ "203.0.113.9, not-an-ip" }, want: "10.0.0.7" },
+    { trustProxy: "true", remoteAddr: "[2001:db8::1]:443", want: "2001:db8::1" },
+  }
+  for _, tc := range tests {
+    t.Setenv("TRUST_PROXY", tc.trustProxy)
+    t.Setenv("TRUSTED_PROXIES", tc.proxies)
+    req := httptest.NewRequest("GET", "/", nil)
+    req.RemoteAddr = tc.remoteAddr
+    for _, xff := range tc.xff {
+      req.Header.Add("X-Forwarded-For", xff)
+    }
+    if got := ClientIp(req); got != tc.want {
+      t.Errorf("ClientIp(%q, %q) with TRUST_PROXY=%s = %q; want %q", tc.remoteAddr, tc.xff, tc.trustProxy, got,
+        tc.want)
+    }
+  }
+}
@@ -1,113 +1,82 @@
 package config
 
+/***
+The settings of the configuration loaded at startup (settings.go). The USER environment variable is
+not a setting; it is read as is.
+***/
+
 import (
   "fmt"
-  "github.com/juan-carlos-trimino/gplogger"
   "os"
-  "strconv"
+  "strings"
 )
 
 func GetServer() string {
-  if ev, exists := os.LookupEnv("SERVER"); exists {
-    return ev
-  }
-  return ""  //Default value.
+  return current("").Server
 }
 
 func GetHttp(correlationId string) bool {
-  if ev, exists := os.LookupEnv("HTTP"); exists {
-    v, err := strconv.ParseBool(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: true).", err), correlationId)
-    }
-  }
-  return true
+  return current(correlationId).Http
 }
 
 func GetHttpPort(correlationId string) int {
-  if ev, exists := os.LookupEnv("HTTP_PORT"); exists {
-    v, err := strconv.Atoi(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: 8080).", err), correlationId)
-    }
-  }
-  return 8080
+  return current(correlationId).HttpPort
 }
 
 func GetHttps(correlationId string) bool {
-  if ev, exists := os.LookupEnv("HTTPS"); exists {
-    v, err := strconv.ParseBool(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: false).", err), correlationId)
-    }
-  }
-  return false
+  return current(correlationId).Https
 }
 
 func GetHttpsPort(correlationId string) int {
-  if ev, exists := os.LookupEnv("HTTPS_PORT"); exists {
-    v, err := strconv.Atoi(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: 8443).", err), correlationId)
-    }
-  }
-  return 8443
+  return current(correlationId).HttpsPort
 }
 
 func GetK8s(correlationId string) bool {
-  if ev, exists := os.LookupEnv("K8S"); exists {
-    v, err := strconv.ParseBool(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: false).", err), correlationId)
-    }
-  }
-  return false
+  return current(correlationId).K8s
 }
 
 func GetShutDownTimeout(correlationId string) int {
-  if ev, exists := os.LookupEnv("SHUTDOWN_TIMEOUT"); exists {
-    v, err := strconv.Atoi(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: 15 seconds).", err), correlationId)
-    }
-  }
-  return 15  //Seconds.
+  return current(correlationId).ShutdownTimeout
 }
 
 func GetPprof(correlationId string) bool {
-  if ev, exists := os.LookupEnv("PPROF"); exists {
-    v, err := strconv.ParseBool(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: false).", err), correlationId)
-    }
-  }
-  return false
+  return current(correlationId).Pprof
 }
 
 func GetLetsEncryptCert(correlationId string) bool {
-  if ev, exists := os.LookupEnv("LE_CERT"); exists {
-    v, err := strconv.ParseBool(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: false).", err), correlationId)
-    }
-  }
-  return false
+  return current(correlationId).LetsEncryptCert
+}
+
+func GetTlsCertFile() string {
+  return current("").TlsCertFile
+}
+
+func GetTlsKeyFile() string {
+  return current("").TlsKeyFile
+}
+
+func GetTlsHosts() []string {
+  /***
+  The Subject Alternative Names of the certificate issued by the private CA; host names and IP
+  addresses.
+  ***/
+  return SplitList(current("").TlsHosts)
+}
+
+func GetTlsCertDays(correlationId string) int {
+  return current(correlationId).TlsCertDays
+}
+
+func GetTlsKeyType(correlationId string) string {
+  return current(correlationId).TlsKeyType
+}
+
+func GetAutocertHosts() []string {
+  /***
+  The domain names the server accepts to get a certificate for from Let's Encrypt; the requests for
+  any other name are refused.
+  ***/
+  return SplitList(current("").AutocertHosts)
 }
 
 func GetUser() string {
@@ -118,29 +87,222 @@ func GetUser() string {
 }
 
 func GetPreventProbesOutput(correlationId string) bool {
-  if ev, exists := os.LookupEnv("PREVENT_PROBES_OUTPUT"); exists {
-    v, err := strconv.ParseBool(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: false).", err), correlationId)
-    }
-  }
-  return false
+  return current(correlationId).PreventProbesOutput
+}
+
+func GetMetricsPort(correlationId string) int {
+  /***
+  The port of the listener that serves /metrics; it is separate from the application, so the
+  metrics are not public. 0 disables the metrics.
+  ***/
+  return current(correlationId).MetricsPort
+}
+
+func GetTraceExporter(correlationId string) string {
+  /***
+  Where the spans of the traces are sent: "stdout" or "file" (JSON lines, for local development),
+  "otlp" (an OpenTelemetry collector), or "none".
+  ***/
+  return current(correlationId).TraceExporter
+}
+
+func GetTraceFile() string {
+  return current("").TraceFile
+}
+
+func GetTraceOtlpEndpoint() string {
+  return current("").TraceOtlpEndpoint
+}
+
+func GetTraceSamplePercent(correlationId string) int {
+  return current(correlationId).TraceSamplePercent
+}
+
+func GetDbMigrate(correlationId string) bool {
+  /***
+  When set to true, the server applies the pending migrations of the database (see the migrate package) before it connects;
+  outside K8s, it creates the roles and the database first if they do not exist.
+  ***/
+  return current(correlationId).DbMigrate
+}
+
+func GetRecurringScheduler(correlationId string) bool {
+  /***
+  When set to true, the server runs the background scheduler that posts the recurring transactions.
+  ***/
+  return current(correlationId).RecurringScheduler
 }
 
-func GetAlwaysDbAdmin(correlationId string) bool {
+func GetRecurringInterval(correlationId string) int {
+  return current(correlationId).RecurringInterval
+}
+
+func GetLoginDelayAfter(correlationId string) int {
   /***
-  When set to true, the script is run whether the admin database exists or not. Otherwise, the script is run only when the
-  database does not exist.
+  Number of failed login attempts before every new failure is delayed (exponential backoff).
   ***/
-  if ev, exists := os.LookupEnv("ALWAYS_DB_ADMIN"); exists {
-    v, err := strconv.ParseBool(ev)
-    if err == nil {
-      return v
-    } else {
-      logger.LogInfo(fmt.Sprintf("%s - (Default: false).", err), correlationId)
-    }
+  return current(correlationId).LoginDelayAfter
+}
+
+func GetLoginLockAfter(correlationId string) int {
+  /***
+  Number of failed login attempts before the user is locked out for LOGIN_LOCK_MINUTES.
+  ***/
+  return current(correlationId).LoginLockAfter
+}
+
+func GetLoginLockMinutes(correlationId string) int {
+  return current(correlationId).LoginLockMinutes
+}
+
+func GetPasswordMinLength(correlationId string) int {
+  return current(correlationId).PasswordMinLength
+}
+
+func GetPasswordMinClasses(correlationId string) int {
+  /***
+  Number of character classes (lowercase, uppercase, digits, symbols) a password must contain.
+  ***/
+  return current(correlationId).PasswordMinClasses
+}
+
+func GetPasswordMinScore(correlationId string) int {
+  /***
+  Minimum strength score of a password, from 0 (too guessable) to 4 (very unguessable).
+  ***/
+  return current(correlationId).PasswordMinScore
+}
+
+func GetPasswordHistory(correlationId string) int {
+  /***
+  Number of previous passwords that cannot be reused; 0 allows any previous password.
+  ***/
+  return current(correlationId).PasswordHistory
+}
+
+func GetBreachedPasswordsDir() string {
+  /***
+  Directory with a local copy of the Pwned Passwords range files (one file per SHA-1 prefix); an
+  empty value disables the breached-password check.
+  ***/
+  return current("").BreachedPasswordsDir
+}
+
+func GetMailer(correlationId string) string {
+  /***
+  How the emails are sent: "smtp", or "file" to write them to MAIL_DIR (local development and tests).
+  ***/
+  return current(correlationId).Mailer
+}
+
+func GetMailFrom() string {
+  return current("").MailFrom
+}
+
+func GetMailDir() string {
+  return current("").MailDir
+}
+
+func GetSmtpHost() string {
+  return current("").SmtpHost
+}
+
+func GetSmtpPort(correlationId string) int {
+  return current(correlationId).SmtpPort
+}
+
+func GetSmtpUser() string {
+  return current("").SmtpUser
+}
+
+func GetSmtpPassword() string {
+  return current("").SmtpPassword
+}
+
+func GetPasswordResetMinutes(correlationId string) int {
+  /***
+  How long a password-reset link is valid.
+  ***/
+  return current(correlationId).PasswordResetMinutes
+}
+
+func GetBaseUrl(correlationId string) string {
+  /***
+  The scheme, host, and port the users reach the application at (e.g., https://finances.example.com);
+  the links sent by email start with it. It is not taken from the request, whose Host header is set
+  by the client.
+  ***/
+  c := current(correlationId)
+  if c.BaseUrl != "" {
+    return strings.TrimRight(c.BaseUrl, "/")
+  }
+  if c.Https {
+    return fmt.Sprintf("https://localhost:%d", c.HttpsPort)
   }
-  return false
+  return fmt.Sprintf("http://localhost:%d", c.HttpPort)
 }
+
+func GetTrustProxy(correlationId string) bool {
+  /***
+  Whether the server runs behind a reverse proxy (e.g., the Kubernetes ingress) that sets the
+  X-Forwarded-For header; only then is the header used as the client IP. Otherwise a client could
+  set it to any address.
+  ***/
+  return current(correlationId).TrustProxy
+}
+
+func GetTrustedProxies() int {
+  /***
+  The number of reverse proxies (with TRUST_PROXY=true) that append the address they received the
+  request from to X-Forwarded-For; e.g., 2 for a load balancer in front of the ingress. The client
+  IP is the entry the outermost proxy appended; the entries to its left are written by the client.
+  ***/
+  return current("").TrustedProxies
+}
+
+func GetAuditRetentionDays(correlationId string) int {
+  /***
+  Days the security audit events are kept; 0 keeps them forever.
+  ***/
+  return current(correlationId).AuditRetentionDays
+}
+
+func GetRateLimits() string {
+  /***
+  The rate limits of the routes; see the ratelimit package.
+  ***/
+  return current("").RateLimits
+}
+
+func GetRateLimitStore(correlationId string) string {
+  /***
+  Where the buckets of the rate limiter are kept: "memory" (each instance of the server limits on
+  its own) or "postgres" (shared by all the instances).
+  ***/
+  return current(correlationId).RateLimitStore
+}
+
+func GetStateStore(correlationId string) string {
+  /***
+  Where the state of the pages of each user is kept: "fs" (a file per user and page under the data
+  directory) or "postgres" (shared by all the instances of the server).
+  ***/
+  return current(correlationId).StateStore
+}
+
+func GetSignupLinkKey() string {
+  /***
+  The key that signs the links to verify the sign-ups (at least 32 bytes); all the instances of the
+  server must share it. If it is not set, a random key is used, and the links sent before a restart
+  are no longer valid.
+  ***/
+  return current("").SignupLinkKey
+}
+
+func GetSignupLinkHours(correlationId string) int {
+  /***
+  How long a link to verify a sign-up is valid.
+  ***/
+  return current(correlationId).SignupLinkHours
+}
+
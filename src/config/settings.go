@@ -0,0 +1,514 @@
+package config
+
+/***
+The configuration of the server. Each setting has a default value, which can be overridden, in this
+order (the last one wins):
+(1) A JSON file, given by the flag -config or the environment variable CONFIG_FILE; its keys are the
+    json tags below, e.g. {"http_port": 8080, "db_host": "postgres"}. Unknown keys are errors.
+(2) The environment variable of the setting (env tag), e.g. HTTP_PORT=8080. If <VAR>_FILE is set
+    instead (e.g., DB_PASSWORD_FILE=/run/secrets/db-password), the value is read from that file;
+    this is how the secrets mounted by Kubernetes or Docker are passed.
+(3) The command-line flag of the setting (flag tag), e.g. -http-port=8080.
+
+Load validates the result and reports every problem at once; the server does not start with an
+invalid configuration. The settings tagged secret are masked by Print, unless they are asked for.
+
+Tags: min and max bound an int; oneof lists the accepted values of a string ("|" separated).
+***/
+
+import (
+  "encoding/json"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "net/url"
+  "os"
+  "reflect"
+  "strconv"
+  "strings"
+  "sync"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+type Config struct {
+  //Server.
+  Server string  `json:"server" env:"SERVER" flag:"server" help:"IP address to listen on; empty for all"`
+  Http bool  `json:"http" env:"HTTP" flag:"http" help:"serve HTTP"`
+  HttpPort int  `json:"http_port" env:"HTTP_PORT" flag:"http-port" min:"1" max:"65535" help:"HTTP port"`
+  Https bool  `json:"https" env:"HTTPS" flag:"https" help:"serve HTTPS"`
+  HttpsPort int  `json:"https_port" env:"HTTPS_PORT" flag:"https-port" min:"1" max:"65535" help:"HTTPS port"`
+  K8s bool  `json:"k8s" env:"K8S" flag:"k8s" help:"running in Kubernetes; the database is set up by the cluster"`
+  ShutdownTimeout int  `json:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" flag:"shutdown-timeout" min:"1" help:"seconds to wait for the requests in progress at shutdown"`
+  Pprof bool  `json:"pprof" env:"PPROF" flag:"pprof" help:"serve /debug/pprof"`
+  LetsEncryptCert bool  `json:"le_cert" env:"LE_CERT" flag:"le-cert" help:"get the certificate from Let's Encrypt"`
+  TlsCertFile string  `json:"tls_cert_file" env:"TLS_CERT_FILE" flag:"tls-cert-file" help:"PEM certificate (and chain) of the HTTPS server; reloaded when it changes. Empty for a certificate of a private CA kept in the data directory"`
+  TlsKeyFile string  `json:"tls_key_file" env:"TLS_KEY_FILE" flag:"tls-key-file" help:"PEM key of tls_cert_file"`
+  TlsHosts string  `json:"tls_hosts" env:"TLS_HOSTS" flag:"tls-hosts" help:"host names and IP addresses (comma separated) of the certificate of the private CA"`
+  TlsCertDays int  `json:"tls_cert_days" env:"TLS_CERT_DAYS" flag:"tls-cert-days" min:"1" max:"397" help:"days the certificate of the private CA is valid; it is renewed when a third is left"`
+  TlsKeyType string  `json:"tls_key_type" env:"TLS_KEY_TYPE" flag:"tls-key-type" oneof:"rsa|ecdsa|ed25519" help:"type of the new keys of the private CA and its certificates; most browsers do not accept ed25519"`
+  AutocertHosts string  `json:"autocert_hosts" env:"AUTOCERT_HOSTS" flag:"autocert-hosts" help:"domain names (comma separated) Let's Encrypt certificates are requested for"`
+  PreventProbesOutput bool  `json:"prevent_probes_output" env:"PREVENT_PROBES_OUTPUT" flag:"prevent-probes-output" help:"do not log the probes"`
+  MetricsPort int  `json:"metrics_port" env:"METRICS_PORT" flag:"metrics-port" min:"0" max:"65535" help:"port of the listener of /metrics (Prometheus); 0 disables it"`
+  TraceExporter string  `json:"trace_exporter" env:"TRACE_EXPORTER" flag:"trace-exporter" oneof:"none|stdout|file|otlp" help:"where the spans of the traces are sent; none disables tracing"`
+  TraceFile string  `json:"trace_file" env:"TRACE_FILE" flag:"trace-file" help:"file of the spans (JSON lines) of the file exporter"`
+  TraceOtlpEndpoint string  `json:"trace_otlp_endpoint" env:"TRACE_OTLP_ENDPOINT" flag:"trace-otlp-endpoint" help:"URL of the traces of the OTLP/HTTP collector"`
+  TraceSamplePercent int  `json:"trace_sample_percent" env:"TRACE_SAMPLE_PERCENT" flag:"trace-sample-percent" min:"0" max:"100" help:"percentage of the new traces that are recorded"`
+  BaseUrl string  `json:"base_url" env:"BASE_URL" flag:"base-url" help:"URL the users reach the application at; the links sent by email start with it"`
+  TrustProxy bool  `json:"trust_proxy" env:"TRUST_PROXY" flag:"trust-proxy" help:"use X-Forwarded-For as the client IP"`
+  TrustedProxies int  `json:"trusted_proxies" env:"TRUSTED_PROXIES" flag:"trusted-proxies" min:"1" max:"10" help:"reverse proxies in front of the server that append to X-Forwarded-For (with trust_proxy)"`
+  RateLimits string  `json:"rate_limits" env:"RATE_LIMITS" flag:"rate-limits" help:"rate limits (comma separated) of the routes: [METHOD] PATH KEY=N/UNIT; KEY is ip, user (logins), or session; UNIT is s, m, or h"`
+  RateLimitStore string  `json:"rate_limit_store" env:"RATE_LIMIT_STORE" flag:"rate-limit-store" oneof:"memory|postgres" help:"where the buckets of the rate limiter are kept; postgres shares them among the instances"`
+  StateStore string  `json:"state_store" env:"STATE_STORE" flag:"state-store" oneof:"fs|postgres" help:"where the state of the pages is kept"`
+  //Database.
+  DbHost string  `json:"db_host" env:"DB_HOST" flag:"db-host" help:"Postgres host"`
+  DbPort int  `json:"db_port" env:"DB_PORT" flag:"db-port" min:"1" max:"65535" help:"Postgres port"`
+  DbSslMode string  `json:"db_sslmode" env:"DB_SSLMODE" flag:"db-sslmode" oneof:"disable|allow|prefer|require|verify-ca|verify-full" help:"Postgres sslmode"`
+  DbConnectTimeout int  `json:"db_connect_timeout" env:"DB_CONNECT_TIMEOUT" flag:"db-connect-timeout" min:"1" max:"300" help:"seconds to wait while connecting"`
+  DbUser string  `json:"db_user" env:"DB_USER" flag:"db-user" help:"user of the application database; created with the database (outside Kubernetes)"`
+  DbPassword string  `json:"db_password" env:"DB_PASSWORD" flag:"db-password" secret:"true" help:"password of db_user"`
+  DbName string  `json:"db_name" env:"DB_NAME" flag:"db-name" help:"application database"`
+  DbSetupUser string  `json:"db_setup_user" env:"DB_SETUP_USER" flag:"db-setup-user" help:"user that creates the database (outside Kubernetes) and applies the migrations"`
+  DbSetupPassword string  `json:"db_setup_password" env:"DB_SETUP_PASSWORD" flag:"db-setup-password" secret:"true" help:"password of db_setup_user"`
+  DbSetupName string  `json:"db_setup_name" env:"DB_SETUP_NAME" flag:"db-setup-name" help:"database db_setup_user connects to"`
+  DbMigrate bool  `json:"db_migrate" env:"DB_MIGRATE" flag:"db-migrate" help:"apply the pending migrations of the database at startup"`
+  //Scheduler and audit.
+  RecurringScheduler bool  `json:"recurring_scheduler" env:"RECURRING_SCHEDULER" flag:"recurring-scheduler" help:"post the recurring transactions in the background"`
+  RecurringInterval int  `json:"recurring_interval" env:"RECURRING_INTERVAL" flag:"recurring-interval" min:"1" help:"minutes between the runs of the scheduler"`
+  AuditRetentionDays int  `json:"audit_retention_days" env:"AUDIT_RETENTION_DAYS" flag:"audit-retention-days" min:"0" help:"days the audit events are kept; 0 keeps them forever"`
+  //Logins and passwords.
+  LoginDelayAfter int  `json:"login_delay_after" env:"LOGIN_DELAY_AFTER" flag:"login-delay-after" min:"0" help:"failed logins before every new failure is delayed"`
+  LoginLockAfter int  `json:"login_lock_after" env:"LOGIN_LOCK_AFTER" flag:"login-lock-after" min:"1" help:"failed logins before the user is locked out"`
+  LoginLockMinutes int  `json:"login_lock_minutes" env:"LOGIN_LOCK_MINUTES" flag:"login-lock-minutes" min:"1" help:"minutes a user is locked out"`
+  PasswordMinLength int  `json:"password_min_length" env:"PASSWORD_MIN_LENGTH" flag:"password-min-length" min:"1" max:"72" help:"minimum length of a password"`
+  PasswordMinClasses int  `json:"password_min_classes" env:"PASSWORD_MIN_CLASSES" flag:"password-min-classes" min:"1" max:"4" help:"character classes a password must contain"`
+  PasswordMinScore int  `json:"password_min_score" env:"PASSWORD_MIN_SCORE" flag:"password-min-score" min:"0" max:"4" help:"minimum strength score of a password"`
+  PasswordHistory int  `json:"password_history" env:"PASSWORD_HISTORY" flag:"password-history" min:"0" max:"24" help:"previous passwords that cannot be reused"`
+  BreachedPasswordsDir string  `json:"breached_passwords_dir" env:"BREACHED_PASSWORDS_DIR" flag:"breached-passwords-dir" help:"local copy of the Pwned Passwords range files; empty disables the check"`
+  PasswordResetMinutes int  `json:"password_reset_minutes" env:"PASSWORD_RESET_MINUTES" flag:"password-reset-minutes" min:"1" max:"1440" help:"minutes a password-reset link is valid"`
+  //Sign-up.
+  SignupLinkKey string  `json:"signup_link_key" env:"SIGNUP_LINK_KEY" flag:"signup-link-key" secret:"true" help:"key that signs the sign-up links (at least 32 bytes)"`
+  SignupLinkHours int  `json:"signup_link_hours" env:"SIGNUP_LINK_HOURS" flag:"signup-link-hours" min:"1" max:"168" help:"hours a sign-up link is valid"`
+  //Email.
+  Mailer string  `json:"mailer" env:"MAILER" flag:"mailer" oneof:"smtp|file" help:"how the emails are sent"`
+  MailFrom string  `json:"mail_from" env:"MAIL_FROM" flag:"mail-from" help:"sender of the emails"`
+  MailDir string  `json:"mail_dir" env:"MAIL_DIR" flag:"mail-dir" help:"directory of the file mailer; empty for the temporary directory"`
+  SmtpHost string  `json:"smtp_host" env:"SMTP_HOST" flag:"smtp-host" help:"SMTP server"`
+  SmtpPort int  `json:"smtp_port" env:"SMTP_PORT" flag:"smtp-port" min:"1" max:"65535" help:"SMTP port"`
+  SmtpUser string  `json:"smtp_user" env:"SMTP_USER" flag:"smtp-user" help:"SMTP user; empty for no authentication"`
+  SmtpPassword string  `json:"smtp_password" env:"SMTP_PASSWORD" flag:"smtp-password" secret:"true" help:"SMTP password"`
+}
+
+func Defaults() Config {
+  return Config{
+    Http: true,
+    HttpPort: 8080,
+    HttpsPort: 8443,
+    ShutdownTimeout: 15,  //Seconds.
+    TlsHosts: "localhost,::1,127.0.0.1",
+    TlsCertDays: 90,
+    TlsKeyType: "ecdsa",
+    AutocertHosts: "trimino.xyz,www.trimino.xyz",
+    StateStore: "fs",
+    TrustedProxies: 1,
+    RateLimits: "POST /verify_login ip=20/m, POST /verify_login user=5/m, POST /verify_totp ip=20/m, " +
+      "POST /signup ip=5/h, POST /verify_signup ip=5/h, /storage/s3/ session=60/m, /storage/s3/ ip=120/m, " +
+      "POST /fin/ session=60/m, POST /fin/ ip=120/m",
+    RateLimitStore: "memory",
+    TraceExporter: "none",
+    TraceOtlpEndpoint: "http://localhost:4318/v1/traces",
+    TraceSamplePercent: 100,
+    //https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING
+    DbHost: "localhost",
+    DbPort: 5432,
+    DbSslMode: "disable",
+    DbConnectTimeout: 4,  //Seconds.
+    DbUser: "admin_user",
+    DbPassword: "",  //Required; e.g., DB_PASSWORD_FILE.
+    DbName: "finances",
+    DbSetupUser: "trimino",
+    DbSetupPassword: "",  //Required if db_migrate is true.
+    DbSetupName: "postgres",
+    DbMigrate: true,
+    RecurringScheduler: true,
+    RecurringInterval: 60,  //Minutes.
+    AuditRetentionDays: 365,
+    LoginDelayAfter: 3,
+    LoginLockAfter: 10,
+    LoginLockMinutes: 15,
+    PasswordMinLength: 12,
+    PasswordMinClasses: 3,
+    PasswordMinScore: 2,
+    PasswordHistory: 5,
+    PasswordResetMinutes: 30,
+    SignupLinkHours: 24,
+    Mailer: "file",
+    MailFrom: "no-reply@localhost",
+    SmtpPort: 587,  //Submission port (STARTTLS).
+  }
+}
+
+var loaded struct {
+  sync.RWMutex
+  cfg *Config
+}
+
+//Set the configuration read by the Get functions; it must be called before the server starts.
+func Use(c *Config) {
+  loaded.Lock()
+  defer loaded.Unlock()
+  loaded.cfg = c
+}
+
+/***
+The configuration set by Use. Before that (e.g., in the tests), the defaults overridden by the
+environment are read on every call; an invalid value is logged, and its default is used.
+***/
+func current(correlationId string) *Config {
+  loaded.RLock()
+  c := loaded.cfg
+  loaded.RUnlock()
+  if c != nil {
+    return c
+  }
+  d := Defaults()
+  c = &Config{}
+  *c = d
+  for _, f := range fieldsOf(c) {
+    raw, ok, err := f.fromEnv()
+    if err == nil && ok {
+      if err = f.set(raw); err == nil {
+        err = f.check()
+      }
+    }
+    if err != nil {
+      def := reflect.ValueOf(d).FieldByIndex(f.sf.Index)
+      f.v.Set(def)
+      logger.LogInfo(fmt.Sprintf("%s: %s - (Default: %v).", f.sf.Tag.Get("env"), err, def), correlationId)
+    }
+  }
+  return c
+}
+
+type field struct {
+  sf reflect.StructField
+  v reflect.Value
+}
+
+func fieldsOf(c *Config) []field {
+  rv := reflect.ValueOf(c).Elem()
+  fs := make([]field, 0, rv.NumField())
+  for i := 0; i < rv.NumField(); i++ {
+    fs = append(fs, field{ sf: rv.Type().Field(i), v: rv.Field(i) })
+  }
+  return fs
+}
+
+//The name used in the errors: the key of the file and the environment variable.
+func (f field) name() string {
+  return fmt.Sprintf("%s (%s)", f.sf.Tag.Get("json"), f.sf.Tag.Get("env"))
+}
+
+func (f field) set(raw string) error {
+  switch f.v.Kind() {
+  case reflect.Bool:
+    b, err := strconv.ParseBool(raw)
+    if err != nil {
+      return fmt.Errorf("%q is not a boolean", raw)
+    }
+    f.v.SetBool(b)
+  case reflect.Int:
+    n, err := strconv.Atoi(raw)
+    if err != nil {
+      return fmt.Errorf("%q is not an integer", raw)
+    }
+    f.v.SetInt(int64(n))
+  default:
+    f.v.SetString(raw)
+  }
+  return nil
+}
+
+//The value of <VAR>_FILE, or else of <VAR>; ok is false if neither is set.
+func (f field) fromEnv() (string, bool, error) {
+  env := f.sf.Tag.Get("env")
+  if path, exists := os.LookupEnv(env + "_FILE"); exists {
+    b, err := os.ReadFile(path)
+    if err != nil {
+      return "", false, fmt.Errorf("%s_FILE: %w", env, err)
+    }
+    //The files usually end with a newline.
+    return strings.TrimRight(string(b), "\r\n"), true, nil
+  }
+  ev, exists := os.LookupEnv(env)
+  return ev, exists, nil
+}
+
+//The min, max, and oneof tags.
+func (f field) check() error {
+  switch f.v.Kind() {
+  case reflect.Int:
+    n := f.v.Int()
+    if min, ok := f.sf.Tag.Lookup("min"); ok {
+      if m, _ := strconv.ParseInt(min, 10, 64); n < m {
+        return fmt.Errorf("%d is less than %d", n, m)
+      }
+    }
+    if max, ok := f.sf.Tag.Lookup("max"); ok {
+      if m, _ := strconv.ParseInt(max, 10, 64); n > m {
+        return fmt.Errorf("%d is greater than %d", n, m)
+      }
+    }
+  case reflect.String:
+    if oneof, ok := f.sf.Tag.Lookup("oneof"); ok {
+      values := strings.Split(oneof, "|")
+      for _, v := range values {
+        if f.v.String() == v {
+          return nil
+        }
+      }
+      return fmt.Errorf("%q is not one of %s", f.v.String(), strings.Join(values, ", "))
+    }
+  }
+  return nil
+}
+
+//A flag that keeps the raw value; it is applied after the file and the environment.
+type flagValue struct {
+  raw string
+  isBool bool
+}
+
+func (v *flagValue) String() string {
+  return v.raw
+}
+
+func (v *flagValue) Set(s string) error {
+  v.raw = s
+  return nil
+}
+
+//It lets a boolean flag be given without a value (e.g., -https).
+func (v *flagValue) IsBoolFlag() bool {
+  return v.isBool
+}
+
+//The flags of the settings and -config; the values of the settings are applied by load.
+func newFlagSet(name string, output io.Writer) (*flag.FlagSet, *string, map[string]*flagValue) {
+  fs := flag.NewFlagSet(name, flag.ContinueOnError)
+  fs.SetOutput(output)
+  configFile := fs.String("config", "", "JSON configuration file (or CONFIG_FILE)")
+  values := map[string]*flagValue{}
+  for _, f := range fieldsOf(&Config{}) {
+    v := &flagValue{ isBool: f.v.Kind() == reflect.Bool }
+    values[f.sf.Tag.Get("flag")] = v
+    fs.Var(v, f.sf.Tag.Get("flag"), fmt.Sprintf("%s (%s)", f.sf.Tag.Get("help"), f.sf.Tag.Get("env")))
+  }
+  return fs, configFile, values
+}
+
+/***
+Load the configuration from the defaults, the file, the environment, and the flags in args (e.g.,
+os.Args[1:]). The error lists every invalid setting.
+***/
+func Load(args []string, output io.Writer) (*Config, error) {
+  fs, configFile, values := newFlagSet("finance", output)
+  if err := fs.Parse(args); err != nil {
+    return nil, err
+  } else if fs.NArg() > 0 {
+    return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+  }
+  return load(fs, *configFile, values)
+}
+
+/***
+The flags of the settings for a command that adds its own flags (e.g., "migrate up -dry-run"); after
+fs.Parse, the function returns the configuration as Load does.
+***/
+func FlagSet(name string, output io.Writer) (*flag.FlagSet, func() (*Config, error)) {
+  fs, configFile, values := newFlagSet(name, output)
+  return fs, func() (*Config, error) {
+    return load(fs, *configFile, values)
+  }
+}
+
+func load(fs *flag.FlagSet, configFile string, values map[string]*flagValue) (*Config, error) {
+  c := Defaults()
+  var errs []error
+  if configFile == "" {
+    configFile = os.Getenv("CONFIG_FILE")
+  }
+  if configFile != "" {
+    if err := readFile(&c, configFile); err != nil {
+      errs = append(errs, err)
+    }
+  }
+  setFlags := map[string]bool{}
+  fs.Visit(func(fl *flag.Flag) {
+    setFlags[fl.Name] = true
+  })
+  for _, f := range fieldsOf(&c) {
+    raw, ok, err := f.fromEnv()
+    if err != nil {
+      errs = append(errs, err)
+    } else if ok {
+      if err = f.set(raw); err != nil {
+        errs = append(errs, fmt.Errorf("%s: %w", f.sf.Tag.Get("env"), err))
+      }
+    }
+    if name := f.sf.Tag.Get("flag"); setFlags[name] {
+      if err = f.set(values[name].raw); err != nil {
+        errs = append(errs, fmt.Errorf("-%s: %w", name, err))
+      }
+    }
+  }
+  errs = append(errs, c.Validate()...)
+  if len(errs) > 0 {
+    return nil, errors.Join(errs...)
+  }
+  return &c, nil
+}
+
+func readFile(c *Config, path string) error {
+  f, err := os.Open(path)
+  if err != nil {
+    return fmt.Errorf("configuration file: %w", err)
+  }
+  defer f.Close()
+  dec := json.NewDecoder(f)
+  dec.DisallowUnknownFields()
+  if err = dec.Decode(c); err != nil {
+    return fmt.Errorf("configuration file %s: %w", path, err)
+  }
+  return nil
+}
+
+//The problems of the configuration; none if it is valid.
+func (c *Config) Validate() []error {
+  var errs []error
+  for _, f := range fieldsOf(c) {
+    if err := f.check(); err != nil {
+      errs = append(errs, fmt.Errorf("%s: %w", f.name(), err))
+    }
+  }
+  if !c.Http && !c.Https {
+    errs = append(errs, errors.New("http (HTTP) and https (HTTPS) are both false; the server would not listen"))
+  } else if c.Http && c.Https && c.HttpPort == c.HttpsPort {
+    errs = append(errs, fmt.Errorf("http_port (HTTP_PORT) and https_port (HTTPS_PORT) are both %d", c.HttpPort))
+  }
+  if (c.TlsCertFile == "") != (c.TlsKeyFile == "") {
+    errs = append(errs, errors.New("tls_cert_file (TLS_CERT_FILE) and tls_key_file (TLS_KEY_FILE) must be set together"))
+  }
+  if c.Https && c.LetsEncryptCert && len(SplitList(c.AutocertHosts)) == 0 {
+    errs = append(errs, errors.New("autocert_hosts (AUTOCERT_HOSTS) is required when le_cert is true"))
+  } else if c.Https && !c.LetsEncryptCert && c.TlsCertFile == "" && len(SplitList(c.TlsHosts)) == 0 {
+    errs = append(errs, errors.New("tls_hosts (TLS_HOSTS) is required for the certificate of the private CA"))
+  }
+  if c.MetricsPort != 0 && ((c.Http && c.MetricsPort == c.HttpPort) || (c.Https && c.MetricsPort == c.HttpsPort)) {
+    errs = append(errs, fmt.Errorf("metrics_port (METRICS_PORT) %d is the port of the application; /metrics must not be " +
+      "public", c.MetricsPort))
+  }
+  if c.BaseUrl != "" {
+    if u, err := url.Parse(c.BaseUrl); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+      errs = append(errs, fmt.Errorf("base_url (BASE_URL): %q is not an http or https URL", c.BaseUrl))
+    }
+  }
+  if c.TraceExporter == "file" && c.TraceFile == "" {
+    errs = append(errs, errors.New("trace_file (TRACE_FILE) is required when trace_exporter is file"))
+  } else if c.TraceExporter == "otlp" {
+    if u, err := url.Parse(c.TraceOtlpEndpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+       u.Host == "" {
+      errs = append(errs, fmt.Errorf("trace_otlp_endpoint (TRACE_OTLP_ENDPOINT): %q is not an http or https URL",
+        c.TraceOtlpEndpoint))
+    }
+  }
+  if c.DbPassword == "" {
+    errs = append(errs, errors.New("db_password (DB_PASSWORD) is required"))
+  }
+  if c.DbMigrate && c.DbSetupPassword == "" {
+    errs = append(errs, errors.New("db_setup_password (DB_SETUP_PASSWORD) is required when db_migrate is true"))
+  }
+  if c.Mailer == "smtp" && c.SmtpHost == "" {
+    errs = append(errs, errors.New("smtp_host (SMTP_HOST) is required when mailer is smtp"))
+  }
+  if c.SignupLinkKey != "" && len(c.SignupLinkKey) < 32 {
+    errs = append(errs, errors.New("signup_link_key (SIGNUP_LINK_KEY) must be at least 32 bytes"))
+  }
+  return errs
+}
+
+//The items of a comma-separated setting, without the blanks and the empty items.
+func SplitList(s string) []string {
+  var items []string
+  for _, item := range strings.Split(s, ",") {
+    if item = strings.TrimSpace(item); item != "" {
+      items = append(items, item)
+    }
+  }
+  return items
+}
+
+//The connection string of the application database; the values are quoted as libpq requires.
+func (c *Config) DbConnString() string {
+  return c.connString(c.DbUser, c.DbPassword, c.DbName)
+}
+
+//The connection string of db_setup_user to dbName; e.g., db_setup_name to create the database.
+func (c *Config) DbSetupConnString(dbName string) string {
+  return c.connString(c.DbSetupUser, c.DbSetupPassword, dbName)
+}
+
+func (c *Config) connString(user, password, dbName string) string {
+  quote := func(s string) string {
+    return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+  }
+  return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s connect_timeout=%d sslmode=%s", quote(c.DbHost),
+    c.DbPort, quote(user), quote(password), quote(dbName), c.DbConnectTimeout, c.DbSslMode)
+}
+
+/***
+Write the configuration as JSON (the format of the file). The secrets that are set are masked unless
+showSecrets is true; the output tends to end up in terminals and CI logs.
+***/
+func (c *Config) Print(w io.Writer, showSecrets bool) error {
+  out := *c
+  if !showSecrets {
+    for _, f := range fieldsOf(&out) {
+      if f.sf.Tag.Get("secret") == "true" && f.v.String() != "" {
+        f.v.SetString("******")
+      }
+    }
+  }
+  enc := json.NewEncoder(w)
+  enc.SetIndent("", "  ")
+  return enc.Encode(out)
+}
+
+/***
+The "config" command: "config print [-show-secrets] [flags]" writes the effective configuration (the
+defaults, the file, the environment, and the flags) and exits; the server is not started.
+***/
+func Command(args []string, stdout, stderr io.Writer) int {
+  if len(args) == 0 || args[0] != "print" {
+    fmt.Fprintln(stderr, "usage: config print [-show-secrets] [-config file] [flags]")
+    return 2
+  }
+  fs, configFile, values := newFlagSet("config print", stderr)
+  showSecrets := fs.Bool("show-secrets", false, "write the secrets instead of masking them")
+  if err := fs.Parse(args[1:]); err != nil {
+    return 2
+  } else if fs.NArg() > 0 {
+    fmt.Fprintf(stderr, "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+    return 2
+  }
+  c, err := load(fs, *configFile, values)
+  if err != nil {
+    fmt.Fprintf(stderr, "Invalid configuration:\n%v\n", err)
+    return 1
+  }
+  if err = c.Print(stdout, *showSecrets); err != nil {
+    fmt.Fprintln(stderr, err)
+    return 1
+  }
+  return 0
+}
@@ -0,0 +1,169 @@
+// Testing the functions in settings.go.
+package config
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Load"
+***/
+
+import (
+  "bytes"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+//The tests set environment variables, so they cannot run in parallel.
+func TestLoad(t *testing.T) {
+  dir := t.TempDir()
+  file := filepath.Join(dir, "config.json")
+  if err := os.WriteFile(file, []byte(`{"http_port": 9000, "https_port": 9443, "db_host": "file-host"}`), 0600); err != nil {
+    t.Fatal(err)
+  }
+  secret := filepath.Join(dir, "db-password")
+  if err := os.WriteFile(secret, []byte("s3cret\n"), 0600); err != nil {
+    t.Fatal(err)
+  }
+  t.Setenv("HTTPS_PORT", "9444")
+  t.Setenv("DB_HOST", "env-host")
+  t.Setenv("DB_PASSWORD_FILE", secret)
+  t.Setenv("DB_SETUP_PASSWORD", "setup-secret")
+  c, err := Load([]string{"-config", file, "-db-host", "flag-host", "-https"}, io.Discard)
+  if err != nil {
+    t.Fatalf("Load: %v", err)
+  }
+  tests := []struct {
+    name string
+    got any
+    want any
+  } {
+    { "http_port (file)", c.HttpPort, 9000 },
+    { "https_port (environment over file)", c.HttpsPort, 9444 },
+    { "db_host (flag over environment)", c.DbHost, "flag-host" },
+    { "https (boolean flag without a value)", c.Https, true },
+    { "db_password (secret file)", c.DbPassword, "s3cret" },
+    { "db_name (default)", c.DbName, "finances" },
+  }
+  for _, tc := range tests {
+    if tc.got != tc.want {
+      t.Errorf("%s = %v; want %v", tc.name, tc.got, tc.want)
+    }
+  }
+}
+
+func TestLoadErrors(t *testing.T) {
+  tests := []struct {
+    env map[string]string
+    args []string
+    want []string  //Every problem is reported.
+  } {
+    { map[string]string{ "HTTP_PORT": "abc", "PASSWORD_MIN_CLASSES": "5" }, nil,
+      []string{ `HTTP_PORT: "abc" is not an integer`, "password_min_classes (PASSWORD_MIN_CLASSES): 5 is greater than 4" } },
+    { nil, []string{ "-state-store=s3", "-http=false" },
+      []string{ `state_store (STATE_STORE): "s3" is not one of fs, postgres`, "http (HTTP) and https (HTTPS) are both false" } },
+    { map[string]string{ "MAILER": "smtp", "SIGNUP_LINK_KEY": "short" }, nil,
+      []string{ "smtp_host (SMTP_HOST) is required", "signup_link_key (SIGNUP_LINK_KEY) must be at least 32 bytes" } },
+    { map[string]string{ "SMTP_PASSWORD_FILE": "/nonexistent/smtp-password" }, nil,
+      []string{ "SMTP_PASSWORD_FILE:" } },
+    { nil, []string{ "-base-url=localhost:8080" }, []string{ `base_url (BASE_URL): "localhost:8080" is not` } },
+    { nil, []string{ "-metrics-port=8080" }, []string{ "metrics_port (METRICS_PORT) 8080 is the port of the application" } },
+    { nil, []string{ "-https", "-tls-cert-file=/etc/tls/tls.crt", "-tls-cert-days=400" },
+      []string{ "tls_cert_days (TLS_CERT_DAYS): 400 is greater than 397", "tls_cert_file (TLS_CERT_FILE) and tls_key_file " +
+      "(TLS_KEY_FILE) must be set together" } },
+    { nil, []string{ "-https", "-le-cert", "-autocert-hosts= , " }, []string{ "autocert_hosts (AUTOCERT_HOSTS) is required" } },
+    { nil, []string{ "-unknown" }, []string{ "flag provided but not defined: -unknown" } },
+    //There is no default password.
+    { nil, nil, []string{ "db_password (DB_PASSWORD) is required", "db_setup_password (DB_SETUP_PASSWORD) is required" } },
+  }
+  for _, tc := range tests {
+    t.Run(strings.Join(tc.want, "; "), func(t *testing.T) {
+      for k, v := range tc.env {
+        t.Setenv(k, v)
+      }
+      _, err := Load(tc.args, io.Discard)
+      if err == nil {
+        t.Fatal("Load did not fail")
+      }
+      for _, w := range tc.want {
+        if !strings.Contains(err.Error(), w) {
+          t.Errorf("the error %q does not contain %q", err, w)
+        }
+      }
+    })
+  }
+}
+
+func TestLoadUnknownKey(t *testing.T) {
+  file := filepath.Join(t.TempDir(), "config.json")
+  if err := os.WriteFile(file, []byte(`{"http_prot": 9000}`), 0600); err != nil {
+    t.Fatal(err)
+  }
+  t.Setenv("CONFIG_FILE", file)
+  if _, err := Load(nil, io.Discard); err == nil || !strings.Contains(err.Error(), `unknown field "http_prot"`) {
+    t.Errorf("Load = %v; want an unknown field error", err)
+  }
+}
+
+func TestCommand(t *testing.T) {
+  t.Setenv("SMTP_PASSWORD", "smtp-secret")
+  t.Setenv("DB_PASSWORD", "db-secret")
+  t.Setenv("DB_SETUP_PASSWORD", "setup-secret")
+  tests := []struct {
+    args []string
+    code int
+    contains string
+    excludes string
+  } {
+    //The secrets are masked unless they are asked for.
+    { []string{ "print" }, 0, `"smtp_password": "******"`, "smtp-secret" },
+    { []string{ "print" }, 0, `"db_password": "******"`, "db-secret" },
+    { []string{ "print", "--show-secrets" }, 0, `"smtp_password": "smtp-secret"`, "******" },
+    { []string{ "print", "-redacted" }, 2, "", "" },
+    { []string{ "print", "-http-port=0" }, 1, "", "" },
+    { []string{ "show" }, 2, "", "" },
+  }
+  for _, tc := range tests {
+    var stdout bytes.Buffer
+    if code := Command(tc.args, &stdout, io.Discard); code != tc.code {
+      t.Errorf("Command(%q) = %d; want %d", tc.args, code, tc.code)
+      continue
+    }
+    if !strings.Contains(stdout.String(), tc.contains) {
+      t.Errorf("Command(%q) does not write %q", tc.args, tc.contains)
+    }
+    if tc.excludes != "" && strings.Contains(stdout.String(), tc.excludes) {
+      t.Errorf("Command(%q) writes %q", tc.args, tc.excludes)
+    }
+  }
+}
+
+func TestGettersWithoutLoad(t *testing.T) {
+  t.Setenv("LOGIN_LOCK_AFTER", "7")
+  t.Setenv("PASSWORD_HISTORY", "99")  //Out of range; the default is used.
+  if v := GetLoginLockAfter(""); v != 7 {
+    t.Errorf("GetLoginLockAfter = %d; want 7", v)
+  }
+  if v := GetPasswordHistory(""); v != 5 {
+    t.Errorf("GetPasswordHistory = %d; want 5", v)
+  }
+}
+
+func TestDbConnString(t *testing.T) {
+  t.Parallel()
+  c := Defaults()
+  c.DbPassword = `it's a \ pass`
+  want := `host='localhost' port=5432 user='admin_user' password='it\'s a \\ pass' dbname='finances' connect_timeout=4 ` +
+    `sslmode=disable`
+  if got := c.DbConnString(); got != want {
+    t.Errorf("DbConnString = %s; want %s", got, want)
+  }
+}
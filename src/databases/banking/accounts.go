@@ -0,0 +1,124 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "time"
+)
+
+const (
+  /***
+  The banking tables identify the customer by the username stored in customers.tbl_customers; the
+  session only knows the username, so every query joins through it. This also guarantees that a
+  customer only sees their own accounts.
+  ***/
+  QR_GET_ACCOUNTS = "SELECT a.id::TEXT AS id, a.acct_name, a.acct_type, a.acct_status, a.currency_code, a.created_at " +
+   "FROM accounts.tbl_accounts a " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE c.username = $1 " +
+   "ORDER BY a.acct_name"
+  QR_ADD_ACCOUNT = "INSERT INTO accounts.tbl_accounts(customer_id, acct_name, acct_type, currency_code) " +
+   "SELECT c.id, $2, $3, $4 FROM customers.tbl_customers c WHERE c.username = $1"
+  //The balance is in the currency of the account: deposits minus debits.
+  QR_GET_ACCOUNT_BALANCES = "SELECT a.id::TEXT AS id, a.acct_name, a.acct_status, a.currency_code, " +
+   "COALESCE(SUM(CASE WHEN e.tr_type = 'deposit' THEN e.amount ELSE -e.amount END), 0)::FLOAT8 AS balance " +
+   "FROM accounts.tbl_accounts a " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "LEFT JOIN accounts.tbl_register_entries e ON e.acct_id = a.id " +
+   "WHERE c.username = $1 " +
+   "GROUP BY a.id, a.acct_name, a.acct_status, a.currency_code " +
+   "ORDER BY a.acct_name"
+  QR_GET_REPORTING_CURRENCY = "SELECT reporting_currency FROM customers.tbl_customers WHERE username = $1"
+  QR_SET_REPORTING_CURRENCY = "UPDATE customers.tbl_customers SET reporting_currency = $2, " +
+   "updated_at = CURRENT_TIMESTAMP WHERE username = $1"
+)
+
+type Account struct {  //Struct tags.
+  Id string  `db:"id"`
+  Acct_name string  `db:"acct_name"`
+  Acct_type string  `db:"acct_type"`
+  Acct_status string  `db:"acct_status"`
+  Currency_code string  `db:"currency_code"`
+  Created_at time.Time  `db:"created_at"`
+}
+
+type AccountBalance struct {  //Struct tags.
+  Id string  `db:"id"`
+  Acct_name string  `db:"acct_name"`
+  Acct_status string  `db:"acct_status"`
+  Currency_code string  `db:"currency_code"`
+  Balance float64  `db:"balance"`
+}
+
+func DbGetAccounts(ctx context.Context, userName, correlationId string) ([]Account, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_ACCOUNTS, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetAccounts: %v", err), correlationId)
+    return nil, err
+  }
+  //Automatically scans all rows into a slice of Account structs.
+  accounts, err := pgx.CollectRows(rows, pgx.RowToStructByName[Account])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetAccounts: %v", err), correlationId)
+    return nil, err
+  }
+  return accounts, nil
+}
+
+func DbAddAccount(ctx context.Context, userName, acctName, acctType, currencyCode, correlationId string) error {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_ADD_ACCOUNT, userName, acctName, acctType, currencyCode)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbAddAccount: %v", err), correlationId)
+    return err
+  } else if tag.RowsAffected() == 0 {
+    logger.LogError(fmt.Sprintf("Customer %s not found.", userName), correlationId)
+    return errors.New("Customer not found.")
+  }
+  logger.LogInfo(fmt.Sprintf("Account added. Username: %s, account: %s (%s)", userName, acctName, currencyCode),
+    correlationId)
+  return nil
+}
+
+func DbGetAccountBalances(ctx context.Context, userName, correlationId string) ([]AccountBalance, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_ACCOUNT_BALANCES, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetAccountBalances: %v", err), correlationId)
+    return nil, err
+  }
+  balances, err := pgx.CollectRows(rows, pgx.RowToStructByName[AccountBalance])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetAccountBalances: %v", err), correlationId)
+    return nil, err
+  }
+  return balances, nil
+}
+
+func DbGetReportingCurrency(ctx context.Context, userName, correlationId string) (string, error) {
+  db := GetBsInstance()
+  var currency string
+  if err := db.bsPool.QueryRow(ctx, QR_GET_REPORTING_CURRENCY, userName).Scan(&currency); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetReportingCurrency: %v", err), correlationId)
+    return "", err
+  }
+  return currency, nil
+}
+
+func DbSetReportingCurrency(ctx context.Context, userName, currencyCode, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_SET_REPORTING_CURRENCY, userName, currencyCode)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSetReportingCurrency: %v", err), correlationId)
+  }
+  return err
+}
@@ -0,0 +1,171 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "finance/finances"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "math"
+  "time"
+)
+
+const (
+  QR_GET_ACCOUNT_FOR_UPDATE = "SELECT a.id::TEXT AS id, a.acct_name, a.acct_type, a.acct_status, a.currency_code, " +
+   "a.created_at " +
+   "FROM accounts.tbl_accounts a " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE a.id = $1::UUID AND c.username = $2 " +
+   "FOR UPDATE OF a"
+  QR_GET_ACCOUNT_BALANCE = "SELECT COALESCE(SUM(CASE WHEN tr_type = 'deposit' THEN amount ELSE -amount END), 0)::FLOAT8 " +
+   "FROM accounts.tbl_register_entries WHERE acct_id = $1::UUID"
+  //The trigger on the accounts table verifies that the balance is zero when an account is closed.
+  QR_SET_ACCOUNT_STATUS = "UPDATE accounts.tbl_accounts SET acct_status = $2, updated_at = CURRENT_TIMESTAMP " +
+   "WHERE id = $1::UUID"
+  QR_ADD_ACCOUNT_STATUS_HISTORY = "INSERT INTO accounts.tbl_account_status_history(acct_id, old_status, new_status, " +
+   "changed_by) VALUES($1::UUID, $2, $3, $4)"
+  QR_GET_ACCOUNT_STATUS_HISTORY = "SELECT a.acct_name, h.old_status, h.new_status, h.changed_by, h.changed_at " +
+   "FROM accounts.tbl_account_status_history h " +
+   "JOIN accounts.tbl_accounts a ON a.id = h.acct_id " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE c.username = $1 " +
+   "ORDER BY h.changed_at DESC"
+)
+
+type AccountStatusChange struct {  //Struct tags.
+  Acct_name string  `db:"acct_name"`
+  Old_status string  `db:"old_status"`
+  New_status string  `db:"new_status"`
+  Changed_by string  `db:"changed_by"`
+  Changed_at time.Time  `db:"changed_at"`
+}
+
+/***
+Change the status of an account and record who changed it. To close an account with a positive balance, finalTransferTo is
+the account that receives the balance; the final transfer, the change of the status, and the history are written in one
+transaction. If the accounts use different currencies, the amount received is converted at the latest exchange rate.
+***/
+func DbChangeAccountStatus(ctx context.Context, userName, acctId, newStatus, finalTransferTo, changedBy,
+     correlationId string) error {
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbChangeAccountStatus: %v", err), correlationId)
+    return err
+  }
+  //Rollback is safe to call even if the tx is already closed.
+  defer tx.Rollback(ctx)
+  rows, err := tx.Query(ctx, QR_GET_ACCOUNT_FOR_UPDATE, acctId, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbChangeAccountStatus: %v", err), correlationId)
+    return err
+  }
+  acct, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Account])
+  if errors.Is(err, pgx.ErrNoRows) {
+    return errors.New("Account not found.")
+  } else if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbChangeAccountStatus: %v", err), correlationId)
+    return err
+  }
+  if !finances.ValidAccountStatusTransition(acct.Acct_status, newStatus) {
+    return fmt.Errorf("The account %s cannot change from %s to %s.", acct.Acct_name, acct.Acct_status, newStatus)
+  }
+  if newStatus == finances.AccountClosed {
+    var balance float64
+    if err = tx.QueryRow(ctx, QR_GET_ACCOUNT_BALANCE, acct.Id).Scan(&balance); err != nil {
+      logger.LogError(fmt.Sprintf("Error on DbChangeAccountStatus: %v", err), correlationId)
+      return err
+    }
+    amount, err := finances.ClosingTransferAmount(balance, finalTransferTo != "")
+    if err != nil {
+      return fmt.Errorf("The account %s was not closed: %v.", acct.Acct_name, err)
+    }
+    if amount > 0 {
+      if acct.Acct_status != finances.AccountActive {
+        //A suspended account rejects new entries, including the debit of the final transfer.
+        return errors.New("Reactivate the account to make the final transfer.")
+      }
+      if err = finalTransfer(ctx, tx, userName, &acct, finalTransferTo, amount, correlationId); err != nil {
+        return err
+      }
+    }
+  }
+  if _, err = tx.Exec(ctx, QR_SET_ACCOUNT_STATUS, acct.Id, newStatus); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbChangeAccountStatus: %v", err), correlationId)
+    return err
+  }
+  if _, err = tx.Exec(ctx, QR_ADD_ACCOUNT_STATUS_HISTORY, acct.Id, acct.Acct_status, newStatus, changedBy);
+     err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbChangeAccountStatus: %v", err), correlationId)
+    return err
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbChangeAccountStatus: %v", err), correlationId)
+    return err
+  }
+  logger.LogInfo(fmt.Sprintf("Account status changed. Username: %s, account: %s, %s -> %s, by: %s", userName,
+    acct.Acct_name, acct.Acct_status, newStatus, changedBy), correlationId)
+  return nil
+}
+
+//Move the balance of an account that is being closed to another account.
+func finalTransfer(ctx context.Context, tx pgx.Tx, userName string, acct *Account, toAcctId string, amount float64,
+     correlationId string) error {
+  rows, err := tx.Query(ctx, QR_GET_ACCOUNT_FOR_UPDATE, toAcctId, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on finalTransfer: %v", err), correlationId)
+    return err
+  }
+  to, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Account])
+  if errors.Is(err, pgx.ErrNoRows) {
+    return errors.New("The account for the final transfer was not found.")
+  } else if err != nil {
+    logger.LogError(fmt.Sprintf("Error on finalTransfer: %v", err), correlationId)
+    return err
+  }
+  today := finances.DateOnly(time.Now())
+  received := amount
+  if to.Currency_code != acct.Currency_code {
+    rt, err := DbGetRateTable(ctx, correlationId)
+    if err != nil {
+      return err
+    }
+    converted, ok := rt.Convert(amount, acct.Currency_code, to.Currency_code, today)
+    if !ok {
+      return fmt.Errorf("Missing exchange rate %s/%s for the final transfer.", acct.Currency_code, to.Currency_code)
+    }
+    received = math.Round(converted * 100) / 100
+  }
+  t := Transfer{
+    From_acct_id: acct.Id,
+    To_acct_id: to.Id,
+    Transfer_date: today,
+    Amount_sent: amount,
+    Amount_received: received,
+    Tr_description: "Final transfer; account closed",
+  }
+  //Closing twice is not possible (closed -> closed is not a valid transition), so the token only needs to be unique.
+  token := fmt.Sprintf("close:%s:%d", acct.Id, time.Now().UnixNano())
+  return createTransfer(ctx, tx, userName, token, &t, correlationId)
+}
+
+func DbGetAccountStatusHistory(ctx context.Context, userName, correlationId string) ([]AccountStatusChange, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_ACCOUNT_STATUS_HISTORY, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetAccountStatusHistory: %v", err), correlationId)
+    return nil, err
+  }
+  history, err := pgx.CollectRows(rows, pgx.RowToStructByName[AccountStatusChange])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetAccountStatusHistory: %v", err), correlationId)
+    return nil, err
+  }
+  return history, nil
+}
@@ -7,12 +7,16 @@ package banking
 
 import (
   "context"
+  "errors"
+  "finance/config"
+  "finance/security"
   "fmt"
   "github.com/jackc/pgx/v5"
   "github.com/juan-carlos-trimino/gplogger"
   "golang.org/x/crypto/bcrypt"
   "math"
   "math/rand"
+  "strings"
   "time"
 )
 
@@ -25,9 +29,59 @@ const (
   //Use placeholder syntax (like $1, $2) to safely pass parameters to the function, preventing SQL injection.
   SP_ADD_CUSTOMER = "CALL fin.add_customer($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16)"
   //Pass null for OUT parameter in the call.
-  SP_AUTHENTICATE_USER = "CALL fin.authenticate_user($1, $2, $3, null, null)"
+  SP_AUTHENTICATE_USER = "CALL fin.authenticate_user($1, $2, $3, $4, $5, $6, null, null, null)"
+  SP_UNLOCK_USER = "CALL fin.unlock_user($1, null)"
+  //Users with failed login attempts; the locked ones first.
+  QR_GET_THROTTLED_USERS = "SELECT user_name, failed_attempts, last_attempt, locked_until, " +
+   "COALESCE(locked_until > CURRENT_TIMESTAMP, FALSE) AS locked " +
+   "FROM fin.customers_credentials " +
+   "WHERE failed_attempts > 0 OR locked_until IS NOT NULL " +
+   "ORDER BY locked DESC, last_attempt DESC"
+  QR_GET_CUSTOMERS_CONTACT_DETAILS = "SELECT id, birth_date, gender, address1, address2, city_name, state_name, " +
+   "country_name, zip_code, email, phone, created_at, updated_at " +
+   "FROM fin.customers_contact_details ORDER BY id"
+  /***
+  The ORDER BY, LIMIT, and OFFSET clauses are appended by DbGetCustomers. The first letter is compared
+  byte by byte (COLLATE "C"), so that the ranges A-Z leave no gap and the other last names (digits,
+  punctuation, accented or non-Latin letters) are in the "other" range ($6).
+  ***/
+  QR_GET_CUSTOMERS = "SELECT c.id, cc.user_name, c.first_name, c.last_name, COALESCE(d.email, '') AS email, " +
+   "COALESCE(d.city_name, '') AS city_name, COALESCE(d.country_name, '') AS country_name, cc.is_admin, " +
+   "c.is_active, c.created_at, COUNT(*) OVER() AS total " +
+   "FROM fin.customers c " +
+   "JOIN fin.customers_credentials cc ON cc.id = c.id " +
+   "LEFT JOIN fin.customers_contact_details d ON d.id = c.id " +
+   "WHERE ($3 <> '' OR CASE WHEN $6 THEN UPPER(LEFT(c.last_name, 1)) COLLATE \"C\" NOT BETWEEN 'A' AND 'Z' " +
+   "ELSE UPPER(LEFT(c.last_name, 1)) COLLATE \"C\" BETWEEN $1 AND $2 END) " +
+   "AND ($3 = '' OR c.first_name ILIKE $3 OR c.last_name ILIKE $3 OR cc.user_name ILIKE $3 OR d.email ILIKE $3)"
+  QR_GET_CUSTOMER = "SELECT c.id, cc.user_name, c.first_name, c.middle_name, c.last_name, c.marketing_consent, " +
+   "cc.is_admin, cc.failed_attempts, cc.last_attempt, cc.locked_until, " +
+   "COALESCE(t.confirmed, FALSE) AS two_factor, c.is_active, c.deactivated_at, c.deactivation_reason, c.purged_at, " +
+   "d.birth_date, d.gender, d.address1, d.address2, d.city_name, d.state_name, d.country_name, d.zip_code, " +
+   "d.email, d.phone, c.created_at, c.updated_at " +
+   "FROM fin.customers c " +
+   "JOIN fin.customers_credentials cc ON cc.id = c.id " +
+   "LEFT JOIN fin.customers_contact_details d ON d.id = c.id " +
+   "LEFT JOIN fin.customers_totp t ON t.id = c.id " +
+   "WHERE c.id = $1"
+  SP_DEACTIVATE_CUSTOMER = "CALL fin.deactivate_customer($1, $2, $3, null)"
+  SP_REACTIVATE_CUSTOMER = "CALL fin.reactivate_customer($1, $2, null)"
+  SP_PURGE_CUSTOMER = "CALL fin.purge_customer($1, $2, $3, null)"
+  //The banking profile of a customer holds personal data as well; it follows the new username.
+  QR_PURGE_BANKING_CUSTOMER = "UPDATE customers.tbl_customers SET username = $2, password_hash = '!', " +
+   "first_name = 'Purged', middle_name = NULL, last_name = 'Customer', birth_date = NULL, gender = NULL, " +
+   "address_1 = 'purged', address_2 = NULL, city_name = 'purged', state_name = 'purged', country_name = 'purged', " +
+   "zip_code = NULL, primary_email = NULL, secondary_email = NULL, primary_phone = 'purged', " +
+   "secondary_phone = NULL, updated_at = CURRENT_TIMESTAMP " +
+   "WHERE username = $1"
+  QR_GET_INACTIVE_CUSTOMERS = "SELECT cc.user_name, c.first_name, c.last_name, c.deactivated_at, " +
+   "c.deactivation_reason, c.purged_at " +
+   "FROM fin.customers c " +
+   "JOIN fin.customers_credentials cc ON cc.id = c.id " +
+   "WHERE NOT c.is_active " +
+   "ORDER BY c.deactivated_at DESC NULLS LAST"
   //Change password.
-  SP_CHANGE_PASSWORD = "CALL fin.change_password($1, $2, $3, $4, null)"
+  SP_CHANGE_PASSWORD = "CALL fin.change_password($1, $2, $3, $4, $5, null)"
 )
 
 type Customer struct {
@@ -38,6 +92,89 @@ type Customer struct {
   Birth_date *time.Time
 }
 
+type ThrottledUser struct {  //Struct tags.
+  User_name string  `db:"user_name"`
+  Failed_attempts int32  `db:"failed_attempts"`
+  Last_attempt *time.Time  `db:"last_attempt"`
+  Locked_until *time.Time  `db:"locked_until"`
+  Locked bool  `db:"locked"`
+}
+
+const (
+  CustomerSortName = "name"
+  CustomerSortUsername = "username"
+  CustomerSortEmail = "email"
+  CustomerSortNewest = "newest"
+)
+
+//The only ORDER BY clauses accepted by DbGetCustomers.
+var customerSortColumns = map[string]string{
+  CustomerSortName: "c.last_name, c.first_name, cc.user_name",
+  CustomerSortUsername: "cc.user_name",
+  CustomerSortEmail: "d.email NULLS LAST, cc.user_name",
+  CustomerSortNewest: "c.created_at DESC, cc.user_name",
+}
+
+type CustomerFilter struct {
+  MinLetter, MaxLetter, Search, Sort string
+  OtherLetters bool  //The last name does not start with a letter A-Z; MinLetter and MaxLetter are ignored.
+  Limit, Offset int
+}
+
+type CustomerSummary struct {  //Struct tags.
+  Id int32  `db:"id"`
+  User_name string  `db:"user_name"`
+  First_name string  `db:"first_name"`
+  Last_name string  `db:"last_name"`
+  Email string  `db:"email"`
+  City_name string  `db:"city_name"`
+  Country_name string  `db:"country_name"`
+  Is_admin bool  `db:"is_admin"`
+  Is_active bool  `db:"is_active"`
+  Created_at time.Time  `db:"created_at"`
+  Total int64  `db:"total"`  //The number of rows that match the filter.
+}
+
+type CustomerDetails struct {  //Struct tags.
+  Id int32  `db:"id"`
+  User_name string  `db:"user_name"`
+  First_name string  `db:"first_name"`
+  Middle_name *string  `db:"middle_name"`
+  Last_name string  `db:"last_name"`
+  Marketing_consent bool  `db:"marketing_consent"`
+  Is_admin bool  `db:"is_admin"`
+  Failed_attempts int32  `db:"failed_attempts"`
+  Last_attempt *time.Time  `db:"last_attempt"`
+  Locked_until *time.Time  `db:"locked_until"`
+  Two_factor bool  `db:"two_factor"`
+  Is_active bool  `db:"is_active"`
+  Deactivated_at *time.Time  `db:"deactivated_at"`
+  Deactivation_reason *string  `db:"deactivation_reason"`
+  Purged_at *time.Time  `db:"purged_at"`
+  //Nullable; the contact details are in a separate table.
+  Birth_date *time.Time  `db:"birth_date"`
+  Gender *string  `db:"gender"`
+  Address1 *string  `db:"address1"`
+  Address2 *string  `db:"address2"`
+  City_name *string  `db:"city_name"`
+  State_name *string  `db:"state_name"`
+  Country_name *string  `db:"country_name"`
+  Zip_code *string  `db:"zip_code"`
+  Email *string  `db:"email"`
+  Phone *string  `db:"phone"`
+  Created_at *time.Time  `db:"created_at"`
+  Updated_at *time.Time  `db:"updated_at"`
+}
+
+type InactiveCustomer struct {  //Struct tags.
+  User_name string  `db:"user_name"`
+  First_name string  `db:"first_name"`
+  Last_name string  `db:"last_name"`
+  Deactivated_at *time.Time  `db:"deactivated_at"`
+  Deactivation_reason *string  `db:"deactivation_reason"`
+  Purged_at *time.Time  `db:"purged_at"`
+}
+
 type CustomersContactDetails struct {  //Struct tags.
   Id int32  `db:"id"`
   Birth_date *time.Time  `db:"birth_date"`
@@ -64,6 +201,8 @@ func DbAddCustomer(c *Customer, ctx context.Context, correlationId string) error
   } else {
     logger.LogInfo(fmt.Sprintf("SP fin.add_customer succeeded. Username: %s", c.User_name), correlationId)
   }
+  outcome, details := auditOutcome(err)
+  DbWriteAuditEvent(ctx, AuditUserRegister, c.User_name, outcome, details, correlationId)
   return err
 }
 
@@ -90,56 +229,294 @@ func HashAndSaltPassword(password, correlationId string) string {
   return string(hash)
 }
 
+/***
+The throttling (the exponential delay and the temporary lockout) is decided by fin.authenticate_user; see the
+environment variables LOGIN_DELAY_AFTER, LOGIN_LOCK_AFTER, and LOGIN_LOCK_MINUTES. The procedure returns the delay
+instead of sleeping, and the delay is waited here, after the connection is back in the pool; otherwise, a burst of
+logins for unknown or locked users would hold every connection of the pool. The caller only learns whether the user was
+authenticated, so the login page cannot reveal whether an account exists or is locked.
+***/
 func DbAuthenticateUser(ctx context.Context, userName, password, correlationId string) (bool, bool) {
   db := GetBsInstance()
   var status int
   var isAdmin bool = false
+  var delay float64  //Seconds.
   var ok bool = true
-  err := db.bsPool.QueryRow(ctx, SP_AUTHENTICATE_USER, userName, password, correlationId).Scan(&status, &isAdmin)
+  err := db.bsPool.QueryRow(ctx, SP_AUTHENTICATE_USER, userName, password, correlationId,
+    config.GetLoginDelayAfter(correlationId), config.GetLoginLockAfter(correlationId),
+    config.GetLoginLockMinutes(correlationId)).Scan(&status, &isAdmin, &delay)
   if err != nil {
     logger.LogError(fmt.Sprintf("Error on DbAuthenticateUser: %v", err), correlationId)
     ok = false
   } else if status < 0 {
+    if status == -3 {
+      logger.LogWarning(fmt.Sprintf("Login attempt for locked user %s.", userName), correlationId)
+    } else if status == -4 {
+      logger.LogWarning(fmt.Sprintf("Login attempt for inactive user %s.", userName), correlationId)
+    } else if status == -5 {
+      logger.LogWarning(fmt.Sprintf("Login attempt for user %s, whose sign-up is pending.", userName), correlationId)
+    }
     ok = false
+    //The request may end (e.g., the client goes away) before the delay does.
+    select {
+    case <-time.After(time.Duration(delay * float64(time.Second))):
+    case <-ctx.Done():
+    }
   }
   return ok, isAdmin
 }
 
-func DbGetCustomersContactDetails(ctx context.Context, correlationId string) bool {
+func DbGetThrottledUsers(ctx context.Context, correlationId string) ([]ThrottledUser, error) {
   db := GetBsInstance()
-  var ok bool = true
-  //Call the function returning SETOF/TABLE.
-  rows, err := db.bsPool.Query(ctx, "SELECT * FROM fin.get_customers_contact_details()")
+  rows, err := db.bsPool.Query(ctx, QR_GET_THROTTLED_USERS)
   if err != nil {
-    logger.LogError(fmt.Sprintf("Error on DbGetCustomersContactDetails: %v", err), correlationId)
-    ok = false
-  } else {
-    var users []CustomersContactDetails
-    //Automatically scans all rows into a slice of User structs.
-    users, err := pgx.CollectRows(rows, pgx.RowToStructByName[CustomersContactDetails])
-    if err != nil {
-      logger.LogError(fmt.Sprintf("Error on DbGetCustomersContactDetails: %v", err), correlationId)
-      ok = false
-    } else {
-      for _, user := range users {
-        fmt.Printf("Id: %d, Birth_date: %v, Gender: %s, Address1: %s, Address2: %s, City_name: %s, State_name: %s, Country_name: %s, Zip_code: %s, Email: %s, Phone: %s, Created_at: %v, Updated_at: %v\n", user.Id, user.Birth_date.Format("2006-01-02"), user.Gender, user.Address1, PtrString(user.Address2), user.City_name, user.State_name, user.Country_name, PtrString(user.Zip_code), user.Email, user.Phone, user.Created_at, user.Updated_at)
-      }
-    }
+    logger.LogError(fmt.Sprintf("Error on DbGetThrottledUsers: %v", err), correlationId)
+    return nil, err
+  }
+  users, err := pgx.CollectRows(rows, pgx.RowToStructByName[ThrottledUser])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetThrottledUsers: %v", err), correlationId)
+    return nil, err
+  }
+  return users, nil
+}
+
+//Clear the failed login attempts and the lockout of a user.
+func DbUnlockUser(ctx context.Context, userName, correlationId string) bool {
+  db := GetBsInstance()
+  var ok bool = false
+  if err := db.bsPool.QueryRow(ctx, SP_UNLOCK_USER, userName).Scan(&ok); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUnlockUser: %v", err), correlationId)
+    DbWriteAuditEvent(ctx, AuditUserUnlock, userName, AuditFailure, err.Error(), correlationId)
+    return false
+  }
+  if ok {
+    logger.LogInfo(fmt.Sprintf("User %s unlocked.", userName), correlationId)
   }
+  DbWriteAuditEvent(ctx, AuditUserUnlock, userName, auditOutcomeOk(ok), "", correlationId)
   return ok
 }
 
-func DbChangePassword(ctx context.Context, userName, oldPassword, newPassword, correlationId string) bool {
+//Unregister a customer; it cannot log in until it is reactivated.
+func DbDeactivateCustomer(ctx context.Context, userName, reason, performedBy, correlationId string) bool {
   db := GetBsInstance()
-  var ok bool = true
-  err := db.bsPool.QueryRow(ctx, SP_CHANGE_PASSWORD, userName, oldPassword, newPassword, correlationId).Scan(&ok)
+  var ok bool = false
+  err := db.bsPool.QueryRow(ctx, SP_DEACTIVATE_CUSTOMER, userName, StringPtr(reason), performedBy).Scan(&ok)
   if err != nil {
-    logger.LogError(fmt.Sprintf("Error on DbGetCustomersContactDetails: %v", err), correlationId)
-    ok = false
+    logger.LogError(fmt.Sprintf("Error on DbDeactivateCustomer: %v", err), correlationId)
+    DbWriteAuditEvent(ctx, AuditUserDeactivate, userName, AuditFailure, err.Error(), correlationId)
+    return false
+  }
+  if ok {
+    logger.LogInfo(fmt.Sprintf("Customer %s deactivated by %s.", userName, performedBy), correlationId)
+    //The sessions the customer may still have end on this instance at once; see security.IsSessionEnded.
+    security.ForgetUserSessions(userName)
   }
+  DbWriteAuditEvent(ctx, AuditUserDeactivate, userName, auditOutcomeOk(ok), reason, correlationId)
   return ok
 }
 
+func DbReactivateCustomer(ctx context.Context, userName, performedBy, correlationId string) bool {
+  db := GetBsInstance()
+  var ok bool = false
+  if err := db.bsPool.QueryRow(ctx, SP_REACTIVATE_CUSTOMER, userName, performedBy).Scan(&ok); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbReactivateCustomer: %v", err), correlationId)
+    DbWriteAuditEvent(ctx, AuditUserReactivate, userName, AuditFailure, err.Error(), correlationId)
+    return false
+  }
+  if ok {
+    logger.LogInfo(fmt.Sprintf("Customer %s reactivated by %s.", userName, performedBy), correlationId)
+    security.ForgetUserSessions(userName)
+  }
+  DbWriteAuditEvent(ctx, AuditUserReactivate, userName, auditOutcomeOk(ok), "", correlationId)
+  return ok
+}
+
+/***
+Erase the personal data of an inactive customer (legal erasure); see fin.purge_customer. The rows
+are kept with placeholders so that the accounts and the audit records remain valid. It returns the
+new username of the customer.
+***/
+func DbPurgeCustomer(ctx context.Context, userName, reason, performedBy, correlationId string) (string, error) {
+  newUserName, err := purgeCustomer(ctx, userName, reason, performedBy, correlationId)
+  if err != nil {
+    DbWriteAuditEvent(ctx, AuditUserPurge, userName, AuditFailure, err.Error(), correlationId)
+  } else {
+    //The erased username is not written again; the event refers to the placeholder.
+    DbWriteAuditEvent(ctx, AuditUserPurge, newUserName, AuditSuccess, reason, correlationId)
+  }
+  return newUserName, err
+}
+
+func purgeCustomer(ctx context.Context, userName, reason, performedBy, correlationId string) (string, error) {
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbPurgeCustomer: %v", err), correlationId)
+    return "", err
+  }
+  defer tx.Rollback(ctx)
+  var newUserName *string
+  if err = tx.QueryRow(ctx, SP_PURGE_CUSTOMER, userName, reason, performedBy).Scan(&newUserName); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbPurgeCustomer: %v", err), correlationId)
+    return "", err
+  }
+  if newUserName == nil {
+    return "", errors.New("only inactive customers who are not admins can be purged")
+  }
+  if _, err = tx.Exec(ctx, QR_PURGE_BANKING_CUSTOMER, userName, *newUserName); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbPurgeCustomer: %v", err), correlationId)
+    return "", err
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbPurgeCustomer: %v", err), correlationId)
+    return "", err
+  }
+  logger.LogWarning(fmt.Sprintf("Customer %s purged by %s; now %s.", userName, performedBy, *newUserName),
+    correlationId)
+  return *newUserName, nil
+}
+
+func DbGetInactiveCustomers(ctx context.Context, correlationId string) ([]InactiveCustomer, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_INACTIVE_CUSTOMERS)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetInactiveCustomers: %v", err), correlationId)
+    return nil, err
+  }
+  customers, err := pgx.CollectRows(rows, pgx.RowToStructByName[InactiveCustomer])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetInactiveCustomers: %v", err), correlationId)
+    return nil, err
+  }
+  return customers, nil
+}
+
+func DbGetCustomersContactDetails(ctx context.Context, correlationId string) ([]CustomersContactDetails, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_CUSTOMERS_CONTACT_DETAILS)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetCustomersContactDetails: %v", err), correlationId)
+    return nil, err
+  }
+  //Automatically scans all rows into a slice of structs.
+  users, err := pgx.CollectRows(rows, pgx.RowToStructByName[CustomersContactDetails])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetCustomersContactDetails: %v", err), correlationId)
+    return nil, err
+  }
+  return users, nil
+}
+
+//Escape the LIKE wildcards so that the search text is matched literally.
+func escapeLike(s string) string {
+  return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+/***
+One page of the customer directory and the number of customers that match the filter. The last
+name must start with a letter between f.MinLetter and f.MaxLetter, or with anything else if
+f.OtherLetters; the search text is matched against the names, the username, and the email of all
+the customers, whatever the range.
+***/
+func DbGetCustomers(ctx context.Context, f CustomerFilter, correlationId string) ([]CustomerSummary, int, error) {
+  db := GetBsInstance()
+  orderBy, ok := customerSortColumns[f.Sort]
+  if !ok {
+    orderBy = customerSortColumns[CustomerSortName]
+  }
+  search := ""
+  if s := strings.TrimSpace(f.Search); s != "" {
+    search = "%" + escapeLike(s) + "%"
+  }
+  //The ORDER BY clause comes from the map above, never from the request.
+  query := QR_GET_CUSTOMERS + " ORDER BY " + orderBy + " LIMIT $4 OFFSET $5"
+  rows, err := db.bsPool.Query(ctx, query, strings.ToUpper(f.MinLetter), strings.ToUpper(f.MaxLetter), search,
+    f.Limit, f.Offset, f.OtherLetters)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetCustomers: %v", err), correlationId)
+    return nil, 0, err
+  }
+  customers, err := pgx.CollectRows(rows, pgx.RowToStructByName[CustomerSummary])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetCustomers: %v", err), correlationId)
+    return nil, 0, err
+  }
+  total := 0
+  if len(customers) > 0 {
+    total = int(customers[0].Total)
+  }
+  return customers, total, nil
+}
+
+func DbGetCustomer(ctx context.Context, id int32, correlationId string) (*CustomerDetails, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_CUSTOMER, id)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetCustomer: %v", err), correlationId)
+    return nil, err
+  }
+  c, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[CustomerDetails])
+  if err != nil {
+    if !errors.Is(err, pgx.ErrNoRows) {
+      logger.LogError(fmt.Sprintf("Error on DbGetCustomer: %v", err), correlationId)
+    }
+    return nil, err
+  }
+  return c, nil
+}
+
+var (
+  ErrPasswordPolicy = errors.New("The password does not meet the password policy.")
+  ErrInvalidPassword = errors.New("Unknown user or wrong old password.")
+  ErrPasswordReused = errors.New("The new password was used recently; choose another one.")
+)
+
+/***
+The password policy comes from the environment variables PASSWORD_MIN_LENGTH, PASSWORD_MIN_CLASSES,
+PASSWORD_MIN_SCORE, and BREACHED_PASSWORDS_DIR; the reuse of the last PASSWORD_HISTORY passwords is
+checked by fin.change_password.
+***/
+func NewPasswordPolicy(correlationId string) security.PasswordPolicy {
+  return security.PasswordPolicy{
+    MinLength: config.GetPasswordMinLength(correlationId),
+    MinClasses: config.GetPasswordMinClasses(correlationId),
+    MinScore: config.GetPasswordMinScore(correlationId),
+    BreachedDir: config.GetBreachedPasswordsDir(),
+  }
+}
+
+//Return the messages for the password field; none if the password is acceptable.
+func ValidatePassword(password, userName, correlationId string) []string {
+  msgs, err := NewPasswordPolicy(correlationId).Validate(password, userName)
+  if err != nil {
+    //The other rules were applied; do not lock users out because the list is unreadable.
+    logger.LogError(fmt.Sprintf("Error on ValidatePassword: %v", err), correlationId)
+  }
+  return msgs
+}
+
+/***
+The caller validates the new password (see ValidatePassword); the database checks the old password
+and the password history.
+***/
+func DbChangePassword(ctx context.Context, userName, oldPassword, newPassword, correlationId string) error {
+  db := GetBsInstance()
+  var ret int32
+  err := db.bsPool.QueryRow(ctx, SP_CHANGE_PASSWORD, userName, oldPassword, newPassword,
+    config.GetPasswordHistory(correlationId), correlationId).Scan(&ret)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbChangePassword: %v", err), correlationId)
+  } else if ret == -2 {
+    err = ErrPasswordReused
+  } else if ret != 0 {
+    err = ErrInvalidPassword
+  }
+  outcome, details := auditOutcome(err)
+  DbWriteAuditEvent(ctx, AuditPasswordChange, userName, outcome, details, correlationId)
+  return err
+}
+
 
 
 //////////////////////
@@ -167,13 +544,3 @@ func retryWithExponentialBackoff(retries int, randomFactor float64, baseTime tim
   // Sleep for the backoff interval before retrying.
   time.Sleep(nextBackoff)
 }
-
-
-// ValidatePassword checks password complexity requirements
-// func ValidatePassword(password string) error {
-//     if len(password) < 8 {
-//         return errors.New("password must be at least 8 characters")
-//     }
-//     // Add more password validation rules as needed
-//     return nil
-// }
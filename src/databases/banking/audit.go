@@ -0,0 +1,219 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "strings"
+  "time"
+)
+
+/***
+Security audit log (fin.audit_events, append-only). The web layer puts who is acting and from where
+in the context of the request (see WithAuditInfo); the functions that change the security state
+(registering a user, changing a password, the admin actions) write their events with it, and the
+login and logout pages write theirs.
+***/
+const (
+  AuditSuccess = "success"
+  AuditFailure = "failure"
+  AuditDenied = "denied"
+)
+
+//The event types.
+const (
+  AuditLogin = "login"
+  AuditSecondFactor = "login.second_factor"
+  AuditLogout = "logout"
+  AuditAccessDenied = "access.denied"
+  AuditUserRegister = "user.register"
+  AuditUserSignup = "user.signup"
+  AuditSignupVerify = "user.signup_verify"
+  AuditSignupReview = "user.signup_review"
+  AuditUserDeactivate = "user.deactivate"
+  AuditUserReactivate = "user.reactivate"
+  AuditUserPurge = "user.purge"
+  AuditUserUnlock = "user.unlock"
+  AuditPasswordChange = "password.change"
+  AuditPasswordResetRequest = "password.reset_request"
+  AuditPasswordReset = "password.reset"
+  AuditTwoFactorReset = "twofactor.reset"
+  AuditTwoFactorEnrollLink = "twofactor.enroll_link"
+  AuditRolesChange = "roles.change"
+  AuditSettingsChange = "settings.change"
+)
+
+var AuditEventTypes = []string{
+  AuditLogin, AuditSecondFactor, AuditLogout, AuditAccessDenied, AuditUserRegister, AuditUserSignup,
+  AuditSignupVerify, AuditSignupReview, AuditUserDeactivate,
+  AuditUserReactivate, AuditUserPurge, AuditUserUnlock, AuditPasswordChange, AuditPasswordResetRequest,
+  AuditPasswordReset, AuditTwoFactorReset, AuditTwoFactorEnrollLink, AuditRolesChange, AuditSettingsChange,
+}
+
+const (
+  QR_ADD_AUDIT_EVENT = "INSERT INTO fin.audit_events(event_type, actor, target, client_ip, outcome, correlation_id, " +
+   "details) VALUES($1, $2, $3, $4, $5, $6, $7)"
+  //The LIMIT and OFFSET clauses are appended by DbSearchAuditEvents.
+  QR_SEARCH_AUDIT_EVENTS = "SELECT id, occurred_at, event_type, actor, target, client_ip, outcome, correlation_id, " +
+   "details, COUNT(*) OVER() AS total " +
+   "FROM fin.audit_events " +
+   "WHERE ($1::TIMESTAMPTZ IS NULL OR occurred_at >= $1) " +
+   "AND ($2::TIMESTAMPTZ IS NULL OR occurred_at < $2) " +
+   "AND ($3 = '' OR event_type = $3) " +
+   "AND ($4 = '' OR actor ILIKE $4 OR target ILIKE $4 OR client_ip ILIKE $4 OR correlation_id ILIKE $4) " +
+   "AND ($5 = '' OR outcome = $5) " +
+   "ORDER BY occurred_at DESC, id DESC"
+  SP_PURGE_AUDIT_EVENTS = "CALL fin.purge_audit_events($1, null)"
+)
+
+type auditInfoKey struct{}
+
+type AuditInfo struct {
+  Actor string
+  ClientIp string
+}
+
+//Who is acting and from where; the audit events written with the returned context carry it.
+func WithAuditInfo(ctx context.Context, actor, clientIp string) context.Context {
+  return context.WithValue(ctx, auditInfoKey{}, AuditInfo{ Actor: actor, ClientIp: clientIp })
+}
+
+func AuditInfoFrom(ctx context.Context) AuditInfo {
+  if info, ok := ctx.Value(auditInfoKey{}).(AuditInfo); ok {
+    if info.Actor == "" {
+      info.Actor = "anonymous"
+    }
+    return info
+  }
+  return AuditInfo{ Actor: "system" }
+}
+
+func nullIfEmpty(s string) *string {
+  if s == "" {
+    return nil
+  }
+  return &s
+}
+
+/***
+Write an audit event; the actor and the client IP come from the context (see WithAuditInfo). An
+error is logged but not returned: the action was already done (or refused), and the log must show
+that the event is missing.
+***/
+func DbWriteAuditEvent(ctx context.Context, eventType, target, outcome, details, correlationId string) {
+  db := GetBsInstance()
+  info := AuditInfoFrom(ctx)
+  //The event is written even if the request was canceled.
+  _, err := db.bsPool.Exec(context.WithoutCancel(ctx), QR_ADD_AUDIT_EVENT, eventType, info.Actor, nullIfEmpty(target),
+    nullIfEmpty(info.ClientIp), outcome, nullIfEmpty(correlationId), nullIfEmpty(details))
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbWriteAuditEvent (%s, %s, %s, %s): %v", eventType, info.Actor, target, outcome,
+      err), correlationId)
+  }
+}
+
+//The outcome of an action that returned an error.
+func auditOutcome(err error) (string, string) {
+  if err != nil {
+    return AuditFailure, err.Error()
+  }
+  return AuditSuccess, ""
+}
+
+func auditOutcomeOk(ok bool) string {
+  if ok {
+    return AuditSuccess
+  }
+  return AuditFailure
+}
+
+type AuditEvent struct {  //Struct tags.
+  Id int64  `db:"id"`
+  Occurred_at time.Time  `db:"occurred_at"`
+  Event_type string  `db:"event_type"`
+  Actor string  `db:"actor"`
+  //Nullable types.
+  Target *string  `db:"target"`
+  Client_ip *string  `db:"client_ip"`
+  Outcome string  `db:"outcome"`
+  Correlation_id *string  `db:"correlation_id"`
+  Details *string  `db:"details"`
+  Total int64  `db:"total"`  //The number of rows that match the filter.
+}
+
+type AuditFilter struct {
+  //Nil for no bound; To is exclusive.
+  From, To *time.Time
+  EventType, Outcome string
+  //Matched against the actor, the target, the client IP, and the correlation ID.
+  Search string
+  //Zero for no limit (the export).
+  Limit, Offset int
+}
+
+//The events that match the filter, newest first, and the number of them.
+func DbSearchAuditEvents(ctx context.Context, f AuditFilter, correlationId string) ([]AuditEvent, int, error) {
+  db := GetBsInstance()
+  search := ""
+  if s := strings.TrimSpace(f.Search); s != "" {
+    search = "%" + escapeLike(s) + "%"
+  }
+  query := QR_SEARCH_AUDIT_EVENTS
+  args := []any{ f.From, f.To, f.EventType, search, f.Outcome }
+  if f.Limit > 0 {
+    query += " LIMIT $6 OFFSET $7"
+    args = append(args, f.Limit, f.Offset)
+  }
+  rows, err := db.bsPool.Query(ctx, query, args...)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSearchAuditEvents: %v", err), correlationId)
+    return nil, 0, err
+  }
+  events, err := pgx.CollectRows(rows, pgx.RowToStructByName[AuditEvent])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSearchAuditEvents: %v", err), correlationId)
+    return nil, 0, err
+  }
+  total := 0
+  if len(events) > 0 {
+    total = int(events[0].Total)
+  }
+  return events, total, nil
+}
+
+//Delete the events older than the retention period (in days); it returns the number deleted.
+func DbPurgeAuditEvents(ctx context.Context, days int, correlationId string) (int64, error) {
+  db := GetBsInstance()
+  var deleted int64
+  if err := db.bsPool.QueryRow(ctx, SP_PURGE_AUDIT_EVENTS, days).Scan(&deleted); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbPurgeAuditEvents: %v", err), correlationId)
+    return 0, err
+  }
+  return deleted, nil
+}
+
+//Apply the retention policy once a day; it stops when the context is done.
+func StartAuditRetention(ctx context.Context, days int, correlationId string) {
+  logger.LogInfo(fmt.Sprintf("Starting the audit-log retention (%d days).", days), correlationId)
+  go func() {
+    ticker := time.NewTicker(24 * time.Hour)
+    defer ticker.Stop()
+    for {
+      if deleted, err := DbPurgeAuditEvents(ctx, days, correlationId); err == nil && deleted > 0 {
+        logger.LogInfo(fmt.Sprintf("Audit-log retention deleted %d event(s).", deleted), correlationId)
+      }
+      select {
+      case <- ctx.Done():
+        logger.LogInfo("Stopping the audit-log retention.", correlationId)
+        return
+      case <- ticker.C:
+      }
+    }
+  }()
+}
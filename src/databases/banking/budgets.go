@@ -0,0 +1,196 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "finance/finances"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "time"
+)
+
+const (
+  /***
+  A recursive CTE walks the hierarchy from the top-level categories down; path holds the full name of the category (e.g.,
+  "Food > Groceries") and sorting by it lists every subcategory right after its parent.
+  ***/
+  QR_GET_CATEGORIES = "WITH RECURSIVE tree AS (" +
+   "SELECT cat.id, cat.parent_id, cat.cat_name, cat.rollover, cat.cat_name::TEXT AS path " +
+   "FROM accounts.tbl_categories cat " +
+   "JOIN customers.tbl_customers c ON c.id = cat.customer_id " +
+   "WHERE c.username = $1 AND cat.parent_id IS NULL " +
+   "UNION ALL " +
+   "SELECT cat.id, cat.parent_id, cat.cat_name, cat.rollover, tree.path || ' > ' || cat.cat_name " +
+   "FROM accounts.tbl_categories cat " +
+   "JOIN tree ON cat.parent_id = tree.id) " +
+   "SELECT id::TEXT AS id, parent_id::TEXT AS parent_id, cat_name, rollover, path FROM tree ORDER BY path"
+  //An empty parent id creates a top-level category; otherwise, the parent must belong to the customer.
+  QR_ADD_CATEGORY = "INSERT INTO accounts.tbl_categories(customer_id, parent_id, cat_name, rollover) " +
+   "SELECT c.id, p.id, $3, $4 " +
+   "FROM customers.tbl_customers c " +
+   "LEFT JOIN accounts.tbl_categories p ON p.id = NULLIF($2, '')::UUID AND p.customer_id = c.id " +
+   "WHERE c.username = $1 AND (NULLIF($2, '') IS NULL OR p.id IS NOT NULL)"
+  QR_OWNS_CATEGORY = "EXISTS (SELECT 1 FROM customers.tbl_customers c WHERE c.id = cat.customer_id AND c.username = $2)"
+  QR_DELETE_CATEGORY = "DELETE FROM accounts.tbl_categories cat WHERE cat.id = $1::UUID AND " + QR_OWNS_CATEGORY
+  QR_SET_BUDGET = "INSERT INTO accounts.tbl_budgets(category_id, budget_month, amount) " +
+   "SELECT cat.id, $3, $4 FROM accounts.tbl_categories cat WHERE cat.id = $1::UUID AND " + QR_OWNS_CATEGORY +
+   " ON CONFLICT (category_id, budget_month) DO UPDATE SET amount = EXCLUDED.amount, updated_at = CURRENT_TIMESTAMP"
+  //One row per category and month in [$2, $3]; months without a budget have a budget of zero.
+  QR_GET_BUDGETS_BY_MONTH = "SELECT cat.id::TEXT AS category_id, m.month::DATE AS budget_month, " +
+   "COALESCE(b.amount, 0)::FLOAT8 AS budgeted " +
+   "FROM accounts.tbl_categories cat " +
+   "JOIN customers.tbl_customers c ON c.id = cat.customer_id " +
+   "CROSS JOIN generate_series($2::DATE, $3::DATE, INTERVAL '1 month') AS m(month) " +
+   "LEFT JOIN accounts.tbl_budgets b ON b.category_id = cat.id AND b.budget_month = m.month " +
+   "WHERE c.username = $1 " +
+   "ORDER BY cat.id, m.month"
+  /***
+  The net spending of each category by month and currency in [$2, $3 + 1 month): debits minus deposits (e.g., refunds). The
+  amounts are converted into the reporting currency in Go.
+  ***/
+  QR_GET_SPENDING_BY_MONTH = "SELECT e.category_id::TEXT AS category_id, " +
+   "DATE_TRUNC('month', e.payment_date)::DATE AS budget_month, e.currency_code, " +
+   "SUM(CASE WHEN e.tr_type = 'debit' THEN e.amount ELSE -e.amount END)::FLOAT8 AS amount " +
+   "FROM accounts.tbl_register_entries e " +
+   "JOIN accounts.tbl_categories cat ON cat.id = e.category_id " +
+   "JOIN customers.tbl_customers c ON c.id = cat.customer_id " +
+   "WHERE c.username = $1 AND e.payment_date >= $2 AND e.payment_date < $3::DATE + INTERVAL '1 month' " +
+   "GROUP BY 1, 2, 3"
+)
+
+type Category struct {  //Struct tags.
+  Id string  `db:"id"`
+  //Nullable types.
+  Parent_id *string  `db:"parent_id"`
+  Cat_name string  `db:"cat_name"`
+  Rollover string  `db:"rollover"`
+  Path string  `db:"path"`
+}
+
+type BudgetActual struct {  //Struct tags.
+  Category_id string  `db:"category_id"`
+  Budget_month time.Time  `db:"budget_month"`
+  Budgeted float64  `db:"budgeted"`
+  //Computed in Go; the dash tells pgx to ignore the fields.
+  Actual float64  `db:"-"`
+  //True when some spending could not be converted into the reporting currency (no exchange rate).
+  Unconverted bool  `db:"-"`
+}
+
+type spending struct {  //Struct tags.
+  Category_id string  `db:"category_id"`
+  Budget_month time.Time  `db:"budget_month"`
+  Currency_code string  `db:"currency_code"`
+  Amount float64  `db:"amount"`
+}
+
+//The first day of the month of t.
+func FirstOfMonth(t time.Time) time.Time {
+  return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func DbGetCategories(ctx context.Context, userName, correlationId string) ([]Category, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_CATEGORIES, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetCategories: %v", err), correlationId)
+    return nil, err
+  }
+  categories, err := pgx.CollectRows(rows, pgx.RowToStructByName[Category])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetCategories: %v", err), correlationId)
+    return nil, err
+  }
+  return categories, nil
+}
+
+func DbAddCategory(ctx context.Context, userName, parentId, catName, rollover, correlationId string) error {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_ADD_CATEGORY, userName, parentId, catName, rollover)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbAddCategory: %v", err), correlationId)
+    return err
+  } else if tag.RowsAffected() == 0 {
+    logger.LogError(fmt.Sprintf("Parent category %s not found for user %s.", parentId, userName), correlationId)
+    return errors.New("Parent category not found.")
+  }
+  logger.LogInfo(fmt.Sprintf("Category added. Username: %s, category: %s", userName, catName), correlationId)
+  return nil
+}
+
+//Deleting a category deletes its subcategories and budgets; its register entries become uncategorized.
+func DbDeleteCategory(ctx context.Context, userName, categoryId, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_DELETE_CATEGORY, categoryId, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbDeleteCategory: %v", err), correlationId)
+  }
+  return err
+}
+
+//Create or update the budget of a category for the month of the given date.
+func DbSetBudget(ctx context.Context, userName, categoryId string, month time.Time, amount float64,
+     correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_SET_BUDGET, categoryId, userName, FirstOfMonth(month), amount)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSetBudget: %v", err), correlationId)
+  }
+  return err
+}
+
+/***
+Return the budgeted and actual amounts of every category of the customer for each month from the month of from to the month
+of to. The rows are ordered by category and month. The budgets are in the reporting currency; the spending in other currencies
+is converted at the rate of the last day of its month.
+***/
+func DbGetBudgetActuals(ctx context.Context, userName string, from, to time.Time, rt *finances.RateTable,
+     reportingCurrency, correlationId string) ([]BudgetActual, error) {
+  db := GetBsInstance()
+  from, to = FirstOfMonth(from), FirstOfMonth(to)
+  rows, err := db.bsPool.Query(ctx, QR_GET_BUDGETS_BY_MONTH, userName, from, to)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetBudgetActuals: %v", err), correlationId)
+    return nil, err
+  }
+  actuals, err := pgx.CollectRows(rows, pgx.RowToStructByName[BudgetActual])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetBudgetActuals: %v", err), correlationId)
+    return nil, err
+  }
+  rows, err = db.bsPool.Query(ctx, QR_GET_SPENDING_BY_MONTH, userName, from, to)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetBudgetActuals: %v", err), correlationId)
+    return nil, err
+  }
+  spent, err := pgx.CollectRows(rows, pgx.RowToStructByName[spending])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetBudgetActuals: %v", err), correlationId)
+    return nil, err
+  }
+  index := make(map[string]int, len(actuals))  //key: category id + month
+  for idx, a := range actuals {
+    index[a.Category_id + a.Budget_month.Format("2006-01")] = idx
+  }
+  for _, sp := range spent {
+    idx, ok := index[sp.Category_id + sp.Budget_month.Format("2006-01")]
+    if !ok {
+      continue
+    }
+    lastDay := sp.Budget_month.AddDate(0, 1, -1)
+    if amount, ok := rt.Convert(sp.Amount, sp.Currency_code, reportingCurrency, lastDay); ok {
+      actuals[idx].Actual += amount
+    } else {
+      actuals[idx].Unconverted = true
+      logger.LogWarning(fmt.Sprintf("No exchange rate for %s/%s on %s.", sp.Currency_code, reportingCurrency,
+        lastDay.Format("2006-01-02")), correlationId)
+    }
+  }
+  return actuals, nil
+}
@@ -0,0 +1,129 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "finance/finances"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/jackc/pgx/v5/pgconn"
+  "github.com/juan-carlos-trimino/gplogger"
+  "time"
+)
+
+const (
+  QR_GET_EXCHANGE_RATES = "SELECT rate_date, from_currency, to_currency, rate::FLOAT8 AS rate, rate_source " +
+   "FROM accounts.tbl_exchange_rates ORDER BY rate_date DESC, from_currency, to_currency"
+  QR_SET_EXCHANGE_RATE = "INSERT INTO accounts.tbl_exchange_rates(rate_date, from_currency, to_currency, rate, rate_source) " +
+   "VALUES($1, $2, $3, $4, $5) " +
+   "ON CONFLICT (rate_date, from_currency, to_currency) DO UPDATE SET rate = EXCLUDED.rate, " +
+   "rate_source = EXCLUDED.rate_source, updated_at = CURRENT_TIMESTAMP"
+  QR_ADD_FX_DIFFERENCE = "INSERT INTO accounts.tbl_fx_differences(debit_entry_id, credit_entry_id, reporting_currency, " +
+   "amount, rate_date) VALUES($1::UUID, $2::UUID, $3, $4, $5) ON CONFLICT DO NOTHING"
+  //
+  RateSourceManual string = "manual"
+  RateSourceCsv string = "csv"
+)
+
+type ExchangeRate struct {  //Struct tags.
+  Rate_date time.Time  `db:"rate_date"`
+  From_currency string  `db:"from_currency"`
+  To_currency string  `db:"to_currency"`
+  Rate float64  `db:"rate"`
+  Rate_source string  `db:"rate_source"`
+}
+
+//Both *pgxpool.Pool and pgx.Tx satisfy execer.
+type execer interface {
+  Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+}
+
+func DbGetExchangeRates(ctx context.Context, correlationId string) ([]ExchangeRate, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_EXCHANGE_RATES)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetExchangeRates: %v", err), correlationId)
+    return nil, err
+  }
+  rates, err := pgx.CollectRows(rows, pgx.RowToStructByName[ExchangeRate])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetExchangeRates: %v", err), correlationId)
+    return nil, err
+  }
+  return rates, nil
+}
+
+//Load every exchange rate into a table for the conversions.
+func DbGetRateTable(ctx context.Context, correlationId string) (*finances.RateTable, error) {
+  rates, err := DbGetExchangeRates(ctx, correlationId)
+  if err != nil {
+    return nil, err
+  }
+  list := make([]finances.ExchangeRate, 0, len(rates))
+  for _, r := range rates {
+    list = append(list, finances.ExchangeRate{ Date: r.Rate_date, From: r.From_currency, To: r.To_currency, Rate: r.Rate })
+  }
+  return finances.NewRateTable(list), nil
+}
+
+//Create or replace the rate of a currency pair for a date.
+func DbSetExchangeRate(ctx context.Context, rate finances.ExchangeRate, source, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_SET_EXCHANGE_RATE, finances.DateOnly(rate.Date), rate.From, rate.To, rate.Rate, source)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSetExchangeRate: %v", err), correlationId)
+  }
+  return err
+}
+
+//Import the rates in a single transaction; either all of them are stored or none.
+func DbImportExchangeRates(ctx context.Context, rates []finances.ExchangeRate, correlationId string) (int, error) {
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbImportExchangeRates: %v", err), correlationId)
+    return 0, err
+  }
+  //Rollback is safe to call even if the tx is already closed.
+  defer tx.Rollback(ctx)
+  for _, r := range rates {
+    if _, err = tx.Exec(ctx, QR_SET_EXCHANGE_RATE, finances.DateOnly(r.Date), r.From, r.To, r.Rate, RateSourceCsv); err != nil {
+      logger.LogError(fmt.Sprintf("Error on DbImportExchangeRates: %v", err), correlationId)
+      return 0, err
+    }
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbImportExchangeRates: %v", err), correlationId)
+    return 0, err
+  }
+  logger.LogInfo(fmt.Sprintf("Imported %d exchange rates.", len(rates)), correlationId)
+  return len(rates), nil
+}
+
+/***
+Record the realized FX difference of a transfer between accounts in different currencies. It must run in the transaction
+that posts the transfer; if there is no rate for the transfer date, nothing is recorded and false is returned.
+***/
+func DbRecordFxDifference(ctx context.Context, e execer, rt *finances.RateTable, debitEntryId, creditEntryId string,
+     sent float64, sentCurrency string, received float64, receivedCurrency, reportingCurrency string, date time.Time,
+     correlationId string) (bool, error) {
+  if sentCurrency == receivedCurrency {
+    return false, nil
+  }
+  diff, ok := rt.RealizedFxDifference(sent, sentCurrency, received, receivedCurrency, reportingCurrency, date)
+  if !ok {
+    logger.LogWarning(fmt.Sprintf("No exchange rate for %s/%s/%s on %s; the FX difference was not recorded.",
+      sentCurrency, receivedCurrency, reportingCurrency, date.Format("2006-01-02")), correlationId)
+    return false, nil
+  }
+  if _, err := e.Exec(ctx, QR_ADD_FX_DIFFERENCE, debitEntryId, creditEntryId, reportingCurrency, diff,
+     finances.DateOnly(date)); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbRecordFxDifference: %v", err), correlationId)
+    return false, err
+  }
+  return true, nil
+}
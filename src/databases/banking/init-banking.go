@@ -7,17 +7,13 @@ package banking
 
 import (
   "context"
+  "errors"
   "fmt"
-  "finance/config"
+  "finance/tracing"
   "github.com/jackc/pgx/v5"
   "github.com/jackc/pgx/v5/pgconn"
   "github.com/jackc/pgx/v5/pgxpool"
-  "github.com/juan-carlos-trimino/go-os"
   "github.com/juan-carlos-trimino/gplogger"
-  "os/exec"
-  "regexp"
-  "strconv"
-  "strings"
   "sync"
   "time"
 )
@@ -82,6 +78,8 @@ func InitializeBsPool(ctx context.Context, connString, correlationId string) *ba
       config.BeforeClose = func(conn *pgx.Conn) {
         logger.LogInfo("Before a connection is closed and removed from the pool.", correlationId)
       }
+      //A span for every query done for a traced request.
+      config.ConnConfig.Tracer = tracing.PgxTracer{}
       // Set default query execution timeout
       // config.ConnConfig.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
       //Set the notice handler to capture a RAISE NOTICE (or INFO, WARNING, LOG, DEBUG) message.
@@ -109,47 +107,6 @@ func GetBsInstance() (*banking) {
   }
 }
 
-func ExecuteSqlScript(host, user, password, defaultDb, targetDb, sslmode string, port, connect_timeout int, pathToScript,
-     correlationId string) bool {
-  for _, str := range strings.Split(osu.ShowPermissions(pathToScript, false), "\n") {
-    if str != "" {
-      logger.LogInfo(str, correlationId)
-    }
-  }
-  //Strict input validation: Allow only letters, numbers, and underscores.
-  //It stops malicious SQL characters (like ';', '--', '"') completely (preventing SQL injection).
-  isValidName := regexp.MustCompile(`^[a-zA-Z0-9_]+$`).MatchString
-  if !isValidName(targetDb) {
-    logger.LogError(fmt.Sprintf("Invalid database name %q: Names must only contain alphanumeric characters or underscores", targetDb),
-      correlationId)
-    return false
-  }
-  //Using a connection URI (recommended).
-  //postgresql://[user[:password]@][host[:port]]/[dbname][?option1=value1&option2=value2]
-  // var connString string = fmt.Sprintf("postgres://%s:%s@%s:%d/%s?sslmode=disable", user, password, host, port, dbname)
-  //Using key-value pairs.
-  connString := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s connect_timeout=%d sslmode=%s", host, port, user, password,
-    defaultDb, connect_timeout, sslmode)
-  // logger.LogInfo(fmt.Sprintf("Connection string: %s", connString), correlationId)
-  //psql accepts two distinct connection string formats: URIs and Key-Value.
-  cmd := exec.Command("psql", connString, "-f", pathToScript,
-    "-v", fmt.Sprintf("ALWAYS_DB_ADMIN=%s", strconv.FormatBool(config.GetAlwaysDbAdmin(correlationId))),
-    "-v", fmt.Sprintf("DB_NAME=%s", targetDb))
-  //Run the command and returns its combined standard output and standard error.
-  out, err := cmd.CombinedOutput()
-  if err != nil {
-    logger.LogError(fmt.Sprintf("SQL script failed: %v", err), correlationId)
-    return false
-  }
-  //
-  for _, str := range strings.Split(string(out), "\n") {  //Convert []byte to string.
-    if str != "" {
-      logger.LogInfo(str, correlationId)
-    }
-  }
-  return true
-}
-
 //StringPtr is a helper function to return a pointer to a string.
 func StringPtr(s string) *string {
   if s == "" {
@@ -199,3 +156,19 @@ func (bs *banking) Ping(ctx context.Context) error {
 func (bs *banking) Close() {
   bs.bsPool.Close()
 }
+
+//Ping the database with a connection of the pool; the readiness probe.
+func PoolPing(ctx context.Context) error {
+  if bsInstance == nil {
+    return errors.New("the connection pool was not created")
+  }
+  return bsInstance.bsPool.Ping(ctx)
+}
+
+//The statistics of the connection pool; nil if the pool was not created.
+func PoolStat() *pgxpool.Stat {
+  if bsInstance == nil {
+    return nil
+  }
+  return bsInstance.bsPool.Stat()
+}
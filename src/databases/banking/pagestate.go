@@ -0,0 +1,53 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+)
+
+/***
+The state of the pages of each user (see the statestore package) when it is kept in Postgres. The
+errors are logged by the state store, with the correlation ID of the request.
+***/
+const (
+  QR_GET_PAGE_STATE = "SELECT s.state::TEXT " +
+   "FROM fin.user_page_state s " +
+   "JOIN fin.customers_credentials cc ON cc.id = s.customer_id " +
+   "WHERE cc.user_name = $1 AND s.namespace = $2 AND s.page = $3"
+  QR_SAVE_PAGE_STATE = "INSERT INTO fin.user_page_state(customer_id, namespace, page, state) " +
+   "SELECT id, $2, $3, $4::JSONB FROM fin.customers_credentials WHERE user_name = $1 " +
+   "ON CONFLICT(customer_id, namespace, page) DO UPDATE " +
+   "SET state = EXCLUDED.state, updated_at = CURRENT_TIMESTAMP"
+)
+
+//The saved state (JSON) of the page of the user; nil if there is none.
+func DbGetPageState(ctx context.Context, userName, namespace, page string) ([]byte, error) {
+  db := GetBsInstance()
+  var state string
+  err := db.bsPool.QueryRow(ctx, QR_GET_PAGE_STATE, userName, namespace, page).Scan(&state)
+  if errors.Is(err, pgx.ErrNoRows) {
+    return nil, nil
+  } else if err != nil {
+    return nil, fmt.Errorf("DbGetPageState: %w", err)
+  }
+  return []byte(state), nil
+}
+
+//Insert or replace the state (JSON) of the page of the user.
+func DbSavePageState(ctx context.Context, userName, namespace, page string, state []byte) error {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_SAVE_PAGE_STATE, userName, namespace, page, string(state))
+  if err != nil {
+    return fmt.Errorf("DbSavePageState: %w", err)
+  } else if tag.RowsAffected() == 0 {
+    return fmt.Errorf("unknown user %s", userName)
+  }
+  return nil
+}
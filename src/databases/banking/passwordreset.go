@@ -0,0 +1,92 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "finance/config"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+/***
+Self-service password reset ("forgot password"). The token sent by email is never stored; the
+database keeps its hash (see security.GenerateUrlToken), and a token is single-use and expires after
+PASSWORD_RESET_MINUTES.
+***/
+const (
+  SP_CREATE_PASSWORD_RESET = "CALL fin.create_password_reset($1, $2, $3, null)"
+  QR_GET_PASSWORD_RESET_USER = "SELECT cc.user_name " +
+   "FROM fin.password_reset_tokens t " +
+   "JOIN fin.customers_credentials cc ON cc.id = t.customer_id " +
+   "JOIN fin.customers c ON c.id = t.customer_id " +
+   "WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP AND c.is_active"
+  SP_RESET_PASSWORD = "CALL fin.reset_password($1, $2, $3, $4, null)"
+)
+
+var ErrInvalidResetToken = errors.New("The password-reset link is invalid or has expired.")
+
+/***
+Store the hash of a new reset token for the user. It returns the email address to send the token
+to; an empty address means no token was issued (unknown or inactive user, no email address, or a
+token was requested less than a minute ago), which must not be revealed to the requester.
+***/
+func DbCreatePasswordReset(ctx context.Context, userName, tokenHash, correlationId string) (string, error) {
+  db := GetBsInstance()
+  var email *string
+  err := db.bsPool.QueryRow(ctx, SP_CREATE_PASSWORD_RESET, userName, tokenHash,
+    config.GetPasswordResetMinutes(correlationId)).Scan(&email)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbCreatePasswordReset: %v", err), correlationId)
+    DbWriteAuditEvent(ctx, AuditPasswordResetRequest, userName, AuditFailure, err.Error(), correlationId)
+    return "", err
+  }
+  if email == nil {
+    DbWriteAuditEvent(ctx, AuditPasswordResetRequest, userName, AuditDenied, "No link was issued.", correlationId)
+    return "", nil
+  }
+  DbWriteAuditEvent(ctx, AuditPasswordResetRequest, userName, AuditSuccess, "", correlationId)
+  return *email, nil
+}
+
+//The user a valid (unused and unexpired) token belongs to.
+func DbGetPasswordResetUser(ctx context.Context, tokenHash, correlationId string) (string, error) {
+  db := GetBsInstance()
+  var userName string
+  err := db.bsPool.QueryRow(ctx, QR_GET_PASSWORD_RESET_USER, tokenHash).Scan(&userName)
+  if err != nil {
+    if errors.Is(err, pgx.ErrNoRows) {
+      return "", ErrInvalidResetToken
+    }
+    logger.LogError(fmt.Sprintf("Error on DbGetPasswordResetUser: %v", err), correlationId)
+    return "", err
+  }
+  return userName, nil
+}
+
+/***
+Set the new password and consume the token. The caller validates the new password (see
+ValidatePassword); the database checks the token and the password history.
+***/
+func DbResetPassword(ctx context.Context, tokenHash, newPassword, correlationId string) error {
+  db := GetBsInstance()
+  var ret int32
+  err := db.bsPool.QueryRow(ctx, SP_RESET_PASSWORD, tokenHash, newPassword, config.GetPasswordHistory(correlationId),
+    correlationId).Scan(&ret)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbResetPassword: %v", err), correlationId)
+  } else if ret == -2 {
+    err = ErrPasswordReused
+  } else if ret != 0 {
+    err = ErrInvalidResetToken
+  }
+  //The actor is the user the token belongs to (see WithAuditInfo).
+  outcome, details := auditOutcome(err)
+  DbWriteAuditEvent(ctx, AuditPasswordReset, "", outcome, details, correlationId)
+  return err
+}
@@ -0,0 +1,50 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "fmt"
+  "time"
+)
+
+/***
+The token buckets of the rate limiter (see the ratelimit package) when they are kept in Postgres.
+The bucket is refilled for the time since its last update and a token is taken, if there is one, in
+a single statement; the row lock of the upsert serializes the instances of the server. allowed
+records whether the last request got a token.
+***/
+const (
+  QR_TAKE_RATE_TOKEN = "INSERT INTO fin.rate_limits AS r(key, tokens, allowed, updated_at) " +
+   "VALUES($1, $3::FLOAT8 - 1, TRUE, CURRENT_TIMESTAMP) " +
+   "ON CONFLICT(key) DO UPDATE SET " +
+   "tokens = LEAST($3, r.tokens + EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - r.updated_at)::FLOAT8 * $2) - " +
+   "CASE WHEN LEAST($3, r.tokens + EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - r.updated_at)::FLOAT8 * $2) >= 1 " +
+   "THEN 1 ELSE 0 END, " +
+   "allowed = LEAST($3, r.tokens + EXTRACT(EPOCH FROM CURRENT_TIMESTAMP - r.updated_at)::FLOAT8 * $2) >= 1, " +
+   "updated_at = CURRENT_TIMESTAMP " +
+   "RETURNING allowed, tokens"
+  QR_PURGE_RATE_LIMITS = "DELETE FROM fin.rate_limits WHERE updated_at < CURRENT_TIMESTAMP - $1::INTERVAL"
+)
+
+//Take a token from the bucket of key; tokens is what is left in the bucket.
+func DbTakeRateToken(ctx context.Context, key string, rate float64, burst int) (allowed bool, tokens float64,
+                     err error) {
+  db := GetBsInstance()
+  if err = db.bsPool.QueryRow(ctx, QR_TAKE_RATE_TOKEN, key, rate, burst).Scan(&allowed, &tokens); err != nil {
+    return false, 0, fmt.Errorf("DbTakeRateToken: %w", err)
+  }
+  return allowed, tokens, nil
+}
+
+//Delete the buckets not used for idle; they are full again.
+func DbPurgeRateLimits(ctx context.Context, idle time.Duration) error {
+  db := GetBsInstance()
+  if _, err := db.bsPool.Exec(ctx, QR_PURGE_RATE_LIMITS, fmt.Sprintf("%d seconds", int64(idle.Seconds()))); err != nil {
+    return fmt.Errorf("DbPurgeRateLimits: %w", err)
+  }
+  return nil
+}
@@ -0,0 +1,335 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "finance/finances"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "time"
+)
+
+const (
+  //The template must belong to an account of the customer.
+  QR_ADD_RECURRING_TEMPLATE = "INSERT INTO accounts.tbl_recurring_templates(acct_id, payee, tr_type, amount, tr_description, " +
+   "frequency, interval_count, day_of_month, second_day, start_date, end_date, next_due, post_mode) " +
+   "SELECT a.id, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14 " +
+   "FROM accounts.tbl_accounts a " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE a.id = $1::UUID AND c.username = $2"
+  QR_RECURRING_COLUMNS = "t.id::TEXT AS id, t.acct_id::TEXT AS acct_id, a.acct_name, t.payee, t.tr_type, " +
+   "t.amount::FLOAT8 AS amount, COALESCE(t.tr_description, '') AS tr_description, t.frequency, t.interval_count, " +
+   "t.day_of_month, t.second_day, t.start_date, t.end_date, t.next_due, t.post_mode, t.active "
+  QR_GET_RECURRING_TEMPLATES = "SELECT " + QR_RECURRING_COLUMNS +
+   "FROM accounts.tbl_recurring_templates t " +
+   "JOIN accounts.tbl_accounts a ON a.id = t.acct_id " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE c.username = $1 " +
+   "ORDER BY t.next_due NULLS LAST, t.payee"
+  /***
+  The templates of suspended and closed accounts are held until the account is active again.
+  FOR UPDATE SKIP LOCKED lets several pods run the scheduler at the same time: a template locked by one pod is simply skipped
+  by the others instead of being processed twice. The template is selected again in its transaction, so one that was posted
+  (or whose account was suspended) after the list was read is skipped too.
+  ***/
+  QR_DUE_RECURRING = "FROM accounts.tbl_recurring_templates t " +
+   "JOIN accounts.tbl_accounts a ON a.id = t.acct_id " +
+   "WHERE t.active AND t.next_due <= $1 AND a.acct_status = 'active' "
+  QR_GET_DUE_RECURRING_IDS = "SELECT t.id::TEXT " + QR_DUE_RECURRING + "ORDER BY t.next_due"
+  QR_LOCK_DUE_RECURRING = "SELECT " + QR_RECURRING_COLUMNS + QR_DUE_RECURRING + "AND t.id = $2::UUID " +
+   "FOR UPDATE OF t SKIP LOCKED"
+  QR_OWNS_RECURRING_TEMPLATE = "EXISTS (SELECT 1 FROM accounts.tbl_accounts a " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE a.id = t.acct_id AND c.username = $2)"
+  QR_DELETE_RECURRING_TEMPLATE = "DELETE FROM accounts.tbl_recurring_templates t WHERE t.id = $1::UUID AND " +
+   QR_OWNS_RECURRING_TEMPLATE
+  QR_SKIP_RECURRING = "INSERT INTO accounts.tbl_recurring_skips(template_id, skip_date) " +
+   "SELECT t.id, $3 FROM accounts.tbl_recurring_templates t WHERE t.id = $1::UUID AND " + QR_OWNS_RECURRING_TEMPLATE +
+   " ON CONFLICT DO NOTHING"
+  QR_RESTORE_RECURRING = "DELETE FROM accounts.tbl_recurring_skips s USING accounts.tbl_recurring_templates t " +
+   "WHERE s.template_id = t.id AND t.id = $1::UUID AND s.skip_date = $3 AND " + QR_OWNS_RECURRING_TEMPLATE
+  QR_GET_RECURRING_SKIPS = "SELECT skip_date FROM accounts.tbl_recurring_skips WHERE template_id = $1::UUID"
+  //The entry takes the currency of the account.
+  QR_POST_RECURRING_ENTRY = "INSERT INTO accounts.tbl_register_entries(acct_id, payment_date, payee, tr_type, amount, " +
+   "tr_description, template_id, currency_code) " +
+   "SELECT a.id, $2, $3, $4, $5, NULLIF($6, ''), $7::UUID, a.currency_code " +
+   "FROM accounts.tbl_accounts a WHERE a.id = $1::UUID " +
+   "ON CONFLICT (template_id, payment_date) WHERE template_id IS NOT NULL DO NOTHING"
+  QR_ADD_REMINDER = "INSERT INTO accounts.tbl_reminders(template_id, due_date) VALUES($1::UUID, $2) ON CONFLICT DO NOTHING"
+  QR_ADVANCE_RECURRING = "UPDATE accounts.tbl_recurring_templates SET next_due = $2, active = $3, " +
+   "updated_at = CURRENT_TIMESTAMP WHERE id = $1::UUID"
+  QR_GET_REMINDERS = "SELECT r.id::TEXT AS id, r.due_date, t.payee, t.tr_type, t.amount::FLOAT8 AS amount, " +
+   "a.acct_name " +
+   "FROM accounts.tbl_reminders r " +
+   "JOIN accounts.tbl_recurring_templates t ON t.id = r.template_id " +
+   "JOIN accounts.tbl_accounts a ON a.id = t.acct_id " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE c.username = $1 AND NOT r.dismissed " +
+   "ORDER BY r.due_date"
+  QR_DISMISS_REMINDER = "UPDATE accounts.tbl_reminders r SET dismissed = TRUE " +
+   "FROM accounts.tbl_recurring_templates t " +
+   "WHERE r.template_id = t.id AND r.id = $1::UUID AND " + QR_OWNS_RECURRING_TEMPLATE
+  //
+  PostModePost string = "post"
+  PostModeRemind string = "remind"
+)
+
+type RecurringTemplate struct {  //Struct tags.
+  Id string  `db:"id"`
+  Acct_id string  `db:"acct_id"`
+  Acct_name string  `db:"acct_name"`
+  Payee string  `db:"payee"`
+  Tr_type string  `db:"tr_type"`
+  Amount float64  `db:"amount"`
+  Tr_description string  `db:"tr_description"`
+  Frequency string  `db:"frequency"`
+  Interval_count int32  `db:"interval_count"`
+  Day_of_month int32  `db:"day_of_month"`
+  Second_day int32  `db:"second_day"`
+  Start_date time.Time  `db:"start_date"`
+  //Nullable types.
+  End_date *time.Time  `db:"end_date"`
+  Next_due *time.Time  `db:"next_due"`
+  Post_mode string  `db:"post_mode"`
+  Active bool  `db:"active"`
+}
+
+type Reminder struct {  //Struct tags.
+  Id string  `db:"id"`
+  Due_date time.Time  `db:"due_date"`
+  Payee string  `db:"payee"`
+  Tr_type string  `db:"tr_type"`
+  Amount float64  `db:"amount"`
+  Acct_name string  `db:"acct_name"`
+}
+
+//Return the schedule of the template.
+func (t *RecurringTemplate) Recurrence() finances.Recurrence {
+  r := finances.Recurrence{
+    Frequency: t.Frequency,
+    Interval: int(t.Interval_count),
+    DayOfMonth: int(t.Day_of_month),
+    SecondDay: int(t.Second_day),
+    Start: t.Start_date,
+  }
+  if t.End_date != nil {
+    r.End = *t.End_date
+  }
+  return r
+}
+
+func DbAddRecurringTemplate(ctx context.Context, userName string, t *RecurringTemplate, correlationId string) error {
+  r := t.Recurrence()
+  if !r.Valid() {
+    return errors.New("Invalid schedule; please review the frequency, the days, and the dates.")
+  }
+  next, ok := r.NextOnOrAfter(t.Start_date)
+  if !ok {
+    return errors.New("The schedule has no occurrences between the start and end dates.")
+  }
+  t.Next_due = TimePtr(next)
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_ADD_RECURRING_TEMPLATE, t.Acct_id, userName, t.Payee, t.Tr_type, t.Amount,
+    StringPtr(t.Tr_description), t.Frequency, t.Interval_count, t.Day_of_month, t.Second_day, t.Start_date, t.End_date,
+    t.Next_due, t.Post_mode)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbAddRecurringTemplate: %v", err), correlationId)
+    return err
+  } else if tag.RowsAffected() == 0 {
+    logger.LogError(fmt.Sprintf("Account %s not found for user %s.", t.Acct_id, userName), correlationId)
+    return errors.New("Account not found.")
+  }
+  logger.LogInfo(fmt.Sprintf("Recurring template added. Username: %s, payee: %s", userName, t.Payee), correlationId)
+  return nil
+}
+
+func DbGetRecurringTemplates(ctx context.Context, userName, correlationId string) ([]RecurringTemplate, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_RECURRING_TEMPLATES, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetRecurringTemplates: %v", err), correlationId)
+    return nil, err
+  }
+  templates, err := pgx.CollectRows(rows, pgx.RowToStructByName[RecurringTemplate])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetRecurringTemplates: %v", err), correlationId)
+    return nil, err
+  }
+  return templates, nil
+}
+
+func DbDeleteRecurringTemplate(ctx context.Context, userName, templateId, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_DELETE_RECURRING_TEMPLATE, templateId, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbDeleteRecurringTemplate: %v", err), correlationId)
+  }
+  return err
+}
+
+//Skip a single occurrence of a template; the scheduler and the preview ignore skipped dates.
+func DbSkipRecurringOccurrence(ctx context.Context, userName, templateId string, date time.Time, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_SKIP_RECURRING, templateId, userName, date)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSkipRecurringOccurrence: %v", err), correlationId)
+  }
+  return err
+}
+
+//Undo a skip.
+func DbRestoreRecurringOccurrence(ctx context.Context, userName, templateId string, date time.Time,
+     correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_RESTORE_RECURRING, templateId, userName, date)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbRestoreRecurringOccurrence: %v", err), correlationId)
+  }
+  return err
+}
+
+//The returned map is keyed by the date formatted as YYYY-MM-DD.
+func DbGetRecurringSkips(ctx context.Context, templateId, correlationId string) (map[string]bool, error) {
+  db := GetBsInstance()
+  return getRecurringSkips(ctx, db.bsPool, templateId, correlationId)
+}
+
+//Both *pgxpool.Pool and pgx.Tx satisfy querier; helpers that accept it can run inside or outside of a transaction.
+type querier interface {
+  Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+func getRecurringSkips(ctx context.Context, q querier, templateId, correlationId string) (map[string]bool, error) {
+  rows, err := q.Query(ctx, QR_GET_RECURRING_SKIPS, templateId)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on getRecurringSkips: %v", err), correlationId)
+    return nil, err
+  }
+  dates, err := pgx.CollectRows(rows, pgx.RowTo[time.Time])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on getRecurringSkips: %v", err), correlationId)
+    return nil, err
+  }
+  skips := make(map[string]bool, len(dates))
+  for _, d := range dates {
+    skips[d.Format("2006-01-02")] = true
+  }
+  return skips, nil
+}
+
+func DbGetReminders(ctx context.Context, userName, correlationId string) ([]Reminder, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_REMINDERS, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetReminders: %v", err), correlationId)
+    return nil, err
+  }
+  reminders, err := pgx.CollectRows(rows, pgx.RowToStructByName[Reminder])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetReminders: %v", err), correlationId)
+    return nil, err
+  }
+  return reminders, nil
+}
+
+func DbDismissReminder(ctx context.Context, userName, reminderId, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_DISMISS_REMINDER, reminderId, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbDismissReminder: %v", err), correlationId)
+  }
+  return err
+}
+
+/***
+Post (or remind) every occurrence that is due on or before asOf and advance the templates to their next occurrence. Each
+template is processed in a transaction of its own; one that fails (e.g., its account was closed after the list was read) is
+logged and skipped, and it is tried again on the next run, so it does not hold back the other templates. The unique indexes
+on the register entries and the reminders make a rerun harmless. It returns the number of entries posted plus the number of
+reminders created.
+***/
+func DbPostDueRecurring(ctx context.Context, asOf time.Time, correlationId string) (int, error) {
+  db := GetBsInstance()
+  asOf = finances.DateOnly(asOf)
+  rows, err := db.bsPool.Query(ctx, QR_GET_DUE_RECURRING_IDS, asOf)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbPostDueRecurring: %v", err), correlationId)
+    return 0, err
+  }
+  ids, err := pgx.CollectRows(rows, pgx.RowTo[string])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbPostDueRecurring: %v", err), correlationId)
+    return 0, err
+  }
+  var count int = 0
+  for _, id := range ids {
+    n, err := postDueTemplate(ctx, id, asOf, correlationId)
+    if err != nil {
+      logger.LogError(fmt.Sprintf("Error on DbPostDueRecurring (template %s): %v; skipped", id, err), correlationId)
+      if ctx.Err() != nil {
+        return count, ctx.Err()
+      }
+      continue
+    }
+    count += n
+  }
+  return count, nil
+}
+
+//Post the due occurrences of one template and advance it, in one transaction; nothing is done if another pod holds it.
+func postDueTemplate(ctx context.Context, id string, asOf time.Time, correlationId string) (int, error) {
+  tx, err := GetBsInstance().bsPool.Begin(ctx)
+  if err != nil {
+    return 0, err
+  }
+  //Rollback is safe to call even if the tx is already closed.
+  defer tx.Rollback(ctx)
+  rows, err := tx.Query(ctx, QR_LOCK_DUE_RECURRING, asOf, id)
+  if err != nil {
+    return 0, err
+  }
+  templates, err := pgx.CollectRows(rows, pgx.RowToStructByName[RecurringTemplate])
+  if err != nil || len(templates) == 0 {
+    return 0, err
+  }
+  t := templates[0]
+  skips, err := getRecurringSkips(ctx, tx, t.Id, correlationId)
+  if err != nil {
+    return 0, err
+  }
+  var count int = 0
+  r := t.Recurrence()
+  for _, d := range r.OccurrencesBetween(*t.Next_due, asOf) {
+    if skips[d.Format("2006-01-02")] {
+      continue
+    }
+    if t.Post_mode == PostModeRemind {
+      _, err = tx.Exec(ctx, QR_ADD_REMINDER, t.Id, d)
+    } else {
+      _, err = tx.Exec(ctx, QR_POST_RECURRING_ENTRY, t.Acct_id, d, t.Payee, t.Tr_type, t.Amount, t.Tr_description, t.Id)
+    }
+    if err != nil {
+      return 0, fmt.Errorf("%s: %w", d.Format("2006-01-02"), err)
+    }
+    count++
+  }
+  next, ok := r.NextOnOrAfter(asOf.AddDate(0, 0, 1))
+  if ok {
+    _, err = tx.Exec(ctx, QR_ADVANCE_RECURRING, t.Id, next, true)
+  } else {  //The schedule ended.
+    _, err = tx.Exec(ctx, QR_ADVANCE_RECURRING, t.Id, nil, false)
+  }
+  if err != nil {
+    return 0, err
+  }
+  if err = tx.Commit(ctx); err != nil {
+    return 0, err
+  }
+  return count, nil
+}
@@ -0,0 +1,77 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "time"
+)
+
+const (
+  QR_GET_REGISTER_ENTRIES = "SELECT e.id::TEXT AS id, a.acct_name, e.payment_date, e.payee, e.tr_type, " +
+   "e.amount::FLOAT8 AS amount, COALESCE(e.tr_description, '') AS tr_description, e.category_id::TEXT AS category_id " +
+   "FROM accounts.tbl_register_entries e " +
+   "JOIN accounts.tbl_accounts a ON a.id = e.acct_id " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "WHERE c.username = $1 AND e.payment_date >= $2 AND e.payment_date < $3 " +
+   "ORDER BY e.payment_date, e.payee"
+  /***
+  An empty category id removes the category from the entry. Both the entry and the category must belong to the customer.
+  ***/
+  QR_SET_ENTRY_CATEGORY = "UPDATE accounts.tbl_register_entries e SET category_id = cat.id, " +
+   "updated_at = CURRENT_TIMESTAMP " +
+   "FROM accounts.tbl_accounts a " +
+   "JOIN customers.tbl_customers c ON c.id = a.customer_id " +
+   "LEFT JOIN accounts.tbl_categories cat ON cat.id = NULLIF($3, '')::UUID AND cat.customer_id = c.id " +
+   "WHERE e.id = $1::UUID AND a.id = e.acct_id AND c.username = $2 AND (NULLIF($3, '') IS NULL OR cat.id IS NOT NULL)"
+)
+
+type RegisterEntry struct {  //Struct tags.
+  Id string  `db:"id"`
+  Acct_name string  `db:"acct_name"`
+  Payment_date time.Time  `db:"payment_date"`
+  Payee string  `db:"payee"`
+  Tr_type string  `db:"tr_type"`
+  Amount float64  `db:"amount"`
+  Tr_description string  `db:"tr_description"`
+  //Nullable types.
+  Category_id *string  `db:"category_id"`
+}
+
+//Return the register entries of all the accounts of the customer in the interval [from, to).
+func DbGetRegisterEntries(ctx context.Context, userName string, from, to time.Time, correlationId string) ([]RegisterEntry,
+     error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_REGISTER_ENTRIES, userName, from, to)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetRegisterEntries: %v", err), correlationId)
+    return nil, err
+  }
+  entries, err := pgx.CollectRows(rows, pgx.RowToStructByName[RegisterEntry])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetRegisterEntries: %v", err), correlationId)
+    return nil, err
+  }
+  return entries, nil
+}
+
+func DbSetEntryCategory(ctx context.Context, userName, entryId, categoryId, correlationId string) error {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_SET_ENTRY_CATEGORY, entryId, userName, categoryId)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSetEntryCategory: %v", err), correlationId)
+    return err
+  } else if tag.RowsAffected() == 0 {
+    logger.LogError(fmt.Sprintf("Entry %s or category %s not found for user %s.", entryId, categoryId, userName),
+      correlationId)
+    return errors.New("Entry or category not found.")
+  }
+  return nil
+}
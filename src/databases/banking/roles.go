@@ -0,0 +1,150 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "strings"
+)
+
+/***
+Role-based access control: the roles grant named permissions, and the routes are gated by permission
+(see the authz package). The permissions are the ones seeded by the migration
+0001_fin_schema.up.sql (see the migrate package).
+***/
+const (
+  PermBankingUse = "banking.use"
+  PermStorageRead = "storage.read"
+  PermStorageWrite = "storage.write"
+  PermAdminAccess = "admin.access"
+  PermAdminUsers = "admin.users"
+  PermAdminRoles = "admin.roles"
+  PermAdminSettings = "admin.settings"
+  PermAdminDiagnostics = "admin.diagnostics"
+  PermAuditRead = "audit.read"
+)
+
+const (
+  QR_GET_ROLES = "SELECT r.name, r.description, " +
+   "COALESCE(ARRAY_AGG(rp.permission_name ORDER BY rp.permission_name) " +
+   "FILTER (WHERE rp.permission_name IS NOT NULL), '{}') AS permissions " +
+   "FROM fin.roles r " +
+   "LEFT JOIN fin.role_permissions rp ON rp.role_name = r.name " +
+   "GROUP BY r.name, r.description " +
+   "ORDER BY r.name"
+  //Only the permissions of an active customer count.
+  QR_GET_USER_PERMISSIONS = "SELECT DISTINCT rp.permission_name " +
+   "FROM fin.customers_credentials cc " +
+   "JOIN fin.customers c ON c.id = cc.id " +
+   "JOIN fin.customer_roles cr ON cr.customer_id = cc.id " +
+   "JOIN fin.role_permissions rp ON rp.role_name = cr.role_name " +
+   "WHERE cc.user_name = $1 AND c.is_active"
+  QR_GET_USERS_ROLES = "SELECT cc.user_name, cc.is_admin, " +
+   "COALESCE(ARRAY_AGG(cr.role_name ORDER BY cr.role_name) FILTER (WHERE cr.role_name IS NOT NULL), '{}') AS roles " +
+   "FROM fin.customers_credentials cc " +
+   "JOIN fin.customers c ON c.id = cc.id " +
+   "LEFT JOIN fin.customer_roles cr ON cr.customer_id = cc.id " +
+   "WHERE c.is_active " +
+   "GROUP BY cc.user_name, cc.is_admin " +
+   "ORDER BY cc.user_name"
+  SP_SET_CUSTOMER_ROLES = "CALL fin.set_customer_roles($1, $2, $3, null)"
+)
+
+var (
+  ErrUnknownUserOrRole = errors.New("Unknown user or role.")
+  ErrProtectedRole = errors.New("The admin role cannot be removed from the default admin.")
+)
+
+type Role struct {  //Struct tags.
+  Name string  `db:"name"`
+  Description string  `db:"description"`
+  Permissions []string  `db:"permissions"`
+}
+
+type UserRoles struct {  //Struct tags.
+  User_name string  `db:"user_name"`
+  Is_admin bool  `db:"is_admin"`
+  Roles []string  `db:"roles"`
+}
+
+func DbGetRoles(ctx context.Context, correlationId string) ([]Role, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_ROLES)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetRoles: %v", err), correlationId)
+    return nil, err
+  }
+  roles, err := pgx.CollectRows(rows, pgx.RowToStructByName[Role])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetRoles: %v", err), correlationId)
+    return nil, err
+  }
+  return roles, nil
+}
+
+//The permissions granted by all the roles of the user; none if the user is unknown or inactive.
+func DbGetUserPermissions(ctx context.Context, userName, correlationId string) ([]string, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_USER_PERMISSIONS, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetUserPermissions: %v", err), correlationId)
+    return nil, err
+  }
+  perms, err := pgx.CollectRows(rows, pgx.RowTo[string])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetUserPermissions: %v", err), correlationId)
+    return nil, err
+  }
+  return perms, nil
+}
+
+//The roles of the active users.
+func DbGetUsersRoles(ctx context.Context, correlationId string) ([]UserRoles, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_USERS_ROLES)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetUsersRoles: %v", err), correlationId)
+    return nil, err
+  }
+  users, err := pgx.CollectRows(rows, pgx.RowToStructByName[UserRoles])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetUsersRoles: %v", err), correlationId)
+    return nil, err
+  }
+  return users, nil
+}
+
+/***
+Replace the roles of a user. The is_admin flag follows the 'admin.access' permission; it takes effect
+at the next login of the user, while the permissions are checked on every request.
+***/
+func DbSetUserRoles(ctx context.Context, userName string, roles []string, performedBy, correlationId string) error {
+  db := GetBsInstance()
+  if roles == nil {
+    roles = []string{}
+  }
+  var ret int32
+  err := db.bsPool.QueryRow(ctx, SP_SET_CUSTOMER_ROLES, userName, roles, performedBy).Scan(&ret)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSetUserRoles: %v", err), correlationId)
+  } else if ret == -2 {
+    err = ErrProtectedRole
+  } else if ret != 0 {
+    err = ErrUnknownUserOrRole
+  } else {
+    logger.LogInfo(fmt.Sprintf("Roles of user %s set to %v by %s.", userName, roles, performedBy), correlationId)
+  }
+  outcome, details := auditOutcome(err)
+  if err == nil {
+    details = "roles: " + strings.Join(roles, ", ")
+  }
+  DbWriteAuditEvent(ctx, AuditRolesChange, userName, outcome, details, correlationId)
+  return err
+}
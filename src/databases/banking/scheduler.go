@@ -0,0 +1,39 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "fmt"
+  "github.com/juan-carlos-trimino/gplogger"
+  "time"
+)
+
+/***
+The recurring-transaction scheduler runs inside the server as a background goroutine. On every tick it posts the due
+occurrences of the recurring templates into the register (or creates reminders). The first run happens right away so that
+occurrences missed while the server was down are caught up at startup.
+
+The goroutine ends when ctx is canceled. Running it in several pods is safe; see DbPostDueRecurring.
+***/
+func StartRecurringScheduler(ctx context.Context, interval time.Duration, correlationId string) {
+  logger.LogInfo(fmt.Sprintf("Starting the recurring-transaction scheduler (interval: %v).", interval), correlationId)
+  go func() {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+    for {
+      if count, err := DbPostDueRecurring(ctx, time.Now(), correlationId); err == nil && count > 0 {
+        logger.LogInfo(fmt.Sprintf("Recurring scheduler processed %d occurrence(s).", count), correlationId)
+      }
+      select {
+      case <- ctx.Done():
+        logger.LogInfo("Stopping the recurring-transaction scheduler.", correlationId)
+        return
+      case <- ticker.C:
+      }
+    }
+  }()
+}
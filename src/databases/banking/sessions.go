@@ -0,0 +1,63 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "finance/security"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "time"
+)
+
+/***
+The sessions of a user are ended in the database, so that every instance of the server ends them;
+see security.IsSessionEnded. An inactive (unregistered) customer keeps no session.
+***/
+const (
+  QR_GET_USER_SESSIONS = "SELECT c.is_active, c.sessions_ended_at " +
+   "FROM fin.customers c " +
+   "JOIN fin.customers_credentials cc ON cc.id = c.id " +
+   "WHERE cc.user_name = $1"
+  QR_END_USER_SESSIONS = "UPDATE fin.customers SET sessions_ended_at = $2 " +
+   "WHERE id = (SELECT id FROM fin.customers_credentials WHERE user_name = $1)"
+)
+
+//An unknown user (e.g., purged, since the username was replaced) keeps no session.
+func DbGetUserSessions(ctx context.Context, userName, correlationId string) (security.UserSessions, error) {
+  db := GetBsInstance()
+  var isActive bool
+  var endedAt *time.Time
+  err := db.bsPool.QueryRow(ctx, QR_GET_USER_SESSIONS, userName).Scan(&isActive, &endedAt)
+  if errors.Is(err, pgx.ErrNoRows) {
+    return security.UserSessions{ Revoked: true }, nil
+  } else if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetUserSessions: %v", err), correlationId)
+    return security.UserSessions{}, err
+  }
+  state := security.UserSessions{ Revoked: !isActive }
+  if endedAt != nil {
+    state.EndedAt = *endedAt
+  }
+  return state, nil
+}
+
+/***
+End the sessions the user has now (e.g., after the password was reset); a new login is not ended.
+The time is taken from the clock of the server, which records the start of the sessions.
+***/
+func DbEndUserSessions(ctx context.Context, userName, correlationId string) bool {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_END_USER_SESSIONS, userName, time.Now())
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbEndUserSessions: %v", err), correlationId)
+    return false
+  }
+  security.ForgetUserSessions(userName)
+  return tag.RowsAffected() == 1
+}
@@ -0,0 +1,187 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "strings"
+  "time"
+)
+
+/***
+Self-service sign-up. The customer is created by fin.add_customer (through fin.signup_customer) but
+cannot log in until the email address is verified with the link sent to it and, if the setting
+require_signup_approval is on, an admin approves the sign-up.
+***/
+const (
+  SETTING_REQUIRE_SIGNUP_APPROVAL = "require_signup_approval"
+  SP_SIGNUP_CUSTOMER = "CALL fin.signup_customer($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, " +
+   "null)"
+  SP_VERIFY_SIGNUP = "CALL fin.verify_signup($1, $2, null, null)"
+  SP_REVIEW_SIGNUP = "CALL fin.review_signup($1, $2, $3, $4, null)"
+  QR_GET_UNVERIFIED_SIGNUP = "SELECT c.id, d.email " +
+   "FROM fin.customers c " +
+   "JOIN fin.customers_credentials cc ON cc.id = c.id " +
+   "JOIN fin.customers_contact_details d ON d.id = c.id " +
+   "WHERE cc.user_name = $1 AND c.signup_status = 'pending_email' AND c.is_active"
+  QR_GET_PENDING_SIGNUPS = "SELECT cc.user_name, c.first_name, c.last_name, d.email, c.created_at, c.email_verified_at " +
+   "FROM fin.customers c " +
+   "JOIN fin.customers_credentials cc ON cc.id = c.id " +
+   "JOIN fin.customers_contact_details d ON d.id = c.id " +
+   "WHERE c.signup_status = 'pending_approval' AND c.is_active " +
+   "ORDER BY c.email_verified_at, cc.user_name"
+)
+
+//The outcomes of DbVerifySignup.
+const (
+  SignupActive = 0
+  SignupPendingApproval = 1
+)
+
+var (
+  ErrUsernameTaken = errors.New("The username is already taken; please choose another one.")
+  ErrSignupFailed = errors.New("The account could not be created; check the fields and try again.")
+  ErrInvalidSignup = errors.New("The verification link is invalid or was already used.")
+  ErrUnknownSignup = errors.New("Unknown or already reviewed sign-up.")
+)
+
+type PendingSignup struct {  //Struct tags.
+  User_name string  `db:"user_name"`
+  First_name string  `db:"first_name"`
+  Last_name string  `db:"last_name"`
+  Email string  `db:"email"`
+  Created_at time.Time  `db:"created_at"`
+  //Nullable types.
+  Email_verified_at *time.Time  `db:"email_verified_at"`
+}
+
+/***
+Create a customer that must verify the email address before logging in; it returns the id of the
+customer for the verification link. The errors returned can be shown to the user.
+***/
+func DbSignupCustomer(ctx context.Context, c *Customer, correlationId string) (int32, error) {
+  db := GetBsInstance()
+  var id int32
+  err := db.bsPool.QueryRow(ctx, SP_SIGNUP_CUSTOMER, c.User_name, c.Password, c.First_name, c.Middle_name, c.Last_name,
+    c.Marketing, c.Birth_date, c.Gender, c.Address1, c.Address2, c.City, c.State, c.Country, c.Zip_code, c.Email,
+    c.Phone).Scan(&id)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("SP fin.signup_customer: %v", err), correlationId)
+    DbWriteAuditEvent(ctx, AuditUserSignup, c.User_name, AuditFailure, err.Error(), correlationId)
+    //The message raised by fin.add_customer for a duplicate username.
+    if strings.Contains(err.Error(), "is already taken") {
+      return 0, ErrUsernameTaken
+    }
+    return 0, ErrSignupFailed
+  }
+  logger.LogInfo(fmt.Sprintf("SP fin.signup_customer succeeded. Username: %s", c.User_name), correlationId)
+  DbWriteAuditEvent(ctx, AuditUserSignup, c.User_name, AuditSuccess, "email verification pending", correlationId)
+  return id, nil
+}
+
+//The id and the email address of a sign-up that was not verified yet; 0 if there is none.
+func DbGetUnverifiedSignup(ctx context.Context, userName, correlationId string) (int32, string, error) {
+  db := GetBsInstance()
+  var id int32
+  var email string
+  err := db.bsPool.QueryRow(ctx, QR_GET_UNVERIFIED_SIGNUP, userName).Scan(&id, &email)
+  if errors.Is(err, pgx.ErrNoRows) {
+    return 0, "", nil
+  } else if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetUnverifiedSignup: %v", err), correlationId)
+    return 0, "", err
+  }
+  return id, email, nil
+}
+
+//The email address of the customer was verified; it returns SignupActive or SignupPendingApproval.
+func DbVerifySignup(ctx context.Context, id int32, correlationId string) (int, error) {
+  db := GetBsInstance()
+  requireApproval := DbGetRequireSignupApproval(ctx, correlationId)
+  var ret int
+  var userName *string
+  if err := db.bsPool.QueryRow(ctx, SP_VERIFY_SIGNUP, id, requireApproval).Scan(&ret, &userName); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbVerifySignup: %v", err), correlationId)
+    return 0, err
+  }
+  target := PtrString(userName)
+  if ret < 0 {
+    DbWriteAuditEvent(ctx, AuditSignupVerify, target, AuditFailure, ErrInvalidSignup.Error(), correlationId)
+    return 0, ErrInvalidSignup
+  }
+  details := "active"
+  if ret == SignupPendingApproval {
+    details = "approval pending"
+  }
+  DbWriteAuditEvent(ctx, AuditSignupVerify, target, AuditSuccess, details, correlationId)
+  return ret, nil
+}
+
+func DbGetPendingSignups(ctx context.Context, correlationId string) ([]PendingSignup, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_PENDING_SIGNUPS)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetPendingSignups: %v", err), correlationId)
+    return nil, err
+  }
+  signups, err := pgx.CollectRows(rows, pgx.RowToStructByName[PendingSignup])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetPendingSignups: %v", err), correlationId)
+    return nil, err
+  }
+  return signups, nil
+}
+
+//Approve a verified sign-up, or reject it (the customer is deactivated with the reason).
+func DbReviewSignup(ctx context.Context, userName string, approve bool, reason, performedBy,
+                    correlationId string) error {
+  db := GetBsInstance()
+  var ok bool
+  err := db.bsPool.QueryRow(ctx, SP_REVIEW_SIGNUP, userName, approve, reason, performedBy).Scan(&ok)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbReviewSignup: %v", err), correlationId)
+  } else if !ok {
+    err = ErrUnknownSignup
+  }
+  details := "approved"
+  if !approve {
+    details = "rejected: " + reason
+  }
+  outcome, errDetails := auditOutcome(err)
+  if err != nil {
+    details += "; " + errDetails
+  }
+  DbWriteAuditEvent(ctx, AuditSignupReview, userName, outcome, details, correlationId)
+  return err
+}
+
+func DbGetRequireSignupApproval(ctx context.Context, correlationId string) bool {
+  db := GetBsInstance()
+  var value string
+  if err := db.bsPool.QueryRow(ctx, QR_GET_SETTING, SETTING_REQUIRE_SIGNUP_APPROVAL).Scan(&value); err != nil {
+    if !errors.Is(err, pgx.ErrNoRows) {
+      logger.LogError(fmt.Sprintf("Error on DbGetRequireSignupApproval: %v", err), correlationId)
+    }
+    return false
+  }
+  return value == "true"
+}
+
+func DbSetRequireSignupApproval(ctx context.Context, required bool, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_SET_SETTING, SETTING_REQUIRE_SIGNUP_APPROVAL, fmt.Sprint(required))
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSetRequireSignupApproval: %v", err), correlationId)
+  }
+  outcome, _ := auditOutcome(err)
+  DbWriteAuditEvent(ctx, AuditSettingsChange, "", outcome, fmt.Sprintf("%s = %t", SETTING_REQUIRE_SIGNUP_APPROVAL,
+    required), correlationId)
+  return err
+}
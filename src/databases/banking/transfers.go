@@ -0,0 +1,378 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "finance/finances"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/juan-carlos-trimino/gplogger"
+  "math"
+  "time"
+)
+
+const (
+  //Allow the changes to the entries of a transfer for the rest of the transaction (see the trigger in 0002_accounts_schema.up.sql).
+  QR_ENABLE_TRANSFER_EDIT = "SET LOCAL fin.transfer_edit = 'on'"
+  //Both accounts must belong to the customer and be active. A repeated client token inserts nothing and returns no rows.
+  QR_ADD_TRANSFER = "INSERT INTO accounts.tbl_transfers(customer_id, client_token, from_acct_id, to_acct_id, " +
+   "transfer_date, amount_sent, amount_received, tr_description) " +
+   "SELECT c.id, $2, f.id, t.id, $5, $6, $7, NULLIF($8, '') " +
+   "FROM customers.tbl_customers c " +
+   "JOIN accounts.tbl_accounts f ON f.customer_id = c.id AND f.id = $3::UUID AND f.acct_status = 'active' " +
+   "JOIN accounts.tbl_accounts t ON t.customer_id = c.id AND t.id = $4::UUID AND t.acct_status = 'active' " +
+   "WHERE c.username = $1 " +
+   "ON CONFLICT (customer_id, client_token) DO NOTHING " +
+   "RETURNING id::TEXT"
+  QR_TRANSFER_TOKEN_EXISTS = "SELECT EXISTS (SELECT 1 FROM accounts.tbl_transfers tr " +
+   "JOIN customers.tbl_customers c ON c.id = tr.customer_id WHERE c.username = $1 AND tr.client_token = $2)"
+  QR_TRANSFER_COLUMNS = "SELECT tr.id::TEXT AS id, tr.from_acct_id::TEXT AS from_acct_id, f.acct_name AS from_acct_name, " +
+   "f.currency_code AS from_currency, tr.to_acct_id::TEXT AS to_acct_id, t.acct_name AS to_acct_name, " +
+   "t.currency_code AS to_currency, f.acct_status AS from_status, t.acct_status AS to_status, tr.transfer_date, tr.amount_sent::FLOAT8 AS amount_sent, " +
+   "tr.amount_received::FLOAT8 AS amount_received, COALESCE(tr.tr_description, '') AS tr_description " +
+   "FROM accounts.tbl_transfers tr " +
+   "JOIN accounts.tbl_accounts f ON f.id = tr.from_acct_id " +
+   "JOIN accounts.tbl_accounts t ON t.id = tr.to_acct_id " +
+   "JOIN customers.tbl_customers c ON c.id = tr.customer_id "
+  QR_GET_TRANSFERS = QR_TRANSFER_COLUMNS + "WHERE c.username = $1 ORDER BY tr.transfer_date DESC, tr.created_at DESC"
+  QR_GET_TRANSFER_FOR_UPDATE = QR_TRANSFER_COLUMNS + "WHERE tr.id = $1::UUID AND c.username = $2 FOR UPDATE OF tr"
+  //The entry takes the currency of its account.
+  QR_ADD_TRANSFER_ENTRY = "INSERT INTO accounts.tbl_register_entries(acct_id, payment_date, payee, tr_type, amount, " +
+   "tr_description, currency_code, transfer_id) " +
+   "SELECT a.id, $2, $3, $4, $5, NULLIF($6, ''), a.currency_code, $7::UUID " +
+   "FROM accounts.tbl_accounts a WHERE a.id = $1::UUID " +
+   "RETURNING id::TEXT"
+  QR_UPDATE_TRANSFER = "UPDATE accounts.tbl_transfers SET transfer_date = $2, amount_sent = $3, amount_received = $4, " +
+   "tr_description = NULLIF($5, ''), updated_at = CURRENT_TIMESTAMP WHERE id = $1::UUID"
+  QR_UPDATE_TRANSFER_ENTRY = "UPDATE accounts.tbl_register_entries SET payment_date = $3, amount = $4, " +
+   "tr_description = NULLIF($5, ''), updated_at = CURRENT_TIMESTAMP " +
+   "WHERE transfer_id = $1::UUID AND tr_type = $2 " +
+   "RETURNING id::TEXT"
+  QR_DELETE_FX_DIFFERENCE = "DELETE FROM accounts.tbl_fx_differences WHERE debit_entry_id = $1::UUID AND " +
+   "credit_entry_id = $2::UUID"
+  //The entries (and their FX difference) are deleted by the cascade. The entries of a closed account are read-only.
+  QR_DELETE_TRANSFER = "DELETE FROM accounts.tbl_transfers tr USING customers.tbl_customers c " +
+   "WHERE tr.customer_id = c.id AND tr.id = $1::UUID AND c.username = $2 AND NOT EXISTS (SELECT 1 " +
+   "FROM accounts.tbl_accounts a WHERE a.id IN (tr.from_acct_id, tr.to_acct_id) AND a.acct_status = 'closed')"
+  /***
+  For every transfer: the number of entries, the amount debited from the source account, and the amount deposited into the
+  destination account.
+  ***/
+  QR_CHECK_LEDGER = "SELECT tr.id::TEXT AS transfer_id, c.username, COUNT(e.id)::INT AS entries, " +
+   "COALESCE(SUM(e.amount) FILTER (WHERE e.tr_type = 'debit' AND e.acct_id = tr.from_acct_id), 0)::FLOAT8 AS debited, " +
+   "COALESCE(SUM(e.amount) FILTER (WHERE e.tr_type = 'deposit' AND e.acct_id = tr.to_acct_id), 0)::FLOAT8 AS credited, " +
+   "tr.amount_sent::FLOAT8 AS amount_sent, tr.amount_received::FLOAT8 AS amount_received, " +
+   "(f.currency_code = t.currency_code) AS same_currency " +
+   "FROM accounts.tbl_transfers tr " +
+   "JOIN customers.tbl_customers c ON c.id = tr.customer_id " +
+   "JOIN accounts.tbl_accounts f ON f.id = tr.from_acct_id " +
+   "JOIN accounts.tbl_accounts t ON t.id = tr.to_acct_id " +
+   "LEFT JOIN accounts.tbl_register_entries e ON e.transfer_id = tr.id " +
+   "GROUP BY tr.id, c.username, f.currency_code, t.currency_code " +
+   "ORDER BY tr.id"
+  //
+  TrTypeDebit string = "debit"
+  TrTypeDeposit string = "deposit"
+)
+
+//A repeated submission of the same transfer form.
+var ErrDuplicateTransfer = errors.New("This transfer was already submitted.")
+
+type Transfer struct {  //Struct tags.
+  Id string  `db:"id"`
+  From_acct_id string  `db:"from_acct_id"`
+  From_acct_name string  `db:"from_acct_name"`
+  From_currency string  `db:"from_currency"`
+  To_acct_id string  `db:"to_acct_id"`
+  To_acct_name string  `db:"to_acct_name"`
+  To_currency string  `db:"to_currency"`
+  From_status string  `db:"from_status"`
+  To_status string  `db:"to_status"`
+  Transfer_date time.Time  `db:"transfer_date"`
+  Amount_sent float64  `db:"amount_sent"`
+  Amount_received float64  `db:"amount_received"`
+  Tr_description string  `db:"tr_description"`
+}
+
+type LedgerCheck struct {  //Struct tags.
+  Transfer_id string  `db:"transfer_id"`
+  Username string  `db:"username"`
+  Entries int32  `db:"entries"`
+  Debited float64  `db:"debited"`
+  Credited float64  `db:"credited"`
+  Amount_sent float64  `db:"amount_sent"`
+  Amount_received float64  `db:"amount_received"`
+  Same_currency bool  `db:"same_currency"`
+}
+
+//The amounts are NUMERIC(12, 2); anything below half a cent is a rounding artifact of the conversion to FLOAT8.
+func sameAmount(a, b float64) bool {
+  return math.Abs(a - b) < 0.005
+}
+
+/***
+Return an empty string if the transfer balances; otherwise, a description of the problem. A transfer balances when it has
+exactly two entries, the debit matches the amount sent, the deposit matches the amount received, and, when both accounts use
+the same currency, the debit and the deposit add up to zero.
+***/
+func (lc *LedgerCheck) Problem() string {
+  switch {
+  case lc.Entries != 2:
+    return fmt.Sprintf("%d entries instead of 2", lc.Entries)
+  case !sameAmount(lc.Debited, lc.Amount_sent):
+    return fmt.Sprintf("debit %.2f does not match the amount sent %.2f", lc.Debited, lc.Amount_sent)
+  case !sameAmount(lc.Credited, lc.Amount_received):
+    return fmt.Sprintf("deposit %.2f does not match the amount received %.2f", lc.Credited, lc.Amount_received)
+  case lc.Same_currency && !sameAmount(lc.Debited, lc.Credited):
+    return fmt.Sprintf("the pair does not balance to zero (%.2f)", lc.Credited - lc.Debited)
+  }
+  return ""
+}
+
+func isReadOnlyTransfer(t *Transfer) bool {
+  return t.From_status == finances.AccountClosed || t.To_status == finances.AccountClosed
+}
+
+func validateTransferAmounts(t *Transfer) error {
+  if t.From_currency == t.To_currency && !sameAmount(t.Amount_sent, t.Amount_received) {
+    return errors.New("Both accounts use the same currency; the amounts sent and received must be equal.")
+  }
+  return nil
+}
+
+//Record (or replace) the FX difference of the pair; it is a no-op when both accounts use the same currency.
+func recordTransferFxDifference(ctx context.Context, tx pgx.Tx, userName string, t *Transfer, debitId, creditId,
+     correlationId string) error {
+  if t.From_currency == t.To_currency {
+    return nil
+  }
+  if _, err := tx.Exec(ctx, QR_DELETE_FX_DIFFERENCE, debitId, creditId); err != nil {
+    logger.LogError(fmt.Sprintf("Error on recordTransferFxDifference: %v", err), correlationId)
+    return err
+  }
+  reportingCurrency, err := DbGetReportingCurrency(ctx, userName, correlationId)
+  if err != nil {
+    return err
+  }
+  rt, err := DbGetRateTable(ctx, correlationId)
+  if err != nil {
+    return err
+  }
+  _, err = DbRecordFxDifference(ctx, tx, rt, debitId, creditId, t.Amount_sent, t.From_currency, t.Amount_received,
+    t.To_currency, reportingCurrency, t.Transfer_date, correlationId)
+  return err
+}
+
+/***
+Write a transfer and its matched debit and deposit in one transaction. The client token makes the operation idempotent: if a
+transfer with the same token already exists, nothing is written and ErrDuplicateTransfer is returned.
+***/
+func DbCreateTransfer(ctx context.Context, userName, clientToken string, t *Transfer, correlationId string) error {
+  if clientToken == "" {
+    return errors.New("Missing client token.")
+  }
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbCreateTransfer: %v", err), correlationId)
+    return err
+  }
+  //Rollback is safe to call even if the tx is already closed.
+  defer tx.Rollback(ctx)
+  if err = createTransfer(ctx, tx, userName, clientToken, t, correlationId); err != nil {
+    return err
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbCreateTransfer: %v", err), correlationId)
+    return err
+  }
+  logger.LogInfo(fmt.Sprintf("Transfer created. Username: %s, transfer: %s", userName, t.Id), correlationId)
+  return nil
+}
+
+//Write a transfer and its entries in the transaction tx; see DbCreateTransfer.
+func createTransfer(ctx context.Context, tx pgx.Tx, userName, clientToken string, t *Transfer,
+     correlationId string) error {
+  if _, err := tx.Exec(ctx, QR_ENABLE_TRANSFER_EDIT); err != nil {
+    logger.LogError(fmt.Sprintf("Error on createTransfer: %v", err), correlationId)
+    return err
+  }
+  err := tx.QueryRow(ctx, QR_ADD_TRANSFER, userName, clientToken, t.From_acct_id, t.To_acct_id, t.Transfer_date,
+    t.Amount_sent, t.Amount_received, t.Tr_description).Scan(&t.Id)
+  if errors.Is(err, pgx.ErrNoRows) {
+    var exists bool
+    if err = tx.QueryRow(ctx, QR_TRANSFER_TOKEN_EXISTS, userName, clientToken).Scan(&exists); err != nil {
+      logger.LogError(fmt.Sprintf("Error on createTransfer: %v", err), correlationId)
+      return err
+    } else if exists {
+      logger.LogInfo(fmt.Sprintf("Duplicate transfer ignored. Username: %s, token: %s", userName, clientToken),
+        correlationId)
+      return ErrDuplicateTransfer
+    }
+    logger.LogError(fmt.Sprintf("Active accounts %s and %s not found for user %s.", t.From_acct_id, t.To_acct_id,
+      userName), correlationId)
+    return errors.New("Account not found or not active.")
+  } else if err != nil {
+    logger.LogError(fmt.Sprintf("Error on createTransfer: %v", err), correlationId)
+    return err
+  }
+  //Read the transfer back for the names and the currencies of the accounts.
+  rows, err := tx.Query(ctx, QR_GET_TRANSFER_FOR_UPDATE, t.Id, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on createTransfer: %v", err), correlationId)
+    return err
+  }
+  saved, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Transfer])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on createTransfer: %v", err), correlationId)
+    return err
+  }
+  if err = validateTransferAmounts(&saved); err != nil {
+    return err
+  }
+  var debitId, creditId string
+  if err = tx.QueryRow(ctx, QR_ADD_TRANSFER_ENTRY, saved.From_acct_id, saved.Transfer_date,
+     "Transfer to " + saved.To_acct_name, TrTypeDebit, saved.Amount_sent, saved.Tr_description, saved.Id).
+     Scan(&debitId); err != nil {
+    logger.LogError(fmt.Sprintf("Error on createTransfer: %v", err), correlationId)
+    return err
+  }
+  if err = tx.QueryRow(ctx, QR_ADD_TRANSFER_ENTRY, saved.To_acct_id, saved.Transfer_date,
+     "Transfer from " + saved.From_acct_name, TrTypeDeposit, saved.Amount_received, saved.Tr_description, saved.Id).
+     Scan(&creditId); err != nil {
+    logger.LogError(fmt.Sprintf("Error on createTransfer: %v", err), correlationId)
+    return err
+  }
+  if err = recordTransferFxDifference(ctx, tx, userName, &saved, debitId, creditId, correlationId); err != nil {
+    return err
+  }
+  *t = saved
+  return nil
+}
+
+func DbGetTransfers(ctx context.Context, userName, correlationId string) ([]Transfer, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_TRANSFERS, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetTransfers: %v", err), correlationId)
+    return nil, err
+  }
+  transfers, err := pgx.CollectRows(rows, pgx.RowToStructByName[Transfer])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetTransfers: %v", err), correlationId)
+    return nil, err
+  }
+  return transfers, nil
+}
+
+//Change the date, the amounts, and the description of a transfer and both of its entries in one transaction.
+func DbUpdateTransfer(ctx context.Context, userName string, t *Transfer, correlationId string) error {
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  defer tx.Rollback(ctx)
+  if _, err = tx.Exec(ctx, QR_ENABLE_TRANSFER_EDIT); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  //Lock the transfer; it also checks that it belongs to the customer.
+  rows, err := tx.Query(ctx, QR_GET_TRANSFER_FOR_UPDATE, t.Id, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  saved, err := pgx.CollectOneRow(rows, pgx.RowToStructByName[Transfer])
+  if errors.Is(err, pgx.ErrNoRows) {
+    return errors.New("Transfer not found.")
+  } else if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  if isReadOnlyTransfer(&saved) {
+    return errors.New("The transfer involves a closed account; it is read-only.")
+  }
+  saved.Transfer_date = t.Transfer_date
+  saved.Amount_sent = t.Amount_sent
+  saved.Amount_received = t.Amount_received
+  saved.Tr_description = t.Tr_description
+  //Within one currency, the amount received is always the amount sent.
+  if saved.From_currency == saved.To_currency {
+    saved.Amount_received = saved.Amount_sent
+  }
+  if _, err = tx.Exec(ctx, QR_UPDATE_TRANSFER, saved.Id, saved.Transfer_date, saved.Amount_sent, saved.Amount_received,
+     saved.Tr_description); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  var debitId, creditId string
+  if err = tx.QueryRow(ctx, QR_UPDATE_TRANSFER_ENTRY, saved.Id, TrTypeDebit, saved.Transfer_date, saved.Amount_sent,
+     saved.Tr_description).Scan(&debitId); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  if err = tx.QueryRow(ctx, QR_UPDATE_TRANSFER_ENTRY, saved.Id, TrTypeDeposit, saved.Transfer_date, saved.Amount_received,
+     saved.Tr_description).Scan(&creditId); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  if err = recordTransferFxDifference(ctx, tx, userName, &saved, debitId, creditId, correlationId); err != nil {
+    return err
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUpdateTransfer: %v", err), correlationId)
+    return err
+  }
+  *t = saved
+  logger.LogInfo(fmt.Sprintf("Transfer updated. Username: %s, transfer: %s", userName, t.Id), correlationId)
+  return nil
+}
+
+//Delete a transfer; both entries are deleted with it.
+func DbDeleteTransfer(ctx context.Context, userName, transferId, correlationId string) error {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_DELETE_TRANSFER, transferId, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbDeleteTransfer: %v", err), correlationId)
+    return err
+  } else if tag.RowsAffected() == 0 {
+    return errors.New("Transfer not found or read-only.")
+  }
+  return nil
+}
+
+/***
+Verify the ledger invariant: every transfer is a pair of entries that balances (see LedgerCheck.Problem). It returns the
+number of transfers checked and the ones that failed the check.
+***/
+func DbCheckLedgerInvariant(ctx context.Context, correlationId string) (int, []LedgerCheck, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_CHECK_LEDGER)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbCheckLedgerInvariant: %v", err), correlationId)
+    return 0, nil, err
+  }
+  checks, err := pgx.CollectRows(rows, pgx.RowToStructByName[LedgerCheck])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbCheckLedgerInvariant: %v", err), correlationId)
+    return 0, nil, err
+  }
+  var failed []LedgerCheck
+  for _, lc := range checks {
+    if lc.Problem() != "" {
+      failed = append(failed, lc)
+    }
+  }
+  if len(failed) > 0 {
+    logger.LogWarning(fmt.Sprintf("Ledger invariant violated by %d of %d transfers.", len(failed), len(checks)),
+      correlationId)
+  }
+  return len(checks), failed, nil
+}
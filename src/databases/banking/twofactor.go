@@ -0,0 +1,378 @@
+package banking
+
+//To fold all block comments:
+//  Ctrl+K and Ctrl+/
+//To unfold all block comments:
+//  Ctrl+K and Ctrl+J
+
+import (
+  "context"
+  "errors"
+  "finance/security"
+  "fmt"
+  "github.com/jackc/pgx/v5"
+  "github.com/jackc/pgx/v5/pgconn"
+  "github.com/juan-carlos-trimino/gplogger"
+  "strings"
+  "time"
+)
+
+/***
+TOTP (RFC 6238) second factor. The enrollment is a two-step process: DbStartTotpEnrollment stores a
+new (unconfirmed) secret, and DbConfirmTotp confirms it with the first code from the authenticator
+app and returns the one-time recovery codes. Only a confirmed secret is enforced at login.
+***/
+const (
+  SETTING_REQUIRE_ADMIN_2FA = "require_admin_2fa"
+  QR_GET_TWO_FACTOR = "SELECT c.is_admin, t.secret, COALESCE(t.confirmed, FALSE) AS confirmed, " +
+   "COALESCE(t.last_counter, 0) AS last_counter, " +
+   "(SELECT COUNT(*) FROM fin.customers_recovery_codes r WHERE r.customer_id = c.id AND r.used_at IS NULL)::INT " +
+   "AS recovery_left, " +
+   "(c.is_admin AND COALESCE((SELECT s.value = 'true' FROM fin.settings s WHERE s.name = '" +
+   SETTING_REQUIRE_ADMIN_2FA + "'), FALSE)) AS required " +
+   "FROM fin.customers_credentials c " +
+   "LEFT JOIN fin.customers_totp t ON t.id = c.id " +
+   "WHERE c.user_name = $1"
+  //A confirmed enrollment is never overwritten; it has to be reset first.
+  QR_START_TOTP_ENROLLMENT = "INSERT INTO fin.customers_totp(id, secret) " +
+   "SELECT id, $2 FROM fin.customers_credentials WHERE user_name = $1 " +
+   "ON CONFLICT(id) DO UPDATE SET secret = EXCLUDED.secret, last_counter = 0, " +
+   "created_at = CURRENT_TIMESTAMP " +
+   "WHERE NOT fin.customers_totp.confirmed"
+  QR_GET_TOTP_FOR_UPDATE = "SELECT t.id, t.secret, t.confirmed, t.last_counter " +
+   "FROM fin.customers_totp t " +
+   "JOIN fin.customers_credentials c ON c.id = t.id " +
+   "WHERE c.user_name = $1 " +
+   "FOR UPDATE OF t"
+  QR_CONFIRM_TOTP = "UPDATE fin.customers_totp SET confirmed = TRUE, last_counter = $2, " +
+   "confirmed_at = CURRENT_TIMESTAMP WHERE id = $1"
+  QR_SET_TOTP_COUNTER = "UPDATE fin.customers_totp SET last_counter = $2 WHERE id = $1 AND last_counter < $2"
+  QR_DELETE_RECOVERY_CODES = "DELETE FROM fin.customers_recovery_codes WHERE customer_id = $1"
+  QR_ADD_RECOVERY_CODE = "INSERT INTO fin.customers_recovery_codes(customer_id, code_hash) " +
+   "VALUES($1, crypt($2, gen_salt('bf', 10)))"
+  QR_USE_RECOVERY_CODE = "UPDATE fin.customers_recovery_codes SET used_at = CURRENT_TIMESTAMP " +
+   "WHERE id = (SELECT id FROM fin.customers_recovery_codes " +
+   "WHERE customer_id = $1 AND used_at IS NULL AND code_hash = crypt($2, code_hash) LIMIT 1) " +
+   "AND used_at IS NULL"
+  QR_RESET_TOTP = "DELETE FROM fin.customers_totp " +
+   "WHERE id = (SELECT id FROM fin.customers_credentials WHERE user_name = $1)"
+  QR_RESET_RECOVERY_CODES = "DELETE FROM fin.customers_recovery_codes " +
+   "WHERE customer_id = (SELECT id FROM fin.customers_credentials WHERE user_name = $1)"
+  QR_GET_TWO_FACTOR_USERS = "SELECT c.user_name, c.is_admin, t.confirmed, t.created_at, t.confirmed_at, " +
+   "(SELECT COUNT(*) FROM fin.customers_recovery_codes r WHERE r.customer_id = c.id AND r.used_at IS NULL)::INT " +
+   "AS recovery_left " +
+   "FROM fin.customers_totp t " +
+   "JOIN fin.customers_credentials c ON c.id = t.id " +
+   "ORDER BY c.user_name"
+  //A new link replaces the unused ones of the user.
+  QR_ADD_TOTP_ENROLLMENT_TOKEN = "WITH c AS (SELECT cc.id FROM fin.customers_credentials cc " +
+   "JOIN fin.customers cu ON cu.id = cc.id WHERE cc.user_name = $1 AND cu.is_active), " +
+   "old AS (UPDATE fin.totp_enrollment_tokens t SET used_at = CURRENT_TIMESTAMP FROM c " +
+   "WHERE t.customer_id = c.id AND t.used_at IS NULL) " +
+   "INSERT INTO fin.totp_enrollment_tokens(customer_id, token_hash, created_by, expires_at) " +
+   "SELECT c.id, $2, $3, CURRENT_TIMESTAMP + make_interval(hours => $4) FROM c"
+  QR_GET_TOTP_ENROLLMENT_USER = "SELECT cc.user_name " +
+   "FROM fin.totp_enrollment_tokens t " +
+   "JOIN fin.customers_credentials cc ON cc.id = t.customer_id " +
+   "JOIN fin.customers c ON c.id = t.customer_id " +
+   "WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP AND c.is_active"
+  QR_USE_TOTP_ENROLLMENT_TOKEN = "UPDATE fin.totp_enrollment_tokens SET used_at = CURRENT_TIMESTAMP " +
+   "WHERE token_hash = $1 AND used_at IS NULL AND expires_at > CURRENT_TIMESTAMP"
+  QR_GET_SETTING = "SELECT value FROM fin.settings WHERE name = $1"
+  QR_SET_SETTING = "INSERT INTO fin.settings(name, value) VALUES($1, $2) " +
+   "ON CONFLICT(name) DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP"
+)
+
+var ErrTotpAlreadyEnrolled = errors.New("two-factor authentication is already enabled; reset it first")
+var ErrInvalidTotpCode = errors.New("invalid authentication code")
+var ErrInvalidEnrollmentToken = errors.New("The enrollment link is invalid or has expired; ask an admin for a new one.")
+
+//Hours an enrollment link is valid; the admin hands it over to the user.
+const TotpEnrollmentHours = 24
+
+type TwoFactor struct {  //Struct tags.
+  Is_admin bool  `db:"is_admin"`
+  Secret *string  `db:"secret"`
+  Confirmed bool  `db:"confirmed"`
+  Last_counter int64  `db:"last_counter"`
+  Recovery_left int32  `db:"recovery_left"`
+  //The user is an admin and the admins are required to use two-factor authentication.
+  Required bool  `db:"required"`
+}
+
+//A second factor is checked at login only when the enrollment was confirmed.
+func (tf *TwoFactor) Enabled() bool {
+  return tf.Confirmed && tf.Secret != nil
+}
+
+type TwoFactorUser struct {  //Struct tags.
+  User_name string  `db:"user_name"`
+  Is_admin bool  `db:"is_admin"`
+  Confirmed bool  `db:"confirmed"`
+  Created_at time.Time  `db:"created_at"`
+  Confirmed_at *time.Time  `db:"confirmed_at"`
+  Recovery_left int32  `db:"recovery_left"`
+}
+
+type totpRow struct {
+  Id int32  `db:"id"`
+  Secret string  `db:"secret"`
+  Confirmed bool  `db:"confirmed"`
+  Last_counter int64  `db:"last_counter"`
+}
+
+func DbGetTwoFactor(ctx context.Context, userName, correlationId string) (*TwoFactor, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_TWO_FACTOR, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetTwoFactor: %v", err), correlationId)
+    return nil, err
+  }
+  tf, err := pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[TwoFactor])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetTwoFactor: %v", err), correlationId)
+    return nil, err
+  }
+  return tf, nil
+}
+
+//Store a new secret for the user; it is not enforced until it is confirmed.
+func DbStartTotpEnrollment(ctx context.Context, userName, secret, correlationId string) error {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_START_TOTP_ENROLLMENT, userName, secret)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbStartTotpEnrollment: %v", err), correlationId)
+    return err
+  }
+  if tag.RowsAffected() == 0 {
+    return ErrTotpAlreadyEnrolled
+  }
+  return nil
+}
+
+func getTotpForUpdate(ctx context.Context, tx pgx.Tx, userName string) (*totpRow, error) {
+  rows, err := tx.Query(ctx, QR_GET_TOTP_FOR_UPDATE, userName)
+  if err != nil {
+    return nil, err
+  }
+  return pgx.CollectOneRow(rows, pgx.RowToAddrOfStructByName[totpRow])
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, customerId int32) ([]string, error) {
+  codes, err := security.GenerateRecoveryCodes(security.RecoveryCodesCount)
+  if err != nil {
+    return nil, err
+  }
+  if _, err = tx.Exec(ctx, QR_DELETE_RECOVERY_CODES, customerId); err != nil {
+    return nil, err
+  }
+  for _, code := range codes {
+    if _, err = tx.Exec(ctx, QR_ADD_RECOVERY_CODE, customerId, code); err != nil {
+      return nil, err
+    }
+  }
+  return codes, nil
+}
+
+/***
+Confirm the enrollment with the first code from the authenticator app. It returns the recovery
+codes in plain text; this is the only time they are available, so the caller must show them to the
+user.
+***/
+func DbConfirmTotp(ctx context.Context, userName, code, correlationId string) ([]string, error) {
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbConfirmTotp: %v", err), correlationId)
+    return nil, err
+  }
+  defer tx.Rollback(ctx)
+  t, err := getTotpForUpdate(ctx, tx, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbConfirmTotp: %v", err), correlationId)
+    return nil, err
+  }
+  if t.Confirmed {
+    return nil, ErrTotpAlreadyEnrolled
+  }
+  counter, ok := security.VerifyTotp(t.Secret, code, time.Now(), t.Last_counter)
+  if !ok {
+    return nil, ErrInvalidTotpCode
+  }
+  if _, err = tx.Exec(ctx, QR_CONFIRM_TOTP, t.Id, counter); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbConfirmTotp: %v", err), correlationId)
+    return nil, err
+  }
+  codes, err := replaceRecoveryCodes(ctx, tx, t.Id)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbConfirmTotp: %v", err), correlationId)
+    return nil, err
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbConfirmTotp: %v", err), correlationId)
+    return nil, err
+  }
+  logger.LogInfo(fmt.Sprintf("Two-factor authentication enabled for user %s.", userName), correlationId)
+  return codes, nil
+}
+
+/***
+Check the second factor of a user: either a code from the authenticator app or one of the unused
+recovery codes. Both are single use; the row is locked so that two concurrent logins cannot accept
+the same code.
+***/
+func DbVerifySecondFactor(ctx context.Context, userName, code, correlationId string) bool {
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbVerifySecondFactor: %v", err), correlationId)
+    return false
+  }
+  defer tx.Rollback(ctx)
+  t, err := getTotpForUpdate(ctx, tx, userName)
+  if err != nil {
+    if !errors.Is(err, pgx.ErrNoRows) {
+      logger.LogError(fmt.Sprintf("Error on DbVerifySecondFactor: %v", err), correlationId)
+    }
+    return false
+  }
+  if !t.Confirmed {
+    return false
+  }
+  code = strings.TrimSpace(code)
+  if counter, ok := security.VerifyTotp(t.Secret, code, time.Now(), t.Last_counter); ok {
+    _, err = tx.Exec(ctx, QR_SET_TOTP_COUNTER, t.Id, counter)
+  } else if len(code) > security.TotpDigits {
+    var tag pgconn.CommandTag
+    tag, err = tx.Exec(ctx, QR_USE_RECOVERY_CODE, t.Id, security.NormalizeRecoveryCode(code))
+    if err == nil && tag.RowsAffected() == 0 {
+      return false
+    }
+    if err == nil {
+      logger.LogWarning(fmt.Sprintf("User %s used a recovery code.", userName), correlationId)
+    }
+  } else {
+    return false
+  }
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbVerifySecondFactor: %v", err), correlationId)
+    return false
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbVerifySecondFactor: %v", err), correlationId)
+    return false
+  }
+  return true
+}
+
+//Remove the secret and the recovery codes of a user; the user can enroll again.
+func DbResetTwoFactor(ctx context.Context, userName, correlationId string) bool {
+  ok := resetTwoFactor(ctx, userName, correlationId)
+  DbWriteAuditEvent(ctx, AuditTwoFactorReset, userName, auditOutcomeOk(ok), "", correlationId)
+  return ok
+}
+
+func resetTwoFactor(ctx context.Context, userName, correlationId string) bool {
+  db := GetBsInstance()
+  tx, err := db.bsPool.Begin(ctx)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbResetTwoFactor: %v", err), correlationId)
+    return false
+  }
+  defer tx.Rollback(ctx)
+  if _, err = tx.Exec(ctx, QR_RESET_RECOVERY_CODES, userName); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbResetTwoFactor: %v", err), correlationId)
+    return false
+  }
+  tag, err := tx.Exec(ctx, QR_RESET_TOTP, userName)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbResetTwoFactor: %v", err), correlationId)
+    return false
+  }
+  if err = tx.Commit(ctx); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbResetTwoFactor: %v", err), correlationId)
+    return false
+  }
+  if tag.RowsAffected() == 0 {
+    return false
+  }
+  logger.LogInfo(fmt.Sprintf("Two-factor authentication reset for user %s.", userName), correlationId)
+  return true
+}
+
+func DbGetTwoFactorUsers(ctx context.Context, correlationId string) ([]TwoFactorUser, error) {
+  db := GetBsInstance()
+  rows, err := db.bsPool.Query(ctx, QR_GET_TWO_FACTOR_USERS)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetTwoFactorUsers: %v", err), correlationId)
+    return nil, err
+  }
+  users, err := pgx.CollectRows(rows, pgx.RowToStructByName[TwoFactorUser])
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbGetTwoFactorUsers: %v", err), correlationId)
+    return nil, err
+  }
+  return users, nil
+}
+
+func DbGetRequireAdmin2fa(ctx context.Context, correlationId string) bool {
+  db := GetBsInstance()
+  var value string
+  if err := db.bsPool.QueryRow(ctx, QR_GET_SETTING, SETTING_REQUIRE_ADMIN_2FA).Scan(&value); err != nil {
+    if !errors.Is(err, pgx.ErrNoRows) {
+      logger.LogError(fmt.Sprintf("Error on DbGetRequireAdmin2fa: %v", err), correlationId)
+    }
+    return false
+  }
+  return value == "true"
+}
+
+func DbSetRequireAdmin2fa(ctx context.Context, required bool, correlationId string) error {
+  db := GetBsInstance()
+  _, err := db.bsPool.Exec(ctx, QR_SET_SETTING, SETTING_REQUIRE_ADMIN_2FA, fmt.Sprint(required))
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbSetRequireAdmin2fa: %v", err), correlationId)
+  }
+  outcome, _ := auditOutcome(err)
+  DbWriteAuditEvent(ctx, AuditSettingsChange, "", outcome, fmt.Sprintf("%s = %t", SETTING_REQUIRE_ADMIN_2FA, required),
+    correlationId)
+  return err
+}
+
+/***
+Store the hash of a one-time enrollment link for the user; see fin.totp_enrollment_tokens. The
+actor of the audit event is the admin who issued it.
+***/
+func DbCreateTotpEnrollmentLink(ctx context.Context, userName, tokenHash, createdBy, correlationId string) bool {
+  db := GetBsInstance()
+  tag, err := db.bsPool.Exec(ctx, QR_ADD_TOTP_ENROLLMENT_TOKEN, userName, tokenHash, createdBy, TotpEnrollmentHours)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbCreateTotpEnrollmentLink: %v", err), correlationId)
+  }
+  ok := err == nil && tag.RowsAffected() == 1
+  DbWriteAuditEvent(ctx, AuditTwoFactorEnrollLink, userName, auditOutcomeOk(ok), "", correlationId)
+  return ok
+}
+
+//The user a valid (unused and unexpired) enrollment link belongs to.
+func DbGetTotpEnrollmentUser(ctx context.Context, tokenHash, correlationId string) (string, error) {
+  db := GetBsInstance()
+  var userName string
+  err := db.bsPool.QueryRow(ctx, QR_GET_TOTP_ENROLLMENT_USER, tokenHash).Scan(&userName)
+  if err != nil {
+    if errors.Is(err, pgx.ErrNoRows) {
+      return "", ErrInvalidEnrollmentToken
+    }
+    logger.LogError(fmt.Sprintf("Error on DbGetTotpEnrollmentUser: %v", err), correlationId)
+    return "", err
+  }
+  return userName, nil
+}
+
+//Consume the enrollment link once the enrollment was confirmed.
+func DbUseTotpEnrollmentLink(ctx context.Context, tokenHash, correlationId string) {
+  db := GetBsInstance()
+  if _, err := db.bsPool.Exec(ctx, QR_USE_TOTP_ENROLLMENT_TOKEN, tokenHash); err != nil {
+    logger.LogError(fmt.Sprintf("Error on DbUseTotpEnrollmentLink: %v", err), correlationId)
+  }
+}
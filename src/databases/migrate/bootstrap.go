@@ -0,0 +1,87 @@
+package migrate
+
+/***
+The roles and the database, which the migrations cannot create: a migration runs in the database,
+in a transaction, and CREATE DATABASE cannot run in a transaction. The roles exist at the cluster
+level:
+  admin_role  The group role that owns the database and its objects; it cannot log in.
+  db_user     The user of the application; it logs in and inherits the privileges of admin_role.
+Nothing that exists is changed (e.g., the password of an existing user).
+***/
+
+import (
+  "context"
+  "fmt"
+  "io"
+  "strings"
+  "github.com/jackc/pgx/v5"
+)
+
+//The owner of the database and its objects; the migrations refer to it by name.
+const OwnerRole = "admin_role"
+
+const (
+  QR_ROLE_EXISTS = `SELECT EXISTS(SELECT 1 FROM pg_catalog.pg_roles WHERE rolname = $1)`
+  QR_DATABASE_EXISTS = `SELECT EXISTS(SELECT 1 FROM pg_catalog.pg_database WHERE datname = $1)`
+)
+
+/***
+Create the roles and the database that do not exist. conn is connected to another database of the
+cluster (db_setup_name) as a user that can create roles and databases (db_setup_user). A dry run
+writes the statements to out instead, with the password masked. It returns whether the database
+existed.
+***/
+func Bootstrap(ctx context.Context, conn *pgx.Conn, dbName, user, password string, dryRun bool,
+     out io.Writer) (bool, error) {
+  var dbExists bool
+  err := withLock(ctx, conn, func() error {
+    owner := pgx.Identifier{ OwnerRole }.Sanitize()
+    db := pgx.Identifier{ dbName }.Sanitize()
+    steps := []struct {
+      exists string
+      name string
+      sql []string
+    } {
+      //Since CREATE ROLE defaults to NOLOGIN, this role can't connect to the database, which is perfect for a group role.
+      { QR_ROLE_EXISTS, OwnerRole, []string{
+        "CREATE ROLE " + owner + " WITH NOLOGIN NOINHERIT NOSUPERUSER CREATEROLE NOCREATEDB CONNECTION LIMIT 5" } },
+      { QR_ROLE_EXISTS, user, []string{
+        "CREATE ROLE " + pgx.Identifier{ user }.Sanitize() + " WITH LOGIN INHERIT PASSWORD " + quoteLiteral(password) +
+        " NOSUPERUSER NOCREATEROLE NOCREATEDB CONNECTION LIMIT 5 IN ROLE " + owner } },
+      { QR_DATABASE_EXISTS, dbName, []string{
+        "CREATE DATABASE " + db + " WITH OWNER = " + owner + " ALLOW_CONNECTIONS = TRUE CONNECTION_LIMIT = -1" +
+        " ENCODING = 'UTF8' LC_COLLATE = 'C.UTF8' LC_CTYPE = 'C.UTF8' IS_TEMPLATE = FALSE TEMPLATE = 'template0'",
+        //The 'public' role can connect to a new database; only the members of the owner can.
+        "REVOKE ALL ON DATABASE " + db + " FROM public" } },
+    }
+    for i, step := range steps {
+      var exists bool
+      if err := conn.QueryRow(ctx, step.exists, step.name).Scan(&exists); err != nil {
+        return err
+      }
+      if i == len(steps) - 1 {
+        dbExists = exists
+      }
+      if exists {
+        continue
+      }
+      for _, sql := range step.sql {
+        if dryRun {
+          fmt.Fprintf(out, "%s;\n", strings.Replace(sql, quoteLiteral(password), "'********'", 1))
+        } else if _, err := conn.Exec(ctx, sql); err != nil {
+          return fmt.Errorf("cannot create %s: %w", step.name, err)
+        }
+      }
+      if !dryRun {
+        fmt.Fprintf(out, "Created %s.\n", step.name)
+      }
+    }
+    return nil
+  })
+  return dbExists, err
+}
+
+//A string constant of SQL; CREATE ROLE does not take parameters.
+func quoteLiteral(s string) string {
+  return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
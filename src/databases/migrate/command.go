@@ -0,0 +1,178 @@
+package migrate
+
+/***
+The "migrate" command manages the migrations of the configured database and exits; the server is
+not started. It takes the flags of the settings (e.g., -config, -db-host) after its own.
+  migrate up [-dry-run]               Create the roles and the database outside Kubernetes (as the
+                                      server does at startup), and apply the pending migrations.
+  migrate down [-steps n] [-dry-run]  Roll back the last n (1) applied migrations.
+  migrate status                      List the migrations and their state; the exit code is 1 if an
+                                      applied migration changed or is missing.
+The migrations are applied as db_setup_user.
+***/
+
+import (
+  "context"
+  "finance/config"
+  "fmt"
+  "io"
+  "strings"
+  "text/tabwriter"
+  "time"
+  "github.com/jackc/pgx/v5"
+  "github.com/jackc/pgx/v5/pgconn"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+const usage = "usage: migrate up [-dry-run] | down [-steps n] [-dry-run] | status [config flags]"
+
+func Command(args []string, stdout, stderr io.Writer) int {
+  if len(args) == 0 || (args[0] != "up" && args[0] != "down" && args[0] != "status") {
+    fmt.Fprintln(stderr, usage)
+    return 2
+  }
+  flags, load := config.FlagSet("migrate " + args[0], stderr)
+  dryRun, steps := new(bool), new(int)
+  if args[0] != "status" {
+    dryRun = flags.Bool("dry-run", false, "write the SQL that would run instead of running it")
+  }
+  if args[0] == "down" {
+    steps = flags.Int("steps", 1, "migrations to roll back")
+  }
+  if err := flags.Parse(args[1:]); err != nil {
+    return 2
+  } else if flags.NArg() > 0 || (args[0] == "down" && *steps < 1) {
+    fmt.Fprintln(stderr, usage)
+    return 2
+  }
+  cfg, err := load()
+  if err != nil {
+    fmt.Fprintf(stderr, "Invalid configuration:\n%v\n", err)
+    return 1
+  }
+  ctx := context.Background()
+  switch args[0] {
+  case "up":
+    err = Migrate(ctx, cfg, !cfg.K8s, *dryRun, stdout)
+  case "down":
+    err = down(ctx, cfg, *steps, *dryRun, stdout)
+  case "status":
+    var ok bool
+    if ok, err = status(ctx, cfg, stdout); err == nil && !ok {
+      return 1
+    }
+  }
+  if err != nil {
+    fmt.Fprintln(stderr, err)
+    return 1
+  }
+  return 0
+}
+
+/***
+Bring the database up to date: create the roles and the database if bootstrap is set (see
+Bootstrap), and apply the pending migrations. The progress is written to out.
+***/
+func Migrate(ctx context.Context, cfg *config.Config, bootstrap, dryRun bool, out io.Writer) error {
+  migrations, err := Migrations()
+  if err != nil {
+    return err
+  }
+  if bootstrap {
+    conn, err := connect(ctx, cfg.DbSetupConnString(cfg.DbSetupName), out)
+    if err != nil {
+      return err
+    }
+    exists, err := Bootstrap(ctx, conn, cfg.DbName, cfg.DbUser, cfg.DbPassword, dryRun, out)
+    conn.Close(ctx)
+    if err != nil {
+      return err
+    }
+    //There is no database to read the applied migrations from; all of them would run.
+    if dryRun && !exists {
+      for _, m := range migrations {
+        fmt.Fprintf(out, "-- %s (up)\n%s\n", m, m.Up)
+      }
+      return nil
+    }
+  }
+  conn, err := connect(ctx, cfg.DbSetupConnString(cfg.DbName), out)
+  if err != nil {
+    return err
+  }
+  defer conn.Close(ctx)
+  count, err := NewRunner(conn, migrations, out).Up(ctx, dryRun)
+  if err == nil && count == 0 {
+    fmt.Fprintf(out, "The database %s is up to date.\n", cfg.DbName)
+  }
+  return err
+}
+
+func down(ctx context.Context, cfg *config.Config, steps int, dryRun bool, out io.Writer) error {
+  migrations, err := Migrations()
+  if err != nil {
+    return err
+  }
+  conn, err := connect(ctx, cfg.DbSetupConnString(cfg.DbName), out)
+  if err != nil {
+    return err
+  }
+  defer conn.Close(ctx)
+  count, err := NewRunner(conn, migrations, out).Down(ctx, steps, dryRun)
+  if err == nil && count == 0 {
+    fmt.Fprintln(out, "No migration is applied.")
+  }
+  return err
+}
+
+//ok is false if an applied migration changed or is missing.
+func status(ctx context.Context, cfg *config.Config, out io.Writer) (bool, error) {
+  migrations, err := Migrations()
+  if err != nil {
+    return false, err
+  }
+  conn, err := connect(ctx, cfg.DbSetupConnString(cfg.DbName), out)
+  if err != nil {
+    return false, err
+  }
+  defer conn.Close(ctx)
+  states, err := NewRunner(conn, migrations, out).Status(ctx)
+  if err != nil {
+    return false, err
+  }
+  tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+  fmt.Fprintln(tw, "VERSION\tNAME\tSTATUS\tAPPLIED AT")
+  for _, s := range states {
+    appliedAt := ""
+    if !s.AppliedAt.IsZero() {
+      appliedAt = s.AppliedAt.Format(time.RFC3339)
+    }
+    fmt.Fprintf(tw, "%04d\t%s\t%s\t%s\n", s.Version, s.Name, s.Status, appliedAt)
+  }
+  return verify(states) == nil, tw.Flush()
+}
+
+//A connection of its own; the advisory lock belongs to the session.
+func connect(ctx context.Context, connString string, out io.Writer) (*pgx.Conn, error) {
+  connConfig, err := pgx.ParseConfig(connString)
+  if err != nil {
+    return nil, err
+  }
+  //The notices of the SQL (RAISE NOTICE) are part of the progress.
+  connConfig.OnNotice = func(conn *pgconn.PgConn, notice *pgconn.Notice) {
+    fmt.Fprintf(out, "%s: %s\n", notice.Severity, notice.Message)
+  }
+  return pgx.ConnectConfig(ctx, connConfig)
+}
+
+//An io.Writer that logs every line; the server logs the progress of the migrations at startup.
+type LogWriter string  //The correlation ID.
+
+func (w LogWriter) Write(p []byte) (int, error) {
+  for _, line := range strings.Split(string(p), "\n") {
+    if line != "" {
+      logger.LogInfo(line, string(w))
+    }
+  }
+  return len(p), nil
+}
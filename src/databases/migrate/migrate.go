@@ -0,0 +1,353 @@
+package migrate
+
+/***
+Versioned migrations of the database. A migration is a pair of SQL files in the sql directory,
+embedded in the binary:
+  NNNN_name.up.sql    applies the change.
+  NNNN_name.down.sql  reverts it (optional; without it, the migration cannot be rolled back).
+The versions (NNNN) start at 1 and have no gaps. Each migration runs in its own transaction, and
+the applied ones are recorded in public.schema_migrations with the SHA-256 of the up file.
+An applied file must not change: the checksums are compared before anything runs, and the runner
+refuses to go on if one differs; a new change goes in a new migration.
+The runner holds an advisory lock of the database while it works, so the instances of the server
+that start at the same time do not race: one applies the migrations, and the others wait for the
+lock and find nothing left to do.
+***/
+
+import (
+  "context"
+  "crypto/sha256"
+  "embed"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "io"
+  "io/fs"
+  "regexp"
+  "strconv"
+  "time"
+  "github.com/jackc/pgx/v5"
+)
+
+//go:embed sql/*.sql
+var files embed.FS
+
+//The key of the advisory lock; any number that no other advisory lock of the database uses.
+const lockKey int64 = 0x66696e616e6365  //"finance"
+
+const (
+  QR_CREATE_SCHEMA_MIGRATIONS = `
+    CREATE TABLE IF NOT EXISTS public.schema_migrations(
+      version     BIGINT PRIMARY KEY,
+      name        TEXT NOT NULL,
+      checksum    TEXT NOT NULL,
+      applied_at  TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`
+  QR_SCHEMA_MIGRATIONS_EXISTS = `SELECT to_regclass('public.schema_migrations') IS NOT NULL`
+  QR_APPLIED_MIGRATIONS = `
+    SELECT version, name, checksum, applied_at
+    FROM public.schema_migrations
+    ORDER BY version`
+  QR_INSERT_MIGRATION = `INSERT INTO public.schema_migrations(version, name, checksum) VALUES($1, $2, $3)`
+  QR_DELETE_MIGRATION = `DELETE FROM public.schema_migrations WHERE version = $1`
+)
+
+type Migration struct {
+  Version int
+  Name string
+  Up string
+  Down string  //Empty if the migration cannot be rolled back.
+  Checksum string  //The SHA-256 of Up, in hexadecimal.
+}
+
+func (m Migration) String() string {
+  return fmt.Sprintf("%04d_%s", m.Version, m.Name)
+}
+
+var fileName = regexp.MustCompile(`^([0-9]+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+//The migrations embedded in the binary.
+func Migrations() ([]Migration, error) {
+  dir, err := fs.Sub(files, "sql")
+  if err != nil {
+    return nil, err
+  }
+  return Load(dir)
+}
+
+//The migrations of the directory, in order of version.
+func Load(dir fs.FS) ([]Migration, error) {
+  entries, err := fs.ReadDir(dir, ".")
+  if err != nil {
+    return nil, err
+  }
+  byVersion := map[int]*Migration{}
+  for _, e := range entries {
+    match := fileName.FindStringSubmatch(e.Name())
+    if e.IsDir() || match == nil {
+      return nil, fmt.Errorf("%s is not a migration (NNNN_name.up.sql or NNNN_name.down.sql)", e.Name())
+    }
+    version, err := strconv.Atoi(match[1])
+    if err != nil || version < 1 {
+      return nil, fmt.Errorf("%s: the version must be a positive number", e.Name())
+    }
+    data, err := fs.ReadFile(dir, e.Name())
+    if err != nil {
+      return nil, err
+    }
+    m := byVersion[version]
+    if m == nil {
+      m = &Migration{ Version: version, Name: match[2] }
+      byVersion[version] = m
+    } else if m.Name != match[2] {
+      return nil, fmt.Errorf("version %d has two names: %s and %s", version, m.Name, match[2])
+    }
+    if match[3] == "up" {
+      sum := sha256.Sum256(data)
+      m.Up, m.Checksum = string(data), hex.EncodeToString(sum[:])
+    } else {
+      m.Down = string(data)
+    }
+  }
+  migrations := make([]Migration, 0, len(byVersion))
+  for version := 1; version <= len(byVersion); version++ {
+    m, ok := byVersion[version]
+    if !ok {
+      return nil, fmt.Errorf("migration %d is missing; the versions must have no gaps", version)
+    }
+    if m.Up == "" {
+      return nil, fmt.Errorf("%s has no up file", m)
+    }
+    migrations = append(migrations, *m)
+  }
+  return migrations, nil
+}
+
+//A row of schema_migrations.
+type Applied struct {
+  Version int
+  Name string
+  Checksum string
+  AppliedAt time.Time
+}
+
+const (
+  StatusApplied = "applied"
+  StatusPending = "pending"
+  StatusChanged = "changed"  //Applied, but the up file is not the one that was applied.
+  StatusMissing = "missing"  //Applied, but the binary has no such migration (e.g., an older binary).
+)
+
+type State struct {
+  Version int
+  Name string
+  Status string
+  AppliedAt time.Time  //Zero if pending.
+}
+
+//The state of every migration, in order of version.
+func Status(migrations []Migration, applied []Applied) []State {
+  byVersion := map[int]Applied{}
+  for _, a := range applied {
+    byVersion[a.Version] = a
+  }
+  var states []State
+  for _, m := range migrations {
+    a, ok := byVersion[m.Version]
+    delete(byVersion, m.Version)
+    switch {
+    case !ok:
+      states = append(states, State{ m.Version, m.Name, StatusPending, time.Time{} })
+    case a.Checksum != m.Checksum:
+      states = append(states, State{ m.Version, m.Name, StatusChanged, a.AppliedAt })
+    default:
+      states = append(states, State{ m.Version, m.Name, StatusApplied, a.AppliedAt })
+    }
+  }
+  //The rest are the applied versions above the last migration of the binary.
+  for _, a := range applied {
+    if _, ok := byVersion[a.Version]; ok {
+      states = append(states, State{ a.Version, a.Name, StatusMissing, a.AppliedAt })
+    }
+  }
+  return states
+}
+
+//The problems of the applied migrations; nothing must run if there are any.
+func verify(states []State) error {
+  var errs []error
+  for _, s := range states {
+    switch s.Status {
+    case StatusChanged:
+      errs = append(errs, fmt.Errorf("%04d_%s was changed after it was applied", s.Version, s.Name))
+    case StatusMissing:
+      errs = append(errs, fmt.Errorf("%04d_%s was applied, but this binary does not have it", s.Version, s.Name))
+    }
+  }
+  return errors.Join(errs...)
+}
+
+//The migrations to apply, in order.
+func pending(migrations []Migration, applied []Applied) ([]Migration, error) {
+  states := Status(migrations, applied)
+  if err := verify(states); err != nil {
+    return nil, err
+  }
+  var todo []Migration
+  for _, s := range states {
+    if s.Status == StatusPending {
+      todo = append(todo, migrations[s.Version - 1])
+    }
+  }
+  return todo, nil
+}
+
+//The last steps applied migrations, newest first.
+func rollbacks(migrations []Migration, applied []Applied, steps int) ([]Migration, error) {
+  states := Status(migrations, applied)
+  if err := verify(states); err != nil {
+    return nil, err
+  }
+  var todo []Migration
+  for i := len(states) - 1; i >= 0 && len(todo) < steps; i-- {
+    if states[i].Status != StatusApplied {
+      continue
+    }
+    m := migrations[states[i].Version - 1]
+    if m.Down == "" {
+      return nil, fmt.Errorf("%s cannot be rolled back; it has no down file", m)
+    }
+    todo = append(todo, m)
+  }
+  return todo, nil
+}
+
+type Runner struct {
+  conn *pgx.Conn
+  migrations []Migration
+  out io.Writer  //The progress, and the SQL of a dry run.
+}
+
+//conn is connected to the database of the migrations as the user that owns schema_migrations.
+func NewRunner(conn *pgx.Conn, migrations []Migration, out io.Writer) *Runner {
+  return &Runner{ conn: conn, migrations: migrations, out: out }
+}
+
+//The rows of schema_migrations; none before the first migration.
+func (r *Runner) Applied(ctx context.Context) ([]Applied, error) {
+  var exists bool
+  if err := r.conn.QueryRow(ctx, QR_SCHEMA_MIGRATIONS_EXISTS).Scan(&exists); err != nil || !exists {
+    return nil, err
+  }
+  rows, err := r.conn.Query(ctx, QR_APPLIED_MIGRATIONS)
+  if err != nil {
+    return nil, err
+  }
+  return pgx.CollectRows(rows, func(row pgx.CollectableRow) (Applied, error) {
+    var a Applied
+    err := row.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedAt)
+    return a, err
+  })
+}
+
+func (r *Runner) Status(ctx context.Context) ([]State, error) {
+  applied, err := r.Applied(ctx)
+  if err != nil {
+    return nil, err
+  }
+  return Status(r.migrations, applied), nil
+}
+
+/***
+Apply the pending migrations, in order, and return how many. A dry run writes the SQL of the
+pending migrations to out instead.
+***/
+func (r *Runner) Up(ctx context.Context, dryRun bool) (int, error) {
+  var count int
+  err := withLock(ctx, r.conn, func() error {
+    applied, err := r.Applied(ctx)
+    if err != nil {
+      return err
+    }
+    todo, err := pending(r.migrations, applied)
+    if err != nil {
+      return err
+    }
+    if dryRun {
+      count = len(todo)
+      for _, m := range todo {
+        fmt.Fprintf(r.out, "-- %s (up)\n%s\n", m, m.Up)
+      }
+      return nil
+    }
+    if _, err = r.conn.Exec(ctx, QR_CREATE_SCHEMA_MIGRATIONS); err != nil {
+      return err
+    }
+    for _, m := range todo {
+      start := time.Now()
+      if err = r.run(ctx, m, m.Up, QR_INSERT_MIGRATION, m.Version, m.Name, m.Checksum); err != nil {
+        return err
+      }
+      count++
+      fmt.Fprintf(r.out, "%s: applied in %s\n", m, time.Since(start).Round(time.Millisecond))
+    }
+    return nil
+  })
+  return count, err
+}
+
+//Roll back the last steps applied migrations, newest first, and return how many.
+func (r *Runner) Down(ctx context.Context, steps int, dryRun bool) (int, error) {
+  var count int
+  err := withLock(ctx, r.conn, func() error {
+    applied, err := r.Applied(ctx)
+    if err != nil {
+      return err
+    }
+    todo, err := rollbacks(r.migrations, applied, steps)
+    if err != nil {
+      return err
+    }
+    for _, m := range todo {
+      if dryRun {
+        fmt.Fprintf(r.out, "-- %s (down)\n%s\n", m, m.Down)
+      } else if err = r.run(ctx, m, m.Down, QR_DELETE_MIGRATION, m.Version); err != nil {
+        return err
+      } else {
+        fmt.Fprintf(r.out, "%s: rolled back\n", m)
+      }
+      count++
+    }
+    return nil
+  })
+  return count, err
+}
+
+/***
+Run the SQL of a migration and change its row of schema_migrations in one transaction. The row is
+changed first; the SQL may change the role of the transaction (SET LOCAL ROLE).
+***/
+func (r *Runner) run(ctx context.Context, m Migration, sql, record string, args ...any) error {
+  tx, err := r.conn.Begin(ctx)
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback(ctx)
+  if _, err = tx.Exec(ctx, record, args...); err != nil {
+    return fmt.Errorf("%s: %w", m, err)
+  }
+  //Without arguments, the simple protocol is used, and the file can have many statements.
+  if _, err = tx.Exec(ctx, sql); err != nil {
+    return fmt.Errorf("%s: %w", m, err)
+  }
+  return tx.Commit(ctx)
+}
+
+//Run f holding the advisory lock of the database; it waits while another instance holds it.
+func withLock(ctx context.Context, conn *pgx.Conn, f func() error) error {
+  if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+    return fmt.Errorf("cannot take the lock of the migrations: %w", err)
+  }
+  defer conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey)
+  return f()
+}
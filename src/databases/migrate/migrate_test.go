@@ -0,0 +1,159 @@
+// Testing the functions in migrate.go, bootstrap.go, and command.go.
+package migrate
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Load"
+***/
+
+import (
+  "io"
+  "regexp"
+  "strings"
+  "testing"
+  "testing/fstest"
+  "time"
+)
+
+func dir(names ...string) fstest.MapFS {
+  fsys := fstest.MapFS{}
+  for _, name := range names {
+    fsys[name] = &fstest.MapFile{ Data: []byte("-- " + name + "\nSELECT 1;\n") }
+  }
+  return fsys
+}
+
+func TestLoad(t *testing.T) {
+  t.Parallel()
+  migrations, err := Load(dir("0002_b.up.sql", "0001_a.up.sql", "0001_a.down.sql"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(migrations) != 2 || migrations[0].String() != "0001_a" || migrations[1].String() != "0002_b" {
+    t.Fatalf("Load = %v; want 0001_a and 0002_b", migrations)
+  }
+  if migrations[0].Down == "" || migrations[1].Down != "" {
+    t.Error("the down files do not belong to their migrations")
+  }
+  if len(migrations[0].Checksum) != 64 || migrations[0].Checksum == migrations[1].Checksum {
+    t.Errorf("checksums %q and %q; want the SHA-256 of each up file", migrations[0].Checksum, migrations[1].Checksum)
+  }
+  tests := []struct {
+    name string
+    files []string
+  } {
+    { "gap", []string{ "0001_a.up.sql", "0003_c.up.sql" } },
+    { "no up file", []string{ "0001_a.up.sql", "0002_b.down.sql" } },
+    { "two names", []string{ "0001_a.up.sql", "0001_b.down.sql" } },
+    { "version zero", []string{ "0000_a.up.sql" } },
+    { "bad name", []string{ "0001_a.sql" } },
+  }
+  for _, tc := range tests {
+    if _, err := Load(dir(tc.files...)); err == nil {
+      t.Errorf("%s: Load(%q) did not fail", tc.name, tc.files)
+    }
+  }
+}
+
+//The embedded migrations are plain SQL; the psql meta-commands (e.g., \c) do not run through pgx.
+func TestMigrations(t *testing.T) {
+  t.Parallel()
+  migrations, err := Migrations()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(migrations) < 2 {
+    t.Fatalf("%d migrations; want the fin and the accounts schemas", len(migrations))
+  }
+  metaCommand := regexp.MustCompile(`(?m)^\s*\\[a-z]`)
+  for _, m := range migrations {
+    if m.Down == "" {
+      t.Errorf("%s has no down file", m)
+    }
+    for _, sql := range []string{ m.Up, m.Down } {
+      if loc := metaCommand.FindStringIndex(sql); loc != nil {
+        t.Errorf("%s has a psql meta-command: %q", m, sql[loc[0]:loc[1]])
+      }
+    }
+    if strings.Contains(m.Up, "CREATE DATABASE") {
+      t.Errorf("%s creates a database, which cannot run in a transaction", m)
+    }
+  }
+}
+
+func TestStatus(t *testing.T) {
+  t.Parallel()
+  migrations, err := Load(dir("0001_a.up.sql", "0001_a.down.sql", "0002_b.up.sql", "0003_c.up.sql",
+    "0003_c.down.sql"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  at := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+  applied := func(versions ...int) []Applied {
+    var rows []Applied
+    for _, v := range versions {
+      rows = append(rows, Applied{ v, migrations[v - 1].Name, migrations[v - 1].Checksum, at })
+    }
+    return rows
+  }
+  states := Status(migrations, applied(1))
+  if len(states) != 3 || states[0].Status != StatusApplied || !states[0].AppliedAt.Equal(at) ||
+     states[1].Status != StatusPending || states[2].Status != StatusPending {
+    t.Errorf("Status = %+v; want 1 applied and 2, 3 pending", states)
+  }
+  todo, err := pending(migrations, applied(1))
+  if err != nil || len(todo) != 2 || todo[0].Version != 2 || todo[1].Version != 3 {
+    t.Errorf("pending = %v, %v; want 2 and 3", todo, err)
+  }
+  if todo, err = pending(migrations, applied(1, 2, 3)); err != nil || len(todo) != 0 {
+    t.Errorf("pending with every migration applied = %v, %v; want none", todo, err)
+  }
+  //A file that changed after it was applied, and a version this binary does not have.
+  changed := applied(1, 2)
+  changed[0].Checksum = "0"
+  missing := append(applied(1, 2, 3), Applied{ 4, "d", "0", at })
+  for _, rows := range [][]Applied{ changed, missing } {
+    if todo, err = pending(migrations, rows); err == nil {
+      t.Errorf("pending(%+v) = %v; want an error", rows, todo)
+    }
+    if _, err = rollbacks(migrations, rows, 1); err == nil {
+      t.Errorf("rollbacks(%+v) did not fail", rows)
+    }
+  }
+  if states = Status(migrations, missing); states[3].Status != StatusMissing || states[3].Name != "d" {
+    t.Errorf("Status = %+v; want 4 missing", states)
+  }
+  //The newest first; 2 has no down file.
+  if todo, err = rollbacks(migrations, applied(1, 3), 5); err != nil || len(todo) != 2 || todo[0].Version != 3 {
+    t.Errorf("rollbacks = %v, %v; want 3 and 1", todo, err)
+  }
+  if _, err = rollbacks(migrations, applied(1, 2), 1); err == nil {
+    t.Error("rollbacks of a migration without a down file did not fail")
+  }
+}
+
+func TestQuoteLiteral(t *testing.T) {
+  t.Parallel()
+  for in, want := range map[string]string{ "12345": "'12345'", "it's": "'it''s'", `a\b`: `'a\b'`, "": "''" } {
+    if got := quoteLiteral(in); got != want {
+      t.Errorf("quoteLiteral(%q) = %s; want %s", in, got, want)
+    }
+  }
+}
+
+func TestCommandUsage(t *testing.T) {
+  t.Parallel()
+  for _, args := range [][]string{ nil, { "sideways" }, { "status", "-dry-run" }, { "up", "-steps", "2" },
+                                  { "down", "-steps", "0" }, { "up", "extra" } } {
+    if code := Command(args, io.Discard, io.Discard); code != 2 {
+      t.Errorf("Command(%q) = %d; want 2", args, code)
+    }
+  }
+}
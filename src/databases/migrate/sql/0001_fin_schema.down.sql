@@ -0,0 +1,8 @@
+-- Drop the fin schema and everything in it; the users, their credentials, and the audit log are lost.
+DO $$
+BEGIN
+  EXECUTE format('ALTER DATABASE %I RESET search_path', current_database());
+END
+$$;
+DROP SCHEMA IF EXISTS fin CASCADE;
+DROP EXTENSION IF EXISTS pgcrypto;
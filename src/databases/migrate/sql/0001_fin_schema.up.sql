@@ -0,0 +1,1437 @@
+/**************************************************************************************************
+Notes
+ * By default, Postgres ignores case and always turns every identifier into lowercase. Postgres
+   preserves case only when the identifier is double quoted (e.g., "id"), and double-quoted
+   identifiers, known as 'delimited identifiers', are case sensitive.
+ * In Postgres, you can CAST between types with the :: shorthand.
+ * In PostgreSQL, stored procedures are not atomic by default but can manage their own transactions
+   explicitly to ensure atomicity. This is a key difference from PostgreSQL functions, which are
+   always atomic and run within a single, implicit transaction.
+ * Postgres executes a function atomically and transactionally; i.e, if the function fails at any
+   step during its execution, all previous changes made within that function are rolled back,
+   ensuring data integrity and consistency.
+ * After a COMMIT or ROLLBACK is issued inside a procedure, a new transaction is automatically
+   started; you do not need a separate START TRANSACTION command.
+ * END is a Postgres extension to the SQL language that is equivalent to COMMIT.
+Notes (Backup)
+ To perform a dump.
+ $ pg_dump finances > /tmp/finances.dump
+ To restore (first create the database).
+ $ psql finances < /tmp/finances.dump
+ To time how long it takes to restore.
+ $ time psql finances < /tmp/finances.dump
+Notes (Indexes)
+ * Postgres defaults to a B-tree index unless another data strcuture is specified. B-tree supports
+   a wide range of data types and can be used for both equality searches (=) and range queries
+   using greater than (>, >=), less than (<, <=), and BETWEEN operators.
+ * It's good practice to run the ANALYZE command after creating an index.
+   When a new index is created, the query planner may not take advantage of it immediately and may
+   continue relying on previously collected statistics. Running ANALYZE updates the table
+   statistics, allowing the planner to take full advantage of the new index.
+ * Postgres automatically creates an index for the primary key column.
+ * Indexes can significantly optimize query performance, but they don't come for free. Each time we
+   create a new index, Postgres must maintain it by updating its structure whenever the value of an
+   indexed column changes in the primary table.
+   Apart from the index maintenance aspects, the more indexes Postgres has, the more time it will
+   spend in the planning phase while selecting and generating the most efficient execution plan for
+   a query.
+**************************************************************************************************/
+/**************************************************************************************************
+The fin schema: the users, their credentials, roles and permissions, the settings, the audit log,
+and the stored procedures of the application.
+The roles (admin_role and the user of the application) and the database are created by the server
+before the migrations run (see migrate.Bootstrap); the migration runs in the database, in a
+transaction, as the user that applies the migrations (db_setup_user).
+**************************************************************************************************/
+/***
+Set the transaction to the new role. The role that is in force at the time of an object creation
+will own the object. Essentially, the owner of an object is analogous to a superuser of that object.
+SET LOCAL lasts until the transaction of the migration ends.
+***/
+SET LOCAL ROLE admin_role;
+
+/**************************************************************************************************
+                                         *** EXTENSION ***
+***************************************************************************************************
+To use bcrypt in Postgres, you can utilize the pgcrypto extension. This extension provides the
+crypt() and gen_salt() functions necessary for secure password hashing and verification within SQL.
+Enable the extension in your specific database by running the following SQL command.
+***/
+CREATE EXTENSION IF NOT EXISTS pgcrypto;
+
+/**************************************************************************************************
+                                           *** SCHEMAS ***
+**************************************************************************************************/
+CREATE SCHEMA IF NOT EXISTS fin;
+/**************************************************************************************************
+                *** DATABASE ROLES AND PRIVILEGES (Schema-level privileges) ***
+**************************************************************************************************/
+GRANT USAGE ON SCHEMA fin TO admin_role;
+/***
+Notice that you removed ALL privileges from 'public' at the database level, but not at the schema
+level of the database. If you remove all privileges from the schema as well, then normal Postgres
+commands would not work for many users without resetting additional privileges.
+Note that starting with Postgres 15 the 'public' role can NO longer create anything by default,
+regardless of the schema.
+***/
+REVOKE CREATE ON SCHEMA public FROM public;
+
+/***
+Set the search path to look for objects in your schema.
+The role must have been granted USAGE privileges on your schema, otherwise the path will be
+ ignored for your schema.
+Setting the search path at the role level will override the database-wide defaults set by
+ ALTER DATABASE.
+If you need all users in a group to share the same search_path, use the following:
+ Set at the Database Level: Apply the search_path to the database itself, so every new connection
+                            defaults to it.
+***/
+--ALTER ROLE admin_role SET search_path = fin;
+DO $$
+BEGIN
+  EXECUTE format('ALTER DATABASE %I SET search_path TO fin, public', current_database());
+END
+$$;
+
+/**************************************************************************************************
+                                           *** TABLES ***
+***************************************************************************************************
+customers-to-customer_contact_details Relationship: One-to-One
+customers-to-credentials Relationship: One-to-One
+URL: /register
+***/
+CREATE TABLE IF NOT EXISTS fin.customers(
+  id                 INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  /***
+  A column defined with a DEFAULT value and a CHECK constraint still needs an explicit NOT NULL
+  constraint if you want to prevent NULL values.
+  Here's why:
+  DEFAULT Constraint: This constraint only applies if no value is provided during an INSERT
+  operation. If you explicitly INSERT a NULL value into a column, the default value is bypassed,
+  and the NULL is inserted (unless NOT NULL is present).
+  CHECK Constraint: In SQL, any comparison involving a NULL value evaluates to UNKNOWN, not TRUE
+  or FALSE. A CHECK constraint only fails if the condition evaluates to FALSE. If it evaluates to
+  TRUE or UNKNOWN (due to a NULL value), the constraint is satisfied and the NULL is accepted.
+  NOT NULL Constraint: This is the only constraint specifically designed to enforce the presence of
+  data and prohibit NULL values in a column.
+  ***/
+  first_name         TEXT NOT NULL
+  -- Block empty strings ('') and strings with only blanks (' ').
+                       CONSTRAINT check_first_name
+                         CHECK(TRIM(first_name) <> ''),
+  middle_name        TEXT,
+  last_name          TEXT NOT NULL
+                       CONSTRAINT check_last_name
+                         CHECK(TRIM(last_name) <> ''),
+  marketing_consent  BOOLEAN NOT NULL DEFAULT FALSE,
+  /***
+  https://www.postgresql.org/docs/current/datatype-datetime.html
+  This stores date and time along with time zone information. PostgreSQL automatically converts the
+  timestamp to UTC for storage and adjusts it back based on the current time zone settings when
+  queried. 8 bytes in length.
+  ***/
+  created_at         TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at         TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_last_name
+  ON fin.customers
+  USING btree(last_name DESC);
+ANALYZE fin.customers;
+
+CREATE TABLE IF NOT EXISTS fin.customers_contact_details(
+  /***
+  A column can be both a primary key (PK) and a foreign key (FK) in a database table. This design
+  is used to represent a one-to-one or one-to-zero relationship between two tables, ensuring that
+  for every row in the child table there is exactly one corresponding row in the parent table.
+  ***/
+  id            INT PRIMARY KEY,
+                /***
+                A foreign key is a column or a set of columns in a database table (the child table)
+                that refers a unique constraint in another table (the parent table) establishing a
+                link between the two.
+                ***/
+                CONSTRAINT fk_customers_contact_details_to_customers
+                  FOREIGN KEY(id)
+                  REFERENCES fin.customers(id)
+                  /***
+                  Automatically deletes all the referencing rows in the child table when the
+                  referenced rows in the parent table are deleted.
+                  ***/
+                  ON DELETE CASCADE,
+  birth_date    DATE NOT NULL  --YYYY-MM-DD
+                  CONSTRAINT check_birth_date
+                    CHECK(birth_date > '1899-12-31'),
+  gender        TEXT NOT NULL,
+  address1      TEXT NOT NULL
+                  CONSTRAINT check_address1
+                    CHECK(TRIM(address1) <> ''),
+  address2      TEXT,
+  city_name     TEXT NOT NULL
+                  CONSTRAINT check_city_name
+                    CHECK(TRIM(city_name) <> ''),
+  state_name    TEXT NOT NULL
+                  CONSTRAINT check_state_name
+                    CHECK(TRIM(state_name) <> ''),
+  country_name  TEXT NOT NULL
+                  CONSTRAINT check_country_name
+                    CHECK(TRIM(country_name) <> ''),
+  zip_code      TEXT,
+  email         TEXT NOT NULL
+                  CONSTRAINT check_email
+                    CHECK(TRIM(email) <> ''),
+  phone         TEXT NOT NULL
+                  CONSTRAINT check_phone
+                    CHECK(TRIM(phone) <> ''),
+  /***
+  https://www.postgresql.org/docs/current/datatype-datetime.html
+  This stores date and time along with time zone information. PostgreSQL automatically converts the
+  timestamp to UTC for storage and adjusts it back based on the current time zone settings when
+  queried. 8 bytes in length.
+  ***/
+  created_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS  fin.customers_credentials(
+  id               INT PRIMARY KEY,
+                   CONSTRAINT fk_customers_credentials_to_customers
+                     FOREIGN KEY(id)
+                     REFERENCES fin.customers(id)
+                     ON DELETE CASCADE,
+  user_name        TEXT UNIQUE NOT NULL
+                     CONSTRAINT check_user_name
+                       CHECK(TRIM(user_name) <> ''),
+  password_hash    TEXT NOT NULL
+                     CONSTRAINT check_password_hash
+                       CHECK(TRIM(password_hash) <> ''),
+  is_admin         BOOLEAN NOT NULL DEFAULT FALSE,
+  -- Store only failed attempts to save space and speed up queries. This is effective for simple
+  -- throttling.
+  failed_attempts  INT NOT NULL DEFAULT 0,
+  -- When tracking the last attempt time for a task, the initial value should generally be set to
+  -- NULL to represent that an attempt has not yet occurred.
+  last_attempt     TIMESTAMP WITH TIME ZONE DEFAULT NULL
+);
+
+-- While set and in the future, the user cannot log in (temporary lockout after too many failed
+-- attempts); an admin can clear it.
+ALTER TABLE fin.customers_credentials
+  ADD COLUMN IF NOT EXISTS locked_until TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+
+-- TOTP (RFC 6238) second factor. The row is created when the user starts the enrollment and
+-- confirmed with the first valid code; an unconfirmed secret is not enforced at login.
+CREATE TABLE IF NOT EXISTS fin.customers_totp(
+  id               INT PRIMARY KEY,
+                   CONSTRAINT fk_customers_totp_to_customers_credentials
+                     FOREIGN KEY(id)
+                     REFERENCES fin.customers_credentials(id)
+                     ON DELETE CASCADE,
+  -- The base32 shared secret; it has to be readable to compute the codes.
+  secret           TEXT NOT NULL
+                     CONSTRAINT check_totp_secret
+                       CHECK(TRIM(secret) <> ''),
+  confirmed        BOOLEAN NOT NULL DEFAULT FALSE,
+  -- The last time step accepted; a code is never accepted twice.
+  last_counter     BIGINT NOT NULL DEFAULT 0,
+  created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  confirmed_at     TIMESTAMP WITH TIME ZONE DEFAULT NULL
+);
+
+-- One-time recovery codes; only the bcrypt hashes are stored.
+CREATE TABLE IF NOT EXISTS fin.customers_recovery_codes(
+  id               INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  customer_id      INT NOT NULL,
+                   CONSTRAINT fk_recovery_codes_to_customers_credentials
+                     FOREIGN KEY(customer_id)
+                     REFERENCES fin.customers_credentials(id)
+                     ON DELETE CASCADE,
+  code_hash        TEXT NOT NULL,
+  used_at          TIMESTAMP WITH TIME ZONE DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_customer_id
+  ON fin.customers_recovery_codes(customer_id);
+
+-- Application-wide settings changed by the admins at run time.
+CREATE TABLE IF NOT EXISTS fin.settings(
+  name             TEXT PRIMARY KEY,
+  value            TEXT NOT NULL,
+  updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+INSERT INTO fin.settings(name, value)
+VALUES('require_admin_2fa', 'false'), ('require_signup_approval', 'false')
+ON CONFLICT(name) DO NOTHING;
+
+-- Customers are never deleted (see trg_customers_prevent_delete); unregistering a customer marks
+-- it inactive, and an inactive customer cannot log in.
+ALTER TABLE fin.customers
+  ADD COLUMN IF NOT EXISTS is_active BOOLEAN NOT NULL DEFAULT TRUE,
+  ADD COLUMN IF NOT EXISTS deactivated_at TIMESTAMP WITH TIME ZONE DEFAULT NULL,
+  ADD COLUMN IF NOT EXISTS deactivation_reason TEXT DEFAULT NULL,
+  -- Set when the personal data was erased (fin.purge_customer); a purged customer stays inactive.
+  ADD COLUMN IF NOT EXISTS purged_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+
+/***
+Self-service sign-up (fin.signup_customer). The customer cannot log in until the status is
+'active': 'pending_email' until the link sent by email is opened (fin.verify_signup), then
+'pending_approval' if the admins review the sign-ups (see the setting require_signup_approval)
+until one approves it (fin.review_signup). The customers created by an admin are active at once.
+***/
+ALTER TABLE fin.customers
+  ADD COLUMN IF NOT EXISTS signup_status TEXT NOT NULL DEFAULT 'active'
+    CONSTRAINT check_signup_status
+      CHECK(signup_status IN('pending_email', 'pending_approval', 'active')),
+  ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+
+-- Who deactivated, reactivated, or purged a customer, when, and why.
+CREATE TABLE IF NOT EXISTS fin.customers_status_audit(
+  id               INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  customer_id      INT NOT NULL,
+                   CONSTRAINT fk_customers_status_audit_to_customers
+                     FOREIGN KEY(customer_id)
+                     REFERENCES fin.customers(id),
+  action           TEXT NOT NULL
+                     CONSTRAINT check_status_audit_action
+                       CHECK(action IN('deactivate', 'reactivate', 'purge')),
+  reason           TEXT,
+  performed_by     TEXT NOT NULL,
+  performed_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_customers_status_audit_customer_id
+  ON fin.customers_status_audit(customer_id);
+
+-- The previous password hashes of every user (see trg_customers_credentials_password_history); a
+-- new password must not match any of the last ones (see fin.change_password).
+CREATE TABLE IF NOT EXISTS fin.customers_password_history(
+  id               INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  customer_id      INT NOT NULL,
+                   CONSTRAINT fk_password_history_to_customers_credentials
+                     FOREIGN KEY(customer_id)
+                     REFERENCES fin.customers_credentials(id)
+                     ON DELETE CASCADE,
+  password_hash    TEXT NOT NULL,
+  created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_history_customer_id
+  ON fin.customers_password_history(customer_id, id DESC);
+
+-- Self-service password resets. Only the SHA-256 hash of the token sent by email is stored; a token
+-- is valid until it expires, is used, or is superseded by a newer one.
+CREATE TABLE IF NOT EXISTS fin.password_reset_tokens(
+  id               INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  customer_id      INT NOT NULL,
+                   CONSTRAINT fk_password_reset_tokens_to_customers_credentials
+                     FOREIGN KEY(customer_id)
+                     REFERENCES fin.customers_credentials(id)
+                     ON DELETE CASCADE,
+  token_hash       TEXT UNIQUE NOT NULL,
+  created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at          TIMESTAMP WITH TIME ZONE DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_customer_id
+  ON fin.password_reset_tokens(customer_id);
+
+/***
+Role-based access control. A role grants named permissions, and a customer can hold several roles.
+The routes of the application are gated by permission (see the authz package). The is_admin flag of
+fin.customers_credentials is kept for the login (the admin area, the JWT, and the protection of the
+admin rows); it is derived from the roles: a customer is an admin if one of its roles grants
+'admin.access' (see fin.set_customer_roles).
+***/
+CREATE TABLE IF NOT EXISTS fin.roles(
+  name             TEXT PRIMARY KEY,
+  description      TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fin.permissions(
+  name             TEXT PRIMARY KEY,
+  description      TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS fin.role_permissions(
+  role_name        TEXT NOT NULL
+                     REFERENCES fin.roles(name)
+                     ON DELETE CASCADE,
+  permission_name  TEXT NOT NULL
+                     REFERENCES fin.permissions(name)
+                     ON DELETE CASCADE,
+  PRIMARY KEY(role_name, permission_name)
+);
+
+CREATE TABLE IF NOT EXISTS fin.customer_roles(
+  customer_id      INT NOT NULL,
+                   CONSTRAINT fk_customer_roles_to_customers_credentials
+                     FOREIGN KEY(customer_id)
+                     REFERENCES fin.customers_credentials(id)
+                     ON DELETE CASCADE,
+  role_name        TEXT NOT NULL
+                     REFERENCES fin.roles(name)
+                     ON DELETE CASCADE,
+  granted_by       TEXT,
+  granted_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY(customer_id, role_name)
+);
+
+INSERT INTO fin.roles(name, description)
+VALUES
+  ('customer', 'Uses the banking pages and the file storage.'),
+  ('teller', 'Serves the customers: the banking pages and the customer directory.'),
+  ('auditor', 'Reviews the application: the diagnostics and the audit log; read-only.'),
+  ('admin', 'Manages the application: every permission.')
+ON CONFLICT(name) DO NOTHING;
+
+INSERT INTO fin.permissions(name, description)
+VALUES
+  ('banking.use', 'Use the banking pages.'),
+  ('storage.read', 'List and download the files in the storage.'),
+  ('storage.write', 'Create buckets, and upload and delete files in the storage.'),
+  ('admin.access', 'Enter the admin area.'),
+  ('admin.users', 'Register, unregister, and manage the users.'),
+  ('admin.roles', 'Assign roles to the users.'),
+  ('admin.settings', 'Change the settings of the application.'),
+  ('admin.diagnostics', 'View the diagnostics.'),
+  ('audit.read', 'Read the audit log.')
+ON CONFLICT(name) DO NOTHING;
+
+INSERT INTO fin.role_permissions(role_name, permission_name)
+VALUES
+  ('customer', 'banking.use'),
+  ('customer', 'storage.read'),
+  ('customer', 'storage.write'),
+  ('teller', 'banking.use'),
+  ('teller', 'admin.access'),
+  ('teller', 'admin.users'),
+  ('auditor', 'admin.access'),
+  ('auditor', 'admin.diagnostics'),
+  ('auditor', 'audit.read')
+ON CONFLICT DO NOTHING;
+
+-- The admin role grants every permission.
+INSERT INTO fin.role_permissions(role_name, permission_name)
+SELECT 'admin', name
+FROM fin.permissions
+ON CONFLICT DO NOTHING;
+
+/***
+Security audit log: who did what to whom, from where, and with which outcome. The table is
+append-only (see trg_audit_events_append_only); the only rows ever deleted are the ones older than
+the retention period, by fin.purge_audit_events.
+***/
+CREATE TABLE IF NOT EXISTS fin.audit_events(
+  id               BIGINT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  occurred_at      TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  -- e.g., 'login', 'logout', 'user.register', 'password.change'.
+  event_type       TEXT NOT NULL,
+  -- The user who acted; the username typed in the login form for a failed login.
+  actor            TEXT NOT NULL,
+  -- The user (or object) acted upon; NULL if it is the actor.
+  target           TEXT,
+  client_ip        TEXT,
+  outcome          TEXT NOT NULL
+                     CONSTRAINT check_audit_outcome
+                       CHECK(outcome IN('success', 'failure', 'denied')),
+  correlation_id   TEXT,
+  details          TEXT
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_events_occurred_at
+  ON fin.audit_events(occurred_at);
+CREATE INDEX IF NOT EXISTS idx_audit_events_actor
+  ON fin.audit_events(actor);
+CREATE INDEX IF NOT EXISTS idx_audit_events_target
+  ON fin.audit_events(target);
+
+-- The state of the pages of each user (the fields of the calculators and the selected subpages)
+-- when the state store is Postgres (STATE_STORE=postgres); all the instances of the server share it.
+CREATE TABLE IF NOT EXISTS fin.user_page_state(
+  customer_id      INT NOT NULL,
+                   CONSTRAINT fk_user_page_state_to_customers_credentials
+                     FOREIGN KEY(customer_id)
+                     REFERENCES fin.customers_credentials(id)
+                     ON DELETE CASCADE,
+  -- 'finances', 'banking', or 'admin'.
+  namespace        TEXT NOT NULL,
+  page             TEXT NOT NULL,
+  state            JSONB NOT NULL,
+  updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY(customer_id, namespace, page)
+);
+
+-- The token buckets of the rate limiter when they are shared by the instances of the server
+-- (RATE_LIMIT_STORE=postgres); the key is the rule and the client IP, username, or session.
+CREATE TABLE IF NOT EXISTS fin.rate_limits(
+  key              TEXT PRIMARY KEY,
+  tokens           DOUBLE PRECISION NOT NULL,
+  allowed          BOOLEAN NOT NULL,
+  updated_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+/**************************************************************************************************
+               *** DATABASE ROLES AND PRIVILEGES (Table-level privileges) ***
+**************************************************************************************************/
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA fin TO admin_role;
+GRANT USAGE, SELECT ON ALL SEQUENCES IN SCHEMA fin TO admin_role;
+
+/***
+Privileges are only granted/revoked for objects in existence at the time of the GRANT/REVOKE. ALTER
+DEFAULT PRIVILEGES allows you to set the privileges that will be applied to objects created in the
+future. (It does not affect privileges assigned to already-existing objects.) Privileges can be set
+globally (i.e., for all objects created in the current database), or just for objects created in
+specified schemas.
+***/
+ALTER DEFAULT PRIVILEGES IN SCHEMA fin
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO admin_role;
+ALTER DEFAULT PRIVILEGES IN SCHEMA fin
+GRANT USAGE, SELECT ON SEQUENCES TO admin_role;
+
+-- The audit log is append-only.
+REVOKE UPDATE, TRUNCATE ON fin.audit_events FROM admin_role;
+
+/***
+Add a row for the default admin account ONLY when the fin.customers table is empty.
+***/
+WITH admin_customer AS (  -- Common Table Expression (CTE).
+  INSERT INTO fin.customers(
+    first_name,
+    last_name)
+  SELECT
+    'n/a',  -- Not applicable.
+    'n/a'
+  WHERE NOT EXISTS(SELECT 1 FROM fin.customers)
+  RETURNING id  -- Capture the auto-generated primary key.
+)
+INSERT INTO fin.customers_credentials(
+  id,
+  user_name,
+  password_hash,
+  is_admin)
+SELECT
+  id,
+  'admin',
+  crypt('admin', gen_salt('bf', 10)),
+  TRUE
+FROM admin_customer;
+
+/***
+Every user holds the customer role, and the admins hold the admin role as well; the trigger
+trg_customers_credentials_default_roles does the same for the new users.
+***/
+INSERT INTO fin.customer_roles(customer_id, role_name, granted_by)
+SELECT id, 'customer', 'system'
+FROM fin.customers_credentials
+ON CONFLICT DO NOTHING;
+
+INSERT INTO fin.customer_roles(customer_id, role_name, granted_by)
+SELECT id, 'admin', 'system'
+FROM fin.customers_credentials
+WHERE is_admin
+ON CONFLICT DO NOTHING;
+
+/**************************************************************************************************
+                                 *** FUNCTIONS/STORED PROCEDURES ***
+**************************************************************************************************/
+-- DROP PROCEDURE IF EXISTS fin.add_customer;
+CREATE OR REPLACE PROCEDURE fin.add_customer(
+  IN p_user_name TEXT,
+  IN p_password TEXT,
+  IN p_first_name TEXT,
+  IN p_middle_name TEXT,
+  IN p_last_name TEXT,
+  IN p_marketing BOOLEAN,
+  IN p_birth_date DATE,
+  IN p_gender CHAR,
+  IN p_address1 TEXT,
+  IN p_address2 TEXT,
+  IN p_city TEXT,
+  IN p_state TEXT,
+  IN p_country TEXT,
+  IN p_zip_code TEXT,
+  IN p_email TEXT,
+  IN p_phone TEXT
+)
+LANGUAGE PLPGSQL
+/***
+This delimits the SP body using dollar-quoted string constants, which avoids the need to escape
+single quotes within the code.
+***/
+AS $$
+DECLARE
+  c_id INT;
+  pwd_hash TEXT;
+--This block encloses the executable logic of the stored procedure's body.
+BEGIN
+  -- First, insert into the 'customers' table and return the new id.
+  INSERT INTO fin.customers(
+    first_name,
+    middle_name,
+    last_name,
+    marketing_consent)
+  VALUES(
+    p_first_name,
+    p_middle_name,
+    p_last_name,
+    p_marketing)
+  RETURNING id INTO c_id;  -- This makes the new 'id' available to the next statements.
+  INSERT INTO fin.customers_contact_details(
+    id,
+    birth_date,
+    gender,
+    address1,
+    address2,
+    city_name,
+    state_name,
+    country_name,
+    zip_code,
+    email,
+    phone)
+  VALUES(
+    c_id,
+    p_birth_date,
+    p_gender,
+    p_address1,
+    p_address2,
+    p_city,
+    p_state,
+    p_country,
+    p_zip_code,
+    p_email,
+    p_phone);
+  pwd_hash := crypt(p_password, gen_salt('bf', 10));
+  INSERT INTO fin.customers_credentials(
+    id,
+    user_name,
+    password_hash)
+  VALUES(
+    c_id,
+    p_user_name,
+    pwd_hash);
+EXCEPTION  -- https://www.postgresql.org/docs/current/errcodes-appendix.html
+  WHEN unique_violation THEN
+    -- Re-raise the exception to inform the caller and ensure rollback of the transaction.
+    RAISE EXCEPTION 'Username (%) is already taken. Please choose another one.', p_user_name;
+  WHEN check_violation THEN
+    RAISE EXCEPTION '% -- %', SQLSTATE, SQLERRM;
+  WHEN OTHERS THEN
+    RAISE EXCEPTION '% -- %', SQLSTATE, SQLERRM;
+END;
+$$;
+
+/***
+Self-service sign-up: the customer is created by fin.add_customer, but cannot log in until the email
+address is verified (and the sign-up approved, if required); see the column signup_status. It
+returns the id of the new customer, which is signed in the verification link.
+***/
+CREATE OR REPLACE PROCEDURE fin.signup_customer(
+  IN p_user_name TEXT,
+  IN p_password TEXT,
+  IN p_first_name TEXT,
+  IN p_middle_name TEXT,
+  IN p_last_name TEXT,
+  IN p_marketing BOOLEAN,
+  IN p_birth_date DATE,
+  IN p_gender CHAR,
+  IN p_address1 TEXT,
+  IN p_address2 TEXT,
+  IN p_city TEXT,
+  IN p_state TEXT,
+  IN p_country TEXT,
+  IN p_zip_code TEXT,
+  IN p_email TEXT,
+  IN p_phone TEXT,
+  OUT pid INT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  CALL fin.add_customer(p_user_name, p_password, p_first_name, p_middle_name, p_last_name, p_marketing,
+    p_birth_date, p_gender, p_address1, p_address2, p_city, p_state, p_country, p_zip_code, p_email,
+    p_phone);
+  SELECT id INTO pid FROM fin.customers_credentials WHERE user_name = p_user_name;
+  UPDATE fin.customers
+  SET
+    signup_status = 'pending_email'
+  WHERE id = pid;
+END;
+$$;
+
+/***
+The customer opened the verification link. Return values:
+ 1: The email address was verified; the sign-up waits for the approval of an admin.
+ 0: The email address was verified; the customer can log in.
+-1: Unknown customer, or the email address was already verified.
+***/
+CREATE OR REPLACE PROCEDURE fin.verify_signup(
+  IN pid INT,
+  IN prequire_approval BOOL,
+  OUT ret INT,
+  OUT puser_name TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vverified BOOL;
+BEGIN
+  UPDATE fin.customers
+  SET
+    signup_status = CASE WHEN prequire_approval THEN 'pending_approval' ELSE 'active' END,
+    email_verified_at = CURRENT_TIMESTAMP,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE id = pid AND signup_status = 'pending_email' AND is_active;
+  vverified := FOUND;
+  SELECT user_name INTO puser_name FROM fin.customers_credentials WHERE id = pid;
+  IF NOT vverified THEN
+    ret := -1;
+  ELSIF prequire_approval THEN
+    ret := 1;
+  ELSE
+    ret := 0;
+  END IF;
+END;
+$$;
+
+/***
+An admin approves a verified sign-up, or rejects it; a rejected customer is deactivated (with the
+reason) and can be purged. Reactivating it puts it back in the approval queue.
+***/
+CREATE OR REPLACE PROCEDURE fin.review_signup(
+  IN puser_name TEXT,
+  IN papprove BOOL,
+  IN preason TEXT,
+  IN pperformed_by TEXT,
+  OUT ret BOOL
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vid INT;
+BEGIN
+  SELECT c.id
+  INTO vid
+  FROM fin.customers c
+  JOIN fin.customers_credentials cc ON cc.id = c.id
+  WHERE cc.user_name = puser_name AND c.signup_status = 'pending_approval' AND c.is_active
+  FOR UPDATE OF c;
+  ret := FOUND;
+  IF NOT ret THEN
+    RETURN;
+  ELSIF papprove THEN
+    UPDATE fin.customers
+    SET
+      signup_status = 'active',
+      updated_at = CURRENT_TIMESTAMP
+    WHERE id = vid;
+  ELSE
+    UPDATE fin.customers
+    SET
+      is_active = FALSE,
+      deactivated_at = CURRENT_TIMESTAMP,
+      deactivation_reason = 'Sign-up rejected: ' || preason,
+      updated_at = CURRENT_TIMESTAMP
+    WHERE id = vid;
+    INSERT INTO fin.customers_status_audit(customer_id, action, reason, performed_by)
+    VALUES(vid, 'deactivate', 'Sign-up rejected: ' || preason, pperformed_by);
+  END IF;
+END;
+$$;
+
+/***
+Return values:
+  -5 -- The sign-up of the user is pending (email verification or approval).
+  -4 -- The user is inactive (unregistered).
+  -3 -- The user is locked out.
+  -2 -- Invalid (unknown) user.
+  -1 -- Authentication failed.
+   0 -- User authenticated.
+Throttling:
+  pdelay_after  -- Failed attempts before every new failure is delayed (exponential backoff).
+  plock_after   -- Failed attempts before the user is locked out for plock_minutes; once the lock
+                   expires, every new failure locks the user again until a successful login (or an
+                   admin unlock) resets the counter.
+***/
+DROP PROCEDURE IF EXISTS fin.authenticate_user(TEXT, TEXT, TEXT, OUT INT, OUT BOOL);
+CREATE OR REPLACE PROCEDURE fin.authenticate_user(
+  IN puser_name TEXT,
+  IN ppassword TEXT,
+  IN correlation_id TEXT,
+  IN pdelay_after INT,
+  IN plock_after INT,
+  IN plock_minutes INT,
+  OUT pout INT,
+  OUT pis_admin BOOL
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vbase_delay CONSTANT NUMERIC := 1;  -- Seconds.
+  vmin_delay CONSTANT NUMERIC := 0.5;  -- Seconds (500ms).
+  vmax_delay CONSTANT NUMERIC := 30;  -- Seconds.
+  vunknown_user_delay CONSTANT NUMERIC := 5;  -- Seconds.
+  vsleep_time NUMERIC := 0;
+  vfailed_attempts INT;
+  vhash TEXT;
+  vlocked_until TIMESTAMP WITH TIME ZONE;
+  vis_active BOOL;
+  vsignup_status TEXT;
+BEGIN
+  pis_admin := false;
+  SELECT
+    cc.password_hash,
+    cc.locked_until,
+    c.is_active,
+    c.signup_status
+  INTO
+    vhash,
+    vlocked_until,
+    vis_active,
+    vsignup_status
+  FROM fin.customers_credentials cc
+  JOIN fin.customers c ON c.id = cc.id
+  WHERE cc.user_name = puser_name;
+  /***
+  The SELECT INTO statement in Postgres (without the STRICT keyword) sets a special variable
+  called FOUND to TRUE if a row is returned, and FALSE if no row is found. No exception is raised
+  automatically in this case, allowing you to check the condition and handle it gracefully.
+  ***/
+  IF NOT FOUND THEN  -- User not found; password can't be valid.
+    pout := -2;
+    RAISE NOTICE 'Unknown user (%); sleeping for % seconds', puser_name, vunknown_user_delay USING DETAIL = correlation_id;
+    -- Pause the current session for the specified number of seconds (can be a decimal).
+    PERFORM pg_sleep(vunknown_user_delay);
+  /***
+  While locked, the password is not even checked, so guessing it is pointless; the attempt does not
+  extend the lock either.
+  ***/
+  -- An unregistered (inactive) customer is treated like an unknown user.
+  ELSIF NOT vis_active THEN
+    pout := -4;
+    RAISE NOTICE 'Inactive user (%)', puser_name USING DETAIL = correlation_id;
+    PERFORM pg_sleep(vunknown_user_delay);
+  -- A sign-up that was not verified (or approved) yet; also treated like an unknown user.
+  ELSIF vsignup_status <> 'active' THEN
+    pout := -5;
+    RAISE NOTICE 'Pending sign-up (%): %', puser_name, vsignup_status USING DETAIL = correlation_id;
+    PERFORM pg_sleep(vunknown_user_delay);
+  ELSIF vlocked_until IS NOT NULL AND vlocked_until > CURRENT_TIMESTAMP THEN
+    pout := -3;
+    RAISE NOTICE 'User (%) locked until %', puser_name, vlocked_until USING DETAIL = correlation_id;
+    PERFORM pg_sleep(vunknown_user_delay);
+  /***
+  Use the crypt function to compare the provided password against the stored hash;
+  the crypt function uses the salt from the stored hash to perform the comparison.
+  ***/
+  ELSIF vhash = crypt(ppassword, vhash) THEN  -- User authenticated.
+    pout := 0;
+    UPDATE fin.customers_credentials
+    SET
+      failed_attempts = 0,
+      last_attempt = CURRENT_TIMESTAMP,
+      locked_until = NULL
+    WHERE user_name = puser_name
+    RETURNING is_admin INTO pis_admin;
+  ELSE  -- Authentication failed.
+    pout := -1;
+    UPDATE fin.customers_credentials
+    SET
+      failed_attempts = failed_attempts + 1,
+      last_attempt = CURRENT_TIMESTAMP
+    WHERE user_name = puser_name
+    RETURNING failed_attempts INTO vfailed_attempts;
+    IF vfailed_attempts >= plock_after THEN
+      UPDATE fin.customers_credentials
+      SET
+        locked_until = CURRENT_TIMESTAMP + MAKE_INTERVAL(mins => plock_minutes)
+      WHERE user_name = puser_name;
+      RAISE NOTICE 'Attempt % failed; user (%) locked for % minutes', vfailed_attempts, puser_name, plock_minutes
+        USING DETAIL = correlation_id;
+    ELSIF vfailed_attempts > pdelay_after THEN
+      /***
+      The basic algorithm increases the wait time between each retry attempt, often calculated as
+      base_delay * (2 ^ failed_attempts).
+      Exponential backoff: Delay doubles each time (e.g., 1s, 2s, 4s, 8s, 16s etc.), usually up to
+      a defined maximum.
+      Add random noise (jitter) to the exponential backoff time to prevent multiple clients from
+      retrying simultaneously, which can cause a "thundering herd."
+      The random() function supports a (min, max) syntax for integers and numeric types, returning
+      a random value within the specified INCLUSIVE range.
+      ***/
+      vsleep_time := RANDOM(vmin_delay, LEAST(vmax_delay, CAST(vbase_delay * POWER(2, vfailed_attempts) AS NUMERIC)));
+      RAISE NOTICE 'Attempt % failed; blocking for % seconds...', vfailed_attempts, vsleep_time USING DETAIL = correlation_id;
+      PERFORM pg_sleep(vsleep_time);
+    END IF;
+  END IF;
+END;
+$$;
+
+-- Clear the failed attempts and the lock of a user.
+CREATE OR REPLACE PROCEDURE fin.unlock_user(
+  IN puser_name TEXT,
+  OUT ret BOOL
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  UPDATE fin.customers_credentials
+  SET
+    failed_attempts = 0,
+    locked_until = NULL
+  WHERE user_name = puser_name;
+  ret := FOUND;
+END;
+$$;
+
+/***
+Unregister a customer. The admins are protected (see trg_customers_credentials_prevent_delete) and
+cannot be deactivated.
+***/
+CREATE OR REPLACE PROCEDURE fin.deactivate_customer(
+  IN puser_name TEXT,
+  IN preason TEXT,
+  IN pperformed_by TEXT,
+  OUT ret BOOL
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vid INT;
+BEGIN
+  UPDATE fin.customers c
+  SET
+    is_active = FALSE,
+    deactivated_at = CURRENT_TIMESTAMP,
+    deactivation_reason = preason,
+    updated_at = CURRENT_TIMESTAMP
+  FROM fin.customers_credentials cc
+  WHERE cc.id = c.id AND cc.user_name = puser_name AND NOT cc.is_admin AND c.is_active
+  RETURNING c.id INTO vid;
+  ret := FOUND;
+  IF ret THEN
+    INSERT INTO fin.customers_status_audit(customer_id, action, reason, performed_by)
+    VALUES(vid, 'deactivate', preason, pperformed_by);
+  END IF;
+END;
+$$;
+
+-- A purged customer cannot be reactivated; its personal data is gone.
+CREATE OR REPLACE PROCEDURE fin.reactivate_customer(
+  IN puser_name TEXT,
+  IN pperformed_by TEXT,
+  OUT ret BOOL
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vid INT;
+BEGIN
+  UPDATE fin.customers c
+  SET
+    is_active = TRUE,
+    deactivated_at = NULL,
+    deactivation_reason = NULL,
+    updated_at = CURRENT_TIMESTAMP
+  FROM fin.customers_credentials cc
+  WHERE cc.id = c.id AND cc.user_name = puser_name AND NOT c.is_active AND c.purged_at IS NULL
+  RETURNING c.id INTO vid;
+  ret := FOUND;
+  IF ret THEN
+    -- The failed attempts made while the customer was inactive do not count.
+    UPDATE fin.customers_credentials
+    SET
+      failed_attempts = 0,
+      locked_until = NULL
+    WHERE id = vid;
+    INSERT INTO fin.customers_status_audit(customer_id, action, reason, performed_by)
+    VALUES(vid, 'reactivate', NULL, pperformed_by);
+  END IF;
+END;
+$$;
+
+/***
+Erase the personal data of an inactive customer (legal erasure). The rows are kept, so everything
+that references the customer stays valid; the names, contact details, and credentials are replaced
+with placeholders and the username becomes 'purged-<id>'. It returns the new username, or NULL if
+the customer does not exist, is still active, or is an admin.
+***/
+CREATE OR REPLACE PROCEDURE fin.purge_customer(
+  IN puser_name TEXT,
+  IN preason TEXT,
+  IN pperformed_by TEXT,
+  OUT pnew_user_name TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vid INT;
+BEGIN
+  pnew_user_name := NULL;
+  SELECT c.id
+  INTO vid
+  FROM fin.customers c
+  JOIN fin.customers_credentials cc ON cc.id = c.id
+  WHERE cc.user_name = puser_name AND NOT cc.is_admin AND NOT c.is_active
+  FOR UPDATE OF c, cc;
+  IF NOT FOUND THEN
+    RETURN;
+  END IF;
+  pnew_user_name := 'purged-' || vid;
+  UPDATE fin.customers
+  SET
+    first_name = 'Purged',
+    middle_name = NULL,
+    last_name = 'Customer ' || vid,
+    marketing_consent = FALSE,
+    purged_at = CURRENT_TIMESTAMP,
+    updated_at = CURRENT_TIMESTAMP
+  WHERE id = vid;
+  UPDATE fin.customers_contact_details
+  SET
+    birth_date = '1900-01-01',
+    gender = 'unknown',
+    address1 = 'purged',
+    address2 = NULL,
+    city_name = 'purged',
+    state_name = 'purged',
+    country_name = 'purged',
+    zip_code = NULL,
+    email = pnew_user_name || '@invalid',
+    phone = 'purged',
+    updated_at = CURRENT_TIMESTAMP
+  WHERE id = vid;
+  -- No password can match '!' (it is not a valid crypt hash).
+  UPDATE fin.customers_credentials
+  SET
+    user_name = pnew_user_name,
+    password_hash = '!',
+    failed_attempts = 0,
+    last_attempt = NULL,
+    locked_until = NULL
+  WHERE id = vid;
+  DELETE FROM fin.customers_recovery_codes WHERE customer_id = vid;
+  DELETE FROM fin.customers_totp WHERE id = vid;
+  DELETE FROM fin.customers_password_history WHERE customer_id = vid;
+  DELETE FROM fin.password_reset_tokens WHERE customer_id = vid;
+  DELETE FROM fin.user_page_state WHERE customer_id = vid;
+  INSERT INTO fin.customers_status_audit(customer_id, action, reason, performed_by)
+  VALUES(vid, 'purge', preason, pperformed_by);
+END;
+$$;
+
+/***
+Whether the password matches the current password of the customer or any of the previous ones, up
+to p_history passwords in total (the history includes the current password; the trigger records
+every new hash).
+***/
+CREATE OR REPLACE FUNCTION fin.password_was_used(
+  p_id INT,
+  p_password TEXT,
+  p_history INT
+)
+RETURNS BOOL
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  RETURN EXISTS(
+    SELECT 1
+    FROM fin.customers_credentials cc
+    WHERE cc.id = p_id AND cc.password_hash = crypt(p_password, cc.password_hash)
+  ) OR EXISTS(
+    SELECT 1
+    FROM (SELECT password_hash
+          FROM fin.customers_password_history
+          WHERE customer_id = p_id
+          ORDER BY id DESC
+          LIMIT GREATEST(p_history, 0)) h
+    WHERE h.password_hash = crypt(p_password, h.password_hash)
+  );
+END;
+$$;
+
+/***
+Change the password of a user after checking the old one. The new password must not match any of
+the last p_history passwords of the user (the current one counts, and it is always checked). Return
+values:
+ 0: The password was changed.
+-1: Unknown user or wrong old password.
+-2: The new password was used recently.
+***/
+DROP PROCEDURE IF EXISTS fin.change_password(TEXT, TEXT, TEXT, TEXT, OUT BOOL);
+CREATE OR REPLACE PROCEDURE fin.change_password(
+  IN p_user_name TEXT,
+  IN p_old_password TEXT,
+  IN p_new_password TEXT,
+  IN p_history INT,
+  IN correlation_id TEXT,
+  OUT ret INT
+)
+LANGUAGE PLPGSQL
+/***
+This delimits the SP body using dollar-quoted string constants, which avoids the need to escape single quotes within the code.
+***/
+AS $$
+DECLARE
+  vid INT;
+  vpwd_hash TEXT;
+--This block encloses the executable logic of the stored procedure's body.
+BEGIN
+  ret := -1;
+  SELECT
+    id,
+    password_hash
+  INTO
+    vid,
+    vpwd_hash
+  FROM fin.customers_credentials
+  WHERE user_name = p_user_name
+  FOR UPDATE;
+  IF NOT FOUND THEN  -- User not found; password can't be valid.
+    RAISE NOTICE 'Unknown user (%); password not changed.', p_user_name USING DETAIL = correlation_id;
+  ELSIF vpwd_hash = crypt(p_old_password, vpwd_hash) THEN  -- User authenticated.
+    IF fin.password_was_used(vid, p_new_password, p_history) THEN
+      ret := -2;
+      RETURN;
+    END IF;
+    vpwd_hash := crypt(p_new_password, gen_salt('bf', 10));
+    UPDATE fin.customers_credentials
+    SET
+      password_hash = vpwd_hash
+    WHERE id = vid;
+    ret := 0;
+  END IF;
+END;
+$$;
+
+/***
+Issue a password-reset token for an active customer. Any unused token of the customer is
+superseded. It returns the email address to send the token to, or NULL if the user does not exist,
+is inactive, has no email address, or requested a token less than a minute ago (so the form cannot be
+used to flood a mailbox).
+***/
+CREATE OR REPLACE PROCEDURE fin.create_password_reset(
+  IN puser_name TEXT,
+  IN ptoken_hash TEXT,
+  IN pminutes INT,
+  OUT pemail TEXT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vid INT;
+  vemail TEXT;
+BEGIN
+  pemail := NULL;
+  SELECT cc.id, d.email
+  INTO vid, vemail
+  FROM fin.customers_credentials cc
+  JOIN fin.customers c ON c.id = cc.id
+  LEFT JOIN fin.customers_contact_details d ON d.id = cc.id
+  WHERE cc.user_name = puser_name AND c.is_active AND c.signup_status = 'active'
+  FOR UPDATE OF cc;
+  IF NOT FOUND OR COALESCE(TRIM(vemail), '') = '' THEN
+    RETURN;
+  END IF;
+  IF EXISTS(SELECT 1
+            FROM fin.password_reset_tokens
+            WHERE customer_id = vid AND created_at > CURRENT_TIMESTAMP - INTERVAL '1 minute') THEN
+    RETURN;
+  END IF;
+  UPDATE fin.password_reset_tokens
+  SET
+    used_at = CURRENT_TIMESTAMP
+  WHERE customer_id = vid AND used_at IS NULL;
+  INSERT INTO fin.password_reset_tokens(customer_id, token_hash, expires_at)
+  VALUES(vid, ptoken_hash, CURRENT_TIMESTAMP + make_interval(mins => pminutes));
+  pemail := vemail;
+END;
+$$;
+
+/***
+Set a new password with a reset token; the token is consumed, and the failed login attempts and the
+lockout are cleared. Return values:
+ 0: The password was changed.
+-1: The token is unknown, expired, or used, or the customer is inactive.
+-2: The new password was used recently (the token can still be used).
+***/
+CREATE OR REPLACE PROCEDURE fin.reset_password(
+  IN ptoken_hash TEXT,
+  IN pnew_password TEXT,
+  IN phistory INT,
+  IN correlation_id TEXT,
+  OUT ret INT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vtoken_id INT;
+  vid INT;
+BEGIN
+  ret := -1;
+  SELECT t.id, t.customer_id
+  INTO vtoken_id, vid
+  FROM fin.password_reset_tokens t
+  JOIN fin.customers c ON c.id = t.customer_id
+  WHERE t.token_hash = ptoken_hash AND t.used_at IS NULL AND t.expires_at > CURRENT_TIMESTAMP AND c.is_active
+  FOR UPDATE OF t;
+  IF NOT FOUND THEN
+    RAISE NOTICE 'Invalid password-reset token.' USING DETAIL = correlation_id;
+    RETURN;
+  END IF;
+  IF fin.password_was_used(vid, pnew_password, phistory) THEN
+    ret := -2;
+    RETURN;
+  END IF;
+  UPDATE fin.customers_credentials
+  SET
+    password_hash = crypt(pnew_password, gen_salt('bf', 10)),
+    failed_attempts = 0,
+    last_attempt = NULL,
+    locked_until = NULL
+  WHERE id = vid;
+  UPDATE fin.password_reset_tokens
+  SET
+    used_at = CURRENT_TIMESTAMP
+  WHERE id = vtoken_id;
+  ret := 0;
+END;
+$$;
+
+/***
+Replace the roles of a user; is_admin follows the 'admin.access' permission. The default admin
+(id 1) always keeps the admin role, so the application cannot be left without one. Return values:
+ 0: The roles were saved.
+-1: Unknown user or role.
+-2: The admin role cannot be removed from the default admin.
+***/
+CREATE OR REPLACE PROCEDURE fin.set_customer_roles(
+  IN puser_name TEXT,
+  IN proles TEXT[],
+  IN pperformed_by TEXT,
+  OUT ret INT
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vid INT;
+BEGIN
+  ret := -1;
+  SELECT id
+  INTO vid
+  FROM fin.customers_credentials
+  WHERE user_name = puser_name
+  FOR UPDATE;
+  IF NOT FOUND OR EXISTS(SELECT 1
+                         FROM unnest(proles) r(name)
+                         WHERE r.name NOT IN(SELECT name FROM fin.roles)) THEN
+    RETURN;
+  END IF;
+  IF vid = 1 AND NOT 'admin' = ANY(proles) THEN
+    ret := -2;
+    RETURN;
+  END IF;
+  DELETE FROM fin.customer_roles
+  WHERE customer_id = vid AND NOT role_name = ANY(proles);
+  INSERT INTO fin.customer_roles(customer_id, role_name, granted_by)
+  SELECT vid, r.name, pperformed_by
+  FROM unnest(proles) r(name)
+  ON CONFLICT DO NOTHING;
+  UPDATE fin.customers_credentials
+  SET
+    is_admin = EXISTS(SELECT 1
+                      FROM fin.customer_roles cr
+                      JOIN fin.role_permissions rp ON rp.role_name = cr.role_name
+                      WHERE cr.customer_id = vid AND rp.permission_name = 'admin.access')
+  WHERE id = vid;
+  ret := 0;
+END;
+$$;
+
+/***
+Delete the audit events older than pdays days (the retention policy); it returns the number of
+events deleted. This is the only way to delete them (see fin.audit_events_block_changes).
+***/
+CREATE OR REPLACE PROCEDURE fin.purge_audit_events(
+  IN pdays INT,
+  OUT pdeleted BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  pdeleted := 0;
+  IF pdays IS NULL OR pdays < 1 THEN
+    RETURN;
+  END IF;
+  -- Visible to the trigger until the end of the transaction.
+  PERFORM set_config('fin.audit_retention', 'on', true);
+  DELETE FROM fin.audit_events
+  WHERE occurred_at < CURRENT_TIMESTAMP - make_interval(days => pdays);
+  GET DIAGNOSTICS pdeleted = ROW_COUNT;
+  PERFORM set_config('fin.audit_retention', 'off', true);
+END;
+$$;
+
+/**************************************************************************************************
+                            *** TRIGGER FUNCTIONS/STORED PROCEDURES ***
+**************************************************************************************************/
+CREATE OR REPLACE FUNCTION fin.customers_block_row_deletion()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  /***
+  In a DELETE trigger, the special variable NEW is always NULL. You must use OLD.column_name to
+  grab the data from the row being removed.
+  ***/
+  IF OLD.id = 1 THEN
+    RAISE EXCEPTION 'Deletion not allowed: This row is protected.';
+  END IF;
+  -- Crucial: Return OLD so Postgres proceeds with the deletion.
+  RETURN OLD;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_customers_prevent_delete
+BEFORE DELETE ON fin.customers
+FOR EACH ROW
+EXECUTE FUNCTION fin.customers_block_row_deletion();
+
+CREATE OR REPLACE FUNCTION fin.customers_credentials_block_row_deletion()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  IF OLD.is_admin = TRUE THEN
+    RAISE EXCEPTION 'Deletion not allowed: This row is protected.';
+  END IF;
+  RETURN OLD;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_customers_credentials_prevent_delete
+BEFORE DELETE ON fin.customers_credentials
+FOR EACH ROW
+EXECUTE FUNCTION fin.customers_credentials_block_row_deletion();
+
+/***
+Record every new password hash; only the last 24 (the longest history fin.change_password can be
+asked to check) are kept. The '!' placeholder of a purged user is not a password.
+***/
+CREATE OR REPLACE FUNCTION fin.customers_credentials_record_password()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  IF NEW.password_hash = '!' OR (TG_OP = 'UPDATE' AND NEW.password_hash = OLD.password_hash) THEN
+    RETURN NULL;
+  END IF;
+  INSERT INTO fin.customers_password_history(customer_id, password_hash)
+  VALUES(NEW.id, NEW.password_hash);
+  DELETE FROM fin.customers_password_history
+  WHERE customer_id = NEW.id AND id NOT IN(
+    SELECT id
+    FROM fin.customers_password_history
+    WHERE customer_id = NEW.id
+    ORDER BY id DESC
+    LIMIT 24);
+  -- The return value of an AFTER trigger is ignored.
+  RETURN NULL;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_customers_credentials_password_history
+AFTER INSERT OR UPDATE OF password_hash ON fin.customers_credentials
+FOR EACH ROW
+EXECUTE FUNCTION fin.customers_credentials_record_password();
+
+-- A new user is a customer; a new admin is an admin as well.
+CREATE OR REPLACE FUNCTION fin.customers_credentials_default_roles()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  INSERT INTO fin.customer_roles(customer_id, role_name, granted_by)
+  VALUES(NEW.id, 'customer', 'system')
+  ON CONFLICT DO NOTHING;
+  IF NEW.is_admin THEN
+    INSERT INTO fin.customer_roles(customer_id, role_name, granted_by)
+    VALUES(NEW.id, 'admin', 'system')
+    ON CONFLICT DO NOTHING;
+  END IF;
+  RETURN NULL;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_customers_credentials_default_roles
+AFTER INSERT ON fin.customers_credentials
+FOR EACH ROW
+EXECUTE FUNCTION fin.customers_credentials_default_roles();
+
+-- The audit events cannot be changed, and they can be deleted only by fin.purge_audit_events.
+CREATE OR REPLACE FUNCTION fin.audit_events_block_changes()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  IF TG_OP = 'DELETE' AND current_setting('fin.audit_retention', true) = 'on' THEN
+    RETURN OLD;
+  END IF;
+  RAISE EXCEPTION '% not allowed: The audit log is append-only.', TG_OP;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_audit_events_append_only
+BEFORE UPDATE OR DELETE ON fin.audit_events
+FOR EACH ROW
+EXECUTE FUNCTION fin.audit_events_block_changes();
+
+CREATE OR REPLACE TRIGGER trg_audit_events_no_truncate
+BEFORE TRUNCATE ON fin.audit_events
+FOR EACH STATEMENT
+EXECUTE FUNCTION fin.audit_events_block_changes();
+
+
+
+
+
+
+
+/*************************************************************************************************/
+
+-- CREATE OR REPLACE FUNCTION fin.get_customers_contact_details()
+-- RETURNS SETOF fin.customers_contact_details
+-- LANGUAGE PLPGSQL
+-- AS $$
+-- BEGIN
+--   RETURN QUERY SELECT * FROM fin.customers_contact_details;
+-- END;
+-- $$;
+
+
+
+-- CREATE OR REPLACE PROCEDURE fin.customer_contact_details(
+--   IN puser_name TEXT
+-- )
+-- LANGUAGE PLPGSQL
+-- AS $$
+-- BEGIN
+-- END;
+-- $$;
+
+-- CREATE OR REPLACE PROCEDURE fin.customers_credentials()
+-- LANGUAGE PLPGSQL
+-- AS $$
+-- BEGIN
+-- END;
+-- $$;
+
+-- CREATE OR REPLACE PROCEDURE fin.customer_credentials(
+--   IN puser_name TEXT
+-- )
+-- LANGUAGE PLPGSQL
+-- AS $$
+-- BEGIN
+-- END;
+-- $$;
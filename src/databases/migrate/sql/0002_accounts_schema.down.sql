@@ -0,0 +1,3 @@
+-- Drop the customers and accounts schemas and everything in them; the registers are lost.
+DROP SCHEMA IF EXISTS accounts CASCADE;
+DROP SCHEMA IF EXISTS customers CASCADE;
@@ -0,0 +1,629 @@
+-- Online Banking System: the customers, their accounts, and the register of each account.
+-- The objects are owned by admin_role, as the ones of the fin schema (see 0001_fin_schema.up.sql).
+SET LOCAL ROLE admin_role;
+
+-- ************************************************************************************************
+-- Create the schemas
+-- ************************************************************************************************
+CREATE SCHEMA IF NOT EXISTS customers;
+CREATE SCHEMA IF NOT EXISTS accounts;
+
+-- For the rest of the migration, look for objects in the schema first, and if not found, fall back
+-- to the default public schema.
+SET LOCAL search_path TO customers, public;
+
+-- ************************************************************************************************
+-- Create the tables
+-- ************************************************************************************************
+-- Customers - Accounts Relationship
+-- One-to-Many Relationship - One customer is allowed to create many accounts. This relationship is
+-- established using foreign keys, where the primary key of the "one" table is referenced as a
+-- foreign key in the "many" table.
+CREATE TABLE IF NOT EXISTS customers.tbl_customers(
+  -- Version UUIDv7 includes a Unix timestamp in its value, making it more index-friendly because
+  -- UUIDs generated close in time are stored nearer to each other in the index and can be accessed
+  -- faster.
+  id               UUID PRIMARY KEY DEFAULT uuidv7(),
+  username         VARCHAR(64) UNIQUE NOT NULL,
+  password_hash    VARCHAR(256) NOT NULL,
+  first_name       VARCHAR(64) NOT NULL,
+  middle_name      VARCHAR(64),
+  last_name        VARCHAR(64) NOT NULL,
+  birth_date       DATE NOT NULL  --YYYY-MM-DD
+                     CONSTRAINT check_birth_date
+                       CHECK(birth_date > '1899-12-31'),
+  gender           CHAR NOT NULL
+                     CONSTRAINT check_gender
+                       CHECK(gender IN('F', 'M')),
+  address_1        VARCHAR(128) NOT NULL,
+  address_2        VARCHAR(128),
+  city_name        VARCHAR(128) NOT NULL,
+  state_name       VARCHAR(128) NOT NULL,
+  country_name     VARCHAR(64) NOT NULL,
+  zip_code         VARCHAR(16),
+  primary_email    VARCHAR(128),
+  secondary_email  VARCHAR(128),
+  primary_phone    VARCHAR(16) NOT NULL,
+  secondary_phone  VARCHAR(16),
+  -- https://www.postgresql.org/docs/current/datatype-datetime.html
+  -- This stores date and time along with time zone information. PostgreSQL automatically converts
+  -- the timestamp to UTC for storage and adjusts it back based on the current time zone settings
+  -- when queried. 8 bytes in length.
+  -- Balances and reports are converted into this currency.
+  reporting_currency  CHAR(3) NOT NULL DEFAULT 'USD'
+                        CONSTRAINT check_reporting_currency
+                          CHECK(reporting_currency ~ '^[A-Z]{3}$'),
+  created_at       TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at       TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+SET LOCAL search_path TO accounts, public;
+
+-- Accounts - Registers Relationship
+-- Many-to-Many Relationship - An account can have multiple transactions and a transaction can
+--                             involve multiple account numbers.
+CREATE TABLE IF NOT EXISTS accounts.tbl_accounts(
+  id           UUID PRIMARY KEY DEFAULT uuidv7(),
+  -- A customer can hold many accounts (e.g., one per currency); the account names are unique per
+  -- customer.
+  customer_id  UUID NOT NULL,
+  acct_name    VARCHAR(64) NOT NULL,
+  acct_type    VARCHAR(16) NOT NULL DEFAULT 'checking'
+                 CONSTRAINT check_account_type
+                   CHECK(acct_type IN('checking', 'savings', 'mma', 'cd')),
+  -- ISO 4217 alphabetic code of the currency of the account (e.g., USD, EUR, MXN).
+  currency_code  CHAR(3) NOT NULL DEFAULT 'USD'
+                   CONSTRAINT check_account_currency_code
+                     CHECK(currency_code ~ '^[A-Z]{3}$'),
+  acct_status  VARCHAR(16) NOT NULL DEFAULT 'active'
+                 CONSTRAINT check_account_status
+                   CHECK(acct_status IN('active', 'closed', 'suspended')),
+  created_at   TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+               CONSTRAINT unique_account_name
+                 UNIQUE(customer_id, acct_name),
+               -- A foreign key is a column or a set of columns in a database table (the
+               -- "child table") that refers a unique constraint in another table (the
+               -- "parent table") establishing a link between the two.
+               CONSTRAINT fk_accounts_to_customers
+                 FOREIGN KEY(customer_id)
+                 REFERENCES customers.tbl_customers(id)
+                 -- Automatically deletes all the referencing rows in the child table
+                 -- (tbl_accounts) when the referenced rows in the parent table (tbl_customers)
+                 -- are deleted.
+                 ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS accounts.tbl_register_entries(
+  id              UUID PRIMARY KEY DEFAULT uuidv7(),
+  -- An account has many register entries.
+  acct_id         UUID NOT NULL,
+  check_number    INT UNIQUE,
+  payment_date    DATE NOT NULL,  --YYYY-MM-DD
+  payee           VARCHAR(64) NOT NULL,
+  tr_type         VARCHAR(16) NOT NULL
+                    CONSTRAINT check_tr_type
+                      CHECK(tr_type IN('deposit', 'debit')),
+  -- There is no difference between NUMERIC and DECIMAL in PostgreSQL.
+  amount          NUMERIC(12, 2) NOT NULL
+                    CONSTRAINT check_amount
+                      CHECK(amount > 0),
+  cleared         BOOLEAN NOT NULL DEFAULT FALSE,
+  tr_description  VARCHAR(128),
+  -- The currency of the amount; normally, the currency of the account.
+  currency_code   CHAR(3) NOT NULL DEFAULT 'USD'
+                    CONSTRAINT check_entry_currency_code
+                      CHECK(currency_code ~ '^[A-Z]{3}$'),
+  created_at      TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at      TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                  CONSTRAINT fk_transactions_to_accounts
+                    FOREIGN KEY(acct_id)
+                    REFERENCES accounts.tbl_accounts(id)
+                    ON DELETE CASCADE
+);
+
+-- ************************************************************************************************
+-- Recurring transactions (scheduled bills)
+-- ************************************************************************************************
+-- Accounts - Recurring Templates Relationship
+-- One-to-Many Relationship - An account can have many recurring templates. The background scheduler
+-- in the server posts the due occurrences of a template into the register (post_mode = 'post') or
+-- creates a reminder instead (post_mode = 'remind').
+CREATE TABLE IF NOT EXISTS accounts.tbl_recurring_templates(
+  id              UUID PRIMARY KEY DEFAULT uuidv7(),
+  acct_id         UUID NOT NULL,
+  payee           VARCHAR(64) NOT NULL,
+  tr_type         VARCHAR(16) NOT NULL
+                    CONSTRAINT check_recurring_tr_type
+                      CHECK(tr_type IN('deposit', 'debit')),
+  amount          NUMERIC(12, 2) NOT NULL
+                    CONSTRAINT check_recurring_amount
+                      CHECK(amount > 0),
+  tr_description  VARCHAR(128),
+  -- The values must match the constants in finances/Recurrence.go.
+  frequency       VARCHAR(32) NOT NULL
+                    CONSTRAINT check_recurring_frequency
+                      CHECK(frequency IN('days', 'weeks', 'monthly-day', 'monthly-last-business-day',
+                                         'semi-monthly', 'yearly')),
+  -- Every N days or N weeks.
+  interval_count  INT NOT NULL DEFAULT 1
+                    CONSTRAINT check_recurring_interval_count
+                      CHECK(interval_count > 0),
+  -- Day of the month for monthly, semi-monthly and yearly schedules (0 = use the start date).
+  day_of_month    INT NOT NULL DEFAULT 0
+                    CONSTRAINT check_recurring_day_of_month
+                      CHECK(day_of_month BETWEEN 0 AND 31),
+  -- Second day of the month for semi-monthly schedules (0 = the 15th).
+  second_day      INT NOT NULL DEFAULT 0
+                    CONSTRAINT check_recurring_second_day
+                      CHECK(second_day BETWEEN 0 AND 31),
+  start_date      DATE NOT NULL,  --YYYY-MM-DD
+  end_date        DATE,
+  -- The next occurrence that has not been processed by the scheduler.
+  next_due        DATE,
+  post_mode       VARCHAR(16) NOT NULL DEFAULT 'post'
+                    CONSTRAINT check_recurring_post_mode
+                      CHECK(post_mode IN('post', 'remind')),
+  active          BOOLEAN NOT NULL DEFAULT TRUE,
+  created_at      TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at      TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                  CONSTRAINT fk_recurring_templates_to_accounts
+                    FOREIGN KEY(acct_id)
+                    REFERENCES accounts.tbl_accounts(id)
+                    ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_recurring_templates_next_due
+  ON accounts.tbl_recurring_templates
+  USING btree(next_due)
+  WHERE active;
+ANALYZE accounts.tbl_recurring_templates;
+
+-- Single occurrences of a template that the user chose to skip.
+CREATE TABLE IF NOT EXISTS accounts.tbl_recurring_skips(
+  template_id  UUID NOT NULL,
+  skip_date    DATE NOT NULL,
+  created_at   TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+               PRIMARY KEY(template_id, skip_date),
+               CONSTRAINT fk_recurring_skips_to_templates
+                 FOREIGN KEY(template_id)
+                 REFERENCES accounts.tbl_recurring_templates(id)
+                 ON DELETE CASCADE
+);
+
+-- Reminders created by the scheduler for templates with post_mode = 'remind'.
+CREATE TABLE IF NOT EXISTS accounts.tbl_reminders(
+  id              UUID PRIMARY KEY DEFAULT uuidv7(),
+  template_id     UUID NOT NULL,
+  due_date        DATE NOT NULL,
+  dismissed       BOOLEAN NOT NULL DEFAULT FALSE,
+  created_at      TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                  -- Running the scheduler twice (or in several pods) never duplicates a reminder.
+                  CONSTRAINT unique_reminder_per_occurrence
+                    UNIQUE(template_id, due_date),
+                  CONSTRAINT fk_reminders_to_templates
+                    FOREIGN KEY(template_id)
+                    REFERENCES accounts.tbl_recurring_templates(id)
+                    ON DELETE CASCADE
+);
+
+-- Link the register entries posted by the scheduler to their template.
+ALTER TABLE accounts.tbl_register_entries
+  ADD COLUMN IF NOT EXISTS template_id UUID
+    CONSTRAINT fk_register_entries_to_templates
+      REFERENCES accounts.tbl_recurring_templates(id)
+      ON DELETE SET NULL;
+-- Running the scheduler twice (or in several pods) never posts an occurrence twice.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_register_entries_template_occurrence
+  ON accounts.tbl_register_entries(template_id, payment_date)
+  WHERE template_id IS NOT NULL;
+
+-- ************************************************************************************************
+-- Spending categories and monthly budgets
+-- ************************************************************************************************
+-- Customers - Categories Relationship
+-- One-to-Many Relationship - Each customer owns a hierarchy of categories (e.g., Housing > Mortgage,
+-- Food > Groceries). A top-level category has no parent.
+CREATE TABLE IF NOT EXISTS accounts.tbl_categories(
+  id           UUID PRIMARY KEY DEFAULT uuidv7(),
+  customer_id  UUID NOT NULL,
+  parent_id    UUID,
+  cat_name     VARCHAR(64) NOT NULL,
+  -- What happens to the remaining budget at the end of a month:
+  --   none     - Nothing; every month starts from its own budget.
+  --   unspent  - The unspent amount is added to the next month.
+  --   all      - The unspent amount is added to and the overspent amount is taken from the next month.
+  rollover     VARCHAR(16) NOT NULL DEFAULT 'none'
+                 CONSTRAINT check_category_rollover
+                   CHECK(rollover IN('none', 'unspent', 'all')),
+  created_at   TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at   TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+               -- NULLS NOT DISTINCT treats two top-level categories (parent_id IS NULL) with the same
+               -- name as duplicates.
+               CONSTRAINT unique_category_name
+                 UNIQUE NULLS NOT DISTINCT(customer_id, parent_id, cat_name),
+               CONSTRAINT fk_categories_to_customers
+                 FOREIGN KEY(customer_id)
+                 REFERENCES customers.tbl_customers(id)
+                 ON DELETE CASCADE,
+               -- Deleting a category deletes its subcategories.
+               CONSTRAINT fk_categories_to_parent
+                 FOREIGN KEY(parent_id)
+                 REFERENCES accounts.tbl_categories(id)
+                 ON DELETE CASCADE
+);
+
+-- Categories - Budgets Relationship
+-- One-to-Many Relationship - A category has (at most) one budget per month.
+CREATE TABLE IF NOT EXISTS accounts.tbl_budgets(
+  id            UUID PRIMARY KEY DEFAULT uuidv7(),
+  category_id   UUID NOT NULL,
+  -- Always the first day of the month.
+  budget_month  DATE NOT NULL
+                  CONSTRAINT check_budget_month
+                    CHECK(EXTRACT(DAY FROM budget_month) = 1),
+  amount        NUMERIC(12, 2) NOT NULL
+                  CONSTRAINT check_budget_amount
+                    CHECK(amount >= 0),
+  created_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at    TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                CONSTRAINT unique_budget_per_month
+                  UNIQUE(category_id, budget_month),
+                CONSTRAINT fk_budgets_to_categories
+                  FOREIGN KEY(category_id)
+                  REFERENCES accounts.tbl_categories(id)
+                  ON DELETE CASCADE
+);
+
+-- Assign a category to the register entries; deleting a category leaves its entries uncategorized.
+ALTER TABLE accounts.tbl_register_entries
+  ADD COLUMN IF NOT EXISTS category_id UUID
+    CONSTRAINT fk_register_entries_to_categories
+      REFERENCES accounts.tbl_categories(id)
+      ON DELETE SET NULL;
+CREATE INDEX IF NOT EXISTS idx_register_entries_category
+  ON accounts.tbl_register_entries
+  USING btree(category_id, payment_date)
+  WHERE category_id IS NOT NULL;
+
+-- ************************************************************************************************
+-- Currencies
+-- ************************************************************************************************
+-- Dated exchange rates shared by all the customers; on rate_date one unit of from_currency buys
+-- rate units of to_currency. The rates are entered manually or imported from a CSV file.
+CREATE TABLE IF NOT EXISTS accounts.tbl_exchange_rates(
+  rate_date      DATE NOT NULL,
+  from_currency  CHAR(3) NOT NULL
+                   CONSTRAINT check_rate_from_currency
+                     CHECK(from_currency ~ '^[A-Z]{3}$'),
+  to_currency    CHAR(3) NOT NULL
+                   CONSTRAINT check_rate_to_currency
+                     CHECK(to_currency ~ '^[A-Z]{3}$'),
+  rate           NUMERIC(18, 8) NOT NULL
+                   CONSTRAINT check_rate
+                     CHECK(rate > 0),
+  rate_source    VARCHAR(16) NOT NULL DEFAULT 'manual'
+                   CONSTRAINT check_rate_source
+                     CHECK(rate_source IN('manual', 'csv')),
+  created_at     TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at     TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                 PRIMARY KEY(rate_date, from_currency, to_currency),
+                 CONSTRAINT check_rate_pair
+                   CHECK(from_currency <> to_currency)
+);
+
+-- The realized FX difference of a transfer between accounts in different currencies: the value
+-- received minus the value sent, in the reporting currency of the customer, at the rates of the
+-- transfer date (a negative amount is a loss).
+CREATE TABLE IF NOT EXISTS accounts.tbl_fx_differences(
+  id                  UUID PRIMARY KEY DEFAULT uuidv7(),
+  debit_entry_id      UUID NOT NULL,
+  credit_entry_id     UUID NOT NULL,
+  reporting_currency  CHAR(3) NOT NULL,
+  amount              NUMERIC(12, 2) NOT NULL,
+  rate_date           DATE NOT NULL,
+  created_at          TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                      CONSTRAINT unique_fx_difference_per_transfer
+                        UNIQUE(debit_entry_id, credit_entry_id),
+                      CONSTRAINT fk_fx_differences_to_debit_entries
+                        FOREIGN KEY(debit_entry_id)
+                        REFERENCES accounts.tbl_register_entries(id)
+                        ON DELETE CASCADE,
+                      CONSTRAINT fk_fx_differences_to_credit_entries
+                        FOREIGN KEY(credit_entry_id)
+                        REFERENCES accounts.tbl_register_entries(id)
+                        ON DELETE CASCADE
+);
+
+-- ************************************************************************************************
+-- Transfers between the accounts of a customer
+-- ************************************************************************************************
+-- A transfer is a matched pair of register entries: a debit in the source account and a deposit in
+-- the destination account. Both entries are written in one transaction and point to the transfer,
+-- so deleting the transfer deletes the pair.
+CREATE TABLE IF NOT EXISTS accounts.tbl_transfers(
+  id               UUID PRIMARY KEY DEFAULT uuidv7(),
+  customer_id      UUID NOT NULL,
+  -- Token generated when the transfer form is rendered; a repeated submission of the same form
+  -- carries the same token and is ignored (idempotency).
+  client_token     VARCHAR(64) NOT NULL,
+  from_acct_id     UUID NOT NULL,
+  to_acct_id       UUID NOT NULL,
+  transfer_date    DATE NOT NULL,  --YYYY-MM-DD
+  -- In the currency of the source account.
+  amount_sent      NUMERIC(12, 2) NOT NULL
+                     CONSTRAINT check_transfer_amount_sent
+                       CHECK(amount_sent > 0),
+  -- In the currency of the destination account.
+  amount_received  NUMERIC(12, 2) NOT NULL
+                     CONSTRAINT check_transfer_amount_received
+                       CHECK(amount_received > 0),
+  tr_description   VARCHAR(128),
+  created_at       TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+  updated_at       TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+                   CONSTRAINT unique_transfer_client_token
+                     UNIQUE(customer_id, client_token),
+                   CONSTRAINT check_transfer_accounts
+                     CHECK(from_acct_id <> to_acct_id),
+                   CONSTRAINT fk_transfers_to_customers
+                     FOREIGN KEY(customer_id)
+                     REFERENCES customers.tbl_customers(id)
+                     ON DELETE CASCADE,
+                   CONSTRAINT fk_transfers_to_from_accounts
+                     FOREIGN KEY(from_acct_id)
+                     REFERENCES accounts.tbl_accounts(id)
+                     ON DELETE CASCADE,
+                   CONSTRAINT fk_transfers_to_to_accounts
+                     FOREIGN KEY(to_acct_id)
+                     REFERENCES accounts.tbl_accounts(id)
+                     ON DELETE CASCADE
+);
+
+ALTER TABLE accounts.tbl_register_entries
+  ADD COLUMN IF NOT EXISTS transfer_id UUID
+    CONSTRAINT fk_register_entries_to_transfers
+      REFERENCES accounts.tbl_transfers(id)
+      ON DELETE CASCADE;
+-- At most one debit and one deposit per transfer.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_register_entries_transfer_side
+  ON accounts.tbl_register_entries(transfer_id, tr_type)
+  WHERE transfer_id IS NOT NULL;
+
+-- The entries of a transfer can only be deleted together with the transfer (or with their
+-- account), and the fields that make up the pair can only be changed by the code that edits the
+-- transfer; that code runs SET LOCAL fin.transfer_edit = 'on' in its transaction. Other fields
+-- (e.g., the category or the cleared flag) can be changed freely.
+CREATE OR REPLACE FUNCTION accounts.register_entries_protect_transfers()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    -- When the delete cascades from the transfer or the account, the parent is already gone.
+    IF OLD.transfer_id IS NOT NULL
+       AND EXISTS (SELECT 1 FROM accounts.tbl_transfers WHERE id = OLD.transfer_id)
+       AND EXISTS (SELECT 1 FROM accounts.tbl_accounts WHERE id = OLD.acct_id) THEN
+      RAISE EXCEPTION 'Deletion not allowed: The entry is part of a transfer; delete the transfer instead.';
+    END IF;
+    RETURN OLD;
+  END IF;
+  -- UPDATE
+  IF (OLD.transfer_id IS NOT NULL OR NEW.transfer_id IS NOT NULL)
+     AND (NEW.transfer_id, NEW.acct_id, NEW.tr_type, NEW.amount, NEW.payment_date, NEW.currency_code)
+         IS DISTINCT FROM
+         (OLD.transfer_id, OLD.acct_id, OLD.tr_type, OLD.amount, OLD.payment_date, OLD.currency_code)
+     AND current_setting('fin.transfer_edit', TRUE) IS DISTINCT FROM 'on' THEN
+    RAISE EXCEPTION 'Update not allowed: The entry is part of a transfer; edit the transfer instead.';
+  END IF;
+  RETURN NEW;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_register_entries_protect_transfers
+BEFORE UPDATE OR DELETE ON accounts.tbl_register_entries
+FOR EACH ROW
+EXECUTE FUNCTION accounts.register_entries_protect_transfers();
+
+-- ************************************************************************************************
+-- Account status
+-- ************************************************************************************************
+-- Accounts - Status History Relationship
+-- One-to-Many Relationship - Every change of the status of an account is recorded with the user who
+-- made it and when.
+--   active     - The account accepts new entries.
+--   suspended  - The account rejects new entries; the existing entries can still be changed.
+--   closed     - The account is read-only; it stays visible in the history and can be reopened.
+CREATE TABLE IF NOT EXISTS accounts.tbl_account_status_history(
+  id           UUID PRIMARY KEY DEFAULT uuidv7(),
+  acct_id      UUID NOT NULL,
+  old_status   VARCHAR(16) NOT NULL,
+  new_status   VARCHAR(16) NOT NULL,
+  changed_by   VARCHAR(64) NOT NULL,
+  changed_at   TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+               CONSTRAINT fk_account_status_history_to_accounts
+                 FOREIGN KEY(acct_id)
+                 REFERENCES accounts.tbl_accounts(id)
+                 ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_account_status_history_acct
+  ON accounts.tbl_account_status_history(acct_id, changed_at);
+
+-- A closed account can only be reopened, and it can only be closed with a zero balance (the code
+-- that closes an account moves the remaining balance with a final transfer first).
+CREATE OR REPLACE FUNCTION accounts.accounts_check_status()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vbalance NUMERIC(12, 2);
+BEGIN
+  IF OLD.acct_status = 'closed' AND NEW.acct_status = 'closed'
+     AND (NEW.acct_name, NEW.acct_type, NEW.currency_code)
+         IS DISTINCT FROM
+         (OLD.acct_name, OLD.acct_type, OLD.currency_code) THEN
+    RAISE EXCEPTION 'Update not allowed: The account is closed.';
+  END IF;
+  IF NEW.acct_status = 'closed' AND OLD.acct_status <> 'closed' THEN
+    SELECT COALESCE(SUM(CASE WHEN tr_type = 'deposit' THEN amount ELSE -amount END), 0)
+      INTO vbalance
+      FROM accounts.tbl_register_entries
+      WHERE acct_id = NEW.id;
+    IF vbalance <> 0 THEN
+      RAISE EXCEPTION 'Update not allowed: The balance of the account is %; it must be zero to close it.', vbalance;
+    END IF;
+  END IF;
+  RETURN NEW;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_accounts_check_status
+BEFORE UPDATE ON accounts.tbl_accounts
+FOR EACH ROW
+EXECUTE FUNCTION accounts.accounts_check_status();
+
+-- Only an active account accepts new entries, and the entries of a closed account cannot be changed
+-- or deleted (unless the account itself is being deleted).
+CREATE OR REPLACE FUNCTION accounts.register_entries_check_account_status()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vstatus VARCHAR(16);
+BEGIN
+  IF TG_OP = 'INSERT' THEN
+    SELECT acct_status INTO vstatus FROM accounts.tbl_accounts WHERE id = NEW.acct_id;
+    IF vstatus IS DISTINCT FROM 'active' THEN
+      RAISE EXCEPTION 'Insert not allowed: The account is %.', vstatus;
+    END IF;
+    RETURN NEW;
+  END IF;
+  SELECT acct_status INTO vstatus FROM accounts.tbl_accounts WHERE id = OLD.acct_id;
+  IF vstatus = 'closed' THEN
+    RAISE EXCEPTION '% not allowed: The account is closed.', INITCAP(TG_OP);
+  END IF;
+  IF TG_OP = 'DELETE' THEN
+    RETURN OLD;
+  END IF;
+  RETURN NEW;
+END;
+$$;
+
+CREATE OR REPLACE TRIGGER trg_register_entries_check_account_status
+BEFORE INSERT OR UPDATE OR DELETE ON accounts.tbl_register_entries
+FOR EACH ROW
+EXECUTE FUNCTION accounts.register_entries_check_account_status();
+
+
+
+
+/**************************************************************************************************
+Create the stored procedures
+***************************************************************************************************
+This statement will create the procedure if it doesn't exist, or replace its definition if it does.
+This is a more concise way to update or create a procedure.
+**************************************************************************************************/
+-- CREATE OR REPLACE PROCEDURE customers.sp_customer(
+--  IN pusername VARCHAR(64),
+--  IN ppassword_hash VARCHAR(256),
+-- --  IN pcustomer_type VARCHAR(16) DEFAULT 'regular',
+--  INOUT pcustomer_id INT DEFAULT -1)
+--  /***
+--  In PostgreSQL, when discussing "procedure language SQL vs PostgreSQL," the distinction lies in
+--  the type of functions or procedures you can create within the database.
+--  When to choose which:
+--   * Use LANGUAGE SQL when your function primarily involves data manipulation or retrieval that can
+--     be expressed efficiently through SQL statements, and complex procedural logic is not required.
+--   * Use LANGUAGE PL/pgSQL when you need advanced procedural capabilities, such as loops,
+--     conditional logic, error handling, or dynamic SQL generation, that are not possible or
+--     practical with pure SQL functions.
+--  ***/
+-- LANGUAGE PLPGSQL
+-- /***
+-- This defines the procedure's body using dollar-quoted string constants. This avoids the need to
+-- escape single quotes within the procedure's code.
+-- ***/
+-- AS $$
+--   /***
+--   (Optional) This section within the procedure body is used to declare local variables that are
+--   only accessible within the procedure.
+--   ***/
+--   -- DECLARE
+--   /***
+--   This block encloses the SQL statements and control structures that constitute the procedure's
+--   logic.
+--   ***/
+-- BEGIN
+--   -- INSERT INTO bs.tbl_customer(customer_type, username, password_hash)
+--   INSERT INTO customers.tbl_accounts(username, password_hash)
+--   --  VALUES(pcustomer_type, pusername, ppassword_hash)
+--    VALUES( pusername, ppassword_hash)
+--    RETURNING customer_id INTO pcustomer_id;
+-- END;
+-- /***
+-- The final semicolon marks the end of the CREATE PROCEDURE statement.
+-- ***/
+-- $$;
+
+
+
+
+
+-- CREATE OR REPLACE PROCEDURE customers.sp_customer_info(
+--   IN username VARCHAR(64),
+--   IN password_hash VARCHAR(256),
+--   -- IN customer_type VARCHAR(16),
+--   IN first_name VARCHAR(64),
+--   IN middle_name VARCHAR(64),
+--   IN last_name VARCHAR(64),
+--   IN date_of_birth DATE,
+--   IN tax_identifier VARCHAR(16),
+--   IN address_1 VARCHAR(128),
+--   IN address_2 VARCHAR(128),
+--   IN city_name VARCHAR(128),
+--   IN state_name VARCHAR(128),
+--   IN country_name VARCHAR(64),
+--   IN zip_code VARCHAR(16),
+--   IN primary_email VARCHAR(256),
+--   IN secondary_email VARCHAR(256),
+--   IN primary_phone VARCHAR(16),
+--   IN secondary_phone VARCHAR(16)
+-- )
+-- LANGUAGE PLPGSQL
+-- AS $$
+-- DECLARE
+--  customer_id INT = -1;
+-- BEGIN
+--   -- CALL bs.sp_customer(username, password_hash, customer_type, customer_id);
+--   CALL customers.sp_customer(username, password_hash,  customer_id);
+--   INSERT INTO customers.tbl_customer_info (customer_id, first_name, middle_name, last_name, date_of_birth, tax_identifier,
+--    address_1, address_2, city_name, state_name, country_name, zip_code, primary_email,
+--    secondary_email, primary_phone, secondary_phone)
+--    VALUES(customer_id, first_name, middle_name, last_name, date_of_birth, tax_identifier, address_1, address_2,
+--     city_name, state_name, country_name, zip_code, primary_email, secondary_email, primary_phone,
+--     secondary_phone);
+--   COMMIT;
+-- END;
+-- $$;
+
+
+-- CREATE OR REPLACE FUNCTION customers.fn_get_all_customers()
+-- RETURNS TABLE (id INT, name TEXT)
+-- LANGUAGE plpgsql
+-- AS $$
+-- BEGIN
+--   RETURN QUERY SELECT customer_id, customer_type, username, password_hash, created_at, updated_at FROM customers.tbl_accounts;
+-- END;
+-- $$;
+
+-- ************************************************************************************************
+-- Privileges
+-- ************************************************************************************************
+GRANT USAGE ON SCHEMA customers TO admin_role;
+GRANT USAGE ON SCHEMA accounts TO admin_role;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA customers TO admin_role;
+GRANT SELECT, INSERT, UPDATE, DELETE ON ALL TABLES IN SCHEMA accounts TO admin_role;
+ALTER DEFAULT PRIVILEGES IN SCHEMA customers
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO admin_role;
+ALTER DEFAULT PRIVILEGES IN SCHEMA accounts
+GRANT SELECT, INSERT, UPDATE, DELETE ON TABLES TO admin_role;
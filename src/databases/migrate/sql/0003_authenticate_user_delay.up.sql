@@ -0,0 +1,119 @@
+-- fin.authenticate_user returns the delay of a refused login instead of sleeping; the caller waits
+-- after the connection is back in the pool, so the slow logins do not hold the connections.
+SET LOCAL ROLE admin_role;
+
+/***
+Return values:
+  -5 -- The sign-up of the user is pending (email verification or approval).
+  -4 -- The user is inactive (unregistered).
+  -3 -- The user is locked out.
+  -2 -- Invalid (unknown) user.
+  -1 -- Authentication failed.
+   0 -- User authenticated.
+  pdelay -- Seconds the caller must wait before it answers; 0 if none.
+Throttling:
+  pdelay_after  -- Failed attempts before every new failure is delayed (exponential backoff).
+  plock_after   -- Failed attempts before the user is locked out for plock_minutes; once the lock
+                   expires, every new failure locks the user again until a successful login (or an
+                   admin unlock) resets the counter.
+The unknown, inactive, pending, and locked users get the same delay, so the time of the answer does
+not reveal which one it is.
+***/
+DROP PROCEDURE IF EXISTS fin.authenticate_user(TEXT, TEXT, TEXT, INT, INT, INT, OUT INT, OUT BOOL);
+CREATE OR REPLACE PROCEDURE fin.authenticate_user(
+  IN puser_name TEXT,
+  IN ppassword TEXT,
+  IN correlation_id TEXT,
+  IN pdelay_after INT,
+  IN plock_after INT,
+  IN plock_minutes INT,
+  OUT pout INT,
+  OUT pis_admin BOOL,
+  OUT pdelay NUMERIC
+)
+LANGUAGE PLPGSQL
+AS $$
+DECLARE
+  vbase_delay CONSTANT NUMERIC := 1;  -- Seconds.
+  vmin_delay CONSTANT NUMERIC := 0.5;  -- Seconds (500ms).
+  vmax_delay CONSTANT NUMERIC := 30;  -- Seconds.
+  vunknown_user_delay CONSTANT NUMERIC := 5;  -- Seconds.
+  vfailed_attempts INT;
+  vhash TEXT;
+  vlocked_until TIMESTAMP WITH TIME ZONE;
+  vis_active BOOL;
+  vsignup_status TEXT;
+BEGIN
+  pis_admin := false;
+  pdelay := 0;
+  SELECT
+    cc.password_hash,
+    cc.locked_until,
+    c.is_active,
+    c.signup_status
+  INTO
+    vhash,
+    vlocked_until,
+    vis_active,
+    vsignup_status
+  FROM fin.customers_credentials cc
+  JOIN fin.customers c ON c.id = cc.id
+  WHERE cc.user_name = puser_name;
+  IF NOT FOUND THEN  -- User not found; password can't be valid.
+    pout := -2;
+    pdelay := vunknown_user_delay;
+    RAISE NOTICE 'Unknown user (%)', puser_name USING DETAIL = correlation_id;
+  -- An unregistered (inactive) customer is treated like an unknown user.
+  ELSIF NOT vis_active THEN
+    pout := -4;
+    pdelay := vunknown_user_delay;
+    RAISE NOTICE 'Inactive user (%)', puser_name USING DETAIL = correlation_id;
+  -- A sign-up that was not verified (or approved) yet; also treated like an unknown user.
+  ELSIF vsignup_status <> 'active' THEN
+    pout := -5;
+    pdelay := vunknown_user_delay;
+    RAISE NOTICE 'Pending sign-up (%): %', puser_name, vsignup_status USING DETAIL = correlation_id;
+  /***
+  While locked, the password is not even checked, so guessing it is pointless; the attempt does not
+  extend the lock either.
+  ***/
+  ELSIF vlocked_until IS NOT NULL AND vlocked_until > CURRENT_TIMESTAMP THEN
+    pout := -3;
+    pdelay := vunknown_user_delay;
+    RAISE NOTICE 'User (%) locked until %', puser_name, vlocked_until USING DETAIL = correlation_id;
+  /***
+  Use the crypt function to compare the provided password against the stored hash;
+  the crypt function uses the salt from the stored hash to perform the comparison.
+  ***/
+  ELSIF vhash = crypt(ppassword, vhash) THEN  -- User authenticated.
+    pout := 0;
+    UPDATE fin.customers_credentials
+    SET
+      failed_attempts = 0,
+      last_attempt = CURRENT_TIMESTAMP,
+      locked_until = NULL
+    WHERE user_name = puser_name
+    RETURNING is_admin INTO pis_admin;
+  ELSE  -- Authentication failed.
+    pout := -1;
+    UPDATE fin.customers_credentials
+    SET
+      failed_attempts = failed_attempts + 1,
+      last_attempt = CURRENT_TIMESTAMP
+    WHERE user_name = puser_name
+    RETURNING failed_attempts INTO vfailed_attempts;
+    IF vfailed_attempts >= plock_after THEN
+      UPDATE fin.customers_credentials
+      SET
+        locked_until = CURRENT_TIMESTAMP + MAKE_INTERVAL(mins => plock_minutes)
+      WHERE user_name = puser_name;
+      RAISE NOTICE 'Attempt % failed; user (%) locked for % minutes', vfailed_attempts, puser_name, plock_minutes
+        USING DETAIL = correlation_id;
+    ELSIF vfailed_attempts > pdelay_after THEN
+      -- Exponential backoff with jitter: a random delay up to base_delay * (2 ^ failed_attempts).
+      pdelay := RANDOM(vmin_delay, LEAST(vmax_delay, CAST(vbase_delay * POWER(2, vfailed_attempts) AS NUMERIC)));
+      RAISE NOTICE 'Attempt % failed; delayed for % seconds', vfailed_attempts, pdelay USING DETAIL = correlation_id;
+    END IF;
+  END IF;
+END;
+$$;
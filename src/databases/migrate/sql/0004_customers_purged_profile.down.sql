@@ -0,0 +1,14 @@
+-- The purged profiles get the placeholders back before the columns become NOT NULL again.
+SET LOCAL ROLE admin_role;
+
+UPDATE customers.tbl_customers
+SET
+  birth_date = COALESCE(birth_date, '1900-01-01'),
+  gender = COALESCE(gender, 'F')
+WHERE birth_date IS NULL OR gender IS NULL;
+
+ALTER TABLE customers.tbl_customers
+  DROP CONSTRAINT check_gender,
+  ADD CONSTRAINT check_gender CHECK(gender IN('F', 'M')),
+  ALTER COLUMN birth_date SET NOT NULL,
+  ALTER COLUMN gender SET NOT NULL;
@@ -0,0 +1,19 @@
+-- A purged customer has no birth date or gender; the banking profile kept made-up values (1900-01-01
+-- and 'F') because the columns could not be NULL.
+SET LOCAL ROLE admin_role;
+
+ALTER TABLE customers.tbl_customers
+  ALTER COLUMN birth_date DROP NOT NULL,
+  ALTER COLUMN gender DROP NOT NULL,
+  DROP CONSTRAINT check_gender,
+  ADD CONSTRAINT check_gender CHECK(gender IS NULL OR gender IN('F', 'M'));
+
+-- The profiles purged so far: fin.purge_customer sets purged_at, and the banking profile has the
+-- same placeholder username as the credentials (see QR_PURGE_BANKING_CUSTOMER).
+UPDATE customers.tbl_customers t
+SET
+  birth_date = NULL,
+  gender = NULL
+FROM fin.customers c
+JOIN fin.customers_credentials cc ON cc.id = c.id
+WHERE cc.user_name = t.username AND c.purged_at IS NOT NULL;
@@ -0,0 +1,39 @@
+-- Give the audit log back to admin_role; the retention is allowed by the fin.audit_retention setting again.
+ALTER TABLE fin.audit_events OWNER TO admin_role;
+ALTER FUNCTION fin.audit_events_block_changes() OWNER TO admin_role;
+ALTER PROCEDURE fin.purge_audit_events(INT, OUT BIGINT) OWNER TO admin_role;
+REVOKE ALL ON SCHEMA fin FROM audit_owner;
+GRANT SELECT, INSERT, DELETE ON fin.audit_events TO admin_role;
+SET LOCAL ROLE admin_role;
+
+CREATE OR REPLACE FUNCTION fin.audit_events_block_changes()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  IF TG_OP = 'DELETE' AND current_setting('fin.audit_retention', true) = 'on' THEN
+    RETURN OLD;
+  END IF;
+  RAISE EXCEPTION '% not allowed: The audit log is append-only.', TG_OP;
+END;
+$$;
+
+CREATE OR REPLACE PROCEDURE fin.purge_audit_events(
+  IN pdays INT,
+  OUT pdeleted BIGINT
+)
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  pdeleted := 0;
+  IF pdays IS NULL OR pdays < 1 THEN
+    RETURN;
+  END IF;
+  -- Visible to the trigger until the end of the transaction.
+  PERFORM set_config('fin.audit_retention', 'on', true);
+  DELETE FROM fin.audit_events
+  WHERE occurred_at < CURRENT_TIMESTAMP - make_interval(days => pdays);
+  GET DIAGNOSTICS pdeleted = ROW_COUNT;
+  PERFORM set_config('fin.audit_retention', 'off', true);
+END;
+$$;
@@ -0,0 +1,68 @@
+/***
+The audit log was append-only only as long as nobody tried: the user of the application is a
+member of admin_role, which owned fin.audit_events (it could DISABLE TRIGGER and grant itself the
+privileges back), and the trigger let through any DELETE of a session that set the
+fin.audit_retention setting.
+Now the table belongs to audit_owner, a role that cannot log in and that the user of the
+application is not a member of:
+  * admin_role can only read and insert the events.
+  * The retention (fin.purge_audit_events) runs as audit_owner (SECURITY DEFINER), and the trigger
+    lets a DELETE through only when the current user is audit_owner.
+The role is created here, as db_setup_user, before the migration changes its role; the roles of
+the cluster are not part of the database, so the down migration leaves it.
+***/
+DO $$
+BEGIN
+  IF NOT EXISTS(SELECT 1 FROM pg_catalog.pg_roles WHERE rolname = 'audit_owner') THEN
+    CREATE ROLE audit_owner WITH NOLOGIN NOINHERIT NOSUPERUSER NOCREATEROLE NOCREATEDB;
+  END IF;
+END;
+$$;
+-- db_setup_user hands the objects to audit_owner (ALTER ... OWNER TO requires the membership).
+GRANT audit_owner TO CURRENT_USER;
+GRANT USAGE ON SCHEMA fin TO audit_owner;
+
+-- The events cannot be changed, and they can be deleted only by fin.purge_audit_events.
+CREATE OR REPLACE FUNCTION fin.audit_events_block_changes()
+RETURNS TRIGGER
+LANGUAGE PLPGSQL
+AS $$
+BEGIN
+  IF TG_OP = 'DELETE' AND current_user = 'audit_owner' THEN
+    RETURN OLD;
+  END IF;
+  RAISE EXCEPTION '% not allowed: The audit log is append-only.', TG_OP;
+END;
+$$;
+
+/***
+Delete the audit events older than pdays days (the retention policy); it returns the number of
+events deleted. This is the only way to delete them (see fin.audit_events_block_changes).
+***/
+CREATE OR REPLACE PROCEDURE fin.purge_audit_events(
+  IN pdays INT,
+  OUT pdeleted BIGINT
+)
+LANGUAGE PLPGSQL
+SECURITY DEFINER
+-- A SECURITY DEFINER routine must not find the objects of the caller first.
+SET search_path = fin, pg_temp
+AS $$
+BEGIN
+  pdeleted := 0;
+  IF pdays IS NULL OR pdays < 1 THEN
+    RETURN;
+  END IF;
+  DELETE FROM fin.audit_events
+  WHERE occurred_at < CURRENT_TIMESTAMP - make_interval(days => pdays);
+  GET DIAGNOSTICS pdeleted = ROW_COUNT;
+END;
+$$;
+
+ALTER TABLE fin.audit_events OWNER TO audit_owner;
+ALTER FUNCTION fin.audit_events_block_changes() OWNER TO audit_owner;
+ALTER PROCEDURE fin.purge_audit_events(INT, OUT BIGINT) OWNER TO audit_owner;
+REVOKE ALL ON fin.audit_events FROM PUBLIC, admin_role;
+GRANT SELECT, INSERT ON fin.audit_events TO admin_role;
+REVOKE ALL ON PROCEDURE fin.purge_audit_events(INT, OUT BIGINT) FROM PUBLIC;
+GRANT EXECUTE ON PROCEDURE fin.purge_audit_events(INT, OUT BIGINT) TO admin_role;
@@ -0,0 +1,4 @@
+-- The links that were not used are lost.
+SET LOCAL ROLE admin_role;
+
+DROP TABLE IF EXISTS fin.totp_enrollment_tokens;
@@ -0,0 +1,26 @@
+/***
+One-time links to enroll in two-factor authentication, issued by an admin. An admin who must use
+two-factor authentication (require_admin_2fa) but has not enrolled yet cannot log in: the password
+alone must not let whoever knows it enroll an authenticator of their own. The link is handed to the
+user by the admin who issued it. As with the password-reset links, only the hash of the token is
+stored; a link is single-use and expires.
+***/
+SET LOCAL ROLE admin_role;
+
+CREATE TABLE IF NOT EXISTS fin.totp_enrollment_tokens(
+  id               INT PRIMARY KEY GENERATED ALWAYS AS IDENTITY,
+  customer_id      INT NOT NULL,
+                   CONSTRAINT fk_totp_enrollment_tokens_to_customers_credentials
+                     FOREIGN KEY(customer_id)
+                     REFERENCES fin.customers_credentials(id)
+                     ON DELETE CASCADE,
+  token_hash       TEXT UNIQUE NOT NULL,
+  -- The admin who issued the link.
+  created_by       TEXT NOT NULL,
+  created_at       TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at       TIMESTAMP WITH TIME ZONE NOT NULL,
+  used_at          TIMESTAMP WITH TIME ZONE DEFAULT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_totp_enrollment_tokens_customer_id
+  ON fin.totp_enrollment_tokens(customer_id);
@@ -0,0 +1,5 @@
+-- The sessions ended after a password reset are not ended on the other instances anymore.
+SET LOCAL ROLE admin_role;
+
+ALTER TABLE fin.customers
+  DROP COLUMN IF EXISTS sessions_ended_at;
@@ -0,0 +1,11 @@
+/***
+The sessions of a customer are ended in the database, so that every instance of the server sees it:
+the sessions are in the memory of the instance that started them, and the admin request that ends
+them may be served by another instance. A customer who is inactive (unregistered) keeps no session;
+the sessions that started before sessions_ended_at (e.g., the password was reset) are ended. See
+security.IsSessionEnded.
+***/
+SET LOCAL ROLE admin_role;
+
+ALTER TABLE fin.customers
+  ADD COLUMN IF NOT EXISTS sessions_ended_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
@@ -0,0 +1,54 @@
+// AccountStatus defines the status transitions of a bank account.
+package finances
+
+import (
+  "errors"
+  "fmt"
+  "math"
+)
+
+const (
+  //The values are stored as-is in the database.
+  AccountActive string = "active"
+  AccountSuspended string = "suspended"
+  AccountClosed string = "closed"
+)
+
+/***
+The allowed transitions:
+  active    -> suspended, closed
+  suspended -> active, closed
+  closed    -> active (reopen)
+***/
+var accountStatusTransitions = map[string][]string{
+  AccountActive: { AccountSuspended, AccountClosed },
+  AccountSuspended: { AccountActive, AccountClosed },
+  AccountClosed: { AccountActive },
+}
+
+func ValidAccountStatusTransition(from, to string) bool {
+  for _, s := range accountStatusTransitions[from] {
+    if s == to {
+      return true
+    }
+  }
+  return false
+}
+
+/***
+Return the amount of the final transfer required to close an account with the given balance. An account can only be closed
+with a zero balance; a positive balance must be moved to another account (hasDestination), and a negative balance must be
+covered first.
+***/
+func ClosingTransferAmount(balance float64, hasDestination bool) (float64, error) {
+  balance = math.Round(balance * 100) / 100
+  switch {
+  case balance == 0:
+    return 0, nil
+  case balance < 0:
+    return 0, fmt.Errorf("the balance is %.2f; cover it before closing the account", balance)
+  case !hasDestination:
+    return 0, errors.New("the balance is not zero; choose an account for the final transfer")
+  }
+  return balance, nil
+}
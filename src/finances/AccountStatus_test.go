@@ -0,0 +1,67 @@
+// Testing the functions in AccountStatus.go.
+package finances
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Account"
+***/
+
+import (
+  "testing"
+)
+
+func TestValidAccountStatusTransition(t *testing.T) {
+  t.Parallel()
+  type test struct {
+    from string
+    to string
+    want bool
+  }
+  var tests = []test {
+    { from: AccountActive, to: AccountSuspended, want: true },
+    { from: AccountActive, to: AccountClosed, want: true },
+    { from: AccountSuspended, to: AccountActive, want: true },
+    { from: AccountSuspended, to: AccountClosed, want: true },
+    { from: AccountClosed, to: AccountActive, want: true },
+    { from: AccountClosed, to: AccountSuspended, want: false },
+    { from: AccountActive, to: AccountActive, want: false },
+    { from: "unknown", to: AccountActive, want: false },
+  }
+  for _, tc := range tests {
+    if got := ValidAccountStatusTransition(tc.from, tc.to); got != tc.want {
+      t.Errorf("ValidAccountStatusTransition(%s, %s) = %t; want %t", tc.from, tc.to, got, tc.want)
+    }
+  }
+}
+
+func TestClosingTransferAmount(t *testing.T) {
+  t.Parallel()
+  type test struct {
+    name string
+    balance float64
+    hasDestination bool
+    want float64
+    wantErr bool
+  }
+  var tests = []test {
+    { name: "zero balance", balance: 0, want: 0 },
+    { name: "rounding artifact", balance: 0.001, want: 0 },
+    { name: "positive with destination", balance: 125.5, hasDestination: true, want: 125.5 },
+    { name: "positive without destination", balance: 125.5, wantErr: true },
+    { name: "negative", balance: -10, hasDestination: true, wantErr: true },
+  }
+  for _, tc := range tests {
+    got, err := ClosingTransferAmount(tc.balance, tc.hasDestination)
+    if (err != nil) != tc.wantErr || got != tc.want {
+      t.Errorf("%s: ClosingTransferAmount(%v, %t) = %v, %v; want %v, error %t", tc.name, tc.balance, tc.hasDestination,
+        got, err, tc.want, tc.wantErr)
+    }
+  }
+}
@@ -0,0 +1,76 @@
+// Budget computes budget-vs-actual figures for spending categories.
+package finances
+
+const (
+  //Rollover options; the values are stored as-is in the database.
+  RolloverNone string = "none"
+  RolloverUnspent string = "unspent"
+  RolloverAll string = "all"
+)
+
+//The budget and the actual spending of a category for one month.
+type BudgetPeriod struct {
+  Budgeted float64
+  Actual float64
+}
+
+/***
+The budget-vs-actual figures of a category for one month.
+  * CarriedIn: the amount rolled over from the previous month (negative when an overspent amount is taken).
+  * Available: Budgeted + CarriedIn.
+  * Remaining: Available - Actual; Overspent is true when it is negative.
+***/
+type BudgetResult struct {
+  Budgeted float64
+  CarriedIn float64
+  Available float64
+  Actual float64
+  Remaining float64
+  Overspent bool
+}
+
+func (br *BudgetResult) compute() {
+  br.Available = br.Budgeted + br.CarriedIn
+  br.Remaining = br.Available - br.Actual
+  br.Overspent = br.Remaining < 0
+}
+
+/***
+Return the budget-vs-actual figures for consecutive months (in chronological order) of a category. The rollover option
+determines how the remaining amount of a month is carried into the next month:
+  * RolloverNone: it is not carried; every month starts from its own budget.
+  * RolloverUnspent: only an unspent (positive) remaining amount is carried.
+  * RolloverAll: the remaining amount is always carried; an overspent month reduces the next month.
+***/
+func BudgetVsActual(periods []BudgetPeriod, rollover string) []BudgetResult {
+  results := make([]BudgetResult, len(periods))
+  var carry float64 = 0.0
+  for idx, p := range periods {
+    results[idx] = BudgetResult{ Budgeted: p.Budgeted, CarriedIn: carry, Actual: p.Actual }
+    results[idx].compute()
+    switch rollover {
+    case RolloverUnspent:
+      if results[idx].Remaining > 0 {
+        carry = results[idx].Remaining
+      } else {
+        carry = 0.0
+      }
+    case RolloverAll:
+      carry = results[idx].Remaining
+    default:
+      carry = 0.0
+    }
+  }
+  return results
+}
+
+//Return the year-to-date totals; the periods must start in January. Rollovers cancel out over the year and are ignored.
+func BudgetYearToDate(periods []BudgetPeriod) BudgetResult {
+  var ytd BudgetResult
+  for _, p := range periods {
+    ytd.Budgeted += p.Budgeted
+    ytd.Actual += p.Actual
+  }
+  ytd.compute()
+  return ytd
+}
@@ -0,0 +1,59 @@
+// Testing the functions in Budget.go.
+package finances
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Budget"
+***/
+
+import (
+  "testing"
+)
+
+func TestBudgetVsActual(t *testing.T) {
+  t.Parallel()
+  periods := []BudgetPeriod{
+    { Budgeted: 100, Actual: 60 },
+    { Budgeted: 100, Actual: 150 },
+    { Budgeted: 100, Actual: 80 },
+  }
+  type test struct {
+    rollover string
+    wantCarriedIn []float64
+    wantRemaining []float64
+    wantOverspent []bool
+  }
+  var tests = []test {
+    { rollover: RolloverNone, wantCarriedIn: []float64{ 0, 0, 0 }, wantRemaining: []float64{ 40, -50, 20 },
+      wantOverspent: []bool{ false, true, false } },
+    { rollover: RolloverUnspent, wantCarriedIn: []float64{ 0, 40, 0 }, wantRemaining: []float64{ 40, -10, 20 },
+      wantOverspent: []bool{ false, true, false } },
+    { rollover: RolloverAll, wantCarriedIn: []float64{ 0, 40, -10 }, wantRemaining: []float64{ 40, -10, 10 },
+      wantOverspent: []bool{ false, true, false } },
+  }
+  for _, tc := range tests {
+    got := BudgetVsActual(periods, tc.rollover)
+    for idx := range got {
+      if got[idx].CarriedIn != tc.wantCarriedIn[idx] || got[idx].Remaining != tc.wantRemaining[idx] ||
+         got[idx].Overspent != tc.wantOverspent[idx] {
+        t.Errorf("%s: month %d = %+v; want carried in %.2f, remaining %.2f, overspent %t", tc.rollover, idx, got[idx],
+          tc.wantCarriedIn[idx], tc.wantRemaining[idx], tc.wantOverspent[idx])
+      }
+    }
+  }
+}
+
+func TestBudgetYearToDate(t *testing.T) {
+  t.Parallel()
+  got := BudgetYearToDate([]BudgetPeriod{ { Budgeted: 100, Actual: 60 }, { Budgeted: 100, Actual: 150 } })
+  if got.Budgeted != 200 || got.Actual != 210 || got.Remaining != -10 || !got.Overspent {
+    t.Errorf("BudgetYearToDate = %+v; want budgeted 200, actual 210, remaining -10, overspent", got)
+  }
+}
@@ -0,0 +1,184 @@
+// Currency converts amounts between currencies using dated exchange rates.
+package finances
+
+import (
+  "encoding/csv"
+  "errors"
+  "fmt"
+  "io"
+  "sort"
+  "strconv"
+  "strings"
+  "time"
+)
+
+/***
+An ExchangeRate states that on Date one unit of From buys Rate units of To (e.g., From: "USD", To: "MXN", Rate: 17.05).
+Currency codes are ISO 4217 alphabetic codes.
+***/
+type ExchangeRate struct {
+  Date time.Time
+  From string
+  To string
+  Rate float64
+}
+
+/***
+A RateTable holds the exchange rates by currency pair, sorted by date. The rate for a date is the most recent rate on or
+before that date; if a pair is missing, the inverse of the opposite pair is used and, as a last resort, a conversion through
+a third currency (e.g., EUR -> USD -> MXN).
+***/
+type RateTable struct {
+  rates map[string][]ExchangeRate  //key: "FROM/TO"
+  currencies map[string]bool
+}
+
+func pairKey(from, to string) string {
+  return from + "/" + to
+}
+
+//Return true if code looks like an ISO 4217 alphabetic code (three uppercase letters).
+func ValidCurrencyCode(code string) bool {
+  if len(code) != 3 {
+    return false
+  }
+  for _, c := range code {
+    if c < 'A' || c > 'Z' {
+      return false
+    }
+  }
+  return true
+}
+
+func NewRateTable(rates []ExchangeRate) *RateTable {
+  rt := &RateTable{ rates: map[string][]ExchangeRate{}, currencies: map[string]bool{} }
+  for _, r := range rates {
+    if r.Rate <= 0 {
+      continue
+    }
+    r.Date = DateOnly(r.Date)
+    key := pairKey(r.From, r.To)
+    rt.rates[key] = append(rt.rates[key], r)
+    rt.currencies[r.From] = true
+    rt.currencies[r.To] = true
+  }
+  for _, list := range rt.rates {
+    sort.Slice(list, func(i, j int) bool { return list[i].Date.Before(list[j].Date) })
+  }
+  return rt
+}
+
+//The most recent rate of the pair on or before date.
+func (rt *RateTable) direct(from, to string, date time.Time) (float64, bool) {
+  list := rt.rates[pairKey(from, to)]
+  date = DateOnly(date)
+  //Index of the first rate after date; the one before it is the answer.
+  idx := sort.Search(len(list), func(i int) bool { return list[i].Date.After(date) })
+  if idx == 0 {
+    return 0, false
+  }
+  return list[idx - 1].Rate, true
+}
+
+func (rt *RateTable) directOrInverse(from, to string, date time.Time) (float64, bool) {
+  if r, ok := rt.direct(from, to, date); ok {
+    return r, true
+  }
+  if r, ok := rt.direct(to, from, date); ok {
+    return 1 / r, true
+  }
+  return 0, false
+}
+
+//Return the number of units of to that one unit of from buys on date.
+func (rt *RateTable) Rate(from, to string, date time.Time) (float64, bool) {
+  if from == to {
+    return 1, true
+  }
+  if r, ok := rt.directOrInverse(from, to, date); ok {
+    return r, true
+  }
+  //Sort the pivots so the result does not depend on the iteration order of the map.
+  pivots := make([]string, 0, len(rt.currencies))
+  for c := range rt.currencies {
+    pivots = append(pivots, c)
+  }
+  sort.Strings(pivots)
+  for _, c := range pivots {
+    if c == from || c == to {
+      continue
+    }
+    r1, ok1 := rt.directOrInverse(from, c, date)
+    r2, ok2 := rt.directOrInverse(c, to, date)
+    if ok1 && ok2 {
+      return r1 * r2, true
+    }
+  }
+  return 0, false
+}
+
+//Convert an amount; the second return value is false when there is no rate for the pair on that date.
+func (rt *RateTable) Convert(amount float64, from, to string, date time.Time) (float64, bool) {
+  r, ok := rt.Rate(from, to, date)
+  if !ok {
+    return 0, false
+  }
+  return amount * r, true
+}
+
+/***
+The realized FX difference of a transfer between accounts in different currencies, in the reporting currency: the value of
+the amount received minus the value of the amount sent, both at the rates of the transfer date. It is zero when the transfer
+was done at the table's rate, positive for a gain, and negative for a loss (e.g., the bank's spread).
+***/
+func (rt *RateTable) RealizedFxDifference(sent float64, sentCurrency string, received float64, receivedCurrency,
+     reportingCurrency string, date time.Time) (float64, bool) {
+  sentValue, ok1 := rt.Convert(sent, sentCurrency, reportingCurrency, date)
+  receivedValue, ok2 := rt.Convert(received, receivedCurrency, reportingCurrency, date)
+  if !ok1 || !ok2 {
+    return 0, false
+  }
+  return receivedValue - sentValue, true
+}
+
+/***
+Parse exchange rates in CSV format; one rate per line:
+  date,from,to,rate
+  2026-01-02,USD,MXN,17.9512
+A first line that does not start with a date is taken as a header and ignored.
+***/
+func ParseRatesCSV(r io.Reader) ([]ExchangeRate, error) {
+  reader := csv.NewReader(r)
+  reader.FieldsPerRecord = 4
+  reader.TrimLeadingSpace = true
+  var rates []ExchangeRate
+  for line := 1; ; line++ {
+    record, err := reader.Read()
+    if err == io.EOF {
+      break
+    } else if err != nil {
+      return nil, err
+    }
+    date, err := time.Parse("2006-01-02", strings.TrimSpace(record[0]))
+    if err != nil {
+      if line == 1 {  //Header.
+        continue
+      }
+      return nil, fmt.Errorf("line %d: invalid date %q", line, record[0])
+    }
+    from := strings.ToUpper(strings.TrimSpace(record[1]))
+    to := strings.ToUpper(strings.TrimSpace(record[2]))
+    if !ValidCurrencyCode(from) || !ValidCurrencyCode(to) || from == to {
+      return nil, fmt.Errorf("line %d: invalid currency pair %s/%s", line, record[1], record[2])
+    }
+    rate, err := strconv.ParseFloat(strings.TrimSpace(record[3]), 64)
+    if err != nil || rate <= 0 {
+      return nil, fmt.Errorf("line %d: invalid rate %q", line, record[3])
+    }
+    rates = append(rates, ExchangeRate{ Date: date, From: from, To: to, Rate: rate })
+  }
+  if len(rates) == 0 {
+    return nil, errors.New("no exchange rates found")
+  }
+  return rates, nil
+}
@@ -0,0 +1,83 @@
+// Testing the functions in Currency.go.
+package finances
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Rate"
+***/
+
+import (
+  "math"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestRateTable_Rate(t *testing.T) {
+  t.Parallel()
+  rt := NewRateTable([]ExchangeRate{
+    { Date: date(2026, 1, 1), From: "USD", To: "MXN", Rate: 18 },
+    { Date: date(2026, 2, 1), From: "USD", To: "MXN", Rate: 17 },
+    { Date: date(2026, 1, 1), From: "EUR", To: "USD", Rate: 1.10 },
+  })
+  type test struct {
+    name string
+    from string
+    to string
+    on [3]int
+    want float64
+    ok bool
+  }
+  var tests = []test {
+    { name: "same currency", from: "USD", to: "USD", on: [3]int{ 2025, 1, 1 }, want: 1, ok: true },
+    { name: "direct", from: "USD", to: "MXN", on: [3]int{ 2026, 1, 15 }, want: 18, ok: true },
+    { name: "latest on or before", from: "USD", to: "MXN", on: [3]int{ 2026, 3, 1 }, want: 17, ok: true },
+    { name: "inverse", from: "MXN", to: "USD", on: [3]int{ 2026, 2, 1 }, want: 1.0 / 17, ok: true },
+    { name: "through a third currency", from: "EUR", to: "MXN", on: [3]int{ 2026, 1, 2 }, want: 1.10 * 18, ok: true },
+    { name: "before the first rate", from: "USD", to: "MXN", on: [3]int{ 2025, 12, 31 }, ok: false },
+    { name: "unknown currency", from: "USD", to: "JPY", on: [3]int{ 2026, 1, 2 }, ok: false },
+  }
+  for _, tc := range tests {
+    got, ok := rt.Rate(tc.from, tc.to, date(tc.on[0], time.Month(tc.on[1]), tc.on[2]))
+    if ok != tc.ok || (ok && math.Abs(got - tc.want) > 1e-9) {
+      t.Errorf("%s: Rate(%s, %s) = %v, %t; want %v, %t", tc.name, tc.from, tc.to, got, ok, tc.want, tc.ok)
+    }
+  }
+}
+
+func TestRateTable_RealizedFxDifference(t *testing.T) {
+  t.Parallel()
+  rt := NewRateTable([]ExchangeRate{ { Date: date(2026, 1, 1), From: "USD", To: "MXN", Rate: 18 } })
+  //Sent 100 USD and received 1,782 MXN (a 1% spread); the loss is 18 MXN or 1 USD.
+  got, ok := rt.RealizedFxDifference(100, "USD", 1782, "MXN", "USD", date(2026, 1, 5))
+  if !ok || math.Abs(got - (-1)) > 1e-9 {
+    t.Errorf("RealizedFxDifference = %v, %t; want -1, true", got, ok)
+  }
+}
+
+func TestParseRatesCSV(t *testing.T) {
+  t.Parallel()
+  rates, err := ParseRatesCSV(strings.NewReader("date,from,to,rate\n2026-01-02,usd,MXN,17.95\n2026-01-02, EUR, USD, 1.09\n"))
+  if err != nil || len(rates) != 2 || rates[0].From != "USD" || rates[1].Rate != 1.09 {
+    t.Errorf("ParseRatesCSV = %+v, %v; want 2 rates", rates, err)
+  }
+  var invalid = []string {
+    "2026-01-02,USD,MXN,-1\n",
+    "2026-01-02,USD,USD,1\n",
+    "2026-01-02,USD,MX,17\n",
+    "2026-01-02,USD,MXN,17\nnot a date,USD,MXN,17\n",
+    "date,from,to,rate\n",
+  }
+  for _, csv := range invalid {
+    if _, err := ParseRatesCSV(strings.NewReader(csv)); err == nil {
+      t.Errorf("ParseRatesCSV(%q) returned no error", csv)
+    }
+  }
+}
@@ -0,0 +1,206 @@
+// Recurrence computes the occurrence dates of recurring transactions (scheduled bills).
+package finances
+
+import (
+  "time"
+)
+
+const (
+  //Schedules; the values are stored as-is in the database.
+  EveryNDays string = "days"
+  EveryNWeeks string = "weeks"
+  MonthlyOnDay string = "monthly-day"
+  MonthlyLastBusinessDay string = "monthly-last-business-day"
+  SemiMonthly string = "semi-monthly"
+  Yearly string = "yearly"
+  //
+  hoursPerDay = 24
+  daysPerWeekInt = 7
+  //Safety net for malformed schedules; more than enough for any realistic preview window.
+  maxOccurrenceScan = 100000
+)
+
+/***
+A Recurrence describes when a recurring transaction is due.
+  * EveryNDays/EveryNWeeks: every Interval days/weeks counting from Start.
+  * MonthlyOnDay: every month on DayOfMonth; short months use their last day (e.g., the 31st becomes Feb 28).
+  * MonthlyLastBusinessDay: the last weekday (Monday-Friday) of every month. Holidays are not taken into account.
+  * SemiMonthly: twice a month on DayOfMonth and SecondDay (default: the 1st and the 15th).
+  * Yearly: every year on the month of Start and DayOfMonth (default: the day of Start).
+All dates are truncated to midnight UTC; the time of the day is irrelevant for a schedule. A zero End means no end date.
+***/
+type Recurrence struct {
+  Frequency string
+  Interval int
+  DayOfMonth int
+  SecondDay int
+  Start time.Time
+  End time.Time
+}
+
+//Truncate a time to midnight UTC keeping the calendar date.
+func DateOnly(t time.Time) time.Time {
+  y, m, d := t.Date()
+  return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+//The last day of the month; time.Date normalizes day 0 of the next month to the last day of this month.
+func lastDayOfMonth(year int, month time.Month) int {
+  return time.Date(year, month + 1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+//The date for a day of the month; days past the end of the month are clamped to the last day.
+func clampedDate(year int, month time.Month, day int) time.Time {
+  if last := lastDayOfMonth(year, month); day > last {
+    day = last
+  } else if day < 1 {
+    day = 1
+  }
+  return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func lastBusinessDay(year int, month time.Month) time.Time {
+  d := time.Date(year, month, lastDayOfMonth(year, month), 0, 0, 0, 0, time.UTC)
+  for d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+    d = d.AddDate(0, 0, -1)
+  }
+  return d
+}
+
+func (r Recurrence) interval() int {
+  if r.Interval < 1 {
+    return 1
+  }
+  return r.Interval
+}
+
+func (r Recurrence) dayOfMonth() int {
+  if r.DayOfMonth < 1 {
+    if r.Frequency == SemiMonthly {
+      return 1
+    }
+    return r.Start.Day()
+  }
+  return r.DayOfMonth
+}
+
+func (r Recurrence) secondDay() int {
+  if r.SecondDay < 1 {
+    return 15
+  }
+  return r.SecondDay
+}
+
+//Return true if the schedule is well formed.
+func (r Recurrence) Valid() bool {
+  if r.Start.IsZero() || (!r.End.IsZero() && DateOnly(r.End).Before(DateOnly(r.Start))) {
+    return false
+  }
+  switch r.Frequency {
+  case EveryNDays, EveryNWeeks, MonthlyLastBusinessDay:
+    return true
+  case MonthlyOnDay, Yearly:
+    return r.DayOfMonth >= 0 && r.DayOfMonth <= 31
+  case SemiMonthly:
+    return r.dayOfMonth() <= 31 && r.secondDay() <= 31 && r.dayOfMonth() != r.secondDay()
+  default:
+    return false
+  }
+}
+
+/***
+Return the first occurrence on or after date d. The second return value is false when the schedule is invalid or when there
+are no more occurrences (the schedule ended).
+***/
+func (r Recurrence) NextOnOrAfter(d time.Time) (time.Time, bool) {
+  if !r.Valid() {
+    return time.Time{}, false
+  }
+  start := DateOnly(r.Start)
+  d = DateOnly(d)
+  if d.Before(start) {
+    d = start
+  }
+  var next time.Time
+  switch r.Frequency {
+  case EveryNDays, EveryNWeeks:
+    step := r.interval()
+    if r.Frequency == EveryNWeeks {
+      step *= daysPerWeekInt
+    }
+    //Number of whole days between the start and d; no DST issues because both dates are UTC.
+    elapsed := int(d.Sub(start).Hours() / hoursPerDay)
+    k := (elapsed + step - 1) / step  //Ceiling.
+    next = start.AddDate(0, 0, k * step)
+  case MonthlyOnDay:
+    next = clampedDate(d.Year(), d.Month(), r.dayOfMonth())
+    if next.Before(d) {
+      next = clampedDate(d.Year(), d.Month() + 1, r.dayOfMonth())
+    }
+  case MonthlyLastBusinessDay:
+    next = lastBusinessDay(d.Year(), d.Month())
+    if next.Before(d) {
+      //Day 1 avoids normalization problems when moving to the next month.
+      m := time.Date(d.Year(), d.Month() + 1, 1, 0, 0, 0, 0, time.UTC)
+      next = lastBusinessDay(m.Year(), m.Month())
+    }
+  case SemiMonthly:
+    first, second := r.dayOfMonth(), r.secondDay()
+    if first > second {
+      first, second = second, first
+    }
+    next = clampedDate(d.Year(), d.Month(), first)
+    if next.Before(d) {
+      next = clampedDate(d.Year(), d.Month(), second)
+      if next.Before(d) {
+        m := time.Date(d.Year(), d.Month() + 1, 1, 0, 0, 0, 0, time.UTC)
+        next = clampedDate(m.Year(), m.Month(), first)
+      }
+    }
+  case Yearly:
+    next = clampedDate(d.Year(), start.Month(), r.dayOfMonth())
+    if next.Before(d) {
+      next = clampedDate(d.Year() + 1, start.Month(), r.dayOfMonth())
+    }
+  }
+  if !r.End.IsZero() && next.After(DateOnly(r.End)) {
+    return time.Time{}, false
+  }
+  return next, true
+}
+
+/***
+Return up to n occurrences on or after date from, leaving out the dates for which skip returns true. The skip function can be
+nil.
+***/
+func (r Recurrence) Occurrences(from time.Time, n int, skip func(time.Time) bool) []time.Time {
+  dates := make([]time.Time, 0, n)
+  d := from
+  for idx := 0; len(dates) < n && idx < maxOccurrenceScan; idx++ {
+    next, ok := r.NextOnOrAfter(d)
+    if !ok {
+      break
+    }
+    if skip == nil || !skip(next) {
+      dates = append(dates, next)
+    }
+    d = next.AddDate(0, 0, 1)
+  }
+  return dates
+}
+
+//Return all the occurrences in the closed interval [from, to].
+func (r Recurrence) OccurrencesBetween(from, to time.Time) []time.Time {
+  var dates []time.Time
+  to = DateOnly(to)
+  d := from
+  for idx := 0; idx < maxOccurrenceScan; idx++ {
+    next, ok := r.NextOnOrAfter(d)
+    if !ok || next.After(to) {
+      break
+    }
+    dates = append(dates, next)
+    d = next.AddDate(0, 0, 1)
+  }
+  return dates
+}
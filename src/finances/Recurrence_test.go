@@ -0,0 +1,91 @@
+// Testing the functions in Recurrence.go.
+package finances
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Recurrence"
+***/
+
+import (
+  "testing"
+  "time"
+)
+
+func date(y int, m time.Month, d int) time.Time {
+  return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestRecurrence_Occurrences(t *testing.T) {
+  t.Parallel()
+  type test struct {
+    name string
+    r Recurrence
+    from time.Time
+    n int
+    want []time.Time
+  }
+  var tests = []test {
+    { name: "every 10 days", r: Recurrence{ Frequency: EveryNDays, Interval: 10, Start: date(2026, 1, 1) },
+      from: date(2026, 1, 5), n: 3, want: []time.Time{ date(2026, 1, 11), date(2026, 1, 21), date(2026, 1, 31) } },
+    { name: "every 2 weeks", r: Recurrence{ Frequency: EveryNWeeks, Interval: 2, Start: date(2026, 1, 2) },
+      from: date(2026, 1, 2), n: 3, want: []time.Time{ date(2026, 1, 2), date(2026, 1, 16), date(2026, 1, 30) } },
+    { name: "monthly on the 31st", r: Recurrence{ Frequency: MonthlyOnDay, DayOfMonth: 31, Start: date(2026, 1, 1) },
+      from: date(2026, 1, 1), n: 4,
+      want: []time.Time{ date(2026, 1, 31), date(2026, 2, 28), date(2026, 3, 31), date(2026, 4, 30) } },
+    { name: "last business day", r: Recurrence{ Frequency: MonthlyLastBusinessDay, Start: date(2026, 1, 1) },
+      //Jan 31, 2026 is a Saturday and May 31, 2026 is a Sunday.
+      from: date(2026, 1, 1), n: 5,
+      want: []time.Time{ date(2026, 1, 30), date(2026, 2, 27), date(2026, 3, 31), date(2026, 4, 30), date(2026, 5, 29) } },
+    { name: "semi-monthly", r: Recurrence{ Frequency: SemiMonthly, DayOfMonth: 15, SecondDay: 30, Start: date(2026, 1, 20) },
+      from: date(2026, 1, 20), n: 4,
+      want: []time.Time{ date(2026, 1, 30), date(2026, 2, 15), date(2026, 2, 28), date(2026, 3, 15) } },
+    { name: "yearly on Feb 29", r: Recurrence{ Frequency: Yearly, DayOfMonth: 29, Start: date(2028, 2, 29) },
+      from: date(2028, 3, 1), n: 2, want: []time.Time{ date(2029, 2, 28), date(2030, 2, 28) } },
+    { name: "ends", r: Recurrence{ Frequency: MonthlyOnDay, DayOfMonth: 1, Start: date(2026, 1, 1), End: date(2026, 2, 15) },
+      from: date(2025, 12, 1), n: 12, want: []time.Time{ date(2026, 1, 1), date(2026, 2, 1) } },
+    { name: "invalid", r: Recurrence{ Frequency: "hourly", Start: date(2026, 1, 1) },
+      from: date(2026, 1, 1), n: 12, want: []time.Time{} },
+  }
+  for _, tc := range tests {
+    got := tc.r.Occurrences(tc.from, tc.n, nil)
+    if len(got) != len(tc.want) {
+      t.Errorf("%s: got %d occurrences %v, want %d", tc.name, len(got), got, len(tc.want))
+      continue
+    }
+    for idx := range got {
+      if !got[idx].Equal(tc.want[idx]) {
+        t.Errorf("%s: occurrence %d = %s, want %s", tc.name, idx, got[idx].Format("2006-01-02"),
+          tc.want[idx].Format("2006-01-02"))
+      }
+    }
+  }
+}
+
+func TestRecurrence_Skip(t *testing.T) {
+  t.Parallel()
+  r := Recurrence{ Frequency: MonthlyOnDay, DayOfMonth: 5, Start: date(2026, 1, 1) }
+  skipped := date(2026, 2, 5)
+  got := r.Occurrences(date(2026, 1, 1), 3, func(d time.Time) bool { return d.Equal(skipped) })
+  want := []time.Time{ date(2026, 1, 5), date(2026, 3, 5), date(2026, 4, 5) }
+  for idx := range want {
+    if idx >= len(got) || !got[idx].Equal(want[idx]) {
+      t.Fatalf("Occurrences with skip = %v, want %v", got, want)
+    }
+  }
+}
+
+func TestRecurrence_OccurrencesBetween(t *testing.T) {
+  t.Parallel()
+  r := Recurrence{ Frequency: EveryNWeeks, Interval: 1, Start: date(2026, 3, 2) }
+  got := r.OccurrencesBetween(date(2026, 3, 1), date(2026, 3, 23))
+  if len(got) != 4 || !got[3].Equal(date(2026, 3, 23)) {
+    t.Errorf("OccurrencesBetween = %v, want 4 weekly dates ending on 2026-03-23", got)
+  }
+}
@@ -0,0 +1,147 @@
+package health
+
+/***
+The probes of Kubernetes and the health report for the admins.
+(1) Startup (/startup): 503 until the server is started (the database is set up and the templates
+    are loaded); Kubernetes does not run the other probes until it answers 200, so a slow setup
+    (e.g., migrations) does not get the pod restarted.
+(2) Readiness (/readiness): it runs the checks of the dependencies (the database, the data
+    directory, ...), each with a short timeout; 503 if any fails, so Kubernetes stops sending
+    requests to the pod until it recovers.
+(3) Liveness (/liveness): the process answers; it does not check the dependencies, since restarting
+    the pod does not fix a database that is down.
+
+The body is JSON with the status and the latency of each check.
+***/
+
+import (
+  "context"
+  "encoding/json"
+  "net/http"
+  "sync"
+  "sync/atomic"
+  "time"
+)
+
+type Check struct {
+  Name string
+  Run func(ctx context.Context) error
+}
+
+type Result struct {
+  Name string  `json:"name"`
+  Status string  `json:"status"`  //ok or fail.
+  LatencyMs float64  `json:"latency_ms"`
+  Error string  `json:"error,omitempty"`
+}
+
+type Report struct {
+  Status string  `json:"status"`
+  Checks []Result  `json:"checks"`
+  Details map[string]any  `json:"details,omitempty"`
+}
+
+type Checker struct {
+  checks []Check
+  timeout time.Duration
+  started atomic.Bool
+}
+
+//Each check runs with the timeout; a check that does not end in time fails.
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+  return &Checker{ checks: checks, timeout: timeout }
+}
+
+//The server is ready to take requests; see (1).
+func (c *Checker) MarkStarted() {
+  c.started.Store(true)
+}
+
+func (c *Checker) Started() bool {
+  return c.started.Load()
+}
+
+//Run the checks in parallel; the results are in the order of the checks.
+func (c *Checker) Run(ctx context.Context) Report {
+  results := make([]Result, len(c.checks))
+  var wg sync.WaitGroup
+  for i, check := range c.checks {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      cctx, cancel := context.WithTimeout(ctx, c.timeout)
+      defer cancel()
+      start := time.Now()
+      errc := make(chan error, 1)  //The check can outlive the timeout; it must not block.
+      go func() {
+        errc <- check.Run(cctx)
+      }()
+      var err error
+      select {
+      case err = <-errc:
+      case <-cctx.Done():
+        err = cctx.Err()
+      }
+      results[i] = Result{
+        Name: check.Name,
+        Status: "ok",
+        LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+      }
+      if err != nil {
+        results[i].Status = "fail"
+        results[i].Error = err.Error()
+      }
+    }()
+  }
+  wg.Wait()
+  report := Report{ Status: "ok", Checks: results }
+  for _, r := range results {
+    if r.Status != "ok" {
+      report.Status = "fail"
+    }
+  }
+  return report
+}
+
+func writeReport(res http.ResponseWriter, report Report) {
+  res.Header().Set("Content-Type", "application/json")
+  res.Header().Set("Cache-Control", "no-store")
+  if report.Status != "ok" {
+    res.WriteHeader(http.StatusServiceUnavailable)
+  }
+  enc := json.NewEncoder(res)
+  enc.SetIndent("", "  ")
+  enc.Encode(report)
+}
+
+func (c *Checker) StartupHandler(res http.ResponseWriter, req *http.Request) {
+  report := Report{ Status: "ok", Checks: []Result{} }
+  if !c.Started() {
+    report.Status = "starting"
+  }
+  writeReport(res, report)
+}
+
+//It is not ready until it is started.
+func (c *Checker) ReadinessHandler(res http.ResponseWriter, req *http.Request) {
+  if !c.Started() {
+    writeReport(res, Report{ Status: "starting", Checks: []Result{} })
+    return
+  }
+  writeReport(res, c.Run(req.Context()))
+}
+
+/***
+The readiness report with the details returned by details (e.g., the statistics of the connection
+pool); for the admins, not for the probes.
+***/
+func (c *Checker) DetailsHandler(details func() map[string]any) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    report := c.Run(req.Context())
+    if !c.Started() {
+      report.Status = "starting"
+    }
+    report.Details = details()
+    writeReport(res, report)
+  }
+}
@@ -0,0 +1,109 @@
+// Testing the functions in health.go.
+package health
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Readiness"
+***/
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+  "time"
+)
+
+func TestReadiness(t *testing.T) {
+  t.Parallel()
+  ok := Check{ Name: "ok", Run: func(ctx context.Context) error { return nil } }
+  down := Check{ Name: "database", Run: func(ctx context.Context) error { return errors.New("connection refused") } }
+  //It ignores the context; the checker must not wait for it.
+  stuck := Check{ Name: "stuck", Run: func(ctx context.Context) error {
+    time.Sleep(time.Second)
+    return nil
+  } }
+  tests := []struct {
+    name string
+    checks []Check
+    started bool
+    status int
+    body string
+    failed []string
+  } {
+    { "not started", []Check{ ok }, false, http.StatusServiceUnavailable, "starting", nil },
+    { "healthy", []Check{ ok }, true, http.StatusOK, "ok", nil },
+    { "dependency down", []Check{ ok, down }, true, http.StatusServiceUnavailable, "fail", []string{ "database" } },
+    { "timeout", []Check{ stuck, ok }, true, http.StatusServiceUnavailable, "fail", []string{ "stuck" } },
+  }
+  for _, tc := range tests {
+    c := NewChecker(50 * time.Millisecond, tc.checks...)
+    if tc.started {
+      c.MarkStarted()
+    }
+    res := httptest.NewRecorder()
+    start := time.Now()
+    c.ReadinessHandler(res, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+    if elapsed := time.Since(start); elapsed > 500 * time.Millisecond {
+      t.Errorf("%s: the probe took %v", tc.name, elapsed)
+    }
+    if res.Code != tc.status {
+      t.Errorf("%s: status = %d; want %d", tc.name, res.Code, tc.status)
+    }
+    var report Report
+    if err := json.Unmarshal(res.Body.Bytes(), &report); err != nil {
+      t.Fatalf("%s: the body is not JSON: %v", tc.name, err)
+    }
+    if report.Status != tc.body {
+      t.Errorf("%s: status %q; want %q", tc.name, report.Status, tc.body)
+    }
+    var failed []string
+    for _, r := range report.Checks {
+      if r.Status != "ok" {
+        failed = append(failed, r.Name)
+        if r.Error == "" {
+          t.Errorf("%s: the check %s failed without an error", tc.name, r.Name)
+        }
+      }
+    }
+    if len(failed) != len(tc.failed) || (len(failed) > 0 && failed[0] != tc.failed[0]) {
+      t.Errorf("%s: failed checks %v; want %v", tc.name, failed, tc.failed)
+    }
+  }
+}
+
+func TestStartupAndDetails(t *testing.T) {
+  t.Parallel()
+  c := NewChecker(time.Second, Check{ Name: "ok", Run: func(ctx context.Context) error { return nil } })
+  details := c.DetailsHandler(func() map[string]any { return map[string]any{ "goroutines": 7 } })
+  for _, started := range []bool{ false, true } {
+    if started {
+      c.MarkStarted()
+    }
+    want := http.StatusServiceUnavailable
+    if started {
+      want = http.StatusOK
+    }
+    res := httptest.NewRecorder()
+    c.StartupHandler(res, httptest.NewRequest(http.MethodGet, "/startup", nil))
+    if res.Code != want {
+      t.Errorf("startup (started %v): status = %d; want %d", started, res.Code, want)
+    }
+    res = httptest.NewRecorder()
+    details(res, httptest.NewRequest(http.MethodGet, "/healthz/details", nil))
+    var report Report
+    json.Unmarshal(res.Body.Bytes(), &report)
+    if res.Code != want || report.Details["goroutines"] != float64(7) || len(report.Checks) != 1 {
+      t.Errorf("details (started %v): status = %d, report %+v", started, res.Code, report)
+    }
+  }
+}
@@ -0,0 +1,151 @@
+package mailer
+
+/***
+Outgoing email. The application writes to the Mailer interface; the implementation is chosen by the
+environment variable MAILER:
+(1) "smtp": SmtpMailer delivers the messages through an SMTP server (SMTP_HOST, SMTP_PORT, SMTP_USER,
+    SMTP_PASSWORD); the connection is upgraded with STARTTLS when the server offers it, and the
+    credentials are only sent over TLS (or to localhost).
+(2) "file" (default): FileMailer writes every message to a .eml file in MAIL_DIR, for local
+    development and tests; no email leaves the machine.
+The sender address is MAIL_FROM.
+***/
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "errors"
+  "finance/config"
+  "fmt"
+  "net"
+  "net/smtp"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "time"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+type Message struct {
+  To string
+  Subject string
+  Body string  //Plain text.
+}
+
+type Mailer interface {
+  Send(ctx context.Context, m Message) error
+}
+
+//Return the mailer selected by the environment variable MAILER.
+func New(correlationId string) Mailer {
+  from := config.GetMailFrom()
+  switch config.GetMailer(correlationId) {
+  case "smtp":
+    return &SmtpMailer{
+      Host: config.GetSmtpHost(),
+      Port: config.GetSmtpPort(correlationId),
+      User: config.GetSmtpUser(),
+      Password: config.GetSmtpPassword(),
+      From: from,
+    }
+  default:
+    dir := config.GetMailDir()
+    if dir == "" {
+      dir = filepath.Join(os.TempDir(), "finances-mail")
+    }
+    logger.LogInfo(fmt.Sprintf("Emails are written to %s.", dir), correlationId)
+    return &FileMailer{ Dir: dir, From: from }
+  }
+}
+
+/***
+Build the message in the Internet Message Format (RFC 5322). The header fields cannot contain line
+breaks; otherwise, a value supplied by a user could add headers (or recipients) to the message.
+***/
+func buildMessage(from string, m Message, now time.Time) ([]byte, error) {
+  for _, v := range []string{ from, m.To, m.Subject } {
+    if strings.ContainsAny(v, "\r\n") {
+      return nil, errors.New("The email header contains a line break.")
+    }
+  }
+  if m.To == "" {
+    return nil, errors.New("The email has no recipient.")
+  }
+  var sb strings.Builder
+  sb.WriteString("From: " + from + "\r\n")
+  sb.WriteString("To: " + m.To + "\r\n")
+  sb.WriteString("Subject: " + m.Subject + "\r\n")
+  sb.WriteString("Date: " + now.Format(time.RFC1123Z) + "\r\n")
+  sb.WriteString("MIME-Version: 1.0\r\n")
+  sb.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+  sb.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+  sb.WriteString("\r\n")
+  //SMTP requires CRLF line endings.
+  body := strings.ReplaceAll(strings.ReplaceAll(m.Body, "\r\n", "\n"), "\n", "\r\n")
+  sb.WriteString(body)
+  if !strings.HasSuffix(body, "\r\n") {
+    sb.WriteString("\r\n")
+  }
+  return []byte(sb.String()), nil
+}
+
+type SmtpMailer struct {
+  Host string
+  Port int
+  //Leave empty if the server does not require authentication.
+  User string
+  Password string
+  From string
+}
+
+func (s *SmtpMailer) Send(ctx context.Context, m Message) error {
+  if s.Host == "" {
+    return errors.New("SMTP_HOST is not set.")
+  }
+  msg, err := buildMessage(s.From, m, time.Now())
+  if err != nil {
+    return err
+  }
+  var auth smtp.Auth
+  if s.User != "" {
+    //PlainAuth refuses to send the credentials without TLS, except to localhost.
+    auth = smtp.PlainAuth("", s.User, s.Password, s.Host)
+  }
+  addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+  //smtp.SendMail does not take a context; give up waiting for it when the context is done.
+  done := make(chan error, 1)
+  go func() {
+    done <- smtp.SendMail(addr, auth, s.From, []string{ m.To }, msg)
+  }()
+  select {
+  case err = <-done:
+    return err
+  case <-ctx.Done():
+    return ctx.Err()
+  }
+}
+
+type FileMailer struct {
+  Dir string
+  From string
+}
+
+//Write the message to <Dir>/<timestamp>-<random>.eml; it is readable only by the owner.
+func (f *FileMailer) Send(ctx context.Context, m Message) error {
+  now := time.Now()
+  msg, err := buildMessage(f.From, m, now)
+  if err != nil {
+    return err
+  }
+  if err = os.MkdirAll(f.Dir, 0o700); err != nil {
+    return err
+  }
+  b := make([]byte, 4)
+  if _, err = rand.Read(b); err != nil {
+    return err
+  }
+  name := fmt.Sprintf("%s-%s.eml", now.UTC().Format("20060102T150405.000000000Z"), hex.EncodeToString(b))
+  return os.WriteFile(filepath.Join(f.Dir, name), msg, 0o600)
+}
@@ -0,0 +1,79 @@
+// Testing the functions in mailer.go.
+package mailer
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="FileMailer"
+***/
+
+import (
+  "context"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestBuildMessage(t *testing.T) {
+  t.Parallel()
+  now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+  type test struct {
+    name string
+    m Message
+    wantErr bool
+  }
+  var tests = []test {
+    { name: "valid", m: Message{ To: "jane@example.com", Subject: "Hi", Body: "Line 1\nLine 2" } },
+    { name: "no recipient", m: Message{ Subject: "Hi" }, wantErr: true },
+    { name: "header injection in the recipient", m: Message{ To: "a@example.com\r\nBcc: b@example.com" }, wantErr: true },
+    { name: "header injection in the subject", m: Message{ To: "a@example.com", Subject: "Hi\nBcc: b@example.com" },
+      wantErr: true },
+  }
+  for _, tc := range tests {
+    got, err := buildMessage("no-reply@example.com", tc.m, now)
+    if (err != nil) != tc.wantErr {
+      t.Errorf("%s: buildMessage error = %v; want error %v", tc.name, err, tc.wantErr)
+      continue
+    }
+    if tc.wantErr {
+      continue
+    }
+    s := string(got)
+    for _, want := range []string{ "From: no-reply@example.com\r\n", "To: jane@example.com\r\n", "Subject: Hi\r\n",
+        "Date: Fri, 02 Jan 2026 03:04:05 +0000\r\n", "\r\n\r\nLine 1\r\nLine 2\r\n" } {
+      if !strings.Contains(s, want) {
+        t.Errorf("%s: buildMessage = %q; missing %q", tc.name, s, want)
+      }
+    }
+  }
+}
+
+func TestFileMailer(t *testing.T) {
+  t.Parallel()
+  dir := filepath.Join(t.TempDir(), "mail")
+  var m Mailer = &FileMailer{ Dir: dir, From: "no-reply@example.com" }
+  for i := 0; i < 2; i++ {
+    if err := m.Send(context.Background(), Message{ To: "jane@example.com", Subject: "Reset", Body: "token" }); err != nil {
+      t.Fatal(err)
+    }
+  }
+  files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+  if err != nil || len(files) != 2 {
+    t.Fatalf("FileMailer wrote %d files (%v); want 2", len(files), err)
+  }
+  b, _ := os.ReadFile(files[0])
+  if !strings.Contains(string(b), "To: jane@example.com\r\n") || !strings.HasSuffix(string(b), "\r\ntoken\r\n") {
+    t.Errorf("FileMailer wrote %q", b)
+  }
+  if err := m.Send(context.Background(), Message{ To: "" }); err == nil {
+    t.Error("FileMailer.Send without a recipient should fail")
+  }
+}
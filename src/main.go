@@ -13,12 +13,21 @@ import (
   admin "finance/webfinances/wfadmin"  //Importing a package and assigning it a local alias.
   bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
   "context"
+  "finance/authz"
   "crypto/tls"
   "embed"
   "errors"
+  "flag"
   "finance/config"
+  "finance/databases/migrate"
+  "finance/health"
+  "finance/metrics"
+  "finance/ratelimit"
   "finance/renderer"
+  "finance/router"
   "finance/security"
+  "finance/statestore"
+  "finance/tracing"
   "finance/webfinances"
   banking "finance/webfinances/wfbanking"  //Importing a package and assigning it a local alias.
   "fmt"
@@ -36,6 +45,7 @@ import (
   the profile package will only register its handlers with the default multiplexer (http.DefaultServeMux).
   **/
   // _ "net/http/pprof" //Blank import of pprof.
+  "github.com/jackc/pgx/v5/pgxpool"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
   "github.com/juan-carlos-trimino/go-os"
@@ -44,6 +54,8 @@ import (
   "golang.org/x/crypto/acme/autocert"
   "os"
   "os/signal"
+  "path/filepath"
+  "runtime"
   "strconv"
   "strings"
   "sync"
@@ -61,26 +73,6 @@ const (
   bucketName string = "fin-finances"
   dataDirName string = "wsf_data_dir"
   falseCorrelationId = "-1"
-//////////////////////////
-////////////////////////////
-////////////////////////////
-//////////////////////////
-  //https://www.postgresql.org/docs/current/libpq-connect.html#LIBPQ-CONNSTRING
-  host = "localhost"
-  port = 5432
-  default_user = "trimino"
-  default_password = "trimino"
-  default_dbname = "postgres"
-  admin_user = "admin_user"
-  admin_password = "12345"
-  admin_dbname = "finances"
-  sslmode = "disable"  //Or "require", "prefer", etc., depending on your setup.
-  connect_timeout = 4  //Maximum time to wait while connecting, in seconds.
-  pathToScript = "../IaC-app/utilities/postgres/sql/baseline/admin.sql"
-//////////////////////////
-////////////////////////////
-////////////////////////////
-//////////////////////////
 )
 
 /***
@@ -111,7 +103,14 @@ type handlers struct {
   method is to call the underlying function. HandlerFunc is thus an adapter that lets a function value satisfy an interface, where the
   function and the interface's sole method have the same signature.
   ***/
-  mux map[string]http.HandlerFunc  //Multiplexer.
+  router *router.Router  //Multiplexer.
+}
+
+//The middleware stack of the routes of the application.
+var commonMiddlewares = []middlewares.Middleware{
+  middlewares.ValidateSessions,
+  middlewares.SecurityHeaders,
+  middlewares.CorrelationId,
 }
 
 /***
@@ -129,26 +128,71 @@ func (h *handlers) ServeHTTP(res http.ResponseWriter, req *http.Request) {
     correlationId = falseCorrelationId
   }
   var prevent_probes bool = !((strings.EqualFold("/liveness", req.URL.Path) ||
-                               strings.EqualFold("/readiness", req.URL.Path)) &&
+                               strings.EqualFold("/readiness", req.URL.Path) ||
+                               strings.EqualFold("/startup", req.URL.Path)) &&
                                config.GetPreventProbesOutput(correlationId))
   if prevent_probes {
     logger.LogInfo("Entering ServeHTTP/main.", correlationId)
     logger.LogInfo(fmt.Sprintf("Method: %s, Request URI: %s", req.Method, req.RequestURI),
      correlationId)
   }
-  //Implement route forwarding.
-  if handler, ok := h.mux[req.URL.Path]; ok {
-    handler(res, req)
-    return
+  /***
+  End the session of a user that was unregistered (deactivated) while logged in, or whose password
+  was reset after the session started; the request that did it may have been served by another
+  instance of the server (see security.IsSessionEnded).
+  ***/
+  cookie, err := req.Cookie("session_token")
+  if err == nil {
+    un := sessions.GetUserName(cookie.Value)
+    if security.IsSessionEnded(req.Context(), un, cookie.Value, correlationId) {
+      logger.LogInfo(fmt.Sprintf("Ending the session of user %s.", un), correlationId)
+      http.SetCookie(res, sessions.DeleteSession(cookie.Value))
+      security.SessionDeleted(cookie.Value)
+      http.Redirect(res, req, "/login", http.StatusSeeOther)
+      return
+    }
+    metrics.SessionSeen(un)
+  }
+  //Implement route forwarding; the router answers 404 or 405 if no route matches.
+  h.router.ServeHTTP(res, req)
+  //The pages renew the session token (sessions.UpdateEntryInSessions); the session keeps its start.
+  if err == nil {
+    for _, line := range res.Header().Values("Set-Cookie") {
+      if c, err := http.ParseSetCookie(line); err == nil && c.Name == "session_token" && c.Value != "" &&
+         c.Value != cookie.Value {
+        security.SessionRenewed(cookie.Value, c.Value)
+      }
+    }
   }
-  http.NotFound(res, req)  //404 - page not found.
 }
 
 func main() {
-  if !config.GetHttp(falseCorrelationId) && !config.GetHttps(falseCorrelationId) {
-    fmt.Println("You can run only HTTP (default), only HTTPS (set environment variables to:" +
-                " HTTP=false and HTTPS=true), or both (set environment variable to: HTTPS=true).")
+  //"finance config print [-show-secrets] [flags]" shows the effective configuration.
+  if len(os.Args) > 1 && os.Args[1] == "config" {
+    os.Exit(config.Command(os.Args[2:], os.Stdout, os.Stderr))
+  }
+  //"finance certs [flags]" issues the certificates of a private CA; see security.CertsCommand.
+  if len(os.Args) > 1 && os.Args[1] == "certs" {
+    os.Exit(security.CertsCommand(os.Args[2:], os.Stdout, os.Stderr))
+  }
+  //"finance migrate up|down|status [flags]" manages the migrations of the database; see migrate.Command.
+  if len(os.Args) > 1 && os.Args[1] == "migrate" {
+    os.Exit(migrate.Command(os.Args[2:], os.Stdout, os.Stderr))
+  }
+  cfg, err := config.Load(os.Args[1:], os.Stderr)
+  if errors.Is(err, flag.ErrHelp) {
     return
+  } else if err != nil {
+    fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+    os.Exit(2)
+  }
+  config.Use(cfg)
+  if rateLimiter, err = newRateLimiter(); err != nil {
+    fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+    os.Exit(2)
+  }
+  if stopTracing := startTracing(); stopTracing != nil {
+    defer stopTracing()
   }
   //
   if config.GetHttp(falseCorrelationId) {
@@ -194,19 +238,22 @@ func main() {
   } else {
     logger.LogInfo("The current user is not running as root.", falseCorrelationId)
   }
+  probes = newProbes(dataDir)
   readUsers(dataDir, users)
-  webfinances.SetupDirStructure(dataDir)
-  banking.SetupDirStructure(dataDir)
-  admin.SetupDirStructure(dataDir)
-  //Database.
-  if !config.GetK8s(falseCorrelationId) {  //If we are not using K8s, set up the database.
-    if ok := bank.ExecuteSqlScript(host, default_user, default_password, default_dbname, admin_dbname, sslmode,
-       port, connect_timeout, pathToScript, falseCorrelationId); !ok {
-      panic("Call to ExecuteSqlScript failed.")
+  //The state of the pages of each user.
+  if store, err := statestore.New(dataDir, falseCorrelationId); err != nil {
+    panic("Cannot create the state store: " + err.Error())
+  } else {
+    statestore.Use(store)
+  }
+  //Database; if we are not using K8s, create the roles and the database first.
+  if config.GetDbMigrate(falseCorrelationId) {
+    if err := migrate.Migrate(context.Background(), cfg, !config.GetK8s(falseCorrelationId), false,
+       migrate.LogWriter(falseCorrelationId)); err != nil {
+      panic("Cannot migrate the database: " + err.Error())
     }
   }
-  connString := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s connect_timeout=%d sslmode=%s", host, port,
-    admin_user, admin_password, admin_dbname, connect_timeout, sslmode)
+  connString := cfg.DbConnString()
   //logger.LogInfo(fmt.Sprintf("Connection string: %s", psqlInfo), falseCorrelationId)
   dbInstance := bank.InitializeBsPool(context.Background(), connString, falseCorrelationId)
   if dbInstance == nil {
@@ -214,16 +261,45 @@ func main() {
   }
   defer dbInstance.Close()
   dbInstance.VerifyConnection(context.Background(), falseCorrelationId)
+  //Every instance of the server ends the sessions of the users whose sessions were ended in the database.
+  security.SetUserSessionsLoader(bank.DbGetUserSessions)
+  //Post the due recurring transactions in the background; the scheduler stops when main returns.
+  if config.GetRecurringScheduler(falseCorrelationId) {
+    schedCtx, schedCancel := context.WithCancel(context.Background())
+    defer schedCancel()
+    bank.StartRecurringScheduler(schedCtx,
+      time.Duration(config.GetRecurringInterval(falseCorrelationId)) * time.Minute, falseCorrelationId)
+  }
+  //Delete the audit events older than the retention period once a day; 0 keeps them forever.
+  if days := config.GetAuditRetentionDays(falseCorrelationId); days > 0 {
+    auditCtx, auditCancel := context.WithCancel(context.Background())
+    defer auditCancel()
+    bank.StartAuditRetention(auditCtx, days, falseCorrelationId)
+  }
   /***
   Compile all templates from all sub-directories into memory.
   Pass the root virtual filesystem into te renderer initialization function.
   ***/
   renderer.InitTemplates(GlobalTemplateFS)
+  probes.MarkStarted()
   /***
   When Shutdown is called, Serve, ListenAndServe, and ListenAndServeTLS immediately return ErrServerClosed.
   Make sure the program doesn't exit and waits instead for Shutdown to return.
   ***/
   var wg sync.WaitGroup = sync.WaitGroup{}
+  //The metrics are served on their own port, which is not exposed to the users.
+  if port := config.GetMetricsPort(falseCorrelationId); port != 0 {
+    registerPoolMetrics()
+    wg.Add(1)
+    metricsServer := makeMetricsServer(port)
+    go waitForServer(metricsServer, make(chan os.Signal, 1), &wg)
+    go func() {
+      logger.LogInfo(fmt.Sprintf("Starting the metrics server at port %s...", metricsServer.Addr), falseCorrelationId)
+      if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+        logger.LogError(fmt.Sprintf("Metrics server error: %+v", err), falseCorrelationId)
+      }
+    }()
+  }
   var httpServer *http.Server
   if config.GetHttp(falseCorrelationId) {
     if config.GetK8s(falseCorrelationId) {
@@ -238,7 +314,7 @@ func main() {
     certMan = autocert.Manager{
       //It always returns true to indicate acceptance of the CA's Terms of Service during account registration.
       Prompt: autocert.AcceptTOS,
-      HostPolicy: autocert.HostWhitelist("trimino.xyz", "www.trimino.xyz"), //Domain names.
+      HostPolicy: autocert.HostWhitelist(config.GetAutocertHosts()...), //Domain names.
       Cache: autocert.DirCache(dataDir), //Folder for storing certificates.
     }
   }
@@ -283,7 +359,7 @@ func main() {
           GetCertificate: certMan.GetCertificate,
         }
       } else {
-        httpsServer.TLSConfig = makeTlsConfig()
+        httpsServer.TLSConfig = makeTlsConfig(dataDir)
       }
       go waitForServer(httpsServer, signalChan2, &wg)
       logger.LogInfo(fmt.Sprintf("Starting the server at port %s...", httpsServer.Addr), falseCorrelationId)
@@ -346,6 +422,10 @@ func faviconHandler(res http.ResponseWriter, req *http.Request) {
 func makeHandlers() *handlers {
   var wfbankPages = banking.WfBankingPages{}
   var wfbankMngAcctsPages = banking.WfBankingMngAcctsPages{}
+  var wfbankRecurringPages = banking.WfBankingRecurringPages{}
+  var wfbankBudgetsPages = banking.WfBankingBudgetsPages{}
+  var wfbankCurrenciesPages = banking.WfBankingCurrenciesPages{}
+  var wfbankTransfersPages = banking.WfBankingTransfersPages{}
   var wfpages = webfinances.WfPages{}
   var wfadcp = webfinances.WfAdCpPages{}
   var wfadepp = webfinances.WfAdEppPages{}
@@ -368,46 +448,26 @@ func makeHandlers() *handlers {
   var wfadminusers = admin.WfAdminUsersPages{}
 	var wfadminsettings = admin.WfAdminSettingsPages{}
   /***
-  The Go web server will route requests to different functions depending on the requested URL.
-  ***/
-  h := &handlers{}
-  /***
-  With a map, we can give the built-in function make only an initial size and not a capacity, as
-  with slices: hence, a single argument. Just like with slices, if we know up front the number of
-  elements a map will contain, we should create it by providing an initial size. Doing this avoids
-  potential map growth, which is quite heavy computation-wise because it requires reallocating
-  enough space and rebalancing all the elements. Also, specifying a size n doesn't mean making a
-  map with a maximum number of n elements. We can still add more than n elements if needed.
-  (Instead, it means asking the Go runtime to allocate a map with room for at least n elements,
-  which is helpful if we already know the size up front.)
-
-  Maps and memory usage
-  ---------------------
-  A map is composed of eight-element buckets. Under the hood, a Go map is a pointer to a
-  runtime.hmap struct. The number of buckets in a map cannot shrink. Therefore, removing elements
-  from a map doesn't impact the number of existing buckets; it just zeroes the slots in the
-  buckets. A map can only grow and have more buckets; it never shrinks.
-
-  If we don't want to manually restart our service to clean the amount of memory consumed by the
-  map, a solution would be to change the map type to store an array pointer; e.g., change
-  map[int][128]byte to map[int]*[128]byte. It doesn't solve the fact that we will have a
-  significant number of buckets; however, each bucket entry will reserve the size of a pointer for
-  the value instead of 128 bytes (8 bytes on 64-bit systems and 4 bytes on 32-bit systems). Of
-  course, with this solution the array of [128]byte will be stored on the heap; this can lead to
-  fragmentation of the heap as well as putting pressure on the GC.
+  The Go web server will route requests to different functions depending on the method and the
+  path of the request (see the router package). The page handlers answer GET (the page) and POST
+  (its forms); any other method gets 405 (Method Not Allowed) from the router.
+
+  Each group wraps its routes in its middleware stack; the groups of the admin and banking areas
+  run inside the common stack.
   ***/
-  h.mux = make(map[string]http.HandlerFunc, 128)
-  h.mux["/readiness"] = func (res http.ResponseWriter, req *http.Request) {
-    res.WriteHeader(http.StatusOK)
-    ctxKey := middlewares.MwContextKey{}
-    correlationId, _ := ctxKey.GetCorrelationId(req.Context())
-    if !config.GetPreventProbesOutput(correlationId) {
-      startTime, _ := ctxKey.GetStartTime(req.Context())
-      logger.LogInfo(fmt.Sprintf("Readiness probe. Request took %vms\n", time.Since(startTime).Microseconds()),
-        correlationId)
-    }
-  }
-  h.mux["/liveness"] = func (res http.ResponseWriter, req *http.Request) {
+  h := &handlers{ router: router.New() }
+  getPost := []string{ http.MethodGet, http.MethodPost }
+  //Recover runs inside the common stack, so the log of a panic has the correlation ID.
+  //The span of the request (tracing) covers the page rendered by Recover after a panic.
+  //The rate limiter runs inside Recover; its 429 page is rendered as any other error page.
+  common := h.router.Group(commonMiddlewares...).Group(tracing.Middleware).Group(renderer.Recover).
+    Group(rateLimiter.Middleware)
+  common.NotFound(renderer.NotFoundPage)
+  common.MethodNotAllowed(renderer.MethodNotAllowedPage)
+  //The probes accept any method, as they always did.
+  common.Handle("/startup", logProbe("Startup", probes.StartupHandler))
+  common.Handle("/readiness", logProbe("Readiness", probes.ReadinessHandler))
+  common.Handle("/liveness", func (res http.ResponseWriter, req *http.Request) {
     ctxKey := middlewares.MwContextKey{}
     correlationId, _ := ctxKey.GetCorrelationId(req.Context())
     prevent_probes := !config.GetPreventProbesOutput(correlationId)
@@ -422,67 +482,89 @@ func makeHandlers() *handlers {
       logger.LogInfo(fmt.Sprintf("Liveness probe. Request took %vms\n", time.Since(startTime).Microseconds()),
         correlationId)
     }
-  }
+  })
   //Serve static files; i.e., the server will serve them as they are, without processing it first.
-  h.mux["/public/css/home.css"] = wfpages.PublicHomeFile
-  h.mux["/public/js/setPageUI.js"] = wfpages.PublicSetPageUIFile
-  h.mux["/public/js/tableStylesheet.js"] = wfpages.PublicTableStylesheetFile
-  h.mux["/public/js/tabSplitPage.js"] = wfpages.PublicTabSplitPageFile
-  h.mux["/public/js/tabFullPage.js"] = wfpages.PublicTabFullPageFile
-  h.mux["/public/js/slider-alphabet.js"] = wfpages.PublicSliderAlphabetFile
-  h.mux["/favicon.ico"] = faviconHandler
-  h.mux["/"] = wfpages.IndexPage
-  h.mux["/login"] = wfpages.LoginPage
-  h.mux["/verify_login"] = wfpages.VerifyLogin
-  h.mux["/logout"] = wfpages.LogoutPage
-  h.mux["/welcome"] = wfpages.WelcomePage
-  h.mux["/contact"] = wfpages.ContactPage
-  h.mux["/about"] = wfpages.AboutPage
-  h.mux["/admin/welcome"] = middlewares.AdminVerification(wfadmin.WelcomePage)
-  h.mux["/admin/users"] = middlewares.AdminVerification(wfadminusers.AdminUsersPages)
-  h.mux["/admin/settings"] = middlewares.AdminVerification(wfadmin.AdminSettingsPage)
-  h.mux["/admin/settings/security"] = middlewares.AdminVerification(wfadminsettings.AdminSettingsPages)
-  h.mux["/banking"] = wfbankPages.BankingPage
-  h.mux["/banking/manageaccounts"] = wfbankMngAcctsPages.ManageAccountsPages
-  h.mux["/finances"] = wfpages.FinancesPage
-  h.mux["/fin/ordinaryannuity"] = wfpages.OrdinaryAnnuityPage
-  h.mux["/fin/ordinaryannuity/interestrate"] = wfoainterest.OaInterestRatePages
-  h.mux["/fin/ordinaryannuity/fv"] = wfoafv.OaFvPages
-  h.mux["/fin/ordinaryannuity/pv"] = wfoapv.OaPvPages
-  h.mux["/fin/ordinaryannuity/cp"] = wfoacp.OaCpPages
-  h.mux["/fin/ordinaryannuity/epp"] = wfoaepp.OaEppPages
-  h.mux["/fin/ordinaryannuity/ga"] = wfoaga.OaGaPages
-  h.mux["/fin/ordinaryannuity/perpetuity"] = wfoaperpetuity.OaPerpetuityPages
-  h.mux["/fin/annuitydue"] = wfpages.AnnuityDuePage
-  h.mux["/fin/annuitydue/cp"] = wfadcp.AdCpPages
-  h.mux["/fin/annuitydue/epp"] = wfadepp.AdEppPages
-  h.mux["/fin/annuitydue/fv"] = wfadfv.AdFvPages
-  h.mux["/fin/annuitydue/pv"] = wfadpv.AdPvPages
-  h.mux["/fin/bonds"] = wfbonds.BondsPages
-  h.mux["/fin/mortgage"] = wfmortgage.MortgagePages
-  h.mux["/fin/simpleinterest"] = wfpages.SimpleInterestPage
-  h.mux["/fin/simpleinterest/accurate"] = wfsia.SimpleInterestAccuratePages
-  h.mux["/fin/simpleinterest/bankers"] = wfsib.SimpleInterestBankersPages
-  h.mux["/fin/simpleinterest/ordinary"] = wfsio.SimpleInterestOrdinaryPages
-  h.mux["/fin/miscellaneous"] = wfmisc.MiscellaneousPages
+  common.Handle("/public/css/home.css", wfpages.PublicHomeFile, http.MethodGet)
+  common.Handle("/public/js/setPageUI.js", wfpages.PublicSetPageUIFile, http.MethodGet)
+  common.Handle("/public/js/tableStylesheet.js", wfpages.PublicTableStylesheetFile, http.MethodGet)
+  common.Handle("/public/js/tabSplitPage.js", wfpages.PublicTabSplitPageFile, http.MethodGet)
+  common.Handle("/public/js/tabFullPage.js", wfpages.PublicTabFullPageFile, http.MethodGet)
+  common.Handle("/public/js/slider-alphabet.js", wfpages.PublicSliderAlphabetFile, http.MethodGet)
+  common.Handle("/favicon.ico", faviconHandler, http.MethodGet)
+  //"/{$}" matches only the root; "/" would match every path.
+  common.Handle("/{$}", wfpages.IndexPage, http.MethodGet)
+  common.Handle("/login", wfpages.LoginPage, http.MethodGet)
+  common.Handle("/verify_login", wfpages.VerifyLogin, http.MethodPost)
+  common.Handle("/verify_totp", wfpages.VerifyTotp, http.MethodPost)
+  common.Handle("/twofactor", renderer.Page(wfpages.TwoFactorPage), getPost...)
+  common.Handle("/enroll_totp", renderer.Page(wfpages.EnrollTotpPage), getPost...)
+  common.Handle("/forgot_password", renderer.Page(wfpages.ForgotPasswordPage), getPost...)
+  common.Handle("/reset_password", renderer.Page(wfpages.ResetPasswordPage), getPost...)
+  common.Handle("/signup", renderer.Page(wfpages.SignupPage), getPost...)
+  common.Handle("/verify_signup", renderer.Page(wfpages.VerifySignupPage), getPost...)
+  common.Handle("/logout", wfpages.LogoutPage, http.MethodGet)
+  common.Handle("/welcome", wfpages.WelcomePage, http.MethodGet)
+  common.Handle("/contact", wfpages.ContactPage, http.MethodGet)
+  common.Handle("/about", wfpages.AboutPage, http.MethodGet)
+  /***
+  The admin routes require the admin token (JWT) and a permission; the banking routes require a
+  permission. The permissions come from the roles of the user (see the authz package).
+  ***/
+  adminArea := common.Group(middlewares.AdminVerification)
+  adminArea.Handle("/admin/welcome", authz.RequirePermission(bank.PermAdminAccess, wfadmin.WelcomePage), http.MethodGet)
+  adminArea.Handle("/admin/users",
+    authz.RequirePermission(bank.PermAdminUsers, renderer.Page(wfadminusers.AdminUsersPages)), getPost...)
+  adminArea.Handle("/admin/settings", authz.RequirePermission(bank.PermAdminSettings, wfadmin.AdminSettingsPage),
+    http.MethodGet)
+  adminArea.Handle("/admin/settings/security",
+    authz.RequirePermission(bank.PermAdminSettings, renderer.Page(wfadminsettings.AdminSettingsPages)), getPost...)
+  adminArea.Handle("/admin/diagnostics", authz.RequirePermission(bank.PermAdminDiagnostics, wfadmin.DiagnosticsPage),
+    http.MethodGet)
+  adminArea.Handle("/healthz/details",
+    authz.RequirePermission(bank.PermAdminDiagnostics, probes.DetailsHandler(healthDetails)), http.MethodGet)
+  adminArea.Handle("/admin/audit", authz.RequirePermission(bank.PermAuditRead, wfadmin.AuditPage), http.MethodGet)
+  bankingArea := common.Group(func(f http.HandlerFunc) http.HandlerFunc {
+    return authz.RequirePermission(bank.PermBankingUse, f)
+  })
+  bankingArea.Handle("/banking", wfbankPages.BankingPage, http.MethodGet)
+  bankingArea.Handle("/banking/manageaccounts", renderer.Page(wfbankMngAcctsPages.ManageAccountsPages), getPost...)
+  bankingArea.Handle("/banking/recurring", renderer.Page(wfbankRecurringPages.RecurringPages), getPost...)
+  bankingArea.Handle("/banking/budgets", renderer.Page(wfbankBudgetsPages.BudgetsPages), getPost...)
+  bankingArea.Handle("/banking/currencies", renderer.Page(wfbankCurrenciesPages.CurrenciesPages), getPost...)
+  bankingArea.Handle("/banking/transfers", renderer.Page(wfbankTransfersPages.TransfersPages), getPost...)
+  //The POST requests of the calculators are counted by page.
+  calculators := common.Group(metrics.CountCalculations)
+  common.Handle("/finances", wfpages.FinancesPage, http.MethodGet)
+  common.Handle("/fin/ordinaryannuity", wfpages.OrdinaryAnnuityPage, http.MethodGet)
+  calculators.Handle("/fin/ordinaryannuity/interestrate", renderer.Page(wfoainterest.OaInterestRatePages), getPost...)
+  calculators.Handle("/fin/ordinaryannuity/fv", renderer.Page(wfoafv.OaFvPages), getPost...)
+  calculators.Handle("/fin/ordinaryannuity/pv", renderer.Page(wfoapv.OaPvPages), getPost...)
+  calculators.Handle("/fin/ordinaryannuity/cp", renderer.Page(wfoacp.OaCpPages), getPost...)
+  calculators.Handle("/fin/ordinaryannuity/epp", renderer.Page(wfoaepp.OaEppPages), getPost...)
+  calculators.Handle("/fin/ordinaryannuity/ga", renderer.Page(wfoaga.OaGaPages), getPost...)
+  calculators.Handle("/fin/ordinaryannuity/perpetuity", renderer.Page(wfoaperpetuity.OaPerpetuityPages), getPost...)
+  common.Handle("/fin/annuitydue", wfpages.AnnuityDuePage, http.MethodGet)
+  calculators.Handle("/fin/annuitydue/cp", renderer.Page(wfadcp.AdCpPages), getPost...)
+  calculators.Handle("/fin/annuitydue/epp", renderer.Page(wfadepp.AdEppPages), getPost...)
+  calculators.Handle("/fin/annuitydue/fv", renderer.Page(wfadfv.AdFvPages), getPost...)
+  calculators.Handle("/fin/annuitydue/pv", renderer.Page(wfadpv.AdPvPages), getPost...)
+  calculators.Handle("/fin/bonds", renderer.Page(wfbonds.BondsPages), getPost...)
+  calculators.Handle("/fin/mortgage", renderer.Page(wfmortgage.MortgagePages), getPost...)
+  common.Handle("/fin/simpleinterest", wfpages.SimpleInterestPage, http.MethodGet)
+  calculators.Handle("/fin/simpleinterest/accurate", renderer.Page(wfsia.SimpleInterestAccuratePages), getPost...)
+  calculators.Handle("/fin/simpleinterest/bankers", renderer.Page(wfsib.SimpleInterestBankersPages), getPost...)
+  calculators.Handle("/fin/simpleinterest/ordinary", renderer.Page(wfsio.SimpleInterestOrdinaryPages), getPost...)
+  calculators.Handle("/fin/miscellaneous", renderer.Page(wfmisc.MiscellaneousPages), getPost...)
   if config.GetPprof(falseCorrelationId) {
-    h.mux["/debug/pprof/"] = pprof.Index
-    h.mux["/debug/pprof/heap"] = pprof.Handler("heap").ServeHTTP
-    h.mux["/debug/pprof/block"] = pprof.Handler("block").ServeHTTP
-    h.mux["/debug/pprof/goroutine"] = pprof.Handler("goroutine").ServeHTTP
-    h.mux["/debug/pprof/cmdline"] = pprof.Cmdline
-    h.mux["/debug/pprof/profile"] = pprof.Profile
-    h.mux["/debug/pprof/symbol"] = pprof.Symbol
-    h.mux["/debug/pprof/trace"] = pprof.Trace
-  }
-  commonMiddlewares := []middlewares.Middleware{
-    middlewares.ValidateSessions,
-    middlewares.SecurityHeaders,
-    middlewares.CorrelationId,
-  }
-  //
-  for idx, f := range h.mux{
-    h.mux[idx] = middlewares.ChainMiddlewares(f, commonMiddlewares)
+    //The trailing slash matches the profiles served by pprof.Index; e.g., /debug/pprof/allocs.
+    common.Handle("/debug/pprof/", pprof.Index, http.MethodGet)
+    common.Handle("/debug/pprof/heap", pprof.Handler("heap").ServeHTTP, http.MethodGet)
+    common.Handle("/debug/pprof/block", pprof.Handler("block").ServeHTTP, http.MethodGet)
+    common.Handle("/debug/pprof/goroutine", pprof.Handler("goroutine").ServeHTTP, http.MethodGet)
+    common.Handle("/debug/pprof/cmdline", pprof.Cmdline, http.MethodGet)
+    common.Handle("/debug/pprof/profile", pprof.Profile, http.MethodGet)
+    common.Handle("/debug/pprof/symbol", pprof.Symbol, getPost...)
+    common.Handle("/debug/pprof/trace", pprof.Trace, http.MethodGet)
   }
   return h
 }
@@ -494,44 +576,193 @@ func makeHandlersS3(h *handlers) *handlers {
     S3Client: s3Client,
     BucketName: bucketName,
   }
-  muxs := len(h.mux)
-  h.mux["/storage/s3/ListBuckets"] = middlewares.ValidateSessions(s3s.ListBuckets)
-  h.mux["/storage/s3/CreateBucket"] = middlewares.ValidateSessions(s3s.CreateBucket)
-  h.mux["/storage/s3/DeleteBucket"] = middlewares.ValidateSessions(s3s.DeleteBucket)
-  h.mux["/storage/s3/ListItemsInBucket"] = middlewares.ValidateSessions(s3s.ListItemsInBucket)
-  h.mux["/storage/s3/DeleteItemFromBucket"] = middlewares.ValidateSessions(s3s.DeleteItemFromBucket)
-  h.mux["/storage/s3/DownloadItemFromBucket"] = middlewares.ValidateSessions(s3s.DownloadItemFromBucket)
-  h.mux["/storage/s3/UploadItemToBucket"] = middlewares.ValidateSessions(s3s.UploadItemToBucket)
-  commonMiddlewares := []middlewares.Middleware{
-    middlewares.SecurityHeaders,
-    middlewares.CorrelationId,
-    //middlewares.ValidateSessions,
-  }
-  var id int = 0
-  for idx, f := range h.mux{
-    if id < muxs {
-      id++
-      continue
+  //The storage routes validate the session inside the headers and the correlation id.
+  storage := h.router.Group(middlewares.SecurityHeaders, middlewares.CorrelationId).Group(tracing.Middleware).
+    Group(renderer.Recover).Group(middlewares.ValidateSessions).Group(rateLimiter.Middleware)
+  storage.Handle("/storage/s3/ListBuckets", authz.RequirePermission(bank.PermStorageRead, s3s.ListBuckets))
+  storage.Handle("/storage/s3/CreateBucket", authz.RequirePermission(bank.PermStorageWrite, s3s.CreateBucket))
+  storage.Handle("/storage/s3/DeleteBucket", authz.RequirePermission(bank.PermStorageWrite, s3s.DeleteBucket))
+  storage.Handle("/storage/s3/ListItemsInBucket", authz.RequirePermission(bank.PermStorageRead, s3s.ListItemsInBucket))
+  storage.Handle("/storage/s3/DeleteItemFromBucket",
+    authz.RequirePermission(bank.PermStorageWrite, s3s.DeleteItemFromBucket))
+  storage.Handle("/storage/s3/DownloadItemFromBucket",
+    authz.RequirePermission(bank.PermStorageRead, s3s.DownloadItemFromBucket))
+  storage.Handle("/storage/s3/UploadItemToBucket",
+    authz.RequirePermission(bank.PermStorageWrite, s3s.UploadItemToBucket))
+  return h
+}
+
+/***
+The limits of the routes (see the ratelimit package); a login is limited by client IP and by
+username, and the other routes by session and client IP. The key of a session does not change when
+its token is renewed, and it does not reveal the token when the buckets are kept in Postgres
+(RATE_LIMIT_STORE=postgres); see security.SessionId.
+***/
+var rateLimiter = ratelimit.New(nil, ratelimit.NewMemoryStore(), renderer.TooManyRequestsPage)
+
+func newRateLimiter() (*ratelimit.Limiter, error) {
+  rules, err := ratelimit.ParseRules(config.GetRateLimits(), map[string]ratelimit.KeyFunc{
+    "ip": authz.ClientIp,
+    "user": func(req *http.Request) string {
+      return strings.ToLower(strings.TrimSpace(req.PostFormValue("uname")))
+    },
+    "session": authz.SessionKey,
+  })
+  if err != nil {
+    return nil, err
+  }
+  var store ratelimit.Store = ratelimit.NewMemoryStore()
+  if config.GetRateLimitStore(falseCorrelationId) == "postgres" {
+    store = &ratelimit.PgStore{}
+  }
+  logger.LogInfo(fmt.Sprintf("Rate limits: %d rules, kept in %s.", len(rules),
+    config.GetRateLimitStore(falseCorrelationId)), falseCorrelationId)
+  return ratelimit.New(rules, store, renderer.TooManyRequestsPage), nil
+}
+
+/***
+The checks of the readiness probe; main replaces it with the checks of the data directory. The
+probes answer 503 until MarkStarted is called.
+***/
+var probes = health.NewChecker(2 * time.Second)
+var startedAt = time.Now()
+
+func newProbes(dataDir string) *health.Checker {
+  return health.NewChecker(2 * time.Second,
+    health.Check{ Name: "database", Run: bank.PoolPing },
+    health.Check{ Name: "data_dir", Run: func(ctx context.Context) error {
+      //The directory is writable.
+      f, err := os.CreateTemp(dataDir, ".readiness-*")
+      if err != nil {
+        return err
+      }
+      f.Close()
+      return os.Remove(f.Name())
+    } },
+    health.Check{ Name: "templates", Run: func(ctx context.Context) error {
+      return renderer.CheckTemplates()
+    } },
+  )
+}
+
+//The details of /healthz/details.
+func healthDetails() map[string]any {
+  details := map[string]any{
+    "uptime_seconds": int64(time.Since(startedAt).Seconds()),
+    "goroutines": runtime.NumGoroutine(),
+    "go_version": runtime.Version(),
+  }
+  if s := bank.PoolStat(); s != nil {
+    details["db_pool"] = map[string]any{
+      "acquired_connections": s.AcquiredConns(),
+      "idle_connections": s.IdleConns(),
+      "total_connections": s.TotalConns(),
+      "max_connections": s.MaxConns(),
+      "acquires": s.AcquireCount(),
+      "empty_acquires": s.EmptyAcquireCount(),
+      "acquire_wait_ms": s.EmptyAcquireWaitTime().Milliseconds(),
+    }
+  }
+  return details
+}
+
+//Log a probe as the probes always were, unless PREVENT_PROBES_OUTPUT is set.
+func logProbe(name string, f http.HandlerFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    f(res, req)
+    ctxKey := middlewares.MwContextKey{}
+    correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+    if !config.GetPreventProbesOutput(correlationId) {
+      startTime, _ := ctxKey.GetStartTime(req.Context())
+      logger.LogInfo(fmt.Sprintf("%s probe. Request took %vms\n", name, time.Since(startTime).Microseconds()),
+        correlationId)
     }
-    h.mux[idx] = middlewares.ChainMiddlewares(f, commonMiddlewares)
   }
-  return h
+}
+
+//Send the spans of the traces to the configured exporter; the returned function flushes them.
+func startTracing() func() {
+  var exp tracing.Exporter
+  var file *os.File
+  switch config.GetTraceExporter(falseCorrelationId) {
+  case "stdout":
+    exp = tracing.NewWriterExporter(os.Stdout)
+  case "file":
+    var err error
+    if file, err = os.OpenFile(config.GetTraceFile(), os.O_CREATE | os.O_APPEND | os.O_WRONLY, 0600); err != nil {
+      panic("Cannot open the trace file: " + err.Error())
+    }
+    exp = tracing.NewWriterExporter(file)
+  case "otlp":
+    exp = tracing.NewOtlpExporter(config.GetTraceOtlpEndpoint(), "finance")
+  default:
+    return nil
+  }
+  logger.LogInfo(fmt.Sprintf("Tracing to %s (%d%% of the traces).", config.GetTraceExporter(falseCorrelationId),
+    config.GetTraceSamplePercent(falseCorrelationId)), falseCorrelationId)
+  shutdown := tracing.Setup(exp, config.GetTraceSamplePercent(falseCorrelationId), falseCorrelationId)
+  return func() {
+    ctx, cancel := context.WithTimeout(context.Background(), 5 * time.Second)
+    defer cancel()
+    shutdown(ctx)
+    if file != nil {
+      file.Close()
+    }
+  }
+}
+
+//It serves only GET /metrics.
+func makeMetricsServer(port int) *http.Server {
+  mux := http.NewServeMux()
+  mux.Handle("GET /metrics", metrics.Default.Handler())
+  return &http.Server{
+    Addr: config.GetServer() + ":" + strconv.Itoa(port),
+    ReadHeaderTimeout: 5 * time.Second,
+    ReadTimeout: 9 * time.Second,
+    WriteTimeout: 30 * time.Second,
+    Handler: mux,
+  }
+}
+
+//The statistics of the connection pool, read when the metrics are written.
+func registerPoolMetrics() {
+  stat := func(f func(s *pgxpool.Stat) float64) func() float64 {
+    return func() float64 {
+      if s := bank.PoolStat(); s != nil {
+        return f(s)
+      }
+      return 0
+    }
+  }
+  metrics.Default.GaugeFunc("finance_db_pool_acquired_connections", "Connections in use.",
+    stat(func(s *pgxpool.Stat) float64 { return float64(s.AcquiredConns()) }))
+  metrics.Default.GaugeFunc("finance_db_pool_idle_connections", "Idle connections.",
+    stat(func(s *pgxpool.Stat) float64 { return float64(s.IdleConns()) }))
+  metrics.Default.GaugeFunc("finance_db_pool_total_connections", "Connections in the pool.",
+    stat(func(s *pgxpool.Stat) float64 { return float64(s.TotalConns()) }))
+  metrics.Default.GaugeFunc("finance_db_pool_max_connections", "Maximum size of the pool.",
+    stat(func(s *pgxpool.Stat) float64 { return float64(s.MaxConns()) }))
+  metrics.Default.CounterFunc("finance_db_pool_acquires_total", "Connections acquired from the pool.",
+    stat(func(s *pgxpool.Stat) float64 { return float64(s.AcquireCount()) }))
+  metrics.Default.CounterFunc("finance_db_pool_empty_acquires_total",
+    "Acquires that waited for a connection because the pool was empty.",
+    stat(func(s *pgxpool.Stat) float64 { return float64(s.EmptyAcquireCount()) }))
+  metrics.Default.CounterFunc("finance_db_pool_acquire_wait_seconds_total",
+    "Time spent waiting for a connection because the pool was empty.",
+    stat(func(s *pgxpool.Stat) float64 { return s.EmptyAcquireWaitTime().Seconds() }))
 }
 
 func makeHttpToHttpsRedirectHandler(port int) *handlers {
-  /***
-  The Go web server will route requests to different functions depending on the requested URL.
-  ***/
-  h := &handlers{}
-  h.mux = make(map[string]http.HandlerFunc, 1)
-  h.mux["/"] = func(res http.ResponseWriter, req *http.Request) {
+  h := &handlers{ router: router.New() }
+  //"/" matches every path and method, so every request is sent to HTTPS.
+  h.router.Group().Handle("/", func(res http.ResponseWriter, req *http.Request) {
     host, _, _ := net.SplitHostPort(req.Host)
     u := req.URL
     u.Host = net.JoinHostPort(host, strconv.Itoa(port))
     u.Scheme = "https"
     logger.LogInfo(fmt.Sprintf("Redirecting to %s", u.String()), falseCorrelationId)
     http.Redirect(res, req, u.String(), http.StatusMovedPermanently)
-  }
+  })
   return h
 }
 
@@ -555,32 +786,38 @@ func readUsers(dir, filename string) {
   }
 }
 
-// ////////////
-func makeTlsConfig() *tls.Config {
-
-  // see Certificate structure at
-  // http://golang.org/pkg/crypto/x509/#Certificate
-  // see http://golang.org/pkg/crypto/x509/#KeyUsage
-
-  //Addr:      ":4443",
-  //server would listen on IP address 0.0.0.0 and TCP port 4443.
-
-  rootCert, rootCertPEM, rootPrivKey := security.GenRootCA()
-  _, serverCertPEM, serverPrivKeyPEM := security.GenServerCert(rootCert, rootPrivKey)
-
-  // fmt.Println(string(serverCertPEM))
-  // fmt.Println(string(serverPrivKeyPEM))
-
-  //Generate the key/pair for the server.
-  serverTlsCert, err := tls.X509KeyPair(serverCertPEM, serverPrivKeyPEM)
+/***
+The certificate of the server comes from the files of the operator, or from a private CA kept in
+the data directory; see security.CertStore. It is checked every minute: the files are read again
+when they change, and the certificate issued by the CA is renewed before it expires.
+***/
+func makeTlsConfig(dataDir string) *tls.Config {
+  store, err := security.NewCertStore(security.CertOptions{
+    CertFile: config.GetTlsCertFile(),
+    KeyFile: config.GetTlsKeyFile(),
+    Dir: filepath.Join(dataDir, "tls"),
+    Hosts: config.GetTlsHosts(),
+    Validity: time.Duration(config.GetTlsCertDays(falseCorrelationId)) * 24 * time.Hour,
+    KeyType: security.KeyType(config.GetTlsKeyType(falseCorrelationId)),
+  })
   if err != nil {
-    panic("Failed to generate X509KeyPair for the server.\n" + err.Error())
-  }
-
-  // fmt.Println(string(serverTlsCert.Certificate[0]))
-
+    panic("Failed to load the certificate of the server.\n" + err.Error())
+  }
+  logger.LogInfo(fmt.Sprintf("Server certificate: %s, expires %s.", store.Leaf().Subject.CommonName,
+    store.Leaf().NotAfter.Format(time.RFC3339)), falseCorrelationId)
+  go func() {
+    for now := range time.Tick(time.Minute) {
+      notAfter := store.Leaf().NotAfter
+      if err := store.Refresh(now); err != nil {
+        logger.LogError(fmt.Sprintf("Cannot refresh the server certificate: %v", err), falseCorrelationId)
+      } else if leaf := store.Leaf(); !leaf.NotAfter.Equal(notAfter) {
+        logger.LogInfo(fmt.Sprintf("New server certificate: %s, expires %s.", leaf.Subject.CommonName,
+          leaf.NotAfter.Format(time.RFC3339)), falseCorrelationId)
+      }
+    }
+  }()
   rootCAs := security.SystemCertPool()
-  if ok := rootCAs.AppendCertsFromPEM(rootCertPEM); !ok {
+  if caPEM := store.CAPEM(); caPEM != nil && !rootCAs.AppendCertsFromPEM(caPEM) {
     panic("Failed to append CA's certificate.")
   }
   tlsConfig := &tls.Config{
@@ -607,7 +844,7 @@ func makeTlsConfig() *tls.Config {
       // tls.CurveP384,
       tls.CurveP256,
     },
-    Certificates: []tls.Certificate{serverTlsCert},
+    GetCertificate: store.GetCertificate,
     RootCAs: rootCAs,
     // InsecureSkipVerify: true,
   }
@@ -705,7 +942,7 @@ func makeServer(port int, h *handlers) *http.Server {
     the specified message; the context passed to the handler will be canceled.
     Note: The http.Server.WriteTimeout is not necessary since http.TimeoutHandler is being used.
     ***/
-    Handler: http.TimeoutHandler(h, 5 * time.Minute, "Request timeout."),
+    Handler: http.TimeoutHandler(metrics.Instrument(h), 5 * time.Minute, "Request timeout."),
     /***
     It configures the maximum amount of time for the next request when keep-alives are enabled.
     Note that if http.Server.IdleTimeout isn't set, the value of http.Server.ReadTimeout is used
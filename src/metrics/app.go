@@ -0,0 +1,148 @@
+package metrics
+
+/***
+The metrics of the application. The statistics of the connection pool are registered by main,
+since this package does not depend on the database.
+***/
+
+import (
+  "net/http"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+)
+
+var (
+  HttpRequests = Default.CounterVec("finance_http_requests_total",
+    "HTTP requests by method, route pattern, and status code.", "method", "route", "status")
+  HttpDuration = Default.HistogramVec("finance_http_request_duration_seconds",
+    "Latency of the HTTP requests by method, route pattern, and status code.", DefBuckets, "method", "route", "status")
+  HttpInFlight = Default.GaugeVec("finance_http_requests_in_flight", "HTTP requests being served.")
+  TemplateErrors = Default.CounterVec("finance_template_render_errors_total",
+    "Pages that failed to render, by template and stage (parse or execute).", "template", "stage")
+  Calculations = Default.CounterVec("finance_calculations_total",
+    "Calculations (POST requests) by calculator page.", "page")
+  RateLimited = Default.CounterVec("finance_rate_limited_total",
+    "Requests rejected by the rate limiter (429), by rule.", "rule")
+)
+
+func init() {
+  Default.GaugeFunc("finance_sessions_active",
+    "Users whose session was used in the last 10 minutes (the session timeout).", activeSessions)
+}
+
+//The route label of a request: the pattern of the route without the method; e.g., /fin/bonds.
+func route(req *http.Request) string {
+  pattern := req.Pattern
+  if i := strings.IndexByte(pattern, ' '); i >= 0 {
+    pattern = pattern[i + 1:]
+  }
+  if pattern == "" {
+    return "unmatched"
+  }
+  return pattern
+}
+
+//The method label; a client can send any method, so the others are grouped.
+func method(req *http.Request) string {
+  switch req.Method {
+  case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete,
+       http.MethodOptions:
+    return req.Method
+  }
+  return "OTHER"
+}
+
+type statusWriter struct {
+  http.ResponseWriter
+  status int
+  wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+  if !w.wroteHeader {
+    w.status = status
+    w.wroteHeader = true
+  }
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+  w.wroteHeader = true
+  return w.ResponseWriter.Write(b)
+}
+
+//For http.ResponseController; e.g., to flush.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+  return w.ResponseWriter
+}
+
+/***
+Count the requests and measure their latency. It must wrap the router: the route is the pattern
+that the router sets on the request (req.Pattern); the requests that match no route are
+"unmatched".
+***/
+func Instrument(next http.Handler) http.Handler {
+  return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+    HttpInFlight.Add(1)
+    defer HttpInFlight.Add(-1)
+    startTime := time.Now()
+    sw := &statusWriter{ ResponseWriter: res, status: http.StatusOK }
+    next.ServeHTTP(sw, req)
+    status := strconv.Itoa(sw.status)
+    HttpRequests.Inc(method(req), route(req), status)
+    HttpDuration.Observe(time.Since(startTime).Seconds(), method(req), route(req), status)
+  })
+}
+
+//A middleware for the routes of the calculators; each POST is a calculation.
+func CountCalculations(f http.HandlerFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    if req.Method == http.MethodPost {
+      Calculations.Inc(route(req))
+    }
+    f(res, req)
+  }
+}
+
+/***
+The session tokens are rotated on every request, and the sessions package does not count them, so
+the users are counted instead: the time of the last request of each user with a session. A session
+expires 10 minutes after its last request.
+***/
+const sessionTimeout = 10 * time.Minute
+
+var sessions = struct {
+  sync.Mutex
+  lastSeen map[string]time.Time
+} { lastSeen: map[string]time.Time{} }
+
+//Record a request of a user with a session.
+func SessionSeen(userName string) {
+  if userName == "" {
+    return
+  }
+  sessions.Lock()
+  defer sessions.Unlock()
+  sessions.lastSeen[userName] = time.Now()
+}
+
+//The user logged out.
+func SessionEnded(userName string) {
+  sessions.Lock()
+  defer sessions.Unlock()
+  delete(sessions.lastSeen, userName)
+}
+
+//The expired entries are removed.
+func activeSessions() float64 {
+  sessions.Lock()
+  defer sessions.Unlock()
+  for user, seen := range sessions.lastSeen {
+    if time.Since(seen) > sessionTimeout {
+      delete(sessions.lastSeen, user)
+    }
+  }
+  return float64(len(sessions.lastSeen))
+}
@@ -0,0 +1,316 @@
+package metrics
+
+/***
+Metrics in the Prometheus text format (version 0.0.4):
+https://prometheus.io/docs/instrumenting/exposition_formats/#text-based-format
+
+A metric is a counter (it only goes up), a gauge (it goes up and down), or a histogram (the count
+of the observations in buckets, e.g., the latencies of the requests). A vector has labels; each
+combination of label values is a series. The labels must have few values (e.g., the route
+pattern, not the path of the request), since every series is kept in memory.
+
+A func metric (CounterFunc, GaugeFunc) reads its value when the metrics are written; e.g., the
+statistics of the connection pool.
+***/
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "math"
+  "net/http"
+  "slices"
+  "strconv"
+  "strings"
+  "sync"
+)
+
+type Registry struct {
+  mu sync.Mutex
+  metrics []metric
+  names map[string]bool
+}
+
+//The registry of the metrics of the application; Handler writes it.
+var Default = NewRegistry()
+
+func NewRegistry() *Registry {
+  return &Registry{ names: map[string]bool{} }
+}
+
+type metric interface {
+  describe() *desc
+  write(w *bufio.Writer)
+}
+
+type desc struct {
+  name string
+  help string
+  kind string  //counter, gauge, or histogram.
+  labels []string
+}
+
+func (d *desc) describe() *desc {
+  return d
+}
+
+//It panics if the name is used; it is a programming error found at startup.
+func (r *Registry) register(m metric) {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  if r.names[m.describe().name] {
+    panic("metrics: duplicate metric " + m.describe().name)
+  }
+  r.names[m.describe().name] = true
+  r.metrics = append(r.metrics, m)
+}
+
+//Write the metrics, sorted by name.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+  r.mu.Lock()
+  ms := slices.Clone(r.metrics)
+  r.mu.Unlock()
+  slices.SortFunc(ms, func(a, b metric) int {
+    return strings.Compare(a.describe().name, b.describe().name)
+  })
+  cw := &countingWriter{ w: w }
+  bw := bufio.NewWriter(cw)
+  for _, m := range ms {
+    d := m.describe()
+    fmt.Fprintf(bw, "# HELP %s %s\n", d.name, strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(d.help))
+    fmt.Fprintf(bw, "# TYPE %s %s\n", d.name, d.kind)
+    m.write(bw)
+  }
+  err := bw.Flush()
+  return cw.n, err
+}
+
+type countingWriter struct {
+  w io.Writer
+  n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+  n, err := c.w.Write(p)
+  c.n += int64(n)
+  return n, err
+}
+
+func (r *Registry) Handler() http.Handler {
+  return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+    res.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+    r.WriteTo(res)
+  })
+}
+
+func formatValue(v float64) string {
+  switch {
+  case math.IsInf(v, 1):
+    return "+Inf"
+  case math.IsInf(v, -1):
+    return "-Inf"
+  case math.IsNaN(v):
+    return "NaN"
+  }
+  return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//{name="value",...}; extra is appended (e.g., le of a bucket).
+func formatLabels(names, values []string, extra ...string) string {
+  if len(names) == 0 && len(extra) == 0 {
+    return ""
+  }
+  var sb strings.Builder
+  sb.WriteByte('{')
+  escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+  for i, n := range names {
+    if i > 0 {
+      sb.WriteByte(',')
+    }
+    fmt.Fprintf(&sb, `%s="%s"`, n, escape.Replace(values[i]))
+  }
+  for i := 0; i + 1 < len(extra); i += 2 {
+    if sb.Len() > 1 {
+      sb.WriteByte(',')
+    }
+    fmt.Fprintf(&sb, `%s="%s"`, extra[i], extra[i + 1])
+  }
+  sb.WriteByte('}')
+  return sb.String()
+}
+
+//The series of a vector, by their label values.
+type series[T any] struct {
+  mu sync.Mutex
+  byKey map[string]*T
+  values map[string][]string
+}
+
+func (s *series[T]) get(labels []string, values []string, init func() *T) *T {
+  if len(values) != len(labels) {
+    panic(fmt.Sprintf("metrics: %d label values for the labels %v", len(values), labels))
+  }
+  key := strings.Join(values, "\xff")
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if s.byKey == nil {
+    s.byKey = map[string]*T{}
+    s.values = map[string][]string{}
+  }
+  t, ok := s.byKey[key]
+  if !ok {
+    t = init()
+    s.byKey[key] = t
+    s.values[key] = slices.Clone(values)
+  }
+  return t
+}
+
+//Call f for every series, sorted by their label values; the lock is held.
+func (s *series[T]) each(f func(values []string, t *T)) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  keys := make([]string, 0, len(s.byKey))
+  for k := range s.byKey {
+    keys = append(keys, k)
+  }
+  slices.Sort(keys)
+  for _, k := range keys {
+    f(s.values[k], s.byKey[k])
+  }
+}
+
+type CounterVec struct {
+  desc
+  s series[float64]
+}
+
+func (r *Registry) CounterVec(name, help string, labels ...string) *CounterVec {
+  c := &CounterVec{ desc: desc{ name: name, help: help, kind: "counter", labels: labels } }
+  r.register(c)
+  return c
+}
+
+//Add one to the series of the label values.
+func (c *CounterVec) Inc(values ...string) {
+  c.Add(1, values...)
+}
+
+//A counter only goes up; a negative v is ignored.
+func (c *CounterVec) Add(v float64, values ...string) {
+  if v < 0 {
+    return
+  }
+  p := c.s.get(c.labels, values, func() *float64 { return new(float64) })
+  c.s.mu.Lock()
+  *p += v
+  c.s.mu.Unlock()
+}
+
+func (c *CounterVec) write(w *bufio.Writer) {
+  c.s.each(func(values []string, v *float64) {
+    fmt.Fprintf(w, "%s%s %s\n", c.name, formatLabels(c.labels, values), formatValue(*v))
+  })
+}
+
+type GaugeVec struct {
+  desc
+  s series[float64]
+}
+
+func (r *Registry) GaugeVec(name, help string, labels ...string) *GaugeVec {
+  g := &GaugeVec{ desc: desc{ name: name, help: help, kind: "gauge", labels: labels } }
+  r.register(g)
+  return g
+}
+
+func (g *GaugeVec) Set(v float64, values ...string) {
+  p := g.s.get(g.labels, values, func() *float64 { return new(float64) })
+  g.s.mu.Lock()
+  *p = v
+  g.s.mu.Unlock()
+}
+
+func (g *GaugeVec) Add(v float64, values ...string) {
+  p := g.s.get(g.labels, values, func() *float64 { return new(float64) })
+  g.s.mu.Lock()
+  *p += v
+  g.s.mu.Unlock()
+}
+
+func (g *GaugeVec) write(w *bufio.Writer) {
+  g.s.each(func(values []string, v *float64) {
+    fmt.Fprintf(w, "%s%s %s\n", g.name, formatLabels(g.labels, values), formatValue(*v))
+  })
+}
+
+type funcMetric struct {
+  desc
+  f func() float64
+}
+
+func (m *funcMetric) write(w *bufio.Writer) {
+  fmt.Fprintf(w, "%s %s\n", m.name, formatValue(m.f()))
+}
+
+//A counter whose value is read from f; f must not decrease.
+func (r *Registry) CounterFunc(name, help string, f func() float64) {
+  r.register(&funcMetric{ desc: desc{ name: name, help: help, kind: "counter" }, f: f })
+}
+
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+  r.register(&funcMetric{ desc: desc{ name: name, help: help, kind: "gauge" }, f: f })
+}
+
+//The default buckets of the latencies, in seconds.
+var DefBuckets = []float64{ .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10 }
+
+type histogram struct {
+  counts []uint64  //Per bucket, not cumulative; the last one is +Inf.
+  sum float64
+  count uint64
+}
+
+type HistogramVec struct {
+  desc
+  buckets []float64
+  s series[histogram]
+}
+
+//The buckets are the upper bounds, in increasing order; +Inf is added.
+func (r *Registry) HistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+  if !slices.IsSorted(buckets) {
+    panic("metrics: the buckets of " + name + " are not sorted")
+  }
+  h := &HistogramVec{ desc: desc{ name: name, help: help, kind: "histogram", labels: labels }, buckets: buckets }
+  r.register(h)
+  return h
+}
+
+func (h *HistogramVec) Observe(v float64, values ...string) {
+  p := h.s.get(h.labels, values, func() *histogram {
+    return &histogram{ counts: make([]uint64, len(h.buckets) + 1) }
+  })
+  i, _ := slices.BinarySearch(h.buckets, v)  //The first bucket with an upper bound >= v.
+  h.s.mu.Lock()
+  p.counts[i]++
+  p.sum += v
+  p.count++
+  h.s.mu.Unlock()
+}
+
+func (h *HistogramVec) write(w *bufio.Writer) {
+  h.s.each(func(values []string, p *histogram) {
+    var cumulative uint64
+    for i, c := range p.counts {
+      cumulative += c
+      le := math.Inf(1)
+      if i < len(h.buckets) {
+        le = h.buckets[i]
+      }
+      fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, formatLabels(h.labels, values, "le", formatValue(le)), cumulative)
+    }
+    fmt.Fprintf(w, "%s_sum%s %s\n", h.name, formatLabels(h.labels, values), formatValue(p.sum))
+    fmt.Fprintf(w, "%s_count%s %d\n", h.name, formatLabels(h.labels, values), p.count)
+  })
+}
@@ -0,0 +1,145 @@
+// Testing the functions in metrics.go and app.go.
+package metrics
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Registry"
+***/
+
+import (
+  "bytes"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+func TestRegistry(t *testing.T) {
+  t.Parallel()
+  r := NewRegistry()
+  c := r.CounterVec("test_requests_total", "Requests.", "route", "status")
+  c.Inc("/login", "200")
+  c.Inc("/login", "200")
+  c.Add(0.5, `/a"b\c`, "500")
+  c.Add(-1, "/login", "200")  //Ignored.
+  g := r.GaugeVec("test_in_flight", "In flight.")
+  g.Add(3)
+  g.Add(-1)
+  h := r.HistogramVec("test_duration_seconds", "Latency.", []float64{ 0.1, 1 }, "route")
+  h.Observe(0.05, "/x")
+  h.Observe(0.1, "/x")
+  h.Observe(3, "/x")
+  r.GaugeFunc("test_func", "Read when written.", func() float64 { return 7 })
+  var buf bytes.Buffer
+  if _, err := r.WriteTo(&buf); err != nil {
+    t.Fatal(err)
+  }
+  want := `# HELP test_duration_seconds Latency.
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{route="/x",le="0.1"} 2
+test_duration_seconds_bucket{route="/x",le="1"} 2
+test_duration_seconds_bucket{route="/x",le="+Inf"} 3
+test_duration_seconds_sum{route="/x"} 3.15
+test_duration_seconds_count{route="/x"} 3
+# HELP test_func Read when written.
+# TYPE test_func gauge
+test_func 7
+# HELP test_in_flight In flight.
+# TYPE test_in_flight gauge
+test_in_flight 2
+# HELP test_requests_total Requests.
+# TYPE test_requests_total counter
+test_requests_total{route="/a\"b\\c",status="500"} 0.5
+test_requests_total{route="/login",status="200"} 2
+`
+  if buf.String() != want {
+    t.Errorf("WriteTo =\n%s\nwant\n%s", buf.String(), want)
+  }
+}
+
+func TestRegistryPanics(t *testing.T) {
+  t.Parallel()
+  tests := []struct {
+    name string
+    f func(r *Registry)
+  } {
+    { "duplicate name", func(r *Registry) {
+        r.CounterVec("dup", "")
+        r.GaugeVec("dup", "")
+      } },
+    { "wrong number of labels", func(r *Registry) {
+        r.CounterVec("c", "", "a", "b").Inc("x")
+      } },
+    { "unsorted buckets", func(r *Registry) {
+        r.HistogramVec("h", "", []float64{ 1, 0.5 })
+      } },
+  }
+  for _, tc := range tests {
+    func() {
+      defer func() {
+        if recover() == nil {
+          t.Errorf("%s: no panic", tc.name)
+        }
+      }()
+      tc.f(NewRegistry())
+    }()
+  }
+}
+
+func TestInstrument(t *testing.T) {
+  t.Parallel()
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /test/items/{id}", func(res http.ResponseWriter, req *http.Request) {
+    io.WriteString(res, "item")
+  })
+  mux.HandleFunc("POST /test/calc", CountCalculations(func(res http.ResponseWriter, req *http.Request) {
+    res.WriteHeader(http.StatusCreated)
+  }))
+  h := Instrument(mux)
+  requests := []struct {
+    method string
+    path string
+  } {
+    { http.MethodGet, "/test/items/1" },
+    { http.MethodGet, "/test/items/2" },
+    { http.MethodPost, "/test/calc" },
+    { "BREW", "/test/nothing" },
+  }
+  for _, r := range requests {
+    h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(r.method, r.path, nil))
+  }
+  var buf bytes.Buffer
+  Default.WriteTo(&buf)
+  for _, want := range []string{
+    `finance_http_requests_total{method="GET",route="/test/items/{id}",status="200"} 2`,
+    `finance_http_requests_total{method="POST",route="/test/calc",status="201"} 1`,
+    `finance_http_requests_total{method="OTHER",route="unmatched",status="404"} 1`,
+    `finance_http_request_duration_seconds_count{method="GET",route="/test/items/{id}",status="200"} 2`,
+    `finance_calculations_total{page="/test/calc"} 1`,
+    `finance_http_requests_in_flight 0`,
+  } {
+    if !strings.Contains(buf.String(), want) {
+      t.Errorf("the metrics do not contain %s", want)
+    }
+  }
+}
+
+func TestActiveSessions(t *testing.T) {
+  t.Parallel()
+  SessionSeen("test-user-1")
+  SessionSeen("test-user-2")
+  SessionSeen("test-user-2")
+  SessionSeen("")  //No session.
+  SessionEnded("test-user-1")
+  if n := activeSessions(); n != 1 {
+    t.Errorf("activeSessions = %v; want 1", n)
+  }
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+  "context"
+  "sync"
+  "time"
+)
+
+/***
+The buckets of this instance of the server. A bucket that is full again is the same as no bucket,
+so those are removed once per sweep interval; the map does not grow without bound.
+***/
+type MemoryStore struct {
+  mu sync.Mutex
+  buckets map[string]*bucket
+  sweepInterval time.Duration
+  lastSweep time.Time
+}
+
+type bucket struct {
+  tokens float64
+  updated time.Time
+  full time.Time  //When the bucket is full again.
+}
+
+func NewMemoryStore() *MemoryStore {
+  return &MemoryStore{ buckets: map[string]*bucket{}, sweepInterval: time.Minute }
+}
+
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if now.Sub(s.lastSweep) >= s.sweepInterval {
+    for k, b := range s.buckets {
+      if !now.Before(b.full) {
+        delete(s.buckets, k)
+      }
+    }
+    s.lastSweep = now
+  }
+  b, ok := s.buckets[key]
+  if !ok {
+    b = &bucket{ tokens: float64(limit.Burst), updated: now }
+    s.buckets[key] = b
+  }
+  if elapsed := now.Sub(b.updated); elapsed > 0 {
+    b.tokens = min(float64(limit.Burst), b.tokens + elapsed.Seconds() * limit.Rate)
+    b.updated = now
+  }
+  if b.tokens < 1 {
+    return false, limit.wait(b.tokens), nil
+  }
+  b.tokens--
+  b.full = now.Add(time.Duration((float64(limit.Burst) - b.tokens) / limit.Rate * float64(time.Second)))
+  return true, 0, nil
+}
+
+//The buckets kept; for the tests.
+func (s *MemoryStore) len() int {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  return len(s.buckets)
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+  "context"
+  bank "finance/databases/banking"
+  "fmt"
+  "sync"
+  "time"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+/***
+The buckets in fin.rate_limits, shared by all the instances of the server; a bucket is updated in a
+single statement, so the instances do not race. The time is that of the database, the same for
+every instance. The rows not used for a day are deleted once per sweep interval; a failed sweep is
+only logged, since the token was taken (or refused) all the same.
+***/
+type PgStore struct {
+  mu sync.Mutex
+  lastSweep time.Time
+}
+
+const (
+  pgSweepInterval = 10 * time.Minute
+  pgIdle = 24 * time.Hour
+)
+
+func (s *PgStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+  ok, tokens, err := bank.DbTakeRateToken(ctx, key, limit.Rate, limit.Burst)
+  if err != nil {
+    return true, 0, err
+  }
+  s.mu.Lock()
+  sweep := now.Sub(s.lastSweep) >= pgSweepInterval
+  if sweep {
+    s.lastSweep = now
+  }
+  s.mu.Unlock()
+  if sweep {
+    if err = bank.DbPurgeRateLimits(ctx, pgIdle); err != nil {
+      ctxKey := middlewares.MwContextKey{}
+      correlationId, _ := ctxKey.GetCorrelationId(ctx)
+      logger.LogError(fmt.Sprintf("Rate limits sweep: %v", err), correlationId)
+    }
+  }
+  if !ok {
+    return false, limit.wait(tokens), nil
+  }
+  return true, 0, nil
+}
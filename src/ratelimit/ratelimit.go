@@ -0,0 +1,176 @@
+package ratelimit
+
+/***
+Token-bucket rate limiting of the routes. A bucket holds up to Burst tokens and gains Rate tokens
+per second; a request takes one token, and it is rejected with 429 (Too Many Requests) and a
+Retry-After header when the bucket is empty. So a client can send Burst requests at once, and then
+Rate per second on average.
+
+A rule limits a route (and method) per key: the client IP, the username of a login, or the session.
+A request takes a token from the bucket of every rule that matches it; e.g., a login is limited per
+IP (a client trying many usernames) and per username (many clients trying one username). The rules
+are set by the configuration (RATE_LIMITS), one per comma:
+  [METHOD] PATH KEY=N/UNIT
+e.g., "POST /verify_login user=5/m" allows 5 logins per minute for a username. PATH is exact, or a
+prefix if it ends with "/"; UNIT is s, m, or h; the burst is N.
+
+The buckets are kept by a Store: MemoryStore (one per instance of the server) or PgStore (shared by
+all the instances, in Postgres). If the store fails, the request is allowed; the limiter must not
+take the site down with the database.
+***/
+
+import (
+  "context"
+  "errors"
+  "finance/metrics"
+  "fmt"
+  "math"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+type Limit struct {
+  Rate float64  //Tokens per second.
+  Burst int
+}
+
+//The time until the bucket with tokens has a token.
+func (l Limit) wait(tokens float64) time.Duration {
+  return time.Duration((1 - tokens) / l.Rate * float64(time.Second))
+}
+
+type Store interface {
+  //Take a token from the bucket of key; if it is empty, ok is false and retryAfter is the wait.
+  Take(ctx context.Context, key string, limit Limit, now time.Time) (ok bool, retryAfter time.Duration, err error)
+}
+
+//The key of the request for a rule; an empty key skips the rule (e.g., no session yet).
+type KeyFunc func(req *http.Request) string
+
+type Rule struct {
+  Method string  //Empty for any method.
+  Path string
+  Key string  //The name of the KeyFunc; e.g., ip.
+  Limit Limit
+  keyFunc KeyFunc
+}
+
+func (r Rule) String() string {
+  return strings.TrimSpace(r.Method + " " + r.Path) + " " + r.Key
+}
+
+func (r Rule) matches(req *http.Request) bool {
+  if r.Method != "" && r.Method != req.Method {
+    return false
+  }
+  if strings.HasSuffix(r.Path, "/") {
+    return strings.HasPrefix(req.URL.Path, r.Path)
+  }
+  return req.URL.Path == r.Path
+}
+
+var units = map[string]time.Duration{ "s": time.Second, "m": time.Minute, "h": time.Hour }
+
+//Parse the rules of the configuration; keys are the KeyFuncs the rules can name.
+func ParseRules(spec string, keys map[string]KeyFunc) ([]Rule, error) {
+  var rules []Rule
+  var errs []error
+  for _, item := range strings.Split(spec, ",") {
+    if item = strings.TrimSpace(item); item == "" {
+      continue
+    }
+    rule, err := parseRule(item, keys)
+    if err != nil {
+      errs = append(errs, fmt.Errorf("rate limit %q: %w", item, err))
+      continue
+    }
+    rules = append(rules, rule)
+  }
+  return rules, errors.Join(errs...)
+}
+
+func parseRule(item string, keys map[string]KeyFunc) (Rule, error) {
+  var r Rule
+  fields := strings.Fields(item)
+  if len(fields) == 3 {
+    r.Method, fields = strings.ToUpper(fields[0]), fields[1:]
+  }
+  if len(fields) != 2 || !strings.HasPrefix(fields[0], "/") {
+    return r, errors.New("want [METHOD] PATH KEY=N/UNIT")
+  }
+  r.Path = fields[0]
+  key, limit, _ := strings.Cut(fields[1], "=")
+  n, unit, _ := strings.Cut(limit, "/")
+  var ok bool
+  if r.keyFunc, ok = keys[key]; !ok {
+    return r, fmt.Errorf("unknown key %q", key)
+  }
+  r.Key = key
+  count, err := strconv.Atoi(n)
+  if err != nil || count < 1 {
+    return r, fmt.Errorf("%q is not a positive number of requests", n)
+  }
+  per, ok := units[unit]
+  if !ok {
+    return r, fmt.Errorf("%q is not a unit; use s, m, or h", unit)
+  }
+  r.Limit = Limit{ Rate: float64(count) / per.Seconds(), Burst: count }
+  return r, nil
+}
+
+type Limiter struct {
+  rules []Rule
+  store Store
+  reject http.HandlerFunc
+}
+
+//reject writes the response of a rejected request; the Retry-After header is already set.
+func New(rules []Rule, store Store, reject http.HandlerFunc) *Limiter {
+  return &Limiter{ rules: rules, store: store, reject: reject }
+}
+
+func (l *Limiter) Rules() []Rule {
+  return l.rules
+}
+
+/***
+The middleware that applies the rules; it must run inside the middleware of the correlation ID, so
+the rejections are logged with it.
+***/
+func (l *Limiter) Middleware(f http.HandlerFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    for _, r := range l.rules {
+      if !r.matches(req) {
+        continue
+      }
+      key := r.keyFunc(req)
+      if key == "" {
+        continue
+      }
+      ok, retryAfter, err := l.store.Take(req.Context(), r.String() + "|" + key, r.Limit, time.Now())
+      ctxKey := middlewares.MwContextKey{}
+      correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+      if err != nil {
+        logger.LogError(fmt.Sprintf("Rate limit %s: %v", r, err), correlationId)
+        continue
+      }
+      if !ok {
+        seconds := int(math.Ceil(retryAfter.Seconds()))
+        if seconds < 1 {
+          seconds = 1
+        }
+        logger.LogInfo(fmt.Sprintf("Rate limit %s: %s %s rejected; retry after %ds.", r, req.Method, req.URL.Path,
+          seconds), correlationId)
+        metrics.RateLimited.Inc(r.String())
+        res.Header().Set("Retry-After", strconv.Itoa(seconds))
+        l.reject(res, req)
+        return
+      }
+    }
+    f(res, req)
+  }
+}
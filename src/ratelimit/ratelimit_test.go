@@ -0,0 +1,221 @@
+// Testing the functions in ratelimit.go and memory.go.
+package ratelimit
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Rules"
+***/
+
+import (
+  "context"
+  "errors"
+  "finance/authz"
+  "finance/security"
+  "fmt"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+  "time"
+)
+
+var testKeys = map[string]KeyFunc{
+  "ip": func(req *http.Request) string { return req.RemoteAddr },
+  "user": func(req *http.Request) string { return req.PostFormValue("uname") },
+}
+
+func TestParseRules(t *testing.T) {
+  t.Parallel()
+  rules, err := ParseRules("POST /verify_login user=5/m, /storage/s3/ ip=2/s,, ", testKeys)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(rules) != 2 || rules[0].String() != "POST /verify_login user" || rules[1].String() != "/storage/s3/ ip" {
+    t.Fatalf("ParseRules = %v", rules)
+  }
+  if l := rules[0].Limit; l.Burst != 5 || l.Rate != 5.0 / 60 {
+    t.Errorf("limit %+v; want a burst of 5 and 5 per minute", l)
+  }
+  for _, bad := range []string{ "/login", "verify_login ip=5/m", "/login host=5/m", "/login ip=0/m", "/login ip=5/d",
+                                "GET /login ip=5/m extra" } {
+    if _, err := ParseRules(bad, testKeys); err == nil {
+      t.Errorf("ParseRules(%q) did not fail", bad)
+    }
+  }
+  //Every problem is reported.
+  if _, err = ParseRules("/a ip=x/m, /b host=1/s", testKeys); err == nil || !strings.Contains(err.Error(), "/a") ||
+     !strings.Contains(err.Error(), "/b") {
+    t.Errorf("ParseRules error %v; want both rules", err)
+  }
+}
+
+func TestMemoryStore(t *testing.T) {
+  t.Parallel()
+  s := NewMemoryStore()
+  limit := Limit{ Rate: 1, Burst: 3 }
+  ctx := context.Background()
+  now := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+  tests := []struct {
+    after time.Duration
+    ok bool
+    retryAfter time.Duration
+  } {
+    { 0, true, 0 },
+    { 0, true, 0 },
+    { 0, true, 0 },
+    { 0, false, time.Second },  //The burst is spent.
+    { 500 * time.Millisecond, false, 500 * time.Millisecond },
+    { 500 * time.Millisecond, true, 0 },  //A token per second.
+    { 0, false, time.Second },
+  }
+  for i, tc := range tests {
+    now = now.Add(tc.after)
+    ok, retryAfter, err := s.Take(ctx, "k", limit, now)
+    if err != nil || ok != tc.ok || retryAfter != tc.retryAfter {
+      t.Errorf("request %d: Take = %v, %v, %v; want %v, %v", i, ok, retryAfter, err, tc.ok, tc.retryAfter)
+    }
+  }
+  //The other keys have their own buckets.
+  if ok, _, _ := s.Take(ctx, "other", limit, now); !ok {
+    t.Error("the bucket of another key is empty")
+  }
+  //The buckets that are full again are removed.
+  now = now.Add(time.Minute)
+  s.Take(ctx, "new", limit, now)
+  if n := s.len(); n != 1 {
+    t.Errorf("%d buckets after the sweep; want 1", n)
+  }
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(ctx context.Context, key string, limit Limit, now time.Time) (bool, time.Duration, error) {
+  return true, 0, errors.New("connection refused")
+}
+
+func TestMiddleware(t *testing.T) {
+  t.Parallel()
+  rules, err := ParseRules("POST /verify_login ip=3/m, POST /verify_login user=2/m", testKeys)
+  if err != nil {
+    t.Fatal(err)
+  }
+  reject := func(res http.ResponseWriter, req *http.Request) {
+    http.Error(res, "slow down", http.StatusTooManyRequests)
+  }
+  h := New(rules, NewMemoryStore(), reject).Middleware(func(res http.ResponseWriter, req *http.Request) {})
+  login := func(method, ip, user string) *httptest.ResponseRecorder {
+    req := httptest.NewRequest(method, "/verify_login", strings.NewReader("uname=" + user))
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    req.RemoteAddr = ip
+    res := httptest.NewRecorder()
+    h(res, req)
+    return res
+  }
+  tests := []struct {
+    method string
+    ip string
+    user string
+    status int
+  } {
+    { http.MethodPost, "10.0.0.1", "alice", http.StatusOK },
+    { http.MethodPost, "10.0.0.2", "alice", http.StatusOK },
+    { http.MethodPost, "10.0.0.3", "alice", http.StatusTooManyRequests },  //The username is limited.
+    { http.MethodPost, "10.0.0.1", "bob", http.StatusOK },
+    { http.MethodPost, "10.0.0.1", "carol", http.StatusOK },
+    { http.MethodPost, "10.0.0.1", "dave", http.StatusTooManyRequests },  //The client IP is limited.
+    { http.MethodPost, "10.0.0.1", "", http.StatusTooManyRequests },
+    { http.MethodGet, "10.0.0.1", "alice", http.StatusOK },  //The rules are for POST.
+  }
+  for i, tc := range tests {
+    res := login(tc.method, tc.ip, tc.user)
+    if res.Code != tc.status {
+      t.Errorf("request %d (%s %s %s): status %d; want %d", i, tc.method, tc.ip, tc.user, res.Code, tc.status)
+    }
+    if retry := res.Header().Get("Retry-After"); (res.Code == http.StatusTooManyRequests) != (retry != "") {
+      t.Errorf("request %d: Retry-After %q with status %d", i, retry, res.Code)
+    } else if retry != "" && retry != "20" && retry != "30" {
+      t.Errorf("request %d: Retry-After %q; want the seconds until the next token", i, retry)
+    }
+  }
+  //The requests are allowed if the store fails.
+  h = New(rules, failingStore{}, reject).Middleware(func(res http.ResponseWriter, req *http.Request) {})
+  if res := login(http.MethodPost, "10.0.0.1", "dave"); res.Code != http.StatusOK {
+    t.Errorf("status %d with a failing store; want 200", res.Code)
+  }
+}
+
+//A client that sends its own X-Forwarded-For through the proxy does not get a new bucket.
+//It sets TRUST_PROXY, so it cannot run in parallel.
+func TestMiddlewareForwardedFor(t *testing.T) {
+  t.Setenv("TRUST_PROXY", "true")
+  t.Setenv("TRUSTED_PROXIES", "1")
+  rules, err := ParseRules("POST /verify_login ip=2/m", map[string]KeyFunc{ "ip": authz.ClientIp })
+  if err != nil {
+    t.Fatal(err)
+  }
+  reject := func(res http.ResponseWriter, req *http.Request) {
+    http.Error(res, "slow down", http.StatusTooManyRequests)
+  }
+  h := New(rules, NewMemoryStore(), reject).Middleware(func(res http.ResponseWriter, req *http.Request) {})
+  for i, forged := range []string{ "198.51.100.1", "198.51.100.2", "198.51.100.3", "" } {
+    req := httptest.NewRequest(http.MethodPost, "/verify_login", nil)
+    req.RemoteAddr = "10.0.0.7:51234"  //The proxy.
+    if forged != "" {
+      req.Header.Add("X-Forwarded-For", forged)
+    }
+    req.Header.Add("X-Forwarded-For", "203.0.113.9")  //Appended by the proxy.
+    res := httptest.NewRecorder()
+    h(res, req)
+    want := http.StatusOK
+    if i >= 2 {  //The bucket of 203.0.113.9 is empty.
+      want = http.StatusTooManyRequests
+    }
+    if res.Code != want {
+      t.Errorf("request %d (forged %q): status %d; want %d", i, forged, res.Code, want)
+    }
+  }
+}
+
+//The pages renew the session token on every response; the session keeps its bucket.
+func TestMiddlewareRenewedSession(t *testing.T) {
+  t.Parallel()
+  rules, err := ParseRules("POST /fin/ session=2/m", map[string]KeyFunc{ "session": authz.SessionKey })
+  if err != nil {
+    t.Fatal(err)
+  }
+  reject := func(res http.ResponseWriter, req *http.Request) {
+    http.Error(res, "slow down", http.StatusTooManyRequests)
+  }
+  h := New(rules, NewMemoryStore(), reject).Middleware(func(res http.ResponseWriter, req *http.Request) {})
+  post := func(token string) int {
+    req := httptest.NewRequest(http.MethodPost, "/fin/mortgage", nil)
+    req.AddCookie(&http.Cookie{ Name: "session_token", Value: token })
+    res := httptest.NewRecorder()
+    h(res, req)
+    return res.Code
+  }
+  security.SessionStarted("rl-renewed-0")
+  for i := 0; i < 4; i++ {
+    token := fmt.Sprintf("rl-renewed-%d", i)
+    want := http.StatusOK
+    if i >= 2 {  //The bucket of the session is empty.
+      want = http.StatusTooManyRequests
+    }
+    if status := post(token); status != want {
+      t.Errorf("request %d (token %s): status %d; want %d", i, token, status, want)
+    }
+    security.SessionRenewed(token, fmt.Sprintf("rl-renewed-%d", i + 1))
+  }
+  //Another session has a bucket of its own.
+  security.SessionStarted("rl-other")
+  if status := post("rl-other"); status != http.StatusOK {
+    t.Errorf("another session: status %d; want 200", status)
+  }
+}
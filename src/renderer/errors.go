@@ -0,0 +1,160 @@
+package renderer
+
+/***
+The error pages (404, 405, 500, ...). They use the layout without headers, so they render with or
+without a session, and they show the correlation ID of the request; a user who reports the error
+can quote it, and it finds the entries of the request in the log.
+
+A page handler returns an error instead of panicking: Page adapts it to an http.HandlerFunc that logs
+the error and renders the page of its status (PageError), or 500 for any other error. Recover turns
+a panic that escapes a handler into the 500 page and logs the stack.
+***/
+
+import (
+  "errors"
+  "fmt"
+  "net/http"
+  "runtime/debug"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+//An error with the HTTP status code of the page to render; the message is logged, not shown.
+type StatusError struct {
+  Status int
+  Msg string
+}
+
+func (e *StatusError) Error() string {
+  return e.Msg
+}
+
+func PageError(status int, format string, args ...any) error {
+  return &StatusError{ Status: status, Msg: fmt.Sprintf(format, args...) }
+}
+
+//The messages shown to the user; the statuses not listed get the message of 500.
+var errorMessages = map[int]string{
+  http.StatusBadRequest: "The request is not valid.",
+  http.StatusNotFound: "The page you requested does not exist.",
+  http.StatusMethodNotAllowed: "The page does not accept this kind of request.",
+  http.StatusTooManyRequests: "Too many requests. Wait a moment and try again.",
+  http.StatusInternalServerError: "Something went wrong on our side. Try again; if it keeps happening, contact us and " +
+    "quote the reference below.",
+}
+
+func correlationIdOf(req *http.Request) string {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  return correlationId
+}
+
+func ErrorPage(res http.ResponseWriter, status int, correlationId string) {
+  msg, ok := errorMessages[status]
+  if !ok {
+    status = http.StatusInternalServerError
+    msg = errorMessages[status]
+  }
+  templatesNeeded := []string{
+    "webfinances/templates/layout.html",
+    "webfinances/templates/error.html",
+  }
+  RenderStatus(res, status, "layout", templatesNeeded, PageData{
+    Data: struct{
+      LayoutType string
+      Header string
+      Status int
+      Message string
+      Reference string
+    } { "std-wo-headers", http.StatusText(status), status, msg, correlationId },
+  })
+}
+
+func NotFoundPage(res http.ResponseWriter, req *http.Request) {
+  correlationId := correlationIdOf(req)
+  logger.LogInfo(fmt.Sprintf("Not found: %s %s", req.Method, req.URL.Path), correlationId)
+  ErrorPage(res, http.StatusNotFound, correlationId)
+}
+
+//The router sets the Allow header before calling it.
+func MethodNotAllowedPage(res http.ResponseWriter, req *http.Request) {
+  correlationId := correlationIdOf(req)
+  logger.LogInfo(fmt.Sprintf("Method not allowed: %s %s", req.Method, req.URL.Path), correlationId)
+  ErrorPage(res, http.StatusMethodNotAllowed, correlationId)
+}
+
+//The rate limiter rejected the request and set the Retry-After header; it logged the rejection.
+func TooManyRequestsPage(res http.ResponseWriter, req *http.Request) {
+  ErrorPage(res, http.StatusTooManyRequests, correlationIdOf(req))
+}
+
+//A page handler that returns an error instead of writing the error page.
+type PageFunc func(http.ResponseWriter, *http.Request) error
+
+//The handler must return the error before it writes the response.
+func Page(f PageFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    err := f(res, req)
+    if err == nil {
+      return
+    }
+    correlationId := correlationIdOf(req)
+    status := http.StatusInternalServerError
+    var se *StatusError
+    if errors.As(err, &se) {
+      status = se.Status
+    }
+    logger.LogError(fmt.Sprintf("%s %s: %v", req.Method, req.URL.Path, err), correlationId)
+    if status == http.StatusMethodNotAllowed {
+      res.Header().Set("Allow", "GET, HEAD, POST")
+    }
+    ErrorPage(res, status, correlationId)
+  }
+}
+
+//It records whether the response was started; if so, the error page cannot be written.
+type trackingWriter struct {
+  http.ResponseWriter
+  written bool
+}
+
+func (w *trackingWriter) WriteHeader(status int) {
+  w.written = true
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *trackingWriter) Write(b []byte) (int, error) {
+  w.written = true
+  return w.ResponseWriter.Write(b)
+}
+
+//For http.ResponseController; e.g., to flush.
+func (w *trackingWriter) Unwrap() http.ResponseWriter {
+  return w.ResponseWriter
+}
+
+/***
+Recover from a panic in the handler: log it with the stack and render the 500 page, unless the
+response was started. http.ErrAbortHandler is panicked again; the server aborts the response
+without logging it.
+***/
+func Recover(f http.HandlerFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    tw := &trackingWriter{ ResponseWriter: res }
+    defer func() {
+      rec := recover()
+      if rec == nil {
+        return
+      } else if rec == http.ErrAbortHandler {
+        panic(rec)
+      }
+      correlationId := correlationIdOf(req)
+      logger.LogError(fmt.Sprintf("Panic serving %s %s: %v\n%s", req.Method, req.URL.Path, rec, debug.Stack()),
+        correlationId)
+      if !tw.written {
+        ErrorPage(res, http.StatusInternalServerError, correlationId)
+      }
+    }()
+    f(tw, req)
+  }
+}
@@ -0,0 +1,92 @@
+// Testing the functions in errors.go.
+package renderer
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Recover"
+***/
+
+import (
+  "errors"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "testing"
+)
+
+func TestRecover(t *testing.T) {
+  t.Parallel()
+  res := httptest.NewRecorder()
+  Recover(func(res http.ResponseWriter, req *http.Request) {
+    panic("boom")
+  })(res, httptest.NewRequest(http.MethodGet, "/fin/bonds", nil))
+  if res.Code != http.StatusInternalServerError {
+    t.Errorf("status = %d; want %d", res.Code, http.StatusInternalServerError)
+  }
+  //The response was started before the panic; nothing is appended to it.
+  res = httptest.NewRecorder()
+  Recover(func(res http.ResponseWriter, req *http.Request) {
+    io.WriteString(res, "partial")
+    panic("boom")
+  })(res, httptest.NewRequest(http.MethodGet, "/fin/bonds", nil))
+  if res.Code != http.StatusOK || res.Body.String() != "partial" {
+    t.Errorf("got %d %q; want 200 \"partial\"", res.Code, res.Body.String())
+  }
+}
+
+func TestRecoverAbortHandler(t *testing.T) {
+  t.Parallel()
+  defer func() {
+    if rec := recover(); rec != http.ErrAbortHandler {
+      t.Errorf("recovered %v; want http.ErrAbortHandler", rec)
+    }
+  }()
+  Recover(func(res http.ResponseWriter, req *http.Request) {
+    panic(http.ErrAbortHandler)
+  })(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestPage(t *testing.T) {
+  t.Parallel()
+  tests := []struct {
+    err error
+    allow string
+  } {
+    { nil, "" },
+    { PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", "PUT"), "GET, HEAD, POST" },
+    { errors.New("database down"), "" },
+  }
+  for _, tc := range tests {
+    res := httptest.NewRecorder()
+    Page(func(res http.ResponseWriter, req *http.Request) error {
+      if tc.err == nil {
+        io.WriteString(res, "page")
+      }
+      return tc.err
+    })(res, httptest.NewRequest(http.MethodGet, "/fin/bonds", nil))
+    if tc.err == nil {
+      if res.Code != http.StatusOK || res.Body.String() != "page" {
+        t.Errorf("got %d %q; want 200 \"page\"", res.Code, res.Body.String())
+      }
+      continue
+    }
+    //The templates are not loaded in the tests, so every error page is answered with 500.
+    if res.Code < http.StatusBadRequest {
+      t.Errorf("%v: status = %d; want an error status", tc.err, res.Code)
+    }
+    if strings.Contains(res.Body.String(), tc.err.Error()) {
+      t.Errorf("%v: the error is shown to the user", tc.err)
+    }
+    if allow := res.Header().Get("Allow"); allow != tc.allow {
+      t.Errorf("%v: Allow = %q; want %q", tc.err, allow, tc.allow)
+    }
+  }
+}
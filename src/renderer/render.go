@@ -8,7 +8,10 @@ import (
   provides the same interface as text/template and should be used instead of text/template whenever the output is HTML.
   ***/
   "html/template"
+  "finance/metrics"
+  "finance/tracing"
   "net/http"
+  "strings"
 )
 
 var GlobalTemplateFS embed.FS
@@ -25,6 +28,7 @@ var funcMap = template.FuncMap{
     case "2": return "H - N"
     case "3": return "O - T"
     case "4": return "U - Z"
+    case "5": return "Other"
     default: return "A - G"
     }
   },
@@ -36,9 +40,18 @@ func InitTemplates(templateFS embed.FS) {
 }
 
 func Render(res http.ResponseWriter, layoutName string, templatePaths []string, data PageData) {
+  RenderStatus(res, http.StatusOK, layoutName, templatePaths, data)
+}
+
+//Render the page with the HTTP status code; e.g., the error pages.
+func RenderStatus(res http.ResponseWriter, status int, layoutName string, templatePaths []string, data PageData) {
+  _, span := tracing.StartChild(tracing.WriterContext(res), "render " + pageTemplate(templatePaths), tracing.KindInternal)
+  defer span.End()
   //Create an empty template root, attach functions, then parse the FS files
   tmpl, err := template.New(layoutName).Funcs(funcMap).ParseFS(GlobalTemplateFS, templatePaths...)
   if err != nil {
+    metrics.TemplateErrors.Inc(pageTemplate(templatePaths), "parse")
+    span.RecordError(err)
     http.Error(res, "Template parsing failure: " + err.Error(), http.StatusInternalServerError)
     return
   }
@@ -46,9 +59,27 @@ func Render(res http.ResponseWriter, layoutName string, templatePaths []string,
   var buf bytes.Buffer
   err = tmpl.ExecuteTemplate(&buf, layoutName, data)
   if err != nil {
+    metrics.TemplateErrors.Inc(pageTemplate(templatePaths), "execute")
+    span.RecordError(err)
     http.Error(res, "Template rendering error: " + err.Error(), http.StatusInternalServerError)
     return
   }
   res.Header().Set("Content-Type", "text/html; charset=utf-8")
+  res.WriteHeader(status)
   buf.WriteTo(res)
 }
+
+//The template of the page, which follows the layout in the templates of a page; the label of the metrics.
+func pageTemplate(templatePaths []string) string {
+  if len(templatePaths) == 0 {
+    return ""
+  }
+  return strings.TrimPrefix(templatePaths[min(1, len(templatePaths) - 1)], "webfinances/templates/")
+}
+
+//Parse the layout and the error page; the readiness probe fails if the templates cannot be read.
+func CheckTemplates() error {
+  _, err := template.New("layout").Funcs(funcMap).ParseFS(GlobalTemplateFS, "webfinances/templates/layout.html",
+    "webfinances/templates/error.html")
+  return err
+}
@@ -0,0 +1,141 @@
+package router
+
+/***
+Routes requests on the method and the path pattern. The patterns are those of http.ServeMux (Go 1.22
+and later):
+(1) "/login" matches only that path; "/debug/pprof/" (trailing slash) matches the path and all the
+    paths below it; "/{$}" matches only the root.
+(2) "/banking/accounts/{id}" matches any single segment in place of {id}; the handler reads it with
+    req.PathValue("id").
+(3) A route registered for GET also answers HEAD; the handler sees a GET, and the server drops the
+    body of the response.
+(4) If the path matches but the method does not, the answer is 405 (Method Not Allowed) with an
+    Allow header that lists the methods of the path; if no route matches, the answer is 404. The
+    pages of both can be set with Group.NotFound and Group.MethodNotAllowed.
+
+The routes are registered in groups; each group has its own middleware stack, which wraps only the
+routes of the group. A nested group runs inside the stack of its parent.
+***/
+
+import (
+  "net/http"
+  "slices"
+  "strings"
+  "github.com/juan-carlos-trimino/go-middlewares"
+)
+
+type Router struct {
+  mux *http.ServeMux
+  methods []string  //The methods of the routes; they make the Allow header of 405.
+  notFound http.HandlerFunc
+  methodNotAllowed http.HandlerFunc
+}
+
+func New() *Router {
+  return &Router{ mux: http.NewServeMux() }
+}
+
+func (r *Router) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+  //An empty pattern means that no route matched; the redirects to the clean path have a pattern.
+  if _, pattern := r.mux.Handler(req); pattern == "" && (r.notFound != nil || r.methodNotAllowed != nil) {
+    if allow := r.allowed(req); len(allow) == 0 {
+      if r.notFound != nil {
+        r.notFound(res, req)
+        return
+      }
+    } else if r.methodNotAllowed != nil {
+      res.Header().Set("Allow", strings.Join(allow, ", "))
+      r.methodNotAllowed(res, req)
+      return
+    }
+  }
+  r.mux.ServeHTTP(res, req)
+}
+
+//The methods with a route for the path of the request.
+func (r *Router) allowed(req *http.Request) []string {
+  var allow []string
+  probe := req.Clone(req.Context())
+  for _, m := range r.methods {
+    probe.Method = m
+    if _, pattern := r.mux.Handler(probe); pattern != "" {
+      allow = append(allow, m)
+    }
+  }
+  return allow
+}
+
+//The routes that share a middleware stack.
+type Group struct {
+  router *Router
+  parent *Group
+  stack []middlewares.Middleware
+}
+
+//A group at the top level; the stack is chained as by middlewares.ChainMiddlewares.
+func (r *Router) Group(stack ...middlewares.Middleware) *Group {
+  return &Group{ router: r, stack: stack }
+}
+
+//A group whose stack runs inside the stack of g.
+func (g *Group) Group(stack ...middlewares.Middleware) *Group {
+  return &Group{ router: g.router, parent: g, stack: stack }
+}
+
+func (g *Group) wrap(h http.HandlerFunc) http.HandlerFunc {
+  for ; g != nil; g = g.parent {
+    if len(g.stack) > 0 {
+      h = middlewares.ChainMiddlewares(h, g.stack)
+    }
+  }
+  return h
+}
+
+/***
+Register the handler for the path pattern and the methods; without methods, the route accepts any
+method. It panics if the pattern is invalid or conflicts with a registered route, which is a
+programming error found at startup.
+***/
+func (g *Group) Handle(pattern string, h http.HandlerFunc, methods ...string) {
+  h = g.wrap(h)
+  if len(methods) == 0 {
+    g.router.mux.HandleFunc(pattern, h)
+    return
+  }
+  for _, m := range methods {
+    g.router.addMethod(m)
+    if m == http.MethodGet {
+      g.router.addMethod(http.MethodHead)
+      g.router.mux.HandleFunc(m + " " + pattern, headAsGet(h))
+    } else {
+      g.router.mux.HandleFunc(m + " " + pattern, h)
+    }
+  }
+}
+
+//The page handlers accept only GET and POST.
+func headAsGet(h http.HandlerFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    if req.Method == http.MethodHead {
+      req = req.Clone(req.Context())
+      req.Method = http.MethodGet
+    }
+    h(res, req)
+  }
+}
+
+func (r *Router) addMethod(m string) {
+  if i, found := slices.BinarySearch(r.methods, m); !found {
+    r.methods = slices.Insert(r.methods, i, m)
+  }
+}
+
+//The page for the requests that match no route; it runs inside the stack of g.
+func (g *Group) NotFound(h http.HandlerFunc) {
+  g.router.notFound = g.wrap(h)
+}
+
+//The page for the requests whose path has routes, but not for the method; the Allow header is set.
+func (g *Group) MethodNotAllowed(h http.HandlerFunc) {
+  g.router.methodNotAllowed = g.wrap(h)
+}
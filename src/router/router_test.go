@@ -0,0 +1,133 @@
+// Testing the functions in router.go.
+package router
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Router"
+***/
+
+import (
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "testing"
+)
+
+func TestRouter(t *testing.T) {
+  t.Parallel()
+  r := New()
+  g := r.Group()
+  reply := func(body string) http.HandlerFunc {
+    return func(res http.ResponseWriter, req *http.Request) {
+      io.WriteString(res, body)
+    }
+  }
+  g.Handle("/{$}", reply("index"), http.MethodGet)
+  g.Handle("/login", func(res http.ResponseWriter, req *http.Request) {
+    if req.Method != http.MethodGet && req.Method != http.MethodPost {
+      t.Errorf("the handler got %s; want GET or POST", req.Method)
+    }
+    io.WriteString(res, "login")
+  }, http.MethodGet, http.MethodPost)
+  g.Handle("/verify_login", reply("verify"), http.MethodPost)
+  g.Handle("/liveness", reply("alive"))
+  g.Group().Handle("/banking/accounts/{id}", func(res http.ResponseWriter, req *http.Request) {
+    io.WriteString(res, "account " + req.PathValue("id"))
+  }, http.MethodGet)
+  tests := []struct {
+    method string
+    path string
+    status int
+    body string
+    allow string
+  } {
+    { http.MethodGet, "/", http.StatusOK, "index", "" },
+    { http.MethodGet, "/login", http.StatusOK, "login", "" },
+    { http.MethodPost, "/login", http.StatusOK, "login", "" },
+    { http.MethodHead, "/login", http.StatusOK, "", "" },
+    { http.MethodPost, "/verify_login", http.StatusOK, "verify", "" },
+    { http.MethodGet, "/verify_login", http.StatusMethodNotAllowed, "", "POST" },
+    { http.MethodDelete, "/login", http.StatusMethodNotAllowed, "", "GET, HEAD, POST" },
+    { http.MethodPut, "/liveness", http.StatusOK, "alive", "" },
+    { http.MethodGet, "/banking/accounts/42", http.StatusOK, "account 42", "" },
+    { http.MethodGet, "/banking/accounts/42/x", http.StatusNotFound, "", "" },
+    { http.MethodGet, "/unknown", http.StatusNotFound, "", "" },
+    { http.MethodGet, "/login/", http.StatusNotFound, "", "" },
+  }
+  for _, tc := range tests {
+    res := httptest.NewRecorder()
+    r.ServeHTTP(res, httptest.NewRequest(tc.method, tc.path, nil))
+    if res.Code != tc.status {
+      t.Errorf("%s %s: status = %d; want %d", tc.method, tc.path, res.Code, tc.status)
+      continue
+    }
+    if tc.status == http.StatusOK && tc.method != http.MethodHead && res.Body.String() != tc.body {
+      t.Errorf("%s %s: body = %q; want %q", tc.method, tc.path, res.Body.String(), tc.body)
+    }
+    if allow := res.Header().Get("Allow"); allow != tc.allow {
+      t.Errorf("%s %s: Allow = %q; want %q", tc.method, tc.path, allow, tc.allow)
+    }
+  }
+}
+
+func TestFallbacks(t *testing.T) {
+  t.Parallel()
+  r := New()
+  g := r.Group()
+  g.Handle("/login", func(res http.ResponseWriter, req *http.Request) {}, http.MethodGet)
+  g.Handle("/verify_login", func(res http.ResponseWriter, req *http.Request) {}, http.MethodPost)
+  g.NotFound(func(res http.ResponseWriter, req *http.Request) {
+    res.WriteHeader(http.StatusNotFound)
+    io.WriteString(res, "custom 404")
+  })
+  g.MethodNotAllowed(func(res http.ResponseWriter, req *http.Request) {
+    res.WriteHeader(http.StatusMethodNotAllowed)
+    io.WriteString(res, "custom 405")
+  })
+  tests := []struct {
+    method string
+    path string
+    status int
+    body string
+    allow string
+  } {
+    { http.MethodGet, "/unknown", http.StatusNotFound, "custom 404", "" },
+    { http.MethodPost, "/login", http.StatusMethodNotAllowed, "custom 405", "GET, HEAD" },
+    { http.MethodGet, "/verify_login", http.StatusMethodNotAllowed, "custom 405", "POST" },
+    //The clean path is a redirect, not a 404.
+    { http.MethodGet, "/x/../login", http.StatusTemporaryRedirect, "", "" },
+  }
+  for _, tc := range tests {
+    res := httptest.NewRecorder()
+    req := httptest.NewRequest(tc.method, "/", nil)
+    req.URL.Path = tc.path
+    r.ServeHTTP(res, req)
+    if res.Code != tc.status {
+      t.Errorf("%s %s: status = %d; want %d", tc.method, tc.path, res.Code, tc.status)
+    } else if tc.body != "" && res.Body.String() != tc.body {
+      t.Errorf("%s %s: body = %q; want %q", tc.method, tc.path, res.Body.String(), tc.body)
+    }
+    if allow := res.Header().Get("Allow"); allow != tc.allow {
+      t.Errorf("%s %s: Allow = %q; want %q", tc.method, tc.path, allow, tc.allow)
+    }
+  }
+}
+
+func TestHandleConflict(t *testing.T) {
+  t.Parallel()
+  g := New().Group()
+  g.Handle("/login", func(res http.ResponseWriter, req *http.Request) {}, http.MethodGet)
+  defer func() {
+    if recover() == nil {
+      t.Error("registering GET /login twice did not panic")
+    }
+  }()
+  g.Handle("/login", func(res http.ResponseWriter, req *http.Request) {}, http.MethodGet)
+}
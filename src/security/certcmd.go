@@ -0,0 +1,231 @@
+package security
+
+/***
+The "certs" command issues the certificates of a private PKI and exits; the server is not started.
+(1) "certs [flags]" writes a root CA, an intermediate CA signed by the root, a server certificate
+    and the certificates of the clients (for mutual TLS) signed by the intermediate, and an empty CRL
+    of the intermediate. Every chain is verified before it is written. The chain files (server.crt,
+    client-<name>.crt) hold the certificate and then the intermediate CA, as a TLS server or client
+    sends them; the peers trust root-ca.crt.
+(2) "certs crl -revoke serial,... [flags]" adds the serial numbers (in hexadecimal, as printed by
+    (1)) to the CRL of the intermediate CA in the directory.
+The root key should be moved offline once the intermediate is issued.
+***/
+
+import (
+  "crypto/x509"
+  "encoding/pem"
+  "errors"
+  "flag"
+  "fmt"
+  "io"
+  "io/fs"
+  "math/big"
+  "os"
+  "path/filepath"
+  "strings"
+  "time"
+)
+
+const (
+  rootCAFile = "root-ca"
+  interCAFile = "intermediate-ca"
+  crlFile = "intermediate-ca.crl"
+)
+
+func CertsCommand(args []string, stdout, stderr io.Writer) int {
+  if len(args) > 0 && args[0] == "crl" {
+    return crlCommand(args[1:], stdout, stderr)
+  }
+  flags := flag.NewFlagSet("certs", flag.ContinueOnError)
+  flags.SetOutput(stderr)
+  dir := flags.String("out", "certs", "directory the certificates and keys are written to")
+  keyType := flags.String("key-type", string(KeyECDSA), "type of the keys: rsa, ecdsa (P-256), or ed25519")
+  hosts := flags.String("hosts", "localhost,::1,127.0.0.1", "host names and IP addresses (comma separated) of the server certificate")
+  clients := flags.String("clients", "", "names (comma separated) of the services that get a client certificate")
+  days := flags.Int("days", 90, "days the server and client certificates are valid")
+  caDays := flags.Int("ca-days", 3650, "days the root CA is valid; the intermediate CA is valid half as long")
+  force := flags.Bool("force", false, "overwrite the CAs of the directory")
+  if err := flags.Parse(args); err != nil {
+    return 2
+  } else if flags.NArg() > 0 {
+    fmt.Fprintf(stderr, "unexpected arguments: %s\n", strings.Join(flags.Args(), " "))
+    return 2
+  }
+  kt, err := ParseKeyType(*keyType)
+  if err != nil {
+    fmt.Fprintln(stderr, err)
+    return 2
+  }
+  if *days < 1 || *caDays < 2 || *days > *caDays / 2 {
+    fmt.Fprintln(stderr, "-days and -ca-days must be positive, and -days at most half of -ca-days")
+    return 2
+  }
+  serverHosts := splitList(*hosts)
+  if len(serverHosts) == 0 {
+    fmt.Fprintln(stderr, "-hosts is required")
+    return 2
+  }
+  if err = issue(*dir, kt, serverHosts, splitList(*clients), *days, *caDays, *force, stdout); err != nil {
+    fmt.Fprintln(stderr, err)
+    return 1
+  }
+  return 0
+}
+
+type certFile struct {
+  name string
+  data []byte
+  perm os.FileMode
+  cert *x509.Certificate  //Printed; nil for the keys and the CRL.
+}
+
+func issue(dir string, kt KeyType, hosts, clients []string, days, caDays int, force bool, stdout io.Writer) error {
+  day := 24 * time.Hour
+  //A new root would make the certificates issued by the old one untrusted.
+  if _, err := os.Stat(filepath.Join(dir, rootCAFile + ".key")); err == nil && !force {
+    return fmt.Errorf("%s already has a root CA; use -force to replace it", dir)
+  }
+  if err := os.MkdirAll(dir, 0700); err != nil {
+    return err
+  }
+  rootCert, rootPEM, rootKey := GenRootCA(kt, time.Duration(caDays) * day)
+  interCert, interPEM, interKey := GenIntermediateCA(rootCert, rootKey, kt, time.Duration(caDays / 2) * day)
+  if err := VerifyIntermediateCA(rootCert, interCert); err != nil {
+    return err
+  }
+  serverCert, serverPEM, serverKeyPEM := GenServerCert(interCert, interKey, kt, hosts, time.Duration(days) * day)
+  if err := VerifyCertificateChain(rootCert, interCert, serverCert, x509.ExtKeyUsageServerAuth); err != nil {
+    return err
+  }
+  files := []certFile{
+    { rootCAFile + ".key", pem.EncodeToMemory(KeyToPemBlock(rootKey)), 0600, nil },
+    { rootCAFile + ".crt", rootPEM, 0644, rootCert },
+    { interCAFile + ".key", pem.EncodeToMemory(KeyToPemBlock(interKey)), 0600, nil },
+    { interCAFile + ".crt", interPEM, 0644, interCert },
+    { "server.key", serverKeyPEM, 0600, nil },
+    { "server.crt", append(serverPEM, interPEM...), 0644, serverCert },
+  }
+  for _, name := range clients {
+    clientCert, clientPEM, clientKeyPEM := GenClientCert(interCert, interKey, kt, name, time.Duration(days) * day)
+    if err := VerifyCertificateChain(rootCert, interCert, clientCert, x509.ExtKeyUsageClientAuth); err != nil {
+      return err
+    }
+    files = append(files, []certFile{
+      { "client-" + name + ".key", clientKeyPEM, 0600, nil },
+      { "client-" + name + ".crt", append(clientPEM, interPEM...), 0644, clientCert },
+    }...)
+  }
+  crlPEM, err := GenCRL(interCert, interKey, nil, big.NewInt(1), time.Now().Add(7 * day))
+  if err != nil {
+    return err
+  }
+  files = append(files, certFile{ crlFile, crlPEM, 0644, nil })
+  for _, f := range files {
+    if err = writeFileAtomic(filepath.Join(dir, f.name), f.data, f.perm); err != nil {
+      return err
+    }
+    if f.cert != nil {
+      fmt.Fprintf(stdout, "%s: %s, serial %x, expires %s\n", f.name, f.cert.Subject.CommonName, f.cert.SerialNumber,
+        f.cert.NotAfter.Format(time.RFC3339))
+    } else {
+      fmt.Fprintln(stdout, f.name)
+    }
+  }
+  fmt.Fprintln(stdout, "The certificate chains are valid.")
+  return nil
+}
+
+func crlCommand(args []string, stdout, stderr io.Writer) int {
+  flags := flag.NewFlagSet("certs crl", flag.ContinueOnError)
+  flags.SetOutput(stderr)
+  dir := flags.String("out", "certs", "directory of the intermediate CA")
+  revoke := flags.String("revoke", "", "serial numbers (hexadecimal, comma separated) of the certificates to revoke")
+  days := flags.Int("days", 7, "days until the next CRL is due")
+  if err := flags.Parse(args); err != nil {
+    return 2
+  } else if flags.NArg() > 0 || *days < 1 {
+    fmt.Fprintln(stderr, "usage: certs crl [-out dir] [-revoke serial,...] [-days n]")
+    return 2
+  }
+  var serials []*big.Int
+  for _, s := range splitList(*revoke) {
+    n, ok := new(big.Int).SetString(strings.ReplaceAll(s, ":", ""), 16)
+    if !ok {
+      fmt.Fprintf(stderr, "%q is not a hexadecimal serial number\n", s)
+      return 2
+    }
+    serials = append(serials, n)
+  }
+  count, err := updateCRL(*dir, serials, time.Duration(*days) * 24 * time.Hour)
+  if err != nil {
+    fmt.Fprintln(stderr, err)
+    return 1
+  }
+  fmt.Fprintf(stdout, "%s: %d revoked certificates\n", crlFile, count)
+  return 0
+}
+
+//The revoked certificates of the current CRL are kept; its number is incremented.
+func updateCRL(dir string, serials []*big.Int, nextUpdate time.Duration) (int, error) {
+  certPEM, err := os.ReadFile(filepath.Join(dir, interCAFile + ".crt"))
+  if err != nil {
+    return 0, err
+  }
+  caCert, err := ParseCertificatePEM(certPEM)
+  if err != nil {
+    return 0, err
+  }
+  keyPEM, err := os.ReadFile(filepath.Join(dir, interCAFile + ".key"))
+  if err != nil {
+    return 0, err
+  }
+  caKey, err := ParsePrivateKeyPEM(keyPEM)
+  if err != nil {
+    return 0, err
+  }
+  number := big.NewInt(1)
+  var entries []x509.RevocationListEntry
+  if data, err := os.ReadFile(filepath.Join(dir, crlFile)); err == nil {
+    block, _ := pem.Decode(data)
+    if block == nil {
+      return 0, fmt.Errorf("%s is not a PEM CRL", crlFile)
+    }
+    crl, err := x509.ParseRevocationList(block.Bytes)
+    if err != nil {
+      return 0, err
+    }
+    if err = crl.CheckSignatureFrom(caCert); err != nil {
+      return 0, fmt.Errorf("%s was not signed by the intermediate CA: %w", crlFile, err)
+    }
+    entries = crl.RevokedCertificateEntries
+    number.Add(crl.Number, number)
+  } else if !errors.Is(err, fs.ErrNotExist) {
+    return 0, err
+  }
+  now := time.Now()
+  for _, serial := range serials {
+    revoked := false
+    for _, e := range entries {
+      revoked = revoked || e.SerialNumber.Cmp(serial) == 0
+    }
+    if !revoked {
+      entries = append(entries, x509.RevocationListEntry{ SerialNumber: serial, RevocationTime: now })
+    }
+  }
+  crlPEM, err := GenCRL(caCert, caKey, entries, number, now.Add(nextUpdate))
+  if err != nil {
+    return 0, err
+  }
+  return len(entries), writeFileAtomic(filepath.Join(dir, crlFile), crlPEM, 0644)
+}
+
+func splitList(s string) []string {
+  var items []string
+  for _, item := range strings.Split(s, ",") {
+    if item = strings.TrimSpace(item); item != "" {
+      items = append(items, item)
+    }
+  }
+  return items
+}
@@ -0,0 +1,164 @@
+// Testing the functions in security.go, keys.go, and certcmd.go.
+package security
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Certs"
+***/
+
+import (
+  "bytes"
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/pem"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestKeyTypes(t *testing.T) {
+  t.Parallel()
+  for _, kt := range []KeyType{ KeyRSA, KeyECDSA, KeyEd25519 } {
+    rootCert, _, rootKey := GenRootCA(kt, 24 * time.Hour)
+    interCert, _, interKey := GenIntermediateCA(rootCert, rootKey, kt, 12 * time.Hour)
+    if err := VerifyIntermediateCA(rootCert, interCert); err != nil {
+      t.Errorf("%s: %v", kt, err)
+    }
+    serverCert, serverPEM, serverKeyPEM := GenServerCert(interCert, interKey, kt, []string{ "localhost" }, time.Hour)
+    clientCert, _, clientKeyPEM := GenClientCert(interCert, interKey, kt, "finance-scheduler", time.Hour)
+    if err := VerifyCertificateChain(rootCert, interCert, serverCert, x509.ExtKeyUsageServerAuth); err != nil {
+      t.Errorf("%s: %v", kt, err)
+    }
+    if err := VerifyCertificateChain(rootCert, interCert, clientCert, x509.ExtKeyUsageClientAuth); err != nil {
+      t.Errorf("%s: %v", kt, err)
+    }
+    if err := VerifyCertificateChain(rootCert, interCert, clientCert, x509.ExtKeyUsageServerAuth); err == nil {
+      t.Errorf("%s: a client certificate verified as a server certificate", kt)
+    }
+    //Only an RSA key is used to encrypt the key exchange.
+    if enc := serverCert.KeyUsage & x509.KeyUsageKeyEncipherment != 0; enc != (kt == KeyRSA) {
+      t.Errorf("%s: key encipherment %v", kt, enc)
+    }
+    if _, err := tls.X509KeyPair(serverPEM, serverKeyPEM); err != nil {
+      t.Errorf("%s: the server key pair: %v", kt, err)
+    }
+    key, err := ParsePrivateKeyPEM(clientKeyPEM)
+    if err != nil {
+      t.Fatalf("%s: ParsePrivateKeyPEM: %v", kt, err)
+    }
+    if reencoded := pem.EncodeToMemory(KeyToPemBlock(key)); !bytes.Equal(reencoded, clientKeyPEM) {
+      t.Errorf("%s: the key changed after a round trip", kt)
+    }
+  }
+  if _, err := ParseKeyType("dsa"); err == nil {
+    t.Error(`ParseKeyType("dsa") did not fail`)
+  }
+}
+
+func readCert(t *testing.T, path string) *x509.Certificate {
+  t.Helper()
+  data, err := os.ReadFile(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  cert, err := ParseCertificatePEM(data)
+  if err != nil {
+    t.Fatalf("%s: %v", path, err)
+  }
+  return cert
+}
+
+func readCRL(t *testing.T, dir string) *x509.RevocationList {
+  t.Helper()
+  data, err := os.ReadFile(filepath.Join(dir, crlFile))
+  if err != nil {
+    t.Fatal(err)
+  }
+  block, _ := pem.Decode(data)
+  if block == nil {
+    t.Fatal("the CRL is not PEM")
+  }
+  crl, err := x509.ParseRevocationList(block.Bytes)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return crl
+}
+
+func TestCertsCommand(t *testing.T) {
+  t.Parallel()
+  dir := filepath.Join(t.TempDir(), "pki")
+  var stdout bytes.Buffer
+  args := []string{ "-out", dir, "-key-type", "ed25519", "-hosts", "finance.local,10.0.0.7", "-clients", "scheduler" }
+  if code := CertsCommand(args, &stdout, io.Discard); code != 0 {
+    t.Fatalf("CertsCommand(%q) = %d", args, code)
+  }
+  root := readCert(t, filepath.Join(dir, "root-ca.crt"))
+  inter := readCert(t, filepath.Join(dir, "intermediate-ca.crt"))
+  //The chain file holds the certificate and then the intermediate CA.
+  pair, err := tls.LoadX509KeyPair(filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(pair.Certificate) != 2 || !bytes.Equal(pair.Certificate[1], inter.Raw) {
+    t.Errorf("server.crt has %d certificates; want the server and the intermediate CA", len(pair.Certificate))
+  }
+  if err = VerifyCertificateChain(root, inter, pair.Leaf, x509.ExtKeyUsageServerAuth); err != nil {
+    t.Error(err)
+  }
+  if pair.Leaf.DNSNames[0] != "finance.local" || pair.Leaf.IPAddresses[0].String() != "10.0.0.7" {
+    t.Errorf("SANs %v %v; want the hosts", pair.Leaf.DNSNames, pair.Leaf.IPAddresses)
+  }
+  client := readCert(t, filepath.Join(dir, "client-scheduler.crt"))
+  if err = VerifyCertificateChain(root, inter, client, x509.ExtKeyUsageClientAuth); err != nil {
+    t.Error(err)
+  }
+  if fi, err := os.Stat(filepath.Join(dir, "root-ca.key")); err != nil || fi.Mode().Perm() != 0600 {
+    t.Errorf("root-ca.key: %v, %v; want mode 0600", fi, err)
+  }
+  if crl := readCRL(t, dir); len(crl.RevokedCertificateEntries) != 0 || crl.CheckSignatureFrom(inter) != nil {
+    t.Error("the first CRL is not an empty CRL of the intermediate CA")
+  }
+  //The CAs are not replaced by accident.
+  if code := CertsCommand([]string{ "-out", dir }, io.Discard, io.Discard); code != 1 {
+    t.Errorf("CertsCommand over a root CA = %d; want 1", code)
+  }
+  //Revoke the client twice; it is listed once, and every CRL has a new number.
+  serial := fmt.Sprintf("%x", client.SerialNumber)
+  for i := 0; i < 2; i++ {
+    stdout.Reset()
+    if code := CertsCommand([]string{ "crl", "-out", dir, "-revoke", serial }, &stdout, io.Discard); code != 0 {
+      t.Fatalf("certs crl = %d", code)
+    }
+  }
+  crl := readCRL(t, dir)
+  if len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Cmp(client.SerialNumber) != 0 {
+    t.Errorf("revoked %v; want the client certificate", crl.RevokedCertificateEntries)
+  }
+  if crl.Number.Int64() != 3 || !strings.Contains(stdout.String(), "1 revoked") {
+    t.Errorf("CRL number %v, output %q; want 3 and 1 revoked", crl.Number, stdout.String())
+  }
+  for _, bad := range [][]string{
+    { "-key-type", "dsa" },
+    { "-days", "0" },
+    { "-hosts", " , " },
+  } {
+    if code := CertsCommand(append([]string{ "-out", t.TempDir() }, bad...), io.Discard, io.Discard); code != 2 {
+      t.Errorf("CertsCommand(%q) = %d; want 2", bad, code)
+    }
+  }
+  if code := CertsCommand([]string{ "crl", "-out", dir, "-revoke", "xyz" }, io.Discard, io.Discard); code != 2 {
+    t.Errorf("certs crl with a bad serial = %d; want 2", code)
+  }
+}
@@ -0,0 +1,270 @@
+package security
+
+/***
+The certificate of the HTTPS server, for tls.Config.GetCertificate. It comes from either:
+(1) The files of the operator: a PEM certificate (followed by its chain) and its PEM key; e.g.,
+    issued by cert-manager. The files are read again when they change, so a renewed certificate is
+    served without a restart.
+(2) A private CA kept in a directory (the data directory). The CA and the server certificate are
+    created on the first start and reused on the next ones, so the clients that trust the CA keep
+    trusting the server after a restart. The server certificate is issued again, with a new key,
+    when a third of its validity is left or when its hosts (the SANs) change.
+Refresh does (1) and (2); the server calls it periodically.
+***/
+
+import (
+  "crypto"
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/pem"
+  "errors"
+  "fmt"
+  "io/fs"
+  "net"
+  "os"
+  "path/filepath"
+  "slices"
+  "sync"
+  "time"
+)
+
+const (
+  //The CA of (2); it is created again only if it expires before the server certificate would.
+  caValidity = 10 * 365 * 24 * time.Hour
+  caCertFile = "ca.crt"
+  caKeyFile = "ca.key"
+  serverCertFile = "server.crt"
+  serverKeyFile = "server.key"
+)
+
+type CertOptions struct {
+  //(1); both or neither.
+  CertFile string
+  KeyFile string
+  //(2): the directory of the CA and the server certificate, its hosts, its validity, and the type of
+  //the new keys.
+  Dir string
+  Hosts []string
+  Validity time.Duration
+  KeyType KeyType
+}
+
+type CertStore struct {
+  opts CertOptions
+  mu sync.RWMutex
+  cert *tls.Certificate
+  modTime time.Time  //(1): the last change of the files that were read.
+  caPEM []byte  //(2)
+}
+
+//The certificate is loaded (or created) before the store is returned.
+func NewCertStore(opts CertOptions) (*CertStore, error) {
+  if (opts.CertFile == "") != (opts.KeyFile == "") {
+    return nil, errors.New("the certificate file and the key file must be given together")
+  }
+  if opts.CertFile == "" && (opts.Dir == "" || len(opts.Hosts) == 0 || opts.Validity <= 0) {
+    return nil, errors.New("the directory, the hosts, and the validity of the certificate are required")
+  }
+  if opts.KeyType == "" {
+    opts.KeyType = KeyECDSA
+  } else if _, err := ParseKeyType(string(opts.KeyType)); err != nil {
+    return nil, err
+  }
+  s := &CertStore{ opts: opts }
+  if err := s.Refresh(time.Now()); err != nil {
+    return nil, err
+  }
+  return s, nil
+}
+
+func (s *CertStore) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  return s.cert, nil
+}
+
+//The certificate being served.
+func (s *CertStore) Leaf() *x509.Certificate {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  return s.cert.Leaf
+}
+
+//The PEM certificate of the CA of (2); nil for (1).
+func (s *CertStore) CAPEM() []byte {
+  s.mu.RLock()
+  defer s.mu.RUnlock()
+  return s.caPEM
+}
+
+/***
+Reload the files of (1) if they changed, or renew the certificate of (2) if it is due. On error, the
+current certificate is still served.
+***/
+func (s *CertStore) Refresh(now time.Time) error {
+  if s.opts.CertFile != "" {
+    return s.reloadFiles()
+  }
+  return s.renew(now)
+}
+
+func (s *CertStore) reloadFiles() error {
+  var modTime time.Time
+  for _, path := range []string{ s.opts.CertFile, s.opts.KeyFile } {
+    fi, err := os.Stat(path)
+    if err != nil {
+      return err
+    }
+    if fi.ModTime().After(modTime) {
+      modTime = fi.ModTime()
+    }
+  }
+  s.mu.RLock()
+  unchanged := s.cert != nil && modTime.Equal(s.modTime)
+  s.mu.RUnlock()
+  if unchanged {
+    return nil
+  }
+  cert, err := tls.LoadX509KeyPair(s.opts.CertFile, s.opts.KeyFile)
+  if err != nil {
+    return fmt.Errorf("cannot load the certificate %s: %w", s.opts.CertFile, err)
+  }
+  if cert.Leaf == nil {
+    if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+      return err
+    }
+  }
+  s.mu.Lock()
+  s.cert = &cert
+  s.modTime = modTime
+  s.mu.Unlock()
+  return nil
+}
+
+func (s *CertStore) renew(now time.Time) error {
+  s.mu.RLock()
+  cert := s.cert
+  s.mu.RUnlock()
+  if cert != nil && !s.due(cert.Leaf, now) {
+    return nil
+  }
+  if err := os.MkdirAll(s.opts.Dir, 0700); err != nil {
+    return err
+  }
+  caCert, caPEM, caKey, err := s.loadCA(now)
+  if err != nil {
+    return err
+  }
+  //The certificate of a previous start, if it is still good.
+  cert, err = loadKeyPair(filepath.Join(s.opts.Dir, serverCertFile), filepath.Join(s.opts.Dir, serverKeyFile))
+  if err != nil || cert.Leaf.CheckSignatureFrom(caCert) != nil || s.due(cert.Leaf, now) {
+    _, certPEM, keyPEM := GenServerCert(caCert, caKey, s.opts.KeyType, s.opts.Hosts, s.opts.Validity)
+    if err = writeFileAtomic(filepath.Join(s.opts.Dir, serverKeyFile), keyPEM, 0600); err != nil {
+      return err
+    }
+    if err = writeFileAtomic(filepath.Join(s.opts.Dir, serverCertFile), certPEM, 0644); err != nil {
+      return err
+    }
+    if cert, err = keyPair(certPEM, keyPEM); err != nil {
+      return err
+    }
+  }
+  s.mu.Lock()
+  s.cert = cert
+  s.caPEM = caPEM
+  s.mu.Unlock()
+  return nil
+}
+
+//The certificate must be issued again: a third of its validity is left, or its hosts changed.
+func (s *CertStore) due(leaf *x509.Certificate, now time.Time) bool {
+  if now.After(leaf.NotAfter.Add(-leaf.NotAfter.Sub(leaf.NotBefore) / 3)) {
+    return true
+  }
+  var hosts []string
+  for _, ip := range leaf.IPAddresses {
+    hosts = append(hosts, ip.String())
+  }
+  hosts = append(hosts, leaf.DNSNames...)
+  want := make([]string, 0, len(s.opts.Hosts))
+  for _, h := range s.opts.Hosts {
+    if ip := net.ParseIP(h); ip != nil {
+      h = ip.String()
+    }
+    want = append(want, h)
+  }
+  slices.Sort(hosts)
+  slices.Sort(want)
+  return !slices.Equal(hosts, want)
+}
+
+//The CA of the directory; it is created if there is none, or if it expires too soon.
+func (s *CertStore) loadCA(now time.Time) (*x509.Certificate, []byte, crypto.Signer, error) {
+  certPath := filepath.Join(s.opts.Dir, caCertFile)
+  keyPath := filepath.Join(s.opts.Dir, caKeyFile)
+  pair, err := loadKeyPair(certPath, keyPath)
+  if err == nil {
+    key, ok := pair.PrivateKey.(crypto.Signer)
+    if !ok {
+      return nil, nil, nil, fmt.Errorf("%s: the key of the CA cannot sign", keyPath)
+    }
+    if now.Add(s.opts.Validity).Before(pair.Leaf.NotAfter) {
+      caPEM, _ := os.ReadFile(certPath)
+      return pair.Leaf, caPEM, key, nil
+    }
+  } else if !errors.Is(err, fs.ErrNotExist) {
+    return nil, nil, nil, err
+  }
+  caCert, caPEM, caKey := GenRootCA(s.opts.KeyType, caValidity)
+  if err = writeFileAtomic(keyPath, pem.EncodeToMemory(KeyToPemBlock(caKey)), 0600); err != nil {
+    return nil, nil, nil, err
+  }
+  if err = writeFileAtomic(certPath, caPEM, 0644); err != nil {
+    return nil, nil, nil, err
+  }
+  return caCert, caPEM, caKey, nil
+}
+
+func loadKeyPair(certPath, keyPath string) (*tls.Certificate, error) {
+  certPEM, err := os.ReadFile(certPath)
+  if err != nil {
+    return nil, err
+  }
+  keyPEM, err := os.ReadFile(keyPath)
+  if err != nil {
+    return nil, err
+  }
+  return keyPair(certPEM, keyPEM)
+}
+
+func keyPair(certPEM, keyPEM []byte) (*tls.Certificate, error) {
+  cert, err := tls.X509KeyPair(certPEM, keyPEM)
+  if err != nil {
+    return nil, err
+  }
+  if cert.Leaf == nil {
+    if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+      return nil, err
+    }
+  }
+  return &cert, nil
+}
+
+//A reader never sees half a file (e.g., another instance that shares the directory).
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+  f, err := os.CreateTemp(filepath.Dir(path), "." + filepath.Base(path) + "-*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(f.Name())
+  if _, err = f.Write(data); err == nil {
+    err = f.Chmod(perm)
+  }
+  if cerr := f.Close(); err == nil {
+    err = cerr
+  }
+  if err != nil {
+    return err
+  }
+  return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,126 @@
+// Testing the functions in certstore.go.
+package security
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="CertStore"
+***/
+
+import (
+  "crypto/tls"
+  "crypto/x509"
+  "encoding/pem"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+func serial(t *testing.T, s *CertStore) string {
+  t.Helper()
+  cert, err := s.GetCertificate(&tls.ClientHelloInfo{})
+  if err != nil || cert == nil {
+    t.Fatalf("GetCertificate: %v", err)
+  }
+  return cert.Leaf.SerialNumber.String()
+}
+
+func TestCertStorePrivateCA(t *testing.T) {
+  t.Parallel()
+  dir := filepath.Join(t.TempDir(), "tls")
+  opts := CertOptions{ Dir: dir, Hosts: []string{ "localhost", "::1" }, Validity: 30 * 24 * time.Hour }
+  s, err := NewCertStore(opts)
+  if err != nil {
+    t.Fatal(err)
+  }
+  first := serial(t, s)
+  roots := x509.NewCertPool()
+  if !roots.AppendCertsFromPEM(s.CAPEM()) {
+    t.Fatal("CAPEM is not a PEM certificate")
+  }
+  if _, err = s.Leaf().Verify(x509.VerifyOptions{ Roots: roots, DNSName: "localhost" }); err != nil {
+    t.Errorf("the certificate does not verify with the CA: %v", err)
+  }
+  if fi, err := os.Stat(filepath.Join(dir, serverKeyFile)); err != nil || fi.Mode().Perm() != 0600 {
+    t.Errorf("the key of the server: %v, %v; want mode 0600", fi, err)
+  }
+  //A restart reuses the CA and the certificate.
+  s2, err := NewCertStore(opts)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if serial(t, s2) != first || string(s2.CAPEM()) != string(s.CAPEM()) {
+    t.Error("a new store did not reuse the certificate and the CA of the directory")
+  }
+  //Not due yet.
+  if err = s2.Refresh(time.Now().Add(19 * 24 * time.Hour)); err != nil || serial(t, s2) != first {
+    t.Errorf("Refresh renewed the certificate with two thirds of its validity left (%v)", err)
+  }
+  //A third of the validity left: a new certificate from the same CA.
+  if err = s2.Refresh(time.Now().Add(21 * 24 * time.Hour)); err != nil {
+    t.Fatal(err)
+  }
+  if serial(t, s2) == first || string(s2.CAPEM()) != string(s.CAPEM()) {
+    t.Error("Refresh did not renew the certificate with the same CA")
+  }
+  //Other hosts.
+  opts.Hosts = []string{ "finances.example.com" }
+  s3, err := NewCertStore(opts)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if names := s3.Leaf().DNSNames; len(names) != 1 || names[0] != "finances.example.com" {
+    t.Errorf("DNSNames = %v; want the new hosts", names)
+  }
+}
+
+func writePair(t *testing.T, certPath, keyPath string, host string) {
+  t.Helper()
+  caCert, _, caKey := GenRootCA(KeyEd25519, time.Hour)
+  _, certPEM, keyPEM := GenServerCert(caCert, caKey, KeyEd25519, []string{ host }, time.Hour)
+  if err := os.WriteFile(keyPath, keyPEM, 0600); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestCertStoreFiles(t *testing.T) {
+  t.Parallel()
+  dir := t.TempDir()
+  certPath, keyPath := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+  writePair(t, certPath, keyPath, "one.example.com")
+  s, err := NewCertStore(CertOptions{ CertFile: certPath, KeyFile: keyPath })
+  if err != nil {
+    t.Fatal(err)
+  }
+  if s.CAPEM() != nil || s.Leaf().DNSNames[0] != "one.example.com" {
+    t.Fatalf("CAPEM %v, DNSNames %v; want no CA and the certificate of the files", s.CAPEM(), s.Leaf().DNSNames)
+  }
+  //A broken file keeps the current certificate.
+  if err = os.WriteFile(certPath, pem.EncodeToMemory(&pem.Block{ Type: "CERTIFICATE", Bytes: []byte("x") }),
+     0644); err != nil {
+    t.Fatal(err)
+  }
+  os.Chtimes(certPath, time.Now(), time.Now().Add(time.Minute))
+  if err = s.Refresh(time.Now()); err == nil || s.Leaf().DNSNames[0] != "one.example.com" {
+    t.Errorf("Refresh of a broken file: %v, %v; want an error and the old certificate", err, s.Leaf().DNSNames)
+  }
+  //The renewed files are served.
+  writePair(t, certPath, keyPath, "two.example.com")
+  os.Chtimes(certPath, time.Now(), time.Now().Add(2 * time.Minute))
+  if err = s.Refresh(time.Now()); err != nil || s.Leaf().DNSNames[0] != "two.example.com" {
+    t.Errorf("Refresh: %v, %v; want the new certificate", err, s.Leaf().DNSNames)
+  }
+  if _, err = NewCertStore(CertOptions{ CertFile: certPath }); err == nil {
+    t.Error("NewCertStore without the key file did not fail")
+  }
+}
@@ -0,0 +1,96 @@
+package security
+
+/***
+The keys of the certificates:
+(1) RSA-2048: accepted everywhere, but large and slow to generate.
+(2) ECDSA P-256: as strong as RSA-3072; small and fast. Every browser and TLS library accepts it.
+(3) Ed25519: faster still and hard to misuse, but many browsers do not accept it for TLS; use it for
+    the certificates between our own services (mutual TLS).
+***/
+
+import (
+  "crypto"
+  "crypto/ecdsa"
+  "crypto/ed25519"
+  "crypto/elliptic"
+  "crypto/rand"
+  "crypto/rsa"
+  "crypto/x509"
+  "encoding/pem"
+  "errors"
+  "fmt"
+)
+
+type KeyType string
+
+const (
+  KeyRSA KeyType = "rsa"
+  KeyECDSA KeyType = "ecdsa"
+  KeyEd25519 KeyType = "ed25519"
+)
+
+func ParseKeyType(s string) (KeyType, error) {
+  switch kt := KeyType(s); kt {
+  case KeyRSA, KeyECDSA, KeyEd25519:
+    return kt, nil
+  }
+  return "", fmt.Errorf("%q is not a key type; use rsa, ecdsa, or ed25519", s)
+}
+
+func GenerateKey(keyType KeyType) (crypto.Signer, error) {
+  switch keyType {
+  case KeyRSA:
+    return rsa.GenerateKey(rand.Reader, 2048)
+  case KeyECDSA:
+    return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  case KeyEd25519:
+    _, key, err := ed25519.GenerateKey(rand.Reader)
+    return key, err
+  }
+  return nil, fmt.Errorf("%q is not a key type", keyType)
+}
+
+//As the other generators of this package, it panics; the key type is checked by the caller.
+func mustGenerateKey(keyType KeyType) crypto.Signer {
+  key, err := GenerateKey(keyType)
+  if err != nil {
+    panic("Failed to generate a keypair.\n" + err.Error())
+  }
+  return key
+}
+
+//The first private key of the PEM data; in any of the formats written by KeyToPemBlock.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+  for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+    var key any
+    var err error
+    switch block.Type {
+    case "RSA PRIVATE KEY":
+      key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+    case "EC PRIVATE KEY":
+      key, err = x509.ParseECPrivateKey(block.Bytes)
+    case "PRIVATE KEY":
+      key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+    default:
+      continue
+    }
+    if err != nil {
+      return nil, err
+    }
+    if signer, ok := key.(crypto.Signer); ok {
+      return signer, nil
+    }
+    return nil, fmt.Errorf("unsupported private key %T", key)
+  }
+  return nil, errors.New("no private key found")
+}
+
+//The first certificate of the PEM data.
+func ParseCertificatePEM(data []byte) (*x509.Certificate, error) {
+  for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+    if block.Type == "CERTIFICATE" {
+      return x509.ParseCertificate(block.Bytes)
+    }
+  }
+  return nil, errors.New("no certificate found")
+}
@@ -0,0 +1,279 @@
+package security
+
+/***
+Password policy. A password is checked against:
+(1) Its length. bcrypt only uses the first 72 bytes of a password, so longer passwords are
+    rejected instead of being silently truncated.
+(2) The number of character classes (lowercase, uppercase, digits, symbols).
+(3) A strength score from 0 (too guessable) to 4 (very unguessable) in the spirit of zxcvbn: the
+    parts of the password that match common passwords, keyboard rows, sequences, repeats, or the
+    username are worth only a few bits.
+(4) A local list of breached passwords (see BreachedPasswordCount).
+The reuse of previous passwords is checked by the database, where the old hashes are kept.
+***/
+
+import (
+  "bufio"
+  "crypto/sha1"
+  "encoding/hex"
+  "errors"
+  "fmt"
+  "io/fs"
+  "math"
+  "os"
+  "path/filepath"
+  "sort"
+  "strings"
+  "unicode"
+)
+
+//bcrypt ignores the bytes after the 72nd.
+const PasswordMaxBytes = 72
+
+type PasswordPolicy struct {
+  MinLength int
+  //Out of 4: lowercase letters, uppercase letters, digits, and symbols.
+  MinClasses int
+  //Out of 4; see PasswordStrength.
+  MinScore int
+  //The directory with the breached-password range files; empty to skip the check.
+  BreachedDir string
+}
+
+/***
+Check a password against the policy. It returns the messages for the password field (none if the
+password is acceptable); the error is set only if the breached-password list could not be read, in
+which case the other rules were still applied.
+***/
+func (p PasswordPolicy) Validate(password, userName string) ([]string, error) {
+  var msgs []string
+  if n := len([]rune(password)); n < p.MinLength {
+    msgs = append(msgs, fmt.Sprintf("Must be at least %d characters long.", p.MinLength))
+  }
+  if len(password) > PasswordMaxBytes {
+    msgs = append(msgs, fmt.Sprintf("Must be at most %d bytes long.", PasswordMaxBytes))
+  }
+  if PasswordClasses(password) < p.MinClasses {
+    msgs = append(msgs, fmt.Sprintf("Must contain at least %d of: lowercase letters, uppercase letters, digits, " +
+      "symbols.", p.MinClasses))
+  }
+  if u := strings.ToLower(strings.TrimSpace(userName)); len(u) >= 3 && strings.Contains(strings.ToLower(password), u) {
+    msgs = append(msgs, "Must not contain the username.")
+  }
+  if score := PasswordStrength(password, userName); score < p.MinScore {
+    msgs = append(msgs, fmt.Sprintf("Is too easy to guess (strength %d of 4; at least %d is required).", score,
+      p.MinScore))
+  }
+  var err error
+  if p.BreachedDir != "" {
+    var count int
+    if count, err = BreachedPasswordCount(p.BreachedDir, password); count > 0 {
+      msgs = append(msgs, "Has appeared in a data breach; choose another one.")
+    }
+  }
+  return msgs, err
+}
+
+//The number of character classes (lowercase, uppercase, digits, symbols) in the password.
+func PasswordClasses(password string) int {
+  var lower, upper, digit, symbol int
+  for _, r := range password {
+    switch {
+    case unicode.IsLower(r):
+      lower = 1
+    case unicode.IsUpper(r):
+      upper = 1
+    case unicode.IsDigit(r):
+      digit = 1
+    default:
+      symbol = 1
+    }
+  }
+  return lower + upper + digit + symbol
+}
+
+//A short list of the most common passwords and words found in passwords; keyboard rows are added below.
+var commonPasswords = []string{
+  "password", "passw0rd", "qwerty", "letmein", "welcome", "admin", "administrator", "iloveyou", "monkey",
+  "dragon", "master", "sunshine", "princess", "football", "baseball", "shadow", "superman", "batman",
+  "trustno1", "login", "abc123", "starwars", "whatever", "freedom", "secret", "changeme", "default",
+  "hello", "charlie", "michael", "jordan", "summer", "winter", "spring", "autumn", "soccer", "hockey",
+  "ranger", "buster", "thomas", "robert", "pepper", "flower", "computer", "internet", "finance",
+  "finances", "money", "banking", "investment", "january", "february", "october", "november", "december",
+}
+
+//Sequences that are typed, not chosen; any run of 4 or more of them is as weak as a common word.
+var keyboardRows = []string{
+  "qwertyuiop", "asdfghjkl", "zxcvbnm", "1234567890", "0987654321", "abcdefghijklmnopqrstuvwxyz",
+}
+
+//Undo the usual character substitutions before looking for common words.
+var leetReplacer = strings.NewReplacer("@", "a", "4", "a", "0", "o", "1", "i", "!", "i", "3", "e", "$", "s",
+  "5", "s", "7", "t", "+", "t", "8", "b")
+
+//Bits credited to a part of the password that matches a common word, a keyboard row, or the username.
+const dictionaryMatchBits = 11.0
+const yearMatchBits = 7.0
+
+/***
+Estimate how hard the password is to guess, from 0 (too guessable) to 4 (very unguessable). The
+estimate adds the bits of every character; a character drawn from the classes present in the
+password is worth log2(size of those classes), but the characters that belong to a common word, a
+keyboard row, the username, a year, a repeat, or a sequence are worth much less.
+***/
+func PasswordStrength(password, userName string) int {
+  runes := []rune(password)
+  if len(runes) == 0 {
+    return 0
+  }
+  covered := make([]bool, len(runes))
+  bits := 0.0
+  //(1) Common words, keyboard rows, and the username; both as typed and with the substitutions undone.
+  words := append([]string{}, commonPasswords...)
+  for _, row := range keyboardRows {
+    for n := 4; n <= len(row); n++ {
+      for i := 0; i + n <= len(row); i++ {
+        words = append(words, row[i:i + n])
+      }
+    }
+  }
+  if u := strings.ToLower(strings.TrimSpace(userName)); len(u) >= 3 {
+    words = append(words, u)
+  }
+  //The longest matches first; a part of the password is credited only once.
+  sort.SliceStable(words, func(i, j int) bool { return len(words[i]) > len(words[j]) })
+  lower := strings.ToLower(password)
+  for _, candidate := range []string{ lower, leetReplacer.Replace(lower) } {
+    cr := []rune(candidate)
+    if len(cr) != len(runes) {
+      continue
+    }
+    for _, w := range words {
+      wr := []rune(w)
+      for i := 0; i + len(wr) <= len(cr); i++ {
+        if string(cr[i:i + len(wr)]) != w || anyCovered(covered[i:i + len(wr)]) {
+          continue
+        }
+        for j := i; j < i + len(wr); j++ {
+          covered[j] = true
+        }
+        bits += dictionaryMatchBits
+      }
+    }
+  }
+  //(2) Years (1900-2099) are a small set.
+  for i := 0; i + 4 <= len(runes); i++ {
+    y := string(runes[i:i + 4])
+    if (strings.HasPrefix(y, "19") || strings.HasPrefix(y, "20")) && isDigits(y) && !anyCovered(covered[i:i + 4]) {
+      for j := i; j < i + 4; j++ {
+        covered[j] = true
+      }
+      bits += yearMatchBits
+    }
+  }
+  //(3) Repeats (aaa) and sequences (abc, 975): after the first two characters, each one is almost free.
+  for i := 2; i < len(runes); i++ {
+    d1 := runes[i] - runes[i - 1]
+    d2 := runes[i - 1] - runes[i - 2]
+    if d1 == d2 && (d1 == 0 || d1 == 1 || d1 == -1) && !covered[i] {
+      covered[i] = true
+      bits += 1
+    }
+  }
+  //(4) Everything else is worth the size of the alphabet the password draws from.
+  perChar := math.Log2(float64(alphabetSize(password)))
+  for _, c := range covered {
+    if !c {
+      bits += perChar
+    }
+  }
+  switch {
+  case bits < 25:
+    return 0
+  case bits < 35:
+    return 1
+  case bits < 50:
+    return 2
+  case bits < 65:
+    return 3
+  default:
+    return 4
+  }
+}
+
+func anyCovered(c []bool) bool {
+  for _, v := range c {
+    if v {
+      return true
+    }
+  }
+  return false
+}
+
+func isDigits(s string) bool {
+  for _, r := range s {
+    if r < '0' || r > '9' {
+      return false
+    }
+  }
+  return true
+}
+
+func alphabetSize(password string) int {
+  var lower, upper, digit, symbol, other int
+  for _, r := range password {
+    switch {
+    case r > unicode.MaxASCII:
+      other = 100
+    case unicode.IsLower(r):
+      lower = 26
+    case unicode.IsUpper(r):
+      upper = 26
+    case unicode.IsDigit(r):
+      digit = 10
+    default:
+      symbol = 33
+    }
+  }
+  return lower + upper + digit + symbol + other
+}
+
+/***
+Look the password up in a local copy of a breached-password list, using the k-anonymity range
+format of the Pwned Passwords API (https://haveibeenpwned.com/API/v3#PwnedPasswords): the SHA-1 hash
+of the password is split into a 5-character prefix and a 35-character suffix; dir holds one file per
+prefix (named PREFIX or PREFIX.txt, in uppercase) with a "SUFFIX:COUNT" line per breached password.
+It returns how many times the password was seen in breaches (zero if it was not found).
+***/
+func BreachedPasswordCount(dir, password string) (int, error) {
+  sum := sha1.Sum([]byte(password))
+  hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+  prefix, suffix := hash[:5], hash[5:]
+  var file *os.File
+  var err error
+  for _, name := range []string{ prefix + ".txt", prefix } {
+    if file, err = os.Open(filepath.Join(dir, name)); err == nil || !errors.Is(err, fs.ErrNotExist) {
+      break
+    }
+  }
+  if err != nil {
+    if errors.Is(err, fs.ErrNotExist) {
+      return 0, nil  //No breached password has this prefix.
+    }
+    return 0, err
+  }
+  defer file.Close()
+  scanner := bufio.NewScanner(file)
+  for scanner.Scan() {
+    line := strings.TrimSpace(scanner.Text())
+    s, c, _ := strings.Cut(line, ":")
+    if strings.EqualFold(s, suffix) {
+      var count int
+      if _, err := fmt.Sscanf(c, "%d", &count); err != nil || count < 1 {
+        count = 1
+      }
+      return count, nil
+    }
+  }
+  return 0, scanner.Err()
+}
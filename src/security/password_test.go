@@ -0,0 +1,140 @@
+// Testing the functions in password.go.
+package security
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Password"
+***/
+
+import (
+  "crypto/sha1"
+  "encoding/hex"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+)
+
+func TestPasswordClasses(t *testing.T) {
+  t.Parallel()
+  type test struct {
+    password string
+    want int
+  }
+  var tests = []test {
+    { password: "", want: 0 },
+    { password: "abc", want: 1 },
+    { password: "abcDEF", want: 2 },
+    { password: "abcDEF123", want: 3 },
+    { password: "abcDEF123!", want: 4 },
+    { password: "ñandú 7", want: 3 },
+  }
+  for _, tc := range tests {
+    if got := PasswordClasses(tc.password); got != tc.want {
+      t.Errorf("PasswordClasses(%q) = %d; want %d", tc.password, got, tc.want)
+    }
+  }
+}
+
+func TestPasswordStrength(t *testing.T) {
+  t.Parallel()
+  type test struct {
+    password string
+    userName string
+    want int
+  }
+  var tests = []test {
+    { password: "", want: 0 },
+    { password: "password", want: 0 },
+    { password: "Password1!", want: 0 },
+    { password: "P@ssw0rd", want: 0 },
+    { password: "aaaaaaaaaaaa", want: 0 },
+    { password: "abcdefgh12345", want: 0 },
+    { password: "qwerty123456", want: 0 },
+    { password: "Summer2024!", want: 0 },
+    { password: "janedoe2024!", userName: "janedoe", want: 0 },
+    { password: "Tr0ub4dor&3", want: 4 },
+    { password: "correct horse battery staple", want: 4 },
+    { password: "zV8#kq2!Lm9x", want: 4 },
+  }
+  for _, tc := range tests {
+    if got := PasswordStrength(tc.password, tc.userName); got != tc.want {
+      t.Errorf("PasswordStrength(%q, %q) = %d; want %d", tc.password, tc.userName, got, tc.want)
+    }
+  }
+}
+
+func writeBreachedFile(t *testing.T, dir, password string, count string) {
+  t.Helper()
+  sum := sha1.Sum([]byte(password))
+  hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+  content := "0000000000000000000000000000000000A:3\r\n" + hash[5:] + ":" + count + "\r\n"
+  if err := os.WriteFile(filepath.Join(dir, hash[:5] + ".txt"), []byte(content), 0600); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestBreachedPasswordCount(t *testing.T) {
+  t.Parallel()
+  dir := t.TempDir()
+  writeBreachedFile(t, dir, "hunter2hunter2", "1234")
+  type test struct {
+    password string
+    want int
+  }
+  var tests = []test {
+    { password: "hunter2hunter2", want: 1234 },
+    { password: "not in the list", want: 0 },
+  }
+  for _, tc := range tests {
+    got, err := BreachedPasswordCount(dir, tc.password)
+    if err != nil {
+      t.Fatalf("BreachedPasswordCount(%q): %v", tc.password, err)
+    }
+    if got != tc.want {
+      t.Errorf("BreachedPasswordCount(%q) = %d; want %d", tc.password, got, tc.want)
+    }
+  }
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+  t.Parallel()
+  dir := t.TempDir()
+  writeBreachedFile(t, dir, "Zq8!vR2#mW5k", "7")
+  policy := PasswordPolicy{ MinLength: 12, MinClasses: 3, MinScore: 2, BreachedDir: dir }
+  type test struct {
+    password string
+    userName string
+    want []string
+  }
+  var tests = []test {
+    { password: "zV8#kq2!Lm9x", userName: "janedoe", want: nil },
+    { password: "Ab1!", userName: "janedoe", want: []string{ "at least 12 characters", "too easy" } },
+    { password: "abcdefghijklmnop", userName: "janedoe", want: []string{ "at least 3 of", "too easy" } },
+    { password: "xJanedoe#7Qv9", userName: "janedoe", want: []string{ "username" } },
+    { password: strings.Repeat("aB3$", 19), userName: "janedoe", want: []string{ "at most 72 bytes" } },
+    { password: "Zq8!vR2#mW5k", userName: "janedoe", want: []string{ "data breach" } },
+  }
+  for _, tc := range tests {
+    got, err := policy.Validate(tc.password, tc.userName)
+    if err != nil {
+      t.Fatalf("Validate(%q): %v", tc.password, err)
+    }
+    if len(got) != len(tc.want) {
+      t.Errorf("Validate(%q) = %q; want %d messages", tc.password, got, len(tc.want))
+      continue
+    }
+    for i, w := range tc.want {
+      if !strings.Contains(got[i], w) {
+        t.Errorf("Validate(%q)[%d] = %q; want it to contain %q", tc.password, i, got[i], w)
+      }
+    }
+  }
+}
@@ -0,0 +1,181 @@
+package security
+
+/***
+The sessions that must end; e.g., a customer unregistered by an admin while logged in, or a user
+whose password was reset. The sessions are kept in memory by gpsessions, which cannot look them up by
+user, so the server checks every request (IsSessionEnded) and deletes the session that must end.
+
+Whether the sessions of a user must end is kept in Postgres (fin.customers), since the request that
+ends them may be served by another instance of the server: a user who is inactive or unknown keeps
+no session, and the sessions that started before the user's sessions_ended_at are ended (a new login
+is not). The state is loaded by the function set with SetUserSessionsLoader and cached for
+userSessionsTtl, so another instance ends the sessions within that time; the instance that changed
+the state forgets it at once (ForgetUserSessions). The clocks of the instances must be in sync.
+
+The server records when each session started; the start follows the session when its token is
+renewed (sessions.UpdateEntryInSessions). The starts are in memory as well, like the sessions.
+***/
+
+import (
+  "context"
+  "crypto/sha256"
+  "encoding/hex"
+  "sync"
+  "time"
+)
+
+/***
+A session that is not renewed for this long has expired (gpsessions ends a session after minutes
+without a request); its start is forgotten.
+***/
+const staleSession = 24 * time.Hour
+
+const userSessionsTtl = 10 * time.Second
+
+var started = struct {
+  sync.RWMutex
+  sessions map[string]sessionStart  //key: session token
+} { sessions: map[string]sessionStart{} }
+
+type sessionStart struct {
+  id string  //Stays the same when the token is renewed; see SessionId.
+  at time.Time  //The login.
+  renewed time.Time
+}
+
+//Whether the sessions of a user must end; see fin.customers.
+type UserSessions struct {
+  Revoked bool  //The user is inactive (unregistered) or unknown; no session is kept.
+  EndedAt time.Time  //The sessions that started before it are ended; zero if none.
+}
+
+type userSessionsLoader func(ctx context.Context, userName, correlationId string) (UserSessions, error)
+
+type userSessionsEntry struct {
+  state UserSessions
+  expiry time.Time
+}
+
+type userSessionsCache struct {
+  sync.Mutex
+  entries map[string]*userSessionsEntry  //key: username
+  load userSessionsLoader
+  now func() time.Time
+}
+
+func newUserSessionsCache(load userSessionsLoader) *userSessionsCache {
+  return &userSessionsCache{ entries: map[string]*userSessionsEntry{}, load: load, now: time.Now }
+}
+
+var userSessions = newUserSessionsCache(nil)
+
+//The server sets it before it serves the requests (e.g., banking.DbGetUserSessions).
+func SetUserSessionsLoader(load userSessionsLoader) {
+  userSessions.Lock()
+  userSessions.load = load
+  userSessions.entries = map[string]*userSessionsEntry{}
+  userSessions.Unlock()
+}
+
+/***
+A failure to load the state keeps the session (a database outage must not log out every user); it
+is not cached.
+***/
+func (c *userSessionsCache) get(ctx context.Context, userName, correlationId string) (UserSessions, bool) {
+  c.Lock()
+  e, ok := c.entries[userName]
+  load := c.load
+  c.Unlock()
+  if ok && !c.now().After(e.expiry) {
+    return e.state, true
+  }
+  if load == nil {
+    return UserSessions{}, false
+  }
+  state, err := load(ctx, userName, correlationId)
+  if err != nil {
+    return UserSessions{}, false
+  }
+  c.Lock()
+  c.entries[userName] = &userSessionsEntry{ state: state, expiry: c.now().Add(userSessionsTtl) }
+  c.Unlock()
+  return state, true
+}
+
+func (c *userSessionsCache) forget(userName string) {
+  c.Lock()
+  delete(c.entries, userName)
+  c.Unlock()
+}
+
+//Call it after the state of the user changed (e.g., deactivated, reactivated, or password reset).
+func ForgetUserSessions(userName string) {
+  userSessions.forget(userName)
+}
+
+//A user logged in; the session starts now.
+func SessionStarted(sessionToken string) {
+  now := time.Now()
+  started.Lock()
+  defer started.Unlock()
+  for token, s := range started.sessions {
+    if now.Sub(s.renewed) > staleSession {
+      delete(started.sessions, token)
+    }
+  }
+  sum := sha256.Sum256([]byte(sessionToken))
+  started.sessions[sessionToken] = sessionStart{ hex.EncodeToString(sum[:16]), now, now }
+}
+
+//The token of a session was renewed; the session keeps its start.
+func SessionRenewed(oldSessionToken, newSessionToken string) {
+  started.Lock()
+  defer started.Unlock()
+  if s, ok := started.sessions[oldSessionToken]; ok {
+    delete(started.sessions, oldSessionToken)
+    s.renewed = time.Now()
+    started.sessions[newSessionToken] = s
+  }
+}
+
+//The session was deleted (e.g., logout).
+func SessionDeleted(sessionToken string) {
+  started.Lock()
+  delete(started.sessions, sessionToken)
+  started.Unlock()
+}
+
+/***
+An ID of the session that does not change when its token is renewed (e.g., the key of a rate limit);
+empty if the session is unknown. It is derived from the first token, which it does not reveal.
+***/
+func SessionId(sessionToken string) string {
+  started.RLock()
+  defer started.RUnlock()
+  return started.sessions[sessionToken].id
+}
+
+func IsSessionEnded(ctx context.Context, userName, sessionToken, correlationId string) bool {
+  return userSessions.isEnded(ctx, userName, sessionToken, correlationId)
+}
+
+//A session of unknown start started before EndedAt.
+func (c *userSessionsCache) isEnded(ctx context.Context, userName, sessionToken, correlationId string) bool {
+  if userName == "" {
+    return false
+  }
+  state, ok := c.get(ctx, userName, correlationId)
+  if !ok {
+    return false
+  }
+  if state.Revoked {
+    return true
+  }
+  if state.EndedAt.IsZero() {
+    return false
+  }
+  started.RLock()
+  s, ok := started.sessions[sessionToken]
+  started.RUnlock()
+  return !ok || !s.at.After(state.EndedAt)
+}
@@ -0,0 +1,99 @@
+// Testing the functions in revocations.go.
+package security
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Session"
+***/
+
+import (
+  "context"
+  "errors"
+  "testing"
+  "time"
+)
+
+func TestIsSessionEnded(t *testing.T) {
+  t.Parallel()
+  SessionStarted("end-old")
+  SessionStarted("end-other")
+  SessionRenewed("end-old", "end-renewed")
+  time.Sleep(time.Millisecond)
+  endedAt := time.Now()
+  time.Sleep(time.Millisecond)
+  SessionStarted("end-new")
+  states := map[string]UserSessions{
+    "end-user": { EndedAt: endedAt },
+    "end-other-user": {},
+    "end-inactive": { Revoked: true },
+  }
+  c := newUserSessionsCache(func(ctx context.Context, userName, correlationId string) (UserSessions, error) {
+    if userName == "end-db-down" {
+      return UserSessions{}, errors.New("connection refused")
+    }
+    return states[userName], nil
+  })
+  tests := []struct {
+    userName string
+    token string
+    want bool
+  } {
+    { "end-user", "end-renewed", true },  //Started before the end; the token was renewed.
+    { "end-user", "end-old", true },  //The token before the renewal is unknown.
+    { "end-user", "end-new", false },  //A login after the end.
+    { "end-other-user", "end-other", false },
+    { "end-inactive", "end-new", true },  //Whatever the start.
+    { "end-db-down", "end-renewed", false },  //The session is kept.
+    { "", "end-renewed", false },
+  }
+  for _, tc := range tests {
+    if got := c.isEnded(context.Background(), tc.userName, tc.token, "cid"); got != tc.want {
+      t.Errorf("isEnded(%q, %q) = %v; want %v", tc.userName, tc.token, got, tc.want)
+    }
+  }
+  SessionDeleted("end-new")
+  if !c.isEnded(context.Background(), "end-user", "end-new", "cid") {
+    t.Error("isEnded of a deleted session = false; want true")
+  }
+}
+
+//Another instance ended the sessions: they end when the cached state expires, or at once if forgotten.
+func TestUserSessionsCache(t *testing.T) {
+  t.Parallel()
+  SessionStarted("cache-session")
+  now := time.Now()
+  loads := 0
+  state := UserSessions{}
+  c := newUserSessionsCache(func(ctx context.Context, userName, correlationId string) (UserSessions, error) {
+    loads++
+    return state, nil
+  })
+  c.now = func() time.Time { return now }
+  ctx := context.Background()
+  if c.isEnded(ctx, "cache-user", "cache-session", "cid") {
+    t.Fatal("isEnded = true before the sessions were ended")
+  }
+  state = UserSessions{ Revoked: true }
+  if c.isEnded(ctx, "cache-user", "cache-session", "cid") {
+    t.Error("isEnded = true before the cached state expired")
+  }
+  now = now.Add(userSessionsTtl + time.Second)
+  if !c.isEnded(ctx, "cache-user", "cache-session", "cid") {
+    t.Error("isEnded = false after the cached state expired")
+  }
+  state = UserSessions{}
+  c.forget("cache-user")
+  if c.isEnded(ctx, "cache-user", "cache-session", "cid") {
+    t.Error("isEnded = true after the state was forgotten")
+  }
+  if loads != 3 {
+    t.Errorf("loads = %d; want 3", loads)
+  }
+}
@@ -80,7 +80,9 @@ $ openssl x509 -text -noout -in rootCA.crt
 import (
 	// "bytes"
 	// "bytes"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"encoding/pem"
 	"fmt"
@@ -103,8 +105,8 @@ import (
 	// "github.com/pkg/errors"
 )
 
-func genCert(template, parent *x509.Certificate, pubKey *rsa.PublicKey,
-             parentPrivKey *rsa.PrivateKey) (*x509.Certificate, []byte) {
+func genCert(template, parent *x509.Certificate, pubKey crypto.PublicKey,
+             parentPrivKey crypto.Signer) (*x509.Certificate, []byte) {
   /***
   The certificate is signed by parent. If parent is equal to template then the certificate is
   self-signed. The parameter pubKey is the public key of the certificate to be generated and
@@ -126,7 +128,7 @@ func genCert(template, parent *x509.Certificate, pubKey *rsa.PublicKey,
   return cert, certPEM
 }
 
-func rootCATemplate() x509.Certificate {
+func rootCATemplate(validity time.Duration) x509.Certificate {
   //Create the Certificate Request (CR). The CR will be signed with the private key; this provides
   //the identity of the requester.
   template := x509.Certificate{
@@ -135,7 +137,7 @@ func rootCATemplate() x509.Certificate {
     each certificate issued by a given CA; i.e., the issuer name and serial number identify a
     unique certificate.
     ***/
-    SerialNumber: big.NewInt(1),
+    SerialNumber: randomSerial(),
     Subject: pkix.Name{
       Organization: []string{"RootCA, Inc."},
       //https://www.iso.org/iso-3166-country-codes.html
@@ -147,7 +149,7 @@ func rootCATemplate() x509.Certificate {
       CommonName: "JCT - Root CA",
     },
     NotBefore: time.Now(),
-    NotAfter: time.Now().AddDate(0, 0, 7),
+    NotAfter: time.Now().Add(validity),
     IsCA: true,  //A CA certificate.
     BasicConstraintsValid: true,
     //Specify how the certificate's public key may be used.
@@ -168,25 +170,22 @@ func rootCATemplate() x509.Certificate {
   return template
 }
 
-func GenRootCA() (*x509.Certificate, []byte, *rsa.PrivateKey) {
-  template := rootCATemplate()
+func GenRootCA(keyType KeyType, validity time.Duration) (*x509.Certificate, []byte, crypto.Signer) {
+  template := rootCATemplate(validity)
   /***
   A Key Pair consists of a Private and Public key. The private key must be secured; the public key
   is derived from the private key and can be shared. Before generating a certificate, a Key Pair is
   needed.
   ***/
-  privKey, err := rsa.GenerateKey(rand.Reader, 2048)  //Generate a RSA keypair.
-  if err != nil {
-    panic("Failed to generate a RSA keypair.\n" + err.Error())
-  }
+  privKey := mustGenerateKey(keyType)
   //Create a self-signed certificate.
-  cert, certPEM := genCert(&template, &template, &privKey.PublicKey, privKey)
+  cert, certPEM := genCert(&template, &template, privKey.Public(), privKey)
   return cert, certPEM, privKey
 }
 
-func intermediateCATemplate() x509.Certificate {
+func intermediateCATemplate(validity time.Duration) x509.Certificate {
   template := x509.Certificate{
-    SerialNumber: big.NewInt(1),
+    SerialNumber: randomSerial(),
     Subject: pkix.Name{
       Organization: []string{"Intermediate CA, Inc."},
       Country: []string{"US"},
@@ -197,14 +196,14 @@ func intermediateCATemplate() x509.Certificate {
       CommonName: "JCT - Inter CA",
     },
     NotBefore: time.Now(),
-    NotAfter: time.Now().AddDate(0, 0, 7),
+    NotAfter: time.Now().Add(validity),
     IsCA: true,
     BasicConstraintsValid: true,
     KeyUsage: x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature | x509.KeyUsageCRLSign,
     //CAs/ICAs should not have any EKUs specified
     ExtKeyUsage: []x509.ExtKeyUsage{},
-    // MaxPathLenZero: false,
-    // MaxPathLen: 1,
+    //It issues the certificates of the servers and the clients, not other CAs.
+    MaxPathLenZero: true,
     // IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
   }
   return template
@@ -222,20 +221,17 @@ client certificates, such as a cluster certificate.
 The certificate chain needs to be verified up unto the root CA. The trust chain contains your
 certificate, concatenated with all intermediate certificates.
 ***/
-func GenIntermediateCA(cert *x509.Certificate, certPrivKey *rsa.PrivateKey) (*x509.Certificate,
-                       []byte, *rsa.PrivateKey) {
-  template := intermediateCATemplate()
-  interPrivKey, err := rsa.GenerateKey(rand.Reader, 2048);
-  if err != nil {
-    panic(err)
-  }
-  interCert, interCertPEM := genCert(&template, cert, &interPrivKey.PublicKey, certPrivKey)
+func GenIntermediateCA(cert *x509.Certificate, certPrivKey crypto.Signer, keyType KeyType,
+                       validity time.Duration) (*x509.Certificate, []byte, crypto.Signer) {
+  template := intermediateCATemplate(validity)
+  interPrivKey := mustGenerateKey(keyType)
+  interCert, interCertPEM := genCert(&template, cert, interPrivKey.Public(), certPrivKey)
   return interCert, interCertPEM, interPrivKey
 }
 
-func serverTemplate(hosts []string) x509.Certificate {
+func serverTemplate(hosts []string, validity time.Duration) x509.Certificate {
   template := x509.Certificate{
-    SerialNumber: big.NewInt(1),
+    SerialNumber: randomSerial(),
     Subject: pkix.Name{
       Organization: []string{"Company, Inc."},
       Country: []string{"US"},
@@ -246,7 +242,7 @@ func serverTemplate(hosts []string) x509.Certificate {
       CommonName: "Finance cert",
     },
     NotBefore: time.Now(),
-    NotAfter: time.Now().AddDate(0, 0, 7),  //Valid for seven day.
+    NotAfter: time.Now().Add(validity),
     IsCA: false,
     BasicConstraintsValid: true,
     KeyUsage: x509.KeyUsageDigitalSignature |
@@ -273,35 +269,103 @@ func serverTemplate(hosts []string) x509.Certificate {
   return template
 }
 
-func GenServerCert(caCert *x509.Certificate, caCertPrivKey *rsa.PrivateKey) (*x509.Certificate,
-                   []byte, []byte) {
-  template := serverTemplate([]string{"localhost", "::1", "127.0.0.1"})
-  serverPrivKey, err := rsa.GenerateKey(rand.Reader, 2048)
+//hosts are the Subject Alternative Names; e.g., []string{"localhost", "::1", "127.0.0.1"}.
+func GenServerCert(caCert *x509.Certificate, caCertPrivKey crypto.Signer, keyType KeyType, hosts []string,
+                   validity time.Duration) (*x509.Certificate, []byte, []byte) {
+  template := serverTemplate(hosts, validity)
+  serverPrivKey := mustGenerateKey(keyType)
+  leafKeyUsage(&template, serverPrivKey)
+  serverCert, serverCertPEM := genCert(&template, caCert, serverPrivKey.Public(), caCertPrivKey)
+  return serverCert, serverCertPEM, pem.EncodeToMemory(KeyToPemBlock(serverPrivKey))
+}
+
+/***
+The certificate a service presents to the services it calls, when they require one (mutual TLS,
+tls.RequireAndVerifyClientCert); name identifies the service (e.g., "finance-scheduler").
+***/
+func clientTemplate(name string, validity time.Duration) x509.Certificate {
+  return x509.Certificate{
+    SerialNumber: randomSerial(),
+    Subject: pkix.Name{
+      Organization: []string{"Company, Inc."},
+      Country: []string{"US"},
+      CommonName: name,
+    },
+    NotBefore: time.Now(),
+    NotAfter: time.Now().Add(validity),
+    BasicConstraintsValid: true,
+    KeyUsage: x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+  }
+}
+
+func GenClientCert(caCert *x509.Certificate, caCertPrivKey crypto.Signer, keyType KeyType, name string,
+                   validity time.Duration) (*x509.Certificate, []byte, []byte) {
+  template := clientTemplate(name, validity)
+  clientPrivKey := mustGenerateKey(keyType)
+  leafKeyUsage(&template, clientPrivKey)
+  clientCert, clientCertPEM := genCert(&template, caCert, clientPrivKey.Public(), caCertPrivKey)
+  return clientCert, clientCertPEM, pem.EncodeToMemory(KeyToPemBlock(clientPrivKey))
+}
+
+//Key encipherment is the key exchange of RSA; the other keys only sign (the key exchange is ECDHE).
+func leafKeyUsage(template *x509.Certificate, key crypto.Signer) {
+  if _, ok := key.(*rsa.PrivateKey); !ok {
+    template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+  }
+}
+
+/***
+A Certificate Revocation List (CRL) signed by the CA: the serial numbers of the certificates it
+issued that must no longer be accepted. number must grow with every CRL of the CA; the clients
+fetch a new one before nextUpdate.
+***/
+func GenCRL(caCert *x509.Certificate, caPrivKey crypto.Signer, revoked []x509.RevocationListEntry, number *big.Int,
+            nextUpdate time.Time) ([]byte, error) {
+  template := x509.RevocationList{
+    RevokedCertificateEntries: revoked,
+    Number: number,
+    ThisUpdate: time.Now(),
+    NextUpdate: nextUpdate,
+  }
+  crlBytes, err := x509.CreateRevocationList(rand.Reader, &template, caCert, caPrivKey)
   if err != nil {
-    panic("Failed to generate the server key.\n" + err.Error())
+    return nil, err
   }
-  serverCert, serverCertPEM := genCert(&template, caCert, &serverPrivKey.PublicKey, caCertPrivKey)
-  serverPrivKeyPEM := pem.EncodeToMemory(&pem.Block{
-    Type: "RSA PRIVATE KEY",
-    Bytes: x509.MarshalPKCS1PrivateKey(serverPrivKey),
-  })
-  return serverCert, serverCertPEM, serverPrivKeyPEM
+  return pem.EncodeToMemory(&pem.Block{
+    Type: "X509 CRL",
+    Bytes: crlBytes,
+  }), nil
+}
+
+/***
+A random serial number of 128 bits. A CA that is kept (see CertStore) issues many certificates, and
+the browsers reject two certificates with the same issuer and serial number.
+***/
+func randomSerial() *big.Int {
+  serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+  if err != nil {
+    panic("Failed to generate a serial number.\n" + err.Error())
+  }
+  return serial
 }
 
-func VerifyIntermediateCA(rootCert, interCert *x509.Certificate) {
+func VerifyIntermediateCA(rootCert, interCert *x509.Certificate) error {
   roots := x509.NewCertPool()
   roots.AddCert(rootCert)
   opts := x509.VerifyOptions{
     Roots: roots,
+    KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
   }
   //
   if _, err := interCert.Verify(opts); err != nil {
-    panic("Failed to verify certificate.\n" + err.Error())
+    return fmt.Errorf("failed to verify the intermediate CA: %w", err)
   }
-  fmt.Println("Intermediate CA verified.")
+  return nil
 }
 
-func VerifyCertificateChain(rootCert, interCert, serverCert *x509.Certificate) {
+//usage is the use of cert: x509.ExtKeyUsageServerAuth or x509.ExtKeyUsageClientAuth.
+func VerifyCertificateChain(rootCert, interCert, cert *x509.Certificate, usage x509.ExtKeyUsage) error {
   roots := x509.NewCertPool()
   roots.AddCert(rootCert)
   inter := x509.NewCertPool()
@@ -309,13 +373,13 @@ func VerifyCertificateChain(rootCert, interCert, serverCert *x509.Certificate) {
   opts := x509.VerifyOptions{
     Roots: roots,
     Intermediates: inter,
-    // Intermediates: x509.NewCertPool(),
+    KeyUsages: []x509.ExtKeyUsage{usage},
   }
   //
-  if _, err := serverCert.Verify(opts); err != nil {
-    panic("Failed to verify certificate chain.\n" + err.Error())
+  if _, err := cert.Verify(opts); err != nil {
+    return fmt.Errorf("failed to verify the certificate chain of %s: %w", cert.Subject.CommonName, err)
   }
-  fmt.Println("Certificate chain is valid.")
+  return nil
 }
 
 //Return a copy of the system cert pool; on error, return an empty pool.
@@ -348,6 +412,16 @@ func KeyToPemBlock(key interface{}) *pem.Block {
         Bytes: b,
       }
     }
+  case ed25519.PrivateKey:
+    //Ed25519 has no key format of its own; PKCS #8 is the generic one.
+    if b, err := x509.MarshalPKCS8PrivateKey(k); err != nil {
+      panic("Unable to marshal Ed25519 private key.\n" + err.Error())
+    } else {
+      return &pem.Block{
+        Type: "PRIVATE KEY",
+        Bytes: b,
+      }
+    }
   default:
     return nil
   }
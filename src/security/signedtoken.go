@@ -0,0 +1,58 @@
+package security
+
+/***
+Tokens that are not stored: the payload and the expiration are signed with HMAC-SHA256 under a
+server key, and the purpose is part of the signature, so a token issued for one purpose (e.g., the
+verification of a sign-up) cannot be used for another. The format is
+<payload (base64url)>.<expiration (Unix seconds)>.<signature (base64url)>.
+***/
+
+import (
+  "crypto/hmac"
+  "crypto/sha256"
+  "encoding/base64"
+  "errors"
+  "strconv"
+  "strings"
+  "time"
+)
+
+var (
+  ErrInvalidSignedToken = errors.New("invalid token")
+  ErrExpiredSignedToken = errors.New("expired token")
+)
+
+func signToken(key []byte, purpose, payload, expires string) []byte {
+  mac := hmac.New(sha256.New, key)
+  mac.Write([]byte(purpose + "\x00" + payload + "\x00" + expires))
+  return mac.Sum(nil)
+}
+
+func SignToken(key []byte, purpose, payload string, expires time.Time) string {
+  p := base64.RawURLEncoding.EncodeToString([]byte(payload))
+  e := strconv.FormatInt(expires.Unix(), 10)
+  return p + "." + e + "." + base64.RawURLEncoding.EncodeToString(signToken(key, purpose, p, e))
+}
+
+//Return the payload of a token signed for the purpose; the signature is checked before the expiration.
+func VerifySignedToken(key []byte, purpose, token string, now time.Time) (string, error) {
+  parts := strings.Split(token, ".")
+  if len(parts) != 3 {
+    return "", ErrInvalidSignedToken
+  }
+  sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+  if err != nil || !hmac.Equal(sig, signToken(key, purpose, parts[0], parts[1])) {
+    return "", ErrInvalidSignedToken
+  }
+  expires, err := strconv.ParseInt(parts[1], 10, 64)
+  if err != nil {
+    return "", ErrInvalidSignedToken
+  } else if now.Unix() >= expires {
+    return "", ErrExpiredSignedToken
+  }
+  payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+  if err != nil {
+    return "", ErrInvalidSignedToken
+  }
+  return string(payload), nil
+}
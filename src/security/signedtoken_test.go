@@ -0,0 +1,56 @@
+// Testing the functions in signedtoken.go.
+package security
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="SignedToken"
+***/
+
+import (
+  "errors"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestSignedToken(t *testing.T) {
+  t.Parallel()
+  key := []byte("0123456789abcdef0123456789abcdef")
+  now := time.Unix(1700000000, 0)
+  token := SignToken(key, "signup", "42", now.Add(time.Hour))
+  parts := strings.Split(token, ".")
+  type test struct {
+    name string
+    key []byte
+    purpose string
+    token string
+    now time.Time
+    want string
+    wantErr error
+  }
+  var tests = []test {
+    { name: "valid", key: key, purpose: "signup", token: token, now: now, want: "42" },
+    { name: "expired", key: key, purpose: "signup", token: token, now: now.Add(time.Hour), wantErr: ErrExpiredSignedToken },
+    { name: "other purpose", key: key, purpose: "reset", token: token, now: now, wantErr: ErrInvalidSignedToken },
+    { name: "other key", key: []byte("another key"), purpose: "signup", token: token, now: now,
+      wantErr: ErrInvalidSignedToken },
+    { name: "longer expiration", key: key, purpose: "signup", now: now, wantErr: ErrInvalidSignedToken,
+      token: parts[0] + ".9999999999." + parts[2] },
+    { name: "other payload", key: key, purpose: "signup", now: now, wantErr: ErrInvalidSignedToken,
+      token: "NDM." + parts[1] + "." + parts[2] },
+    { name: "malformed", key: key, purpose: "signup", token: "abc", now: now, wantErr: ErrInvalidSignedToken },
+  }
+  for _, tc := range tests {
+    got, err := VerifySignedToken(tc.key, tc.purpose, tc.token, tc.now)
+    if !errors.Is(err, tc.wantErr) || got != tc.want {
+      t.Errorf("%s: VerifySignedToken = %q, %v; want %q, %v", tc.name, got, err, tc.want, tc.wantErr)
+    }
+  }
+}
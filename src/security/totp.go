@@ -0,0 +1,155 @@
+package security
+
+/***
+Time-based One-Time Password (TOTP) as specified in RFC 6238 (https://www.rfc-editor.org/rfc/rfc6238)
+on top of HOTP (RFC 4226). The parameters are the ones every authenticator app (Google
+Authenticator, Microsoft Authenticator, FreeOTP, 1Password, ...) assumes by default: HMAC-SHA1, a
+time step of 30 seconds, and 6 digits.
+
+The shared secret is exchanged with the authenticator app through a provisioning URI
+(otpauth://totp/...); the app either scans it as a QR code or the user types the base32 secret.
+***/
+
+import (
+  "crypto/hmac"
+  "crypto/rand"
+  "crypto/sha1"
+  "crypto/subtle"
+  "encoding/base32"
+  "encoding/binary"
+  "errors"
+  "fmt"
+  "net/url"
+  "strings"
+  "time"
+)
+
+const (
+  TotpDigits = 6
+  TotpPeriod = 30  //Seconds.
+  //Accept the codes of the previous and the next time step to tolerate clock drift.
+  TotpSkew = 1
+  //160 bits, the size recommended by RFC 4226 for HMAC-SHA1.
+  totpSecretSize = 20
+  RecoveryCodesCount = 10
+)
+
+var b32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+//Return a new random shared secret encoded in base32 (without padding).
+func GenerateTotpSecret() (string, error) {
+  b := make([]byte, totpSecretSize)
+  if _, err := rand.Read(b); err != nil {
+    return "", err
+  }
+  return b32NoPadding.EncodeToString(b), nil
+}
+
+func decodeTotpSecret(secret string) ([]byte, error) {
+  //Authenticator apps display the secret in groups and in lowercase; accept it either way.
+  s := strings.ToUpper(strings.ReplaceAll(strings.TrimSpace(secret), " ", ""))
+  s = strings.TrimRight(s, "=")
+  if s == "" {
+    return nil, errors.New("empty TOTP secret")
+  }
+  return b32NoPadding.DecodeString(s)
+}
+
+//The number of time steps since the Unix epoch.
+func TotpCounter(t time.Time) int64 {
+  return t.Unix() / TotpPeriod
+}
+
+//HOTP (RFC 4226): dynamic truncation of HMAC-SHA1(key, counter) to the given number of digits.
+func hotp(key []byte, counter int64, digits int) string {
+  var msg [8]byte
+  binary.BigEndian.PutUint64(msg[:], uint64(counter))
+  mac := hmac.New(sha1.New, key)
+  mac.Write(msg[:])
+  sum := mac.Sum(nil)
+  offset := sum[len(sum) - 1] & 0x0f
+  code := binary.BigEndian.Uint32(sum[offset:offset + 4]) & 0x7fffffff
+  mod := uint32(1)
+  for i := 0; i < digits; i++ {
+    mod *= 10
+  }
+  return fmt.Sprintf("%0*d", digits, code % mod)
+}
+
+//Return the code for the time step that contains t.
+func TotpCode(secret string, t time.Time) (string, error) {
+  key, err := decodeTotpSecret(secret)
+  if err != nil {
+    return "", err
+  }
+  return hotp(key, TotpCounter(t), TotpDigits), nil
+}
+
+/***
+Check a code against the time steps around t. A code is accepted only for a time step after
+lastCounter (the last step accepted for this user), so a code cannot be replayed; the caller must
+store the returned counter.
+***/
+func VerifyTotp(secret, code string, t time.Time, lastCounter int64) (int64, bool) {
+  code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+  if len(code) != TotpDigits {
+    return 0, false
+  }
+  key, err := decodeTotpSecret(secret)
+  if err != nil {
+    return 0, false
+  }
+  counter := TotpCounter(t)
+  for c := counter - TotpSkew; c <= counter + TotpSkew; c++ {
+    if c <= lastCounter {
+      continue
+    }
+    if subtle.ConstantTimeCompare([]byte(hotp(key, c, TotpDigits)), []byte(code)) == 1 {
+      return c, true
+    }
+  }
+  return 0, false
+}
+
+/***
+The key URI format understood by the authenticator apps
+(https://github.com/google/google-authenticator/wiki/Key-Uri-Format). Encode it in a QR code or show
+it to the user as is.
+***/
+func TotpProvisioningUri(issuer, account, secret string) string {
+  label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+  v := url.Values{}
+  v.Set("secret", secret)
+  v.Set("issuer", issuer)
+  v.Set("algorithm", "SHA1")
+  v.Set("digits", fmt.Sprint(TotpDigits))
+  v.Set("period", fmt.Sprint(TotpPeriod))
+  return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+/***
+One-time recovery codes for when the authenticator is not available. Each code has 50 random bits
+written as two groups of five lowercase base32 characters (e.g., "k3v7q-9xmpa"). Only their hashes
+are stored.
+***/
+func GenerateRecoveryCodes(n int) ([]string, error) {
+  codes := make([]string, 0, n)
+  b := make([]byte, 7)  //56 bits; the first 10 base32 characters carry 50 of them.
+  for i := 0; i < n; i++ {
+    if _, err := rand.Read(b); err != nil {
+      return nil, err
+    }
+    s := strings.ToLower(b32NoPadding.EncodeToString(b))
+    codes = append(codes, s[:5] + "-" + s[5:10])
+  }
+  return codes, nil
+}
+
+//Normalize a recovery code typed by the user before comparing it with the stored hashes.
+func NormalizeRecoveryCode(code string) string {
+  s := strings.ToLower(strings.NewReplacer(" ", "", "-", "").Replace(strings.TrimSpace(code)))
+  if len(s) != 10 {
+    return s
+  }
+  return s[:5] + "-" + s[5:]
+}
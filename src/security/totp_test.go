@@ -0,0 +1,139 @@
+// Testing the functions in totp.go.
+package security
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="Totp"
+***/
+
+import (
+  "strings"
+  "testing"
+  "time"
+)
+
+//The ASCII key "12345678901234567890" of the RFC 6238 test vectors, in base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+  t.Parallel()
+  //RFC 6238, Appendix B (SHA1); the 8-digit values truncated to the last 6 digits.
+  type test struct {
+    unix int64
+    want string
+  }
+  var tests = []test {
+    { unix: 59, want: "287082" },
+    { unix: 1111111109, want: "081804" },
+    { unix: 1111111111, want: "050471" },
+    { unix: 1234567890, want: "005924" },
+    { unix: 2000000000, want: "279037" },
+    { unix: 20000000000, want: "353130" },
+  }
+  for _, tc := range tests {
+    got, err := TotpCode(rfcSecret, time.Unix(tc.unix, 0))
+    if err != nil {
+      t.Fatalf("TotpCode(%d): %v", tc.unix, err)
+    }
+    if got != tc.want {
+      t.Errorf("TotpCode(%d) = %s; want %s", tc.unix, got, tc.want)
+    }
+  }
+  //Lowercase and grouped secrets are accepted.
+  got, _ := TotpCode("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", time.Unix(59, 0))
+  if got != "287082" {
+    t.Errorf("TotpCode(lowercase) = %s; want 287082", got)
+  }
+  if _, err := TotpCode("not base32!", time.Unix(59, 0)); err == nil {
+    t.Error("TotpCode(invalid secret) should fail")
+  }
+}
+
+func TestVerifyTotp(t *testing.T) {
+  t.Parallel()
+  now := time.Unix(1111111111, 0)
+  counter := TotpCounter(now)
+  prev, _ := TotpCode(rfcSecret, now.Add(-TotpPeriod * time.Second))
+  cur, _ := TotpCode(rfcSecret, now)
+  next, _ := TotpCode(rfcSecret, now.Add(TotpPeriod * time.Second))
+  old, _ := TotpCode(rfcSecret, now.Add(-2 * TotpPeriod * time.Second))
+  type test struct {
+    name string
+    code string
+    last int64
+    wantOk bool
+    wantCounter int64
+  }
+  var tests = []test {
+    { name: "current", code: cur, last: 0, wantOk: true, wantCounter: counter },
+    { name: "previous step", code: prev, last: 0, wantOk: true, wantCounter: counter - 1 },
+    { name: "next step", code: next, last: 0, wantOk: true, wantCounter: counter + 1 },
+    { name: "outside the window", code: old, last: 0, wantOk: false },
+    { name: "replayed", code: cur, last: counter, wantOk: false },
+    { name: "spaces", code: cur[:3] + " " + cur[3:], last: 0, wantOk: true, wantCounter: counter },
+    { name: "wrong length", code: "12345", last: 0, wantOk: false },
+  }
+  for _, tc := range tests {
+    gotCounter, gotOk := VerifyTotp(rfcSecret, tc.code, now, tc.last)
+    if gotOk != tc.wantOk || (gotOk && gotCounter != tc.wantCounter) {
+      t.Errorf("%s: VerifyTotp = (%d, %v); want (%d, %v)", tc.name, gotCounter, gotOk, tc.wantCounter, tc.wantOk)
+    }
+  }
+}
+
+func TestGenerateTotpSecret(t *testing.T) {
+  t.Parallel()
+  s1, err := GenerateTotpSecret()
+  if err != nil {
+    t.Fatal(err)
+  }
+  s2, _ := GenerateTotpSecret()
+  if len(s1) != 32 || s1 == s2 {
+    t.Errorf("GenerateTotpSecret = %s, %s; want two distinct 32-character secrets", s1, s2)
+  }
+  if _, err := TotpCode(s1, time.Now()); err != nil {
+    t.Errorf("TotpCode(generated secret): %v", err)
+  }
+}
+
+func TestTotpProvisioningUri(t *testing.T) {
+  t.Parallel()
+  got := TotpProvisioningUri("Fin Finance", "jane doe", "ABC")
+  want := "otpauth://totp/Fin%20Finance:jane%20doe?"
+  if !strings.HasPrefix(got, want) {
+    t.Errorf("TotpProvisioningUri = %s; want prefix %s", got, want)
+  }
+  for _, p := range []string{ "secret=ABC", "issuer=Fin+Finance", "digits=6", "period=30", "algorithm=SHA1" } {
+    if !strings.Contains(got, p) {
+      t.Errorf("TotpProvisioningUri = %s; missing %s", got, p)
+    }
+  }
+}
+
+func TestRecoveryCodes(t *testing.T) {
+  t.Parallel()
+  codes, err := GenerateRecoveryCodes(RecoveryCodesCount)
+  if err != nil {
+    t.Fatal(err)
+  }
+  seen := map[string]bool{}
+  for _, c := range codes {
+    if len(c) != 11 || c[5] != '-' || seen[c] {
+      t.Errorf("GenerateRecoveryCodes: invalid or duplicated code %q", c)
+    }
+    seen[c] = true
+    if got := NormalizeRecoveryCode(" " + strings.ToUpper(strings.ReplaceAll(c, "-", "")) + " "); got != c {
+      t.Errorf("NormalizeRecoveryCode = %q; want %q", got, c)
+    }
+  }
+  if len(codes) != RecoveryCodesCount {
+    t.Errorf("GenerateRecoveryCodes returned %d codes; want %d", len(codes), RecoveryCodesCount)
+  }
+}
@@ -0,0 +1,31 @@
+package security
+
+/***
+Single-use tokens sent to the user in a link (e.g., a password reset). The token has 256 random
+bits, so a fast hash (SHA-256) is enough to keep it secret at rest; only the hash is stored, and the
+token received in the link is hashed again to find it.
+***/
+
+import (
+  "crypto/rand"
+  "crypto/sha256"
+  "encoding/base64"
+  "encoding/hex"
+)
+
+const urlTokenSize = 32
+
+//Return a new URL-safe token and the hash to store.
+func GenerateUrlToken() (string, string, error) {
+  b := make([]byte, urlTokenSize)
+  if _, err := rand.Read(b); err != nil {
+    return "", "", err
+  }
+  token := base64.RawURLEncoding.EncodeToString(b)
+  return token, HashUrlToken(token), nil
+}
+
+func HashUrlToken(token string) string {
+  sum := sha256.Sum256([]byte(token))
+  return hex.EncodeToString(sum[:])
+}
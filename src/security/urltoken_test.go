@@ -0,0 +1,46 @@
+// Testing the functions in urltoken.go.
+package security
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="UrlToken"
+***/
+
+import (
+  "net/url"
+  "testing"
+)
+
+func TestGenerateUrlToken(t *testing.T) {
+  t.Parallel()
+  t1, h1, err := GenerateUrlToken()
+  if err != nil {
+    t.Fatal(err)
+  }
+  t2, h2, _ := GenerateUrlToken()
+  if len(t1) != 43 || t1 == t2 || h1 == h2 {
+    t.Errorf("GenerateUrlToken = (%s, %s), (%s, %s); want two distinct 43-character tokens", t1, h1, t2, h2)
+  }
+  if url.QueryEscape(t1) != t1 {
+    t.Errorf("GenerateUrlToken = %s; want a URL-safe token", t1)
+  }
+  if HashUrlToken(t1) != h1 || len(h1) != 64 {
+    t.Errorf("HashUrlToken(%s) = %s; want %s", t1, HashUrlToken(t1), h1)
+  }
+}
+
+func TestHashUrlToken(t *testing.T) {
+  t.Parallel()
+  //SHA-256 of "abc" (FIPS 180-2, Appendix B.1).
+  want := "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"
+  if got := HashUrlToken("abc"); got != want {
+    t.Errorf("HashUrlToken(abc) = %s; want %s", got, want)
+  }
+}
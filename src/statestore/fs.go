@@ -0,0 +1,76 @@
+package statestore
+
+import (
+  "context"
+  "errors"
+  "fmt"
+  "io/fs"
+  "os"
+  "path/filepath"
+)
+
+/***
+A JSON file per user and page: <root>/<namespace>/<user>/<page>.txt (the layout used before the
+state store, so the saved state is kept). A file is replaced atomically: the state is written to a
+temporary file in the same directory, which is then renamed over the old one; a reader sees either
+the old or the new state, never a partial write.
+***/
+type FsStore struct {
+  Root string
+}
+
+func NewFsStore(root string) (*FsStore, error) {
+  if err := os.MkdirAll(root, 0o700); err != nil {
+    return nil, err
+  }
+  return &FsStore{ Root: root }, nil
+}
+
+//Each part of the path must be a single name; e.g., a username cannot be "../admin".
+func (s *FsStore) path(namespace, userName, page string) (string, error) {
+  for _, name := range []string{ namespace, userName, page } {
+    if name == "" || name == "." || name == ".." || filepath.Base(name) != name {
+      return "", fmt.Errorf("invalid name for the state store: %q", name)
+    }
+  }
+  return filepath.Join(s.Root, namespace, userName, page + ".txt"), nil
+}
+
+func (s *FsStore) Load(ctx context.Context, namespace, userName, page string) ([]byte, error) {
+  path, err := s.path(namespace, userName, page)
+  if err != nil {
+    return nil, err
+  }
+  data, err := os.ReadFile(path)
+  if errors.Is(err, fs.ErrNotExist) {
+    return nil, nil
+  }
+  return data, err
+}
+
+func (s *FsStore) Save(ctx context.Context, namespace, userName, page string, data []byte) error {
+  path, err := s.path(namespace, userName, page)
+  if err != nil {
+    return err
+  }
+  dir := filepath.Dir(path)
+  if err = os.MkdirAll(dir, 0o700); err != nil {
+    return err
+  }
+  //CreateTemp creates the file with mode 0600.
+  tmp, err := os.CreateTemp(dir, "." + page + "-*.tmp")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())  //Fails harmlessly after the rename.
+  if _, err = tmp.Write(data); err == nil {
+    err = tmp.Sync()
+  }
+  if cerr := tmp.Close(); err == nil {
+    err = cerr
+  }
+  if err != nil {
+    return err
+  }
+  return os.Rename(tmp.Name(), path)
+}
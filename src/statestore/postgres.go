@@ -0,0 +1,17 @@
+package statestore
+
+import (
+  "context"
+  bank "finance/databases/banking"
+)
+
+//A JSONB row per user and page in fin.user_page_state; it is shared by all the instances of the server.
+type PgStore struct{}
+
+func (s PgStore) Load(ctx context.Context, namespace, userName, page string) ([]byte, error) {
+  return bank.DbGetPageState(ctx, userName, namespace, page)
+}
+
+func (s PgStore) Save(ctx context.Context, namespace, userName, page string, data []byte) error {
+  return bank.DbSavePageState(ctx, userName, namespace, page, data)
+}
@@ -0,0 +1,122 @@
+package statestore
+
+/***
+The state of the pages of each user: the fields of the calculators, the results shown, and the
+selected subpage. A page loads its state when a request needs it and saves it before the request
+ends; nothing is kept in memory between requests, so every instance of the server sees the last
+saved state. The backend is chosen by the environment variable STATE_STORE:
+(1) "fs" (default): FsStore keeps a JSON file per user and page under the data directory.
+(2) "postgres": PgStore keeps a JSONB row per user and page in fin.user_page_state.
+
+Notes about synchronization:
+(1) Browsers share the cookies among tabs and windows, so two requests of the same user (and page)
+    can run at the same time. Open locks the page of the user until the returned function is
+    called; the requests for the same page are serialized, and the other pages are not blocked.
+(2) The lock is local to the instance. Across instances, the last request to save wins, which is
+    the same outcome as two tabs on the same instance one after the other.
+***/
+
+import (
+  "context"
+  "encoding/json"
+  "finance/config"
+  "fmt"
+  "sync"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+type Store interface {
+  //The saved state of the page; nil (and no error) if there is none.
+  Load(ctx context.Context, namespace, userName, page string) ([]byte, error)
+  Save(ctx context.Context, namespace, userName, page string, data []byte) error
+}
+
+var current Store
+
+//The backend chosen by STATE_STORE; dataDir is the root of the files of FsStore.
+func New(dataDir, correlationId string) (Store, error) {
+  switch config.GetStateStore(correlationId) {
+  case "postgres":
+    logger.LogInfo("The state of the pages is kept in Postgres.", correlationId)
+    return PgStore{}, nil
+  default:
+    logger.LogInfo(fmt.Sprintf("The state of the pages is kept in %s.", dataDir), correlationId)
+    return NewFsStore(dataDir)
+  }
+}
+
+//Set the backend; it must be called before the server accepts requests.
+func Use(s Store) {
+  current = s
+}
+
+type pageLock struct {
+  sync.Mutex
+  refs int  //Requests holding or waiting for the lock.
+}
+
+var locks = struct {
+  sync.Mutex
+  pages map[string]*pageLock
+} { pages: map[string]*pageLock{} }
+
+//Lock the page of the user; the returned function unlocks it. Unused locks are removed.
+func lock(namespace, userName, page string) func() {
+  key := namespace + "\x00" + userName + "\x00" + page
+  locks.Lock()
+  pl, ok := locks.pages[key]
+  if !ok {
+    pl = &pageLock{}
+    locks.pages[key] = pl
+  }
+  pl.refs++
+  locks.Unlock()
+  pl.Lock()
+  return func() {
+    pl.Unlock()
+    locks.Lock()
+    pl.refs--
+    if pl.refs == 0 {
+      delete(locks.pages, key)
+    }
+    locks.Unlock()
+  }
+}
+
+/***
+Lock the page of the user and load its saved state into v, which holds the default values; they are
+kept if there is no state or it cannot be read. The caller must call the returned function (after
+Save) to unlock the page.
+***/
+func Open(ctx context.Context, namespace, userName, page string, v any, correlationId string) func() {
+  unlock := lock(namespace, userName, page)
+  if current == nil {
+    logger.LogError("The state store is not set.", correlationId)
+    return unlock
+  }
+  data, err := current.Load(ctx, namespace, userName, page)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error loading the state of %s/%s: %v", namespace, page, err), correlationId)
+  } else if len(data) == 0 {
+    logger.LogInfo(fmt.Sprintf("No saved state for %s/%s; using the default values.", namespace, page),
+      correlationId)
+  } else if err = json.Unmarshal(data, v); err != nil {
+    //Write error, but continue with default values.
+    logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
+  }
+  return unlock
+}
+
+//Save the state of the page; it is saved even if the request timed out.
+func Save(ctx context.Context, namespace, userName, page string, v any, correlationId string) {
+  if current == nil {
+    logger.LogError("The state store is not set.", correlationId)
+    return
+  }
+  data, err := json.Marshal(v)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
+  } else if err = current.Save(context.WithoutCancel(ctx), namespace, userName, page, data); err != nil {
+    logger.LogError(fmt.Sprintf("Error saving the state of %s/%s: %v", namespace, page, err), correlationId)
+  }
+}
@@ -0,0 +1,121 @@
+// Testing the functions in statestore.go and fs.go.
+package statestore
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="FsStore"
+***/
+
+import (
+  "context"
+  "os"
+  "path/filepath"
+  "sync"
+  "testing"
+)
+
+type testFields struct {
+  CurrentPage string `json:"currentPage"`
+  Count int `json:"count"`
+}
+
+func TestFsStore(t *testing.T) {
+  t.Parallel()
+  ctx := context.Background()
+  s, err := NewFsStore(filepath.Join(t.TempDir(), "data"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if data, err := s.Load(ctx, "finances", "jdoe", "mortgage"); err != nil || data != nil {
+    t.Errorf("Load of a missing page = %q, %v; want nil, nil", data, err)
+  }
+  for _, state := range []string{ `{"count":1}`, `{"count":2}` } {
+    if err = s.Save(ctx, "finances", "jdoe", "mortgage", []byte(state)); err != nil {
+      t.Fatalf("Save: %v", err)
+    }
+    if data, err := s.Load(ctx, "finances", "jdoe", "mortgage"); err != nil || string(data) != state {
+      t.Errorf("Load = %q, %v; want %q", data, err, state)
+    }
+  }
+  //The layout used before the state store, and no temporary files left behind.
+  entries, err := os.ReadDir(filepath.Join(s.Root, "finances", "jdoe"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(entries) != 1 || entries[0].Name() != "mortgage.txt" {
+    t.Errorf("Files = %v; want [mortgage.txt]", entries)
+  }
+  for _, userName := range []string{ "", ".", "..", "../admin", "a/b" } {
+    if err = s.Save(ctx, "finances", userName, "mortgage", []byte("{}")); err == nil {
+      t.Errorf("Save for user %q succeeded; want an error", userName)
+    }
+  }
+}
+
+//A store in memory.
+type memStore struct {
+  mu sync.Mutex
+  data map[string][]byte
+}
+
+func (m *memStore) Load(ctx context.Context, namespace, userName, page string) ([]byte, error) {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  return m.data[namespace + "/" + userName + "/" + page], nil
+}
+
+func (m *memStore) Save(ctx context.Context, namespace, userName, page string, data []byte) error {
+  m.mu.Lock()
+  defer m.mu.Unlock()
+  m.data[namespace + "/" + userName + "/" + page] = data
+  return nil
+}
+
+//It sets the package store, so it cannot run in parallel with other tests that use it.
+func TestOpenSave(t *testing.T) {
+  store := &memStore{ data: map[string][]byte{ "finances/bad/page": []byte("{not json") } }
+  Use(store)
+  defer Use(nil)
+  ctx := context.Background()
+  //Defaults when there is no state, and when it is corrupt.
+  for _, userName := range []string{ "new", "bad" } {
+    f := &testFields{ CurrentPage: "rhs-ui1" }
+    unlock := Open(ctx, "finances", userName, "page", f, "")
+    unlock()
+    if f.CurrentPage != "rhs-ui1" || f.Count != 0 {
+      t.Errorf("Open for %s = %+v; want the defaults", userName, *f)
+    }
+  }
+  //Concurrent requests of the same user and page are serialized; none of the increments is lost.
+  const n = 50
+  var wg sync.WaitGroup
+  for i := 0; i < n; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      f := &testFields{}
+      unlock := Open(ctx, "finances", "jdoe", "page", f, "")
+      defer unlock()
+      f.Count++
+      Save(ctx, "finances", "jdoe", "page", f, "")
+    }()
+  }
+  wg.Wait()
+  f := &testFields{}
+  Open(ctx, "finances", "jdoe", "page", f, "")()
+  if f.Count != n {
+    t.Errorf("Count = %d; want %d", f.Count, n)
+  }
+  locks.Lock()
+  defer locks.Unlock()
+  if len(locks.pages) != 0 {
+    t.Errorf("%d lock(s) left; want 0", len(locks.pages))
+  }
+}
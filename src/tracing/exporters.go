@@ -0,0 +1,150 @@
+package tracing
+
+import (
+  "bytes"
+  "context"
+  "encoding/json"
+  "fmt"
+  "io"
+  "net/http"
+  "strconv"
+  "sync"
+  "time"
+)
+
+//It writes a span per line as JSON; e.g., to stdout or a file, for local development.
+type WriterExporter struct {
+  mu sync.Mutex
+  w io.Writer
+}
+
+func NewWriterExporter(w io.Writer) *WriterExporter {
+  return &WriterExporter{ w: w }
+}
+
+func (e *WriterExporter) Export(ctx context.Context, spans []SpanData) error {
+  e.mu.Lock()
+  defer e.mu.Unlock()
+  enc := json.NewEncoder(e.w)
+  for _, s := range spans {
+    if err := enc.Encode(s); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+/***
+It sends the spans to an OpenTelemetry collector with OTLP over HTTP, JSON encoded:
+https://opentelemetry.io/docs/specs/otlp/#otlphttp
+The endpoint is the URL of the traces; e.g., http://otel-collector:4318/v1/traces.
+***/
+type OtlpExporter struct {
+  endpoint string
+  serviceName string
+  client *http.Client
+}
+
+func NewOtlpExporter(endpoint, serviceName string) *OtlpExporter {
+  return &OtlpExporter{ endpoint: endpoint, serviceName: serviceName, client: &http.Client{ Timeout: 10 * time.Second } }
+}
+
+//The OTLP JSON messages; the IDs are hex strings, and the 64-bit integers are strings.
+type otlpValue struct {
+  StringValue *string  `json:"stringValue,omitempty"`
+  IntValue *string  `json:"intValue,omitempty"`
+  DoubleValue *float64  `json:"doubleValue,omitempty"`
+  BoolValue *bool  `json:"boolValue,omitempty"`
+}
+
+type otlpAttr struct {
+  Key string  `json:"key"`
+  Value otlpValue  `json:"value"`
+}
+
+type otlpStatus struct {
+  Code int  `json:"code"`  //1 is OK; 2 is ERROR.
+  Message string  `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+  TraceId string  `json:"traceId"`
+  SpanId string  `json:"spanId"`
+  ParentSpanId string  `json:"parentSpanId,omitempty"`
+  Name string  `json:"name"`
+  Kind int  `json:"kind"`
+  StartTimeUnixNano string  `json:"startTimeUnixNano"`
+  EndTimeUnixNano string  `json:"endTimeUnixNano"`
+  Attributes []otlpAttr  `json:"attributes,omitempty"`
+  Status otlpStatus  `json:"status"`
+}
+
+var otlpKinds = map[SpanKind]int{ KindInternal: 1, KindServer: 2, KindClient: 3 }
+
+func otlpAttrOf(key string, value any) otlpAttr {
+  a := otlpAttr{ Key: key }
+  switch v := value.(type) {
+  case int64:
+    s := strconv.FormatInt(v, 10)
+    a.Value.IntValue = &s
+  case float64:
+    a.Value.DoubleValue = &v
+  case bool:
+    a.Value.BoolValue = &v
+  default:
+    s := fmt.Sprint(v)
+    a.Value.StringValue = &s
+  }
+  return a
+}
+
+func (e *OtlpExporter) Export(ctx context.Context, spans []SpanData) error {
+  out := make([]otlpSpan, 0, len(spans))
+  for _, s := range spans {
+    o := otlpSpan{
+      TraceId: s.TraceID,
+      SpanId: s.SpanID,
+      ParentSpanId: s.ParentSpanID,
+      Name: s.Name,
+      Kind: otlpKinds[s.Kind],
+      StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+      EndTimeUnixNano: strconv.FormatInt(s.End.UnixNano(), 10),
+      Status: otlpStatus{ Code: 1 },
+    }
+    for k, v := range s.Attributes {
+      o.Attributes = append(o.Attributes, otlpAttrOf(k, v))
+    }
+    if s.Error != "" {
+      o.Status = otlpStatus{ Code: 2, Message: s.Error }
+    }
+    out = append(out, o)
+  }
+  body, err := json.Marshal(map[string]any{
+    "resourceSpans": []any{
+      map[string]any{
+        "resource": map[string]any{ "attributes": []otlpAttr{ otlpAttrOf("service.name", e.serviceName) } },
+        "scopeSpans": []any{
+          map[string]any{ "scope": map[string]string{ "name": "finance/tracing" }, "spans": out },
+        },
+      },
+    },
+  })
+  if err != nil {
+    return err
+  }
+  req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+  if err != nil {
+    return err
+  }
+  req.Header.Set("Content-Type", "application/json")
+  res, err := e.client.Do(req)
+  if err != nil {
+    return err
+  }
+  defer res.Body.Close()
+  io.Copy(io.Discard, res.Body)
+  if res.StatusCode / 100 != 2 {
+    return fmt.Errorf("the collector answered %s", res.Status)
+  }
+  return nil
+}
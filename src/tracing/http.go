@@ -0,0 +1,92 @@
+package tracing
+
+import (
+  "context"
+  "fmt"
+  "net/http"
+  "strings"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+//It keeps the span of the request and the status code of the response.
+type spanWriter struct {
+  http.ResponseWriter
+  ctx context.Context
+  status int
+  wroteHeader bool
+}
+
+func (w *spanWriter) WriteHeader(status int) {
+  if !w.wroteHeader {
+    w.status = status
+    w.wroteHeader = true
+  }
+  w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *spanWriter) Write(b []byte) (int, error) {
+  w.wroteHeader = true
+  return w.ResponseWriter.Write(b)
+}
+
+//For http.ResponseController; e.g., to flush.
+func (w *spanWriter) Unwrap() http.ResponseWriter {
+  return w.ResponseWriter
+}
+
+/***
+The context with the span of the request that res answers; the code that gets the writer but not
+the request (e.g., the renderer) starts its spans from it. The writers between must implement
+Unwrap. It is context.Background() if the request is not traced.
+***/
+func WriterContext(res http.ResponseWriter) context.Context {
+  for res != nil {
+    if sw, ok := res.(*spanWriter); ok {
+      return sw.ctx
+    }
+    u, ok := res.(interface{ Unwrap() http.ResponseWriter })
+    if !ok {
+      break
+    }
+    res = u.Unwrap()
+  }
+  return context.Background()
+}
+
+/***
+A middleware that starts the span of the request; it continues the trace of the traceparent
+header, if any. It must run inside the middleware of the correlation ID, which is an attribute of
+the span, and the trace ID is logged with it; so a log entry leads to the trace and vice versa.
+***/
+func Middleware(f http.HandlerFunc) http.HandlerFunc {
+  return func(res http.ResponseWriter, req *http.Request) {
+    ctx := req.Context()
+    if sc, ok := ParseTraceParent(req.Header.Get("traceparent")); ok {
+      ctx = ContextWithRemote(ctx, sc)
+    }
+    route := req.Pattern
+    if i := strings.IndexByte(route, ' '); i >= 0 {
+      route = route[i + 1:]
+    }
+    ctx, span := Start(ctx, strings.TrimSpace(req.Method + " " + route), KindServer)
+    if span == nil {
+      f(res, req)
+      return
+    }
+    defer span.End()
+    ctxKey := middlewares.MwContextKey{}
+    correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+    logger.LogInfo(fmt.Sprintf("Trace ID: %s", span.Context().TraceID), correlationId)
+    span.SetAttr("http.request.method", req.Method)
+    span.SetAttr("url.path", req.URL.Path)
+    span.SetAttr("http.route", route)
+    span.SetAttr("correlation_id", correlationId)
+    sw := &spanWriter{ ResponseWriter: res, ctx: ctx, status: http.StatusOK }
+    f(sw, req.WithContext(ctx))
+    span.SetAttr("http.response.status_code", sw.status)
+    if sw.status >= http.StatusInternalServerError {
+      span.RecordError(fmt.Errorf("%d %s", sw.status, http.StatusText(sw.status)))
+    }
+  }
+}
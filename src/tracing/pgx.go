@@ -0,0 +1,54 @@
+package tracing
+
+import (
+  "context"
+  "strings"
+  "github.com/jackc/pgx/v5"
+  "github.com/jackc/pgx/v5/pgxpool"
+)
+
+/***
+The tracer of the connection pool (pgxpool.Config.ConnConfig.Tracer): a span for every query
+(Query, QueryRow, and Exec) and for every wait for a connection. Only the queries done for a traced
+request have spans; the arguments are not recorded, since they can hold personal data.
+***/
+type PgxTracer struct{}
+
+var (
+  _ pgx.QueryTracer = PgxTracer{}
+  _ pgxpool.AcquireTracer = PgxTracer{}
+)
+
+//The span of a query is named after its command; e.g., "db SELECT".
+func queryName(sql string) string {
+  fields := strings.Fields(sql)
+  if len(fields) == 0 {
+    return "db"
+  }
+  return "db " + strings.ToUpper(fields[0])
+}
+
+func (PgxTracer) TraceQueryStart(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+  ctx, span := StartChild(ctx, queryName(data.SQL), KindClient)
+  span.SetAttr("db.system", "postgresql")
+  span.SetAttr("db.statement", data.SQL)
+  return ctx
+}
+
+func (PgxTracer) TraceQueryEnd(ctx context.Context, conn *pgx.Conn, data pgx.TraceQueryEndData) {
+  span := SpanFromContext(ctx)
+  span.SetAttr("db.rows_affected", data.CommandTag.RowsAffected())
+  span.RecordError(data.Err)
+  span.End()
+}
+
+func (PgxTracer) TraceAcquireStart(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireStartData) context.Context {
+  ctx, _ = StartChild(ctx, "db acquire", KindInternal)
+  return ctx
+}
+
+func (PgxTracer) TraceAcquireEnd(ctx context.Context, pool *pgxpool.Pool, data pgxpool.TraceAcquireEndData) {
+  span := SpanFromContext(ctx)
+  span.RecordError(data.Err)
+  span.End()
+}
@@ -0,0 +1,370 @@
+package tracing
+
+/***
+Distributed tracing in the manner of OpenTelemetry. A trace is the tree of the spans of a request: a
+server span for the request, and child spans for the work done for it (rendering the templates, the
+queries to the database, ...). The spans of a trace share the trace ID; each span has its own ID and
+the ID of its parent.
+
+The trace of a request continues the trace of the caller (e.g., the ingress) if the request has a
+W3C traceparent header: https://www.w3.org/TR/trace-context/#traceparent-header
+
+The spans are sent to the exporter (stdout, a file, or an OTLP collector) in batches, in the
+background. Without an exporter (Setup not called), no span is created, and the *Span methods do
+nothing on a nil span; so the callers do not check whether tracing is enabled.
+***/
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "fmt"
+  "strings"
+  "sync"
+  "time"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+func (t TraceID) String() string {
+  return hex.EncodeToString(t[:])
+}
+
+func (s SpanID) String() string {
+  return hex.EncodeToString(s[:])
+}
+
+//The identity of a span, which is propagated to its children and to the services it calls.
+type SpanContext struct {
+  TraceID TraceID
+  SpanID SpanID
+  Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool {
+  return sc.TraceID != TraceID{} && sc.SpanID != SpanID{}
+}
+
+//The value of the traceparent header (version 00).
+func (sc SpanContext) TraceParent() string {
+  flags := 0
+  if sc.Sampled {
+    flags = 1
+  }
+  return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+//Parse a traceparent header; ok is false if it is not valid (the trace then starts here).
+func ParseTraceParent(s string) (sc SpanContext, ok bool) {
+  parts := strings.Split(strings.TrimSpace(s), "-")
+  //A future version can append fields; version ff is invalid.
+  if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) ||
+     len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+    return SpanContext{}, false
+  }
+  var flags [1]byte
+  if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil {
+    return SpanContext{}, false
+  } else if _, err = hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil {
+    return SpanContext{}, false
+  } else if _, err = hex.Decode(flags[:], []byte(parts[3])); err != nil {
+    return SpanContext{}, false
+  } else if strings.ToLower(s) != s {  //The hex digits are lowercase.
+    return SpanContext{}, false
+  }
+  if sc.Sampled = flags[0] & 1 == 1; !sc.IsValid() {
+    return SpanContext{}, false
+  }
+  return sc, true
+}
+
+type SpanKind string
+
+const (
+  KindServer SpanKind = "server"  //A request received.
+  KindClient SpanKind = "client"  //A request sent; e.g., a query.
+  KindInternal SpanKind = "internal"
+)
+
+type Attr struct {
+  Key string
+  Value any  //string, int64, float64, or bool.
+}
+
+type Span struct {
+  mu sync.Mutex
+  sc SpanContext
+  parent SpanID
+  name string
+  kind SpanKind
+  start time.Time
+  end time.Time
+  attrs []Attr
+  err string
+  ended bool
+}
+
+func (s *Span) Context() SpanContext {
+  if s == nil {
+    return SpanContext{}
+  }
+  return s.sc
+}
+
+func (s *Span) SetAttr(key string, value any) {
+  if s == nil {
+    return
+  }
+  switch v := value.(type) {
+  case int:
+    value = int64(v)
+  case int32:
+    value = int64(v)
+  case string, int64, float64, bool:
+  default:
+    value = fmt.Sprint(v)
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.attrs = append(s.attrs, Attr{ Key: key, Value: value })
+}
+
+//Mark the span as failed; a nil err is ignored.
+func (s *Span) RecordError(err error) {
+  if s == nil || err == nil {
+    return
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  s.err = err.Error()
+}
+
+//End the span and queue it for the exporter; only the first call counts.
+func (s *Span) End() {
+  if s == nil {
+    return
+  }
+  s.mu.Lock()
+  if s.ended {
+    s.mu.Unlock()
+    return
+  }
+  s.ended = true
+  s.end = time.Now()
+  data := s.data()
+  s.mu.Unlock()
+  provider.enqueue(data)
+}
+
+type spanKey struct{}
+type remoteKey struct{}
+
+func ContextWithSpan(ctx context.Context, s *Span) context.Context {
+  return context.WithValue(ctx, spanKey{}, s)
+}
+
+//The span of the context; nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+  s, _ := ctx.Value(spanKey{}).(*Span)
+  return s
+}
+
+//The span of a caller in another service (from the traceparent header).
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+  return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+/***
+Start a span; its parent is the span of ctx, or the remote span of ctx, or else it starts a trace.
+The span is nil if tracing is disabled or the trace is not sampled.
+***/
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+  if !provider.enabled() {
+    return ctx, nil
+  }
+  var parent SpanContext
+  if p := SpanFromContext(ctx); p != nil {
+    parent = p.sc
+  } else if r, ok := ctx.Value(remoteKey{}).(SpanContext); ok {
+    parent = r
+  }
+  var sc SpanContext
+  if parent.IsValid() {
+    if !parent.Sampled {
+      return ctx, nil
+    }
+    sc = SpanContext{ TraceID: parent.TraceID, Sampled: true }
+  } else if provider.sample() {
+    rand.Read(sc.TraceID[:])
+    sc.Sampled = true
+  } else {
+    return ctx, nil
+  }
+  rand.Read(sc.SpanID[:])
+  s := &Span{ sc: sc, parent: parent.SpanID, name: name, kind: kind, start: time.Now() }
+  return ContextWithSpan(ctx, s), s
+}
+
+/***
+Start a span only if ctx has a span; e.g., a query done for a request, not by a background job. The
+returned context has the new span even if it is nil (tracing was disabled meanwhile), so the caller
+that ends the span of the context never ends the parent.
+***/
+func StartChild(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+  if SpanFromContext(ctx) == nil {
+    return ctx, nil
+  }
+  ctx, span := Start(ctx, name, kind)
+  return ContextWithSpan(ctx, span), span
+}
+
+//A finished span, as exported.
+type SpanData struct {
+  TraceID string  `json:"trace_id"`
+  SpanID string  `json:"span_id"`
+  ParentSpanID string  `json:"parent_span_id,omitempty"`
+  Name string  `json:"name"`
+  Kind SpanKind  `json:"kind"`
+  Start time.Time  `json:"start"`
+  End time.Time  `json:"end"`
+  Attributes map[string]any  `json:"attributes,omitempty"`
+  Error string  `json:"error,omitempty"`
+}
+
+//The lock is held.
+func (s *Span) data() SpanData {
+  d := SpanData{
+    TraceID: s.sc.TraceID.String(),
+    SpanID: s.sc.SpanID.String(),
+    Name: s.name,
+    Kind: s.kind,
+    Start: s.start,
+    End: s.end,
+    Error: s.err,
+  }
+  if s.parent != (SpanID{}) {
+    d.ParentSpanID = s.parent.String()
+  }
+  if len(s.attrs) > 0 {
+    d.Attributes = make(map[string]any, len(s.attrs))
+    for _, a := range s.attrs {
+      d.Attributes[a.Key] = a.Value
+    }
+  }
+  return d
+}
+
+type Exporter interface {
+  Export(ctx context.Context, spans []SpanData) error
+}
+
+const (
+  queueSize = 2048  //The spans are dropped when the queue is full; tracing must not slow the requests.
+  batchSize = 256
+  flushInterval = 5 * time.Second
+)
+
+var provider = &tracerProvider{}
+
+type tracerProvider struct {
+  mu sync.RWMutex
+  exporter Exporter
+  samplePercent int
+  queue chan SpanData
+  done chan struct{}
+  dropped int
+}
+
+func (p *tracerProvider) enabled() bool {
+  p.mu.RLock()
+  defer p.mu.RUnlock()
+  return p.exporter != nil
+}
+
+func (p *tracerProvider) sample() bool {
+  p.mu.RLock()
+  percent := p.samplePercent
+  p.mu.RUnlock()
+  if percent >= 100 {
+    return true
+  }
+  var b [1]byte
+  rand.Read(b[:])
+  return int(b[0]) * 100 / 256 < percent
+}
+
+func (p *tracerProvider) enqueue(d SpanData) {
+  p.mu.Lock()
+  defer p.mu.Unlock()
+  if p.queue == nil {
+    return
+  }
+  select {
+  case p.queue <- d:
+  default:
+    p.dropped++
+  }
+}
+
+/***
+Enable tracing: the new traces are sampled at samplePercent (the traces continued from a caller
+follow its decision), and the spans are sent to exp. The returned function flushes the queued spans
+and disables tracing; call it at shutdown.
+***/
+func Setup(exp Exporter, samplePercent int, correlationId string) func(ctx context.Context) {
+  queue := make(chan SpanData, queueSize)
+  done := make(chan struct{})
+  provider.mu.Lock()
+  provider.exporter = exp
+  provider.samplePercent = samplePercent
+  provider.queue = queue
+  provider.done = done
+  provider.mu.Unlock()
+  go func() {
+    defer close(done)
+    ticker := time.NewTicker(flushInterval)
+    defer ticker.Stop()
+    batch := make([]SpanData, 0, batchSize)
+    flush := func() {
+      if len(batch) == 0 {
+        return
+      }
+      ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+      if err := exp.Export(ctx, batch); err != nil {
+        logger.LogError(fmt.Sprintf("Cannot export %d spans: %v", len(batch), err), correlationId)
+      }
+      cancel()
+      batch = batch[:0]
+    }
+    for {
+      select {
+      case d, ok := <-queue:
+        if !ok {
+          flush()
+          return
+        }
+        if batch = append(batch, d); len(batch) >= batchSize {
+          flush()
+        }
+      case <-ticker.C:
+        flush()
+      }
+    }
+  }()
+  return func(ctx context.Context) {
+    provider.mu.Lock()
+    provider.exporter = nil
+    provider.queue = nil
+    if provider.dropped > 0 {
+      logger.LogInfo(fmt.Sprintf("%d spans were dropped; the queue was full.", provider.dropped), correlationId)
+    }
+    provider.mu.Unlock()
+    close(queue)
+    select {
+    case <-done:
+    case <-ctx.Done():
+    }
+  }
+}
@@ -0,0 +1,176 @@
+// Testing the functions in tracing.go, http.go, and exporters.go.
+package tracing
+
+/***
+To build and run the tests:
+$ go test
+
+The -v flag prints the name and execution time of each test in the package:
+$ go test -v
+
+The -run flag, whose argument is a regular expression, causes 'go test' to run only those tests
+whose function name matches the pattern:
+$ go test -v -run="TraceParent"
+***/
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "strings"
+  "sync"
+  "testing"
+)
+
+func TestParseTraceParent(t *testing.T) {
+  t.Parallel()
+  tests := []struct {
+    header string
+    ok bool
+    sampled bool
+  } {
+    { "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true, true },
+    { "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00", true, false },
+    { "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future", true, true },
+    { "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", false, false },
+    { "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false },
+    { "00-00000000000000000000000000000000-00f067aa0ba902b7-01", false, false },
+    { "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false, false },
+    { "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false, false },
+    { "00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false, false },
+    { "00-xbf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false, false },
+    { "", false, false },
+  }
+  for _, tc := range tests {
+    sc, ok := ParseTraceParent(tc.header)
+    if ok != tc.ok || sc.Sampled != tc.sampled {
+      t.Errorf("ParseTraceParent(%q) = %v, sampled %v; want %v, sampled %v", tc.header, ok, sc.Sampled, tc.ok,
+        tc.sampled)
+    }
+    if ok && strings.HasPrefix(tc.header, "00-") && sc.TraceParent() != tc.header {
+      t.Errorf("TraceParent() = %q; want %q", sc.TraceParent(), tc.header)
+    }
+  }
+}
+
+type recorder struct {
+  mu sync.Mutex
+  spans []SpanData
+}
+
+func (r *recorder) Export(ctx context.Context, spans []SpanData) error {
+  r.mu.Lock()
+  defer r.mu.Unlock()
+  r.spans = append(r.spans, spans...)
+  return nil
+}
+
+//The tests below enable tracing for the package, so they do not run in parallel with each other.
+func TestMiddleware(t *testing.T) {
+  rec := &recorder{}
+  shutdown := Setup(rec, 100, "")
+  mux := http.NewServeMux()
+  mux.HandleFunc("GET /items/{id}", Middleware(func(res http.ResponseWriter, req *http.Request) {
+    //A query done for the request, and a page rendered from the writer.
+    _, query := StartChild(req.Context(), "db SELECT", KindClient)
+    query.RecordError(errors.New("no rows"))
+    query.End()
+    _, render := StartChild(WriterContext(res), "render items.html", KindInternal)
+    render.End()
+    res.WriteHeader(http.StatusInternalServerError)
+  }))
+  req := httptest.NewRequest(http.MethodGet, "/items/7", nil)
+  req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+  mux.ServeHTTP(httptest.NewRecorder(), req)
+  //A background job has no span.
+  if _, span := StartChild(context.Background(), "db SELECT", KindClient); span != nil {
+    t.Error("StartChild without a parent created a span")
+  }
+  shutdown(context.Background())
+  if len(rec.spans) != 3 {
+    t.Fatalf("%d spans exported; want 3", len(rec.spans))
+  }
+  byName := map[string]SpanData{}
+  for _, s := range rec.spans {
+    byName[s.Name] = s
+    if s.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+      t.Errorf("%s: trace ID %s; want the trace of the traceparent header", s.Name, s.TraceID)
+    }
+  }
+  server := byName["GET /items/{id}"]
+  if server.ParentSpanID != "00f067aa0ba902b7" || server.Kind != KindServer {
+    t.Errorf("server span: parent %q, kind %q; want the span of the caller, server", server.ParentSpanID, server.Kind)
+  }
+  if server.Attributes["http.response.status_code"] != int64(500) || server.Error == "" {
+    t.Errorf("server span: attributes %v, error %q; want status 500 and an error", server.Attributes, server.Error)
+  }
+  if q := byName["db SELECT"]; q.ParentSpanID != server.SpanID || q.Error != "no rows" {
+    t.Errorf("query span: parent %q, error %q; want %q, \"no rows\"", q.ParentSpanID, q.Error, server.SpanID)
+  }
+  if r := byName["render items.html"]; r.ParentSpanID != server.SpanID {
+    t.Errorf("render span: parent %q; want %q", r.ParentSpanID, server.SpanID)
+  }
+}
+
+func TestSampling(t *testing.T) {
+  rec := &recorder{}
+  shutdown := Setup(rec, 0, "")
+  h := Middleware(func(res http.ResponseWriter, req *http.Request) {})
+  h(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+  //The decision of the caller wins.
+  req := httptest.NewRequest(http.MethodGet, "/", nil)
+  req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+  h(httptest.NewRecorder(), req)
+  shutdown(context.Background())
+  if len(rec.spans) != 1 || rec.spans[0].TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+    t.Errorf("exported %v; want only the span of the sampled caller", rec.spans)
+  }
+  //Disabled.
+  if _, span := Start(context.Background(), "x", KindInternal); span != nil {
+    t.Error("Start after shutdown created a span")
+  }
+}
+
+func TestOtlpExporter(t *testing.T) {
+  t.Parallel()
+  var body map[string]any
+  srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+    if req.Header.Get("Content-Type") != "application/json" {
+      t.Errorf("Content-Type = %q", req.Header.Get("Content-Type"))
+    }
+    b, _ := io.ReadAll(req.Body)
+    if err := json.Unmarshal(b, &body); err != nil {
+      t.Errorf("the body is not JSON: %v", err)
+    }
+  }))
+  defer srv.Close()
+  exp := NewOtlpExporter(srv.URL + "/v1/traces", "finance")
+  err := exp.Export(context.Background(), []SpanData{
+    { TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Name: "GET /", Kind: KindServer,
+      Attributes: map[string]any{ "http.response.status_code": int64(200) } },
+  })
+  if err != nil {
+    t.Fatalf("Export: %v", err)
+  }
+  b, _ := json.Marshal(body)
+  for _, want := range []string{
+    `"traceId":"4bf92f3577b34da6a3ce929d0e0e4736"`,
+    `"kind":2`,
+    `"intValue":"200"`,
+    `"stringValue":"finance"`,
+  } {
+    if !strings.Contains(string(b), want) {
+      t.Errorf("the body %s does not contain %s", b, want)
+    }
+  }
+  failing := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+    http.Error(res, "no", http.StatusServiceUnavailable)
+  }))
+  defer failing.Close()
+  if err = NewOtlpExporter(failing.URL, "finance").Export(context.Background(), nil); err == nil {
+    t.Error("Export to a failing collector did not fail")
+  }
+}
@@ -2,16 +2,14 @@ package webfinances
 
 import (
   "context"
-  "encoding/json"
   "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strconv"
   "strings"
   "time"
@@ -41,12 +39,8 @@ type adCpFields struct {
   Fd3Result string `json:"fd3Result"`
 }
 
-func newAdCpFields(dir1, dir2, correlationId string) *adCpFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newAdCpFields() *adCpFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := adCpFields {
     MenuPage: "",
     CurrentPage: "rhs-ui2",
@@ -70,34 +64,18 @@ func newAdCpFields(dir1, dir2, correlationId string) *adCpFields {
     Fd3FV: "1.00",
     Fd3Result: "",
   }
-  obj, err := readFields(dir + "adcp.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "adcp.txt"), correlationId)
-  }
   return &m
 }
 
-func getAdCpFields(userName string) *adCpFields {
-  return currentFields[userName].adCp
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getAdCpFields(ctx context.Context, userName, correlationId string) (*adCpFields, func()) {
+  m := newAdCpFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "adcp", m, correlationId)
 }
 
 type WfAdCpPages struct {}
 
-func (a WfAdCpPages) AdCpPages(res http.ResponseWriter, req *http.Request) {
+func (a WfAdCpPages) AdCpPages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -106,12 +84,13 @@ func (a WfAdCpPages) AdCpPages(res http.ResponseWriter, req *http.Request) {
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getAdCpFields(userName)
+    fields, unlock := getAdCpFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -282,9 +261,7 @@ func (a WfAdCpPages) AdCpPages(res http.ResponseWriter, req *http.Request) {
             newSession.CsrfToken, fields.Fd3Interest, fields.Fd3Compound, fields.Fd3Payment, fields.Fd3FV, fields.Fd3Result },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
@@ -296,18 +273,10 @@ func (a WfAdCpPages) AdCpPages(res http.ResponseWriter, req *http.Request) {
       }
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/adcp.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "adcp", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
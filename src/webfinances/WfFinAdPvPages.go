@@ -2,16 +2,14 @@ package webfinances
 
 import (
   "context"
-  "encoding/json"
   "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strconv"
   "strings"
   "time"
@@ -37,12 +35,8 @@ type adPvFields struct {
   Fd2Result string `json:"fd2Result"`
 }
 
-func newAdPvFields(dir1, dir2, correlationId string) *adPvFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newAdPvFields() *adPvFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := adPvFields {
     MenuPage: "",
     CurrentPage: "rhs-ui2",
@@ -62,34 +56,18 @@ func newAdPvFields(dir1, dir2, correlationId string) *adPvFields {
     Fd2PMT: "1.00",
     Fd2Result: "",
   }
-  obj, err := readFields(dir + "adpv.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "adpv.txt"), correlationId)
-  }
   return &m
 }
 
-func getAdPvFields(userName string) *adPvFields {
-  return currentFields[userName].adPv
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getAdPvFields(ctx context.Context, userName, correlationId string) (*adPvFields, func()) {
+  m := newAdPvFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "adpv", m, correlationId)
 }
 
 type WfAdPvPages struct {}
 
-func (a WfAdPvPages) AdPvPages(res http.ResponseWriter, req *http.Request) {
+func (a WfAdPvPages) AdPvPages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -98,12 +76,13 @@ func (a WfAdPvPages) AdPvPages(res http.ResponseWriter, req *http.Request) {
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getAdPvFields(userName)
+    fields, unlock := getAdPvFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -225,9 +204,7 @@ func (a WfAdPvPages) AdPvPages(res http.ResponseWriter, req *http.Request) {
             fields.Fd2N, fields.Fd2TimePeriod, fields.Fd2Interest, fields.Fd2Compound, fields.Fd2PMT, fields.Fd2Result },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
@@ -239,18 +216,10 @@ func (a WfAdPvPages) AdPvPages(res http.ResponseWriter, req *http.Request) {
       }
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/adpv.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-          logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "adpv", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
@@ -2,17 +2,15 @@ package webfinances
 
 import (
   "context"
-  "encoding/json"
   "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "math"
   "net/http"
-  "os"
   "strconv"
   "strings"
   "time"
@@ -94,12 +92,8 @@ type bondsFields struct {
   // Fd8Result [2]string `json:"fd8Result"`
 }
 
-func newBondsFields(dir1, dir2, correlationId string) *bondsFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newBondsFields() *bondsFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := bondsFields{
     MenuPage: "",
     CurrentPage: "rhs-ui1",
@@ -175,29 +169,13 @@ func newBondsFields(dir1, dir2, correlationId string) *bondsFields {
     // Fd8Compound: "annually",
     // Fd8Result: [2]string { bond_notes[2], "" },
   }
-  obj, err := readFields(dir + "bonds.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "bonds.txt"), correlationId)
-  }
   return &m
 }
 
-func getBondsFields(userName string) *bondsFields {
-  return currentFields[userName].bonds
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getBondsFields(ctx context.Context, userName, correlationId string) (*bondsFields, func()) {
+  m := newBondsFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "bonds", m, correlationId)
 }
 
 var bond_notes = [...]string {
@@ -212,7 +190,7 @@ var bond_notes = [...]string {
 
 type WfBondsPages struct {}
 
-func (b WfBondsPages) BondsPages(res http.ResponseWriter, req *http.Request) {
+func (b WfBondsPages) BondsPages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -221,12 +199,13 @@ func (b WfBondsPages) BondsPages(res http.ResponseWriter, req *http.Request) {
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getBondsFields(userName)
+    fields, unlock := getBondsFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -848,9 +827,7 @@ func (b WfBondsPages) BondsPages(res http.ResponseWriter, req *http.Request) {
     //     })
     ***/
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
@@ -883,18 +860,10 @@ func (b WfBondsPages) BondsPages(res http.ResponseWriter, req *http.Request) {
       }
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/bonds.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "bonds", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
@@ -2,16 +2,14 @@ package webfinances
 
 import (
   "context"
-  "encoding/json"
   "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strconv"
   "strings"
   "time"
@@ -55,12 +53,8 @@ type miscellaneousFields struct{
   Fd7Result string `json:"fd7Result"`
 }
 
-func newMiscellaneousFields(dir1, dir2, correlationId string) *miscellaneousFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newMiscellaneousFields() *miscellaneousFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := miscellaneousFields{
     MenuPage: "",
     CurrentPage: "rhs-ui1",
@@ -98,29 +92,13 @@ func newMiscellaneousFields(dir1, dir2, correlationId string) *miscellaneousFiel
     Fd7PV: "1.00",
     Fd7Result: "",
   }
-  obj, err := readFields(dir + "miscellaneous.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "miscellaneous.txt"), correlationId)
-  }
   return &m
 }
 
-func getMiscellaneousFields(userName string) *miscellaneousFields {
-  return currentFields[userName].miscellaneous
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getMiscellaneousFields(ctx context.Context, userName, correlationId string) (*miscellaneousFields, func()) {
+  m := newMiscellaneousFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "miscellaneous", m, correlationId)
 }
 
 var misc_notes = [...]string {
@@ -133,7 +111,7 @@ var misc_notes = [...]string {
 
 type WfMiscellaneousPages struct {}
 
-func (mp WfMiscellaneousPages) MiscellaneousPages(res http.ResponseWriter, req *http.Request) {
+func (mp WfMiscellaneousPages) MiscellaneousPages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -142,12 +120,13 @@ func (mp WfMiscellaneousPages) MiscellaneousPages(res http.ResponseWriter, req *
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getMiscellaneousFields(userName)
+    fields, unlock := getMiscellaneousFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -516,9 +495,7 @@ func (mp WfMiscellaneousPages) MiscellaneousPages(res http.ResponseWriter, req *
             fields.Fd7Time, fields.Fd7TimePeriod, fields.Fd7Rate, fields.Fd7Compound, fields.Fd7PV, fields.Fd7Result },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
@@ -540,18 +517,10 @@ func (mp WfMiscellaneousPages) MiscellaneousPages(res http.ResponseWriter, req *
       }
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/miscellaneous.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "miscellaneous", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
@@ -2,16 +2,14 @@ package webfinances
 
 import (
   "context"
-  "encoding/json"
   "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strconv"
   "strings"
   "time"
@@ -45,12 +43,8 @@ type mortgageFields struct {
   Fd3Result [3]string `json:"fd3Result"`
 }
 
-func newMortgageFields(dir1, dir2, correlationId string) *mortgageFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newMortgageFields() *mortgageFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := mortgageFields{
     MenuPage: "",
     CurrentButton: "lhs-button1",
@@ -78,29 +72,13 @@ func newMortgageFields(dir1, dir2, correlationId string) *mortgageFields {
     Fd3Hbalance: "100000.00",
     Fd3Result: [3]string { mortgage_notes[0], mortgage_notes[1], "" },
   }
-  obj, err := readFields(dir + "mortgage.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "mortgage.txt"), correlationId)
-  }
   return &m
 }
 
-func getMortgageFields(userName string) *mortgageFields {
-  return currentFields[userName].mortgage
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getMortgageFields(ctx context.Context, userName, correlationId string) (*mortgageFields, func()) {
+  m := newMortgageFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "mortgage", m, correlationId)
 }
 
 var mortgage_notes = [...]string {
@@ -115,7 +93,7 @@ type Row struct { //Rows for the amortization table.
 
 type WfMortgagePages struct {}
 
-func (mp WfMortgagePages) MortgagePages(res http.ResponseWriter, req *http.Request) {
+func (mp WfMortgagePages) MortgagePages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -124,12 +102,13 @@ func (mp WfMortgagePages) MortgagePages(res http.ResponseWriter, req *http.Reque
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getMortgageFields(userName)
+    fields, unlock := getMortgageFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -352,9 +331,7 @@ func (mp WfMortgagePages) MortgagePages(res http.ResponseWriter, req *http.Reque
             fields.Fd3Mrate, fields.Fd3Mbalance, fields.Fd3Hrate, fields.Fd3Hbalance, fields.Fd3Result, },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
@@ -372,18 +349,10 @@ func (mp WfMortgagePages) MortgagePages(res http.ResponseWriter, req *http.Reque
       }
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/mortgage.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "mortgage", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
@@ -2,16 +2,14 @@ package webfinances
 
 import (
   "context"
-  "encoding/json"
   "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strconv"
   "strings"
   "time"
@@ -30,12 +28,8 @@ type oaInterestRateFields struct {
   Fd1Result string `json:"fd1Result"`
 }
 
-func newOaInterestRateFields(dir1, dir2, correlationId string) *oaInterestRateFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newOaInterestRateFields() *oaInterestRateFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := oaInterestRateFields{
     MenuPage: "",
     CurrentPage: "rhs-ui1",
@@ -48,34 +42,18 @@ func newOaInterestRateFields(dir1, dir2, correlationId string) *oaInterestRateFi
     Fd1FV: "1.07",
     Fd1Result: "",
   }
-  obj, err := readFields(dir + "oainterestrate.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "oainterestrate.txt"), correlationId)
-  }
   return &m
 }
 
-func getOaInterestRateFields(userName string) *oaInterestRateFields {
-  return currentFields[userName].oaInterestRate
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getOaInterestRateFields(ctx context.Context, userName, correlationId string) (*oaInterestRateFields, func()) {
+  m := newOaInterestRateFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "oainterestrate", m, correlationId)
 }
 
 type WfOaInterestRatePages struct{}
 
-func (o WfOaInterestRatePages) OaInterestRatePages(res http.ResponseWriter, req *http.Request) {
+func (o WfOaInterestRatePages) OaInterestRatePages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -84,12 +62,13 @@ func (o WfOaInterestRatePages) OaInterestRatePages(res http.ResponseWriter, req
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getOaInterestRateFields(userName)
+    fields, unlock := getOaInterestRateFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -165,9 +144,7 @@ func (o WfOaInterestRatePages) OaInterestRatePages(res http.ResponseWriter, req
             newSession.CsrfToken, fields.Fd1N, fields.Fd1TimePeriod, fields.Fd1Compound, fields.Fd1PV, fields.Fd1FV, fields.Fd1Result },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
@@ -177,18 +154,10 @@ func (o WfOaInterestRatePages) OaInterestRatePages(res http.ResponseWriter, req
       }
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/oainterestrate.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "oainterestrate", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
@@ -1,10 +1,11 @@
 package webfinances
 
 import (
+  "finance/authz"
   bank "finance/databases/banking" //Importing a package and assigning it a local alias.
-  banking "finance/webfinances/wfbanking"
+  "finance/metrics"
   "finance/renderer"
-  admin "finance/webfinances/wfadmin"
+  "finance/security"
   "fmt"
   "net/http"
   "path/filepath"
@@ -24,6 +25,10 @@ Instead of "Invalid username" or "Invalid password", just use "Invalid username
 ***/
 func invalidSession(res http.ResponseWriter, correlationId string) {
   logger.LogInfo("Invalid session (webfinances.invalidSession).", correlationId)
+  loginPage(res, "Invalid username and/or password")
+}
+
+func loginPage(res http.ResponseWriter, errMsg string) {
   templatesNeeded := []string{
     "webfinances/templates/layout.html",
     "webfinances/templates/login.html",
@@ -33,7 +38,7 @@ func invalidSession(res http.ResponseWriter, correlationId string) {
       LayoutType string
       Header string
       ErrMsg string
-    } { "std-wo-headers", "Login", "Invalid username and/or password" },
+    } { "std-wo-headers", "Login", errMsg },
   })
 }
 
@@ -78,6 +83,50 @@ func (p WfPages) LoginPage(res http.ResponseWriter, req *http.Request) {
   logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
 }
 
+/***
+Create the session of an authenticated user: the session token, the admin token (JWT), and the data
+per user. It returns false if the response was already written.
+***/
+func startSession(res http.ResponseWriter, un string, isAdmin bool, correlationId string) bool {
+  sessionToken, session := sessions.AddEntryToSessions(un)
+  security.SessionStarted(sessionToken)
+  /***
+  Once a cookie is set on a client, it is sent along with every subsequent request. Cookies store
+  historical information (including user login information) on the client's computer. The
+  client's browser sends these cookies everytime the user visits the same website, automatically
+  completing the login step for the user.
+
+  Sessions, on the other hand, store historical information on the server side. The server uses a
+  session id to identify different sessions, and the session id that is generated by the server
+  should always be random and unique. You can use cookies or URL arguments to get the client's
+  identity.
+  ***/
+  http.SetCookie(res, &http.Cookie{
+    Name: "session_token",
+    Value: sessionToken,
+    Expires: session.Expiry,
+  })
+  tokenString, err := middlewares.GenerateJwtToken(isAdmin)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error signing token: %v+", err), correlationId)
+    http.Error(res, "Error signing token: ", http.StatusInternalServerError)
+    return false
+  }
+  http.SetCookie(res, &http.Cookie{
+    Name: "admin_token",
+    Value: tokenString,
+  })
+  return true
+}
+
+func redirectToWelcome(res http.ResponseWriter, req *http.Request, isAdmin bool) {
+  if isAdmin {
+    http.Redirect(res, req, "/admin/welcome", http.StatusSeeOther)
+  } else {
+    http.Redirect(res, req, "/welcome", http.StatusSeeOther)
+  }
+}
+
 func (p WfPages) VerifyLogin(res http.ResponseWriter, req *http.Request) {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
@@ -92,45 +141,32 @@ func (p WfPages) VerifyLogin(res http.ResponseWriter, req *http.Request) {
   }
   un := req.PostFormValue("uname")
   pw := req.PostFormValue("pwd")
+  auditCtx := authz.AuditContext(req, un)
   ok, isAdmin := bank.DbAuthenticateUser(req.Context(), un, pw, correlationId)
   if !ok {
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditLogin, "", bank.AuditFailure, "", correlationId)
     invalidSession(res, correlationId)
-  } else {
-    sessionToken, session := sessions.AddEntryToSessions(un)
+  } else if tf, err := bank.DbGetTwoFactor(req.Context(), un, correlationId); err != nil {
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditLogin, "", bank.AuditFailure, "two-factor state unavailable",
+      correlationId)
+    invalidSession(res, correlationId)
+  } else if tf.Required && !tf.Enabled() {
     /***
-    Once a cookie is set on a client, it is sent along with every subsequent request. Cookies store
-    historical information (including user login information) on the client's computer. The
-    client's browser sends these cookies everytime the user visits the same website, automatically
-    completing the login step for the user.
-
-    Sessions, on the other hand, store historical information on the server side. The server uses a
-    session id to identify different sessions, and the session id that is generated by the server
-    should always be random and unique. You can use cookies or URL arguments to get the client's
-    identity.
+    The password must not be enough to enroll an authenticator: whoever knows it would then have both
+    factors. The user enrolls from a session started before it was required, or with a link issued
+    by an admin (see EnrollTotpPage).
     ***/
-    http.SetCookie(res, &http.Cookie{
-      Name: "session_token",
-      Value: sessionToken,
-      Expires: session.Expiry,
-    })
-    tokenString, err := middlewares.GenerateJwtToken(isAdmin)
-    if err != nil {
-      logger.LogError(fmt.Sprintf("Error signing token: %v+", err), correlationId)
-      http.Error(res, "Error signing token: ", http.StatusInternalServerError)
-      return
-    }
-    http.SetCookie(res, &http.Cookie{
-      Name: "admin_token",
-      Value: tokenString,
-    })
-    if isAdmin {
-      admin.AddSessionDataPerUser(un, correlationId)
-      http.Redirect(res, req, "/admin/welcome", http.StatusSeeOther)
-    } else {
-      AddSessionDataPerUser(un, correlationId)
-      banking.AddSessionDataPerUser(un, correlationId)
-      http.Redirect(res, req, "/welcome", http.StatusSeeOther)
-    }
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditLogin, "", bank.AuditDenied, "two-factor enrollment required",
+      correlationId)
+    logger.LogWarning(fmt.Sprintf("User %s must enroll in two-factor authentication; login refused.", un), correlationId)
+    loginPage(res, "Two-factor authentication is required for this account; ask an admin for an enrollment link.")
+  } else if tf.Enabled() {
+    //The password is correct, but the session is not created until the second factor is checked.
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditLogin, "", bank.AuditSuccess, "second factor pending", correlationId)
+    startSecondFactor(res, req, un, isAdmin, correlationId)
+  } else if startSession(res, un, isAdmin, correlationId) {
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditLogin, "", bank.AuditSuccess, "", correlationId)
+    redirectToWelcome(res, req, isAdmin)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
 }
@@ -145,8 +181,11 @@ func (p WfPages) LogoutPage(res http.ResponseWriter, req *http.Request) {
   if sessionToken == "" {
     invalidSession(res, correlationId)
   } else {
-    DeleteSessionDataPerUser(sessions.GetUserName(sessionToken))
+    un := sessions.GetUserName(sessionToken)
+    bank.DbWriteAuditEvent(authz.AuditContext(req, un), bank.AuditLogout, "", bank.AuditSuccess, "", correlationId)
     cookie := sessions.DeleteSession(sessionToken)
+    security.SessionDeleted(sessionToken)
+    metrics.SessionEnded(un)
     http.SetCookie(res, cookie)
     http.Redirect(res, req, "/", http.StatusSeeOther)
   }
@@ -2,16 +2,14 @@ package webfinances
 
 import (
   "context"
-  "encoding/json"
   "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strconv"
   "strings"
   "time"
@@ -50,12 +48,8 @@ type siAccurateFields struct{
   Fd4Result string `json:"fd4Result"`
 }
 
-func newSiAccurateFields(dir1, dir2, correlationId string) *siAccurateFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newSiAccurateFields() *siAccurateFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := siAccurateFields{
     MenuPage: "",
     CurrentPage: "rhs-ui1",
@@ -88,34 +82,18 @@ func newSiAccurateFields(dir1, dir2, correlationId string) *siAccurateFields {
     Fd4PV: "1.00",
     Fd4Result: "",
   }
-  obj, err := readFields(dir + "siaccurate.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "siaccurate.txt"), correlationId)
-  }
   return &m
 }
 
-func getSiAccurateFields(userName string) *siAccurateFields {
-  return currentFields[userName].siAccurate
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getSiAccurateFields(ctx context.Context, userName, correlationId string) (*siAccurateFields, func()) {
+  m := newSiAccurateFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "siaccurate", m, correlationId)
 }
 
 type WfSiAccuratePages struct{}
 
-func (s WfSiAccuratePages) SimpleInterestAccuratePages(res http.ResponseWriter, req *http.Request) {
+func (s WfSiAccuratePages) SimpleInterestAccuratePages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -124,12 +102,13 @@ func (s WfSiAccuratePages) SimpleInterestAccuratePages(res http.ResponseWriter,
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getSiAccurateFields(userName)
+    fields, unlock := getSiAccurateFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and MultipartForm
     fields; the data are in the form of key-value pairs.
@@ -384,9 +363,7 @@ func (s WfSiAccuratePages) SimpleInterestAccuratePages(res http.ResponseWriter,
             newSession.CsrfToken, fields.Fd4Interest, fields.Fd4Compound, fields.Fd4Amount, fields.Fd4PV, fields.Fd4Result },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogInfo(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
@@ -402,18 +379,10 @@ func (s WfSiAccuratePages) SimpleInterestAccuratePages(res http.ResponseWriter,
       }
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/siaccurate.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "siaccurate", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
@@ -0,0 +1,168 @@
+package webfinances
+
+import (
+  "context"
+  "errors"
+  "finance/authz"
+  bank "finance/databases/banking"
+  "finance/config"
+  "finance/mailer"
+  "finance/renderer"
+  "finance/security"
+  "fmt"
+  "net/http"
+  "net/url"
+  "strings"
+  "sync"
+  "time"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+//The same answer whether or not the user exists, so the form cannot be used to find usernames.
+const resetRequestedMsg = "If the username is registered, a link to reset the password was sent to its email address."
+
+//The mailer of the links sent by email (password reset and sign-up).
+var sharedMailer struct {
+  sync.Once
+  m mailer.Mailer
+}
+
+func getMailer(correlationId string) mailer.Mailer {
+  sharedMailer.Do(func() {
+    sharedMailer.m = mailer.New(correlationId)
+  })
+  return sharedMailer.m
+}
+
+type passwordResetData struct {
+  LayoutType string
+  Header string
+  Token string
+  //False if the link is invalid or expired; the form is not shown.
+  ValidToken bool
+  Done bool
+  PwdErrors []string  //Field-level messages for the new password.
+  ErrMsg string
+}
+
+func renderPasswordReset(res http.ResponseWriter, page string, pd *passwordResetData) {
+  templatesNeeded := []string{
+    "webfinances/templates/layout.html",
+    "webfinances/templates/password/" + page,
+  }
+  renderer.Render(res, "layout", templatesNeeded, renderer.PageData{ Data: pd })
+}
+
+//Issue the token and send the link; it runs after the response, so its duration reveals nothing.
+//auditCtx carries the client of the request for the audit log.
+func sendPasswordReset(auditCtx context.Context, userName, correlationId string) {
+  ctx, cancel := context.WithTimeout(auditCtx, 30 * time.Second)
+  defer cancel()
+  token, tokenHash, err := security.GenerateUrlToken()
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error generating the password-reset token: %v", err), correlationId)
+    return
+  }
+  email, err := bank.DbCreatePasswordReset(ctx, userName, tokenHash, correlationId)
+  if err != nil {
+    return
+  } else if email == "" {
+    logger.LogInfo(fmt.Sprintf("No password-reset link was sent for user %s.", userName), correlationId)
+    return
+  }
+  link := config.GetBaseUrl(correlationId) + "/reset_password?token=" + url.QueryEscape(token)
+  minutes := config.GetPasswordResetMinutes(correlationId)
+  msg := mailer.Message{
+    To: email,
+    Subject: "Reset your password",
+    Body: fmt.Sprintf("A password reset was requested for the user %s.\n\n" +
+      "To choose a new password, open the link below within %d minutes; it can be used only once:\n\n%s\n\n" +
+      "If you did not request it, ignore this message; your password has not been changed.\n", userName, minutes,
+      link),
+  }
+  if err := getMailer(correlationId).Send(ctx, msg); err != nil {
+    logger.LogError(fmt.Sprintf("Error sending the password-reset link: %v", err), correlationId)
+  } else {
+    logger.LogInfo(fmt.Sprintf("Password-reset link sent for user %s.", userName), correlationId)
+  }
+}
+
+//Request a password-reset link ("forgot password").
+func (p WfPages) ForgotPasswordPage(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering ForgotPasswordPage.", correlationId)
+  pd := &passwordResetData{ LayoutType: "std-wo-headers", Header: "Forgot Password" }
+  if req.Method == http.MethodPost {
+    if un := strings.TrimSpace(req.PostFormValue("uname")); un != "" {
+      //The request is anonymous; the context must outlive it.
+      go sendPasswordReset(context.WithoutCancel(authz.AuditContext(req, "")), un, correlationId)
+    }
+    pd.Done = true
+    pd.ErrMsg = resetRequestedMsg
+  } else if req.Method != http.MethodGet {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  renderPasswordReset(res, "forgot.html", pd)
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
+
+//Choose a new password with the token from the link.
+func (p WfPages) ResetPasswordPage(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering ResetPasswordPage.", correlationId)
+  if req.Method != http.MethodPost && req.Method != http.MethodGet {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  pd := &passwordResetData{ LayoutType: "std-wo-headers", Header: "Reset Password" }
+  //The token comes in the link (GET) and then in a hidden field (POST).
+  pd.Token = strings.TrimSpace(req.FormValue("token"))
+  tokenHash := security.HashUrlToken(pd.Token)
+  userName, err := bank.DbGetPasswordResetUser(req.Context(), tokenHash, correlationId)
+  if pd.Token == "" || err != nil {
+    pd.Token = ""
+    pd.ErrMsg = bank.ErrInvalidResetToken.Error()
+    if err != nil && !errors.Is(err, bank.ErrInvalidResetToken) {
+      pd.ErrMsg = "The password cannot be reset right now; try again later."
+    }
+  } else if req.Method == http.MethodGet {
+    pd.ValidToken = true
+  } else {
+    req = req.WithContext(authz.AuditContext(req, userName))
+    pd.ValidToken = true
+    newPwd := req.PostFormValue("newpwd")
+    //Passwords are case sensitive.
+    if newPwd != req.PostFormValue("connewpwd") {
+      pd.ErrMsg = "New password and confirmation password do not match."
+    } else if pd.PwdErrors = bank.ValidatePassword(newPwd, userName, correlationId); len(pd.PwdErrors) > 0 {
+      pd.ErrMsg = "Your password was NOT reset."
+    } else if err = bank.DbResetPassword(req.Context(), tokenHash, newPwd, correlationId); err != nil {
+      if errors.Is(err, bank.ErrPasswordReused) {
+        pd.PwdErrors = []string{ err.Error() }
+        pd.ErrMsg = "Your password was NOT reset."
+      } else {
+        pd.ValidToken = false
+        pd.Token = ""
+        pd.ErrMsg = bank.ErrInvalidResetToken.Error()
+      }
+    } else {
+      logger.LogInfo(fmt.Sprintf("Password reset for user %s.", userName), correlationId)
+      //Whoever knew the old password is logged out; the user logs in again with the new one.
+      bank.DbEndUserSessions(req.Context(), userName, correlationId)
+      pd.ValidToken = false
+      pd.Token = ""
+      pd.Done = true
+      pd.ErrMsg = "Your password has been reset; you can log in with it now."
+    }
+  }
+  renderPasswordReset(res, "reset.html", pd)
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
@@ -0,0 +1,263 @@
+package webfinances
+
+import (
+  "context"
+  "crypto/rand"
+  "errors"
+  "finance/authz"
+  bank "finance/databases/banking"
+  "finance/config"
+  "finance/mailer"
+  "finance/renderer"
+  "finance/security"
+  "fmt"
+  "net/http"
+  "net/mail"
+  "net/url"
+  "strconv"
+  "strings"
+  "sync"
+  "time"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+)
+
+/***
+Self-service sign-up. The account is created by fin.add_customer, but the customer cannot log in
+until the email address is verified with a signed link (no token is stored) and, if an admin turned
+on the approval of sign-ups, the sign-up is approved. Automated sign-ups are slowed down by the rate
+limits of the routes per client IP (RATE_LIMITS; see the ratelimit package) and by a field hidden
+from people (honeypot); a form with the field filled in gets the usual answer, but nothing is
+created.
+***/
+const (
+  signupPurpose = "signup"
+  signupHoneypot = "website"
+  //The same answer whether or not the username is pending, so the form cannot be used to find usernames.
+  signupResentMsg = "If the username is waiting for the verification of its email address, a new link was sent to it."
+  signupSentMsg = "Your account was created. We sent a link to your email address; open it to verify the address " +
+    "before you log in."
+)
+
+var signupKey struct {
+  sync.Once
+  key []byte
+}
+
+func getSignupKey(correlationId string) []byte {
+  signupKey.Do(func() {
+    if k := config.GetSignupLinkKey(); len(k) >= 32 {
+      signupKey.key = []byte(k)
+      return
+    }
+    logger.LogWarning("SIGNUP_LINK_KEY is not set or shorter than 32 bytes; using a random key. The verification " +
+      "links are not valid after a restart or on other instances.", correlationId)
+    signupKey.key = make([]byte, 32)
+    if _, err := rand.Read(signupKey.key); err != nil {
+      panic(err)
+    }
+  })
+  return signupKey.key
+}
+
+type signupData struct {
+  LayoutType string
+  Header string
+  Username string
+  Fname string
+  Mname string
+  Lname string
+  Gender string
+  Bdate string
+  Marketing string
+  Address1 string
+  Address2 string
+  City string
+  State string
+  Country string
+  Zip_Code string
+  Email string
+  Phone string
+  Done bool
+  PwdErrors []string  //Field-level messages for the password.
+  ErrMsg string
+}
+
+func renderSignup(res http.ResponseWriter, page string, pd any) {
+  templatesNeeded := []string{
+    "webfinances/templates/layout.html",
+    "webfinances/templates/signup/" + page,
+  }
+  renderer.Render(res, "layout", templatesNeeded, renderer.PageData{ Data: pd })
+}
+
+//The honeypot is filled in; the caller answers as if it went through.
+func honeypotFilled(req *http.Request, userName, correlationId string) bool {
+  if req.PostFormValue(signupHoneypot) == "" {
+    return false
+  }
+  logger.LogWarning("Sign-up rejected: the honeypot field was filled in.", correlationId)
+  bank.DbWriteAuditEvent(req.Context(), bank.AuditUserSignup, userName, bank.AuditDenied, "honeypot", correlationId)
+  return true
+}
+
+//Send the link to verify the email address; it runs after the response.
+func sendSignupVerification(auditCtx context.Context, userName string, id int32, email, correlationId string) {
+  ctx, cancel := context.WithTimeout(auditCtx, 30 * time.Second)
+  defer cancel()
+  hours := config.GetSignupLinkHours(correlationId)
+  token := security.SignToken(getSignupKey(correlationId), signupPurpose, strconv.FormatInt(int64(id), 10),
+    time.Now().Add(time.Duration(hours) * time.Hour))
+  link := config.GetBaseUrl(correlationId) + "/verify_signup?token=" + url.QueryEscape(token)
+  msg := mailer.Message{
+    To: email,
+    Subject: "Verify your email address",
+    Body: fmt.Sprintf("An account with the username %s was created with this email address.\n\n" +
+      "To verify the address, open the link below within %d hours:\n\n%s\n\n" +
+      "If you did not sign up, ignore this message; the account cannot be used.\n", userName, hours, link),
+  }
+  if err := getMailer(correlationId).Send(ctx, msg); err != nil {
+    logger.LogError(fmt.Sprintf("Error sending the sign-up verification link: %v", err), correlationId)
+  } else {
+    logger.LogInfo(fmt.Sprintf("Sign-up verification link sent for user %s.", userName), correlationId)
+  }
+}
+
+//Create an account.
+func (p WfPages) SignupPage(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering SignupPage.", correlationId)
+  pd := &signupData{ LayoutType: "std-wo-headers", Header: "Create an Account", Gender: "male", Marketing: "false" }
+  if req.Method == http.MethodPost {
+    c := bank.Customer {
+      User_name: strings.TrimSpace(req.PostFormValue("uname")),
+      Password: req.PostFormValue("pwd"),
+      First_name: strings.TrimSpace(req.PostFormValue("fname")),
+      Last_name: strings.TrimSpace(req.PostFormValue("lname")),
+      Gender: req.PostFormValue("gender"),
+      Address1: strings.TrimSpace(req.PostFormValue("address1")),
+      City: strings.TrimSpace(req.PostFormValue("city")),
+      State: strings.TrimSpace(req.PostFormValue("state")),
+      Country: strings.TrimSpace(req.PostFormValue("country")),
+      Email: strings.TrimSpace(req.PostFormValue("email")),
+      Phone: strings.TrimSpace(req.PostFormValue("phone")),
+      Marketing: strings.EqualFold(req.PostFormValue("marketing"), "true"),
+      Middle_name: bank.StringPtr(strings.TrimSpace(req.PostFormValue("mname"))),
+      Address2: bank.StringPtr(strings.TrimSpace(req.PostFormValue("address2"))),
+      Zip_code: bank.StringPtr(strings.TrimSpace(req.PostFormValue("zip_code"))),
+    }
+    req = req.WithContext(authz.AuditContext(req, ""))
+    var err error
+    if honeypotFilled(req, c.User_name, correlationId) {
+      pd.Done = true
+      pd.ErrMsg = signupSentMsg
+    } else if bdate, perr := time.Parse("2006-01-02", req.PostFormValue("bdate")); perr != nil ||
+              bdate.After(time.Now()) {
+      err = errors.New("Enter a valid birth date.")
+    } else if c.Birth_date = bank.TimePtr(bdate); c.User_name == "" || c.First_name == "" || c.Last_name == "" ||
+              c.Address1 == "" || c.City == "" || c.State == "" || c.Country == "" || c.Phone == "" {
+      err = errors.New("Fill in all the required fields.")
+    } else if addr, aerr := mail.ParseAddress(c.Email); aerr != nil || addr.Address != c.Email {
+      err = errors.New("Enter a valid email address.")
+    } else if c.Password != req.PostFormValue("conpwd") {  //Passwords are case sensitive.
+      err = errors.New("Password and confirmation password do not match.")
+    } else if pd.PwdErrors = bank.ValidatePassword(c.Password, c.User_name, correlationId); len(pd.PwdErrors) > 0 {
+      err = bank.ErrPasswordPolicy
+    } else {
+      var id int32
+      if id, err = bank.DbSignupCustomer(req.Context(), &c, correlationId); err == nil {
+        //The context must outlive the request.
+        go sendSignupVerification(context.WithoutCancel(req.Context()), c.User_name, id, c.Email, correlationId)
+        pd.Done = true
+        pd.ErrMsg = signupSentMsg
+      }
+    }
+    if err != nil {
+      //The password is not sent back.
+      pd.Username = c.User_name
+      pd.Fname = c.First_name
+      pd.Mname = bank.PtrString(c.Middle_name)
+      pd.Lname = c.Last_name
+      pd.Gender = c.Gender
+      pd.Bdate = req.PostFormValue("bdate")
+      pd.Marketing = strconv.FormatBool(c.Marketing)
+      pd.Address1 = c.Address1
+      pd.Address2 = bank.PtrString(c.Address2)
+      pd.City = c.City
+      pd.State = c.State
+      pd.Country = c.Country
+      pd.Zip_Code = bank.PtrString(c.Zip_code)
+      pd.Email = c.Email
+      pd.Phone = c.Phone
+      pd.ErrMsg = err.Error()
+    }
+  } else if req.Method != http.MethodGet {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  renderSignup(res, "signup.html", pd)
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
+
+type verifySignupData struct {
+  LayoutType string
+  Header string
+  //The email address was verified; otherwise, the form to request a new link is shown.
+  Verified bool
+  //True if the account can be used now.
+  Active bool
+  ErrMsg string
+}
+
+//Verify the email address with the link (GET), or request a new link (POST).
+func (p WfPages) VerifySignupPage(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering VerifySignupPage.", correlationId)
+  pd := &verifySignupData{ LayoutType: "std-wo-headers", Header: "Verify Email Address" }
+  req = req.WithContext(authz.AuditContext(req, ""))
+  if req.Method == http.MethodGet {
+    payload, err := security.VerifySignedToken(getSignupKey(correlationId), signupPurpose,
+      strings.TrimSpace(req.FormValue("token")), time.Now())
+    var id int64
+    if err == nil {
+      id, err = strconv.ParseInt(payload, 10, 32)
+    }
+    var status int
+    if err == nil {
+      status, err = bank.DbVerifySignup(req.Context(), int32(id), correlationId)
+    }
+    if errors.Is(err, security.ErrExpiredSignedToken) {
+      pd.ErrMsg = "The verification link expired; request a new one below."
+    } else if errors.Is(err, bank.ErrInvalidSignup) || errors.Is(err, security.ErrInvalidSignedToken) ||
+              errors.Is(err, strconv.ErrSyntax) || errors.Is(err, strconv.ErrRange) {
+      pd.ErrMsg = bank.ErrInvalidSignup.Error()
+    } else if err != nil {
+      pd.ErrMsg = "The email address cannot be verified right now; try again later."
+    } else if pd.Verified = true; status == bank.SignupPendingApproval {
+      pd.ErrMsg = "Your email address was verified. An administrator must approve your account before you can log in."
+    } else {
+      pd.Active = true
+      pd.ErrMsg = "Your email address was verified; you can log in now."
+    }
+  } else if req.Method == http.MethodPost {
+    un := strings.TrimSpace(req.PostFormValue("uname"))
+    pd.ErrMsg = signupResentMsg
+    if !honeypotFilled(req, un, correlationId) && un != "" {
+      id, email, err := bank.DbGetUnverifiedSignup(req.Context(), un, correlationId)
+      if err == nil && id != 0 {
+        go sendSignupVerification(context.WithoutCancel(req.Context()), un, id, email, correlationId)
+      }
+    }
+  } else {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  renderSignup(res, "verify.html", pd)
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
@@ -0,0 +1,371 @@
+package webfinances
+
+import (
+  "crypto/rand"
+  "encoding/hex"
+  "errors"
+  "finance/authz"
+  bank "finance/databases/banking"
+  "finance/renderer"
+  "finance/security"
+  "fmt"
+  "net/http"
+  "strings"
+  "sync"
+  "time"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+  "github.com/juan-carlos-trimino/gpsessions"
+)
+
+//The issuer shown by the authenticator apps next to the account name.
+const totpIssuer = "Finances App"
+const pendingLoginCookie = "pending_login"
+//Time to enter the second factor after the password was accepted.
+const pendingLoginTtl = 5 * time.Minute
+const maxSecondFactorAttempts = 5
+
+/***
+A login whose password was accepted but whose second factor was not checked yet. It lives only in
+memory and it is identified by a random token in a cookie; no session exists until the second step
+succeeds.
+***/
+type pendingLogin struct {
+  userName string
+  isAdmin bool
+  attempts int
+  expiry time.Time
+}
+
+var pendingLogins = struct {
+  sync.Mutex
+  m map[string]*pendingLogin  //key: token
+} { m: map[string]*pendingLogin{} }
+
+func addPendingLogin(p *pendingLogin) (string, error) {
+  b := make([]byte, 32)
+  if _, err := rand.Read(b); err != nil {
+    return "", err
+  }
+  token := hex.EncodeToString(b)
+  pendingLogins.Lock()
+  defer pendingLogins.Unlock()
+  //Drop the logins that were abandoned.
+  for k, v := range pendingLogins.m {
+    if time.Now().After(v.expiry) {
+      delete(pendingLogins.m, k)
+    }
+  }
+  pendingLogins.m[token] = p
+  return token, nil
+}
+
+func getPendingLogin(req *http.Request) (string, *pendingLogin) {
+  cookie, err := req.Cookie(pendingLoginCookie)
+  if err != nil {
+    return "", nil
+  }
+  pendingLogins.Lock()
+  defer pendingLogins.Unlock()
+  p, ok := pendingLogins.m[cookie.Value]
+  if !ok {
+    return "", nil
+  }
+  if time.Now().After(p.expiry) {
+    delete(pendingLogins.m, cookie.Value)
+    return "", nil
+  }
+  return cookie.Value, p
+}
+
+//Count a failed attempt; the pending login is dropped after too many of them.
+func failPendingLogin(token string, p *pendingLogin) bool {
+  pendingLogins.Lock()
+  defer pendingLogins.Unlock()
+  p.attempts++
+  if p.attempts >= maxSecondFactorAttempts {
+    delete(pendingLogins.m, token)
+    return false
+  }
+  return true
+}
+
+func deletePendingLogin(res http.ResponseWriter, token string) {
+  pendingLogins.Lock()
+  delete(pendingLogins.m, token)
+  pendingLogins.Unlock()
+  http.SetCookie(res, &http.Cookie{
+    Name: pendingLoginCookie,
+    Value: "",
+    Path: "/",
+    MaxAge: -1,
+    HttpOnly: true,
+    SameSite: http.SameSiteStrictMode,
+  })
+}
+
+type twoFactorData struct {
+  LayoutType string
+  Header string
+  Datetime string
+  MenuPage string
+  CsrfToken string
+  //The page that receives the forms: /verify_totp during the login, /twofactor afterwards, and
+  ///enroll_totp with an enrollment link.
+  Action string
+  Token string  //The token of the enrollment link.
+  Uri string
+  Secret string
+  RecoveryCodes []string
+  Enabled bool
+  Pending bool
+  Required bool
+  RecoveryLeft int32
+  Back string
+  ErrMsg string
+}
+
+func renderTwoFactor(res http.ResponseWriter, page string, pd *twoFactorData) {
+  templatesNeeded := []string{
+    "webfinances/templates/layout.html",
+    "webfinances/templates/twofactor/" + page,
+    "webfinances/templates/twofactor/helpers.html",
+    "webfinances/templates/title.html",
+    "webfinances/templates/datetime.html",
+    "webfinances/templates/navbar.html",
+    "webfinances/templates/footer.html",
+  }
+  renderer.Render(res, "layout", templatesNeeded, renderer.PageData{ Data: pd })
+}
+
+//Generate and store a new (unconfirmed) secret; it returns the provisioning URI.
+func startEnrollment(req *http.Request, userName, correlationId string) (string, string, error) {
+  secret, err := security.GenerateTotpSecret()
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error generating the TOTP secret: %v", err), correlationId)
+    return "", "", err
+  }
+  if err = bank.DbStartTotpEnrollment(req.Context(), userName, secret, correlationId); err != nil {
+    return "", "", err
+  }
+  return secret, security.TotpProvisioningUri(totpIssuer, userName, secret), nil
+}
+
+/***
+The password was accepted; ask for the second factor. A user who must use it but has not enrolled
+yet does not get here (see VerifyLogin): the password alone must not enroll an authenticator.
+***/
+func startSecondFactor(res http.ResponseWriter, req *http.Request, un string, isAdmin bool, correlationId string) {
+  p := &pendingLogin{
+    userName: un,
+    isAdmin: isAdmin,
+    expiry: time.Now().Add(pendingLoginTtl),
+  }
+  token, err := addPendingLogin(p)
+  if err != nil {
+    logger.LogError(fmt.Sprintf("Error creating the pending login: %v", err), correlationId)
+    invalidSession(res, correlationId)
+    return
+  }
+  http.SetCookie(res, &http.Cookie{
+    Name: pendingLoginCookie,
+    Value: token,
+    Path: "/",
+    Expires: p.expiry,
+    HttpOnly: true,
+    SameSite: http.SameSiteStrictMode,
+  })
+  pd := &twoFactorData{ LayoutType: "std-wo-headers", Header: "Two-Factor Authentication", Action: "/verify_totp" }
+  renderTwoFactor(res, "verify.html", pd)
+}
+
+/***
+The second step of the login. The session is created only after a valid code from the authenticator
+app or an unused recovery code.
+***/
+func (p WfPages) VerifyTotp(res http.ResponseWriter, req *http.Request) {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering VerifyTotp.", correlationId)
+  //Only allow POST requests.
+  if req.Method != http.MethodPost {
+    logger.LogInfo("Method not allowed.", correlationId)
+    http.Error(res, "Method not allowed.", http.StatusMethodNotAllowed)
+    return
+  }
+  token, pending := getPendingLogin(req)
+  if pending == nil {
+    invalidSession(res, correlationId)
+    return
+  }
+  code := req.PostFormValue("code")
+  pd := &twoFactorData{ LayoutType: "std-wo-headers", Header: "Two-Factor Authentication", Action: "/verify_totp" }
+  auditCtx := authz.AuditContext(req, pending.userName)
+  if bank.DbVerifySecondFactor(req.Context(), pending.userName, code, correlationId) {
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditSecondFactor, "", bank.AuditSuccess, "", correlationId)
+    deletePendingLogin(res, token)
+    if startSession(res, pending.userName, pending.isAdmin, correlationId) {
+      redirectToWelcome(res, req, pending.isAdmin)
+    }
+  } else if failPendingLogin(token, pending) {
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditSecondFactor, "", bank.AuditFailure, "", correlationId)
+    logger.LogWarning(fmt.Sprintf("Invalid second factor for user %s.", pending.userName), correlationId)
+    pd.ErrMsg = "Invalid authentication code."
+    renderTwoFactor(res, "verify.html", pd)
+  } else {
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditSecondFactor, "", bank.AuditFailure, "too many attempts",
+      correlationId)
+    logger.LogWarning(fmt.Sprintf("Too many invalid second factors for user %s.", pending.userName), correlationId)
+    deletePendingLogin(res, token)
+    invalidSession(res, correlationId)
+  }
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+}
+
+/***
+Enroll in two-factor authentication with a one-time link issued by an admin (see the users pages of
+the admin area); an admin who must use it but has not enrolled cannot log in without one. No session
+is created: the user logs in afterwards with the password and a code.
+***/
+func (p WfPages) EnrollTotpPage(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering EnrollTotpPage.", correlationId)
+  if req.Method != http.MethodPost && req.Method != http.MethodGet {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  pd := &twoFactorData{ LayoutType: "std-wo-headers", Header: "Two-Factor Authentication", Action: "/enroll_totp",
+                        Back: "/login" }
+  //The token comes in the link (GET) and then in a hidden field (POST).
+  pd.Token = strings.TrimSpace(req.FormValue("token"))
+  tokenHash := security.HashUrlToken(pd.Token)
+  userName, err := bank.DbGetTotpEnrollmentUser(req.Context(), tokenHash, correlationId)
+  if pd.Token == "" || err != nil {
+    pd.Token = ""
+    pd.ErrMsg = bank.ErrInvalidEnrollmentToken.Error()
+    if err != nil && !errors.Is(err, bank.ErrInvalidEnrollmentToken) {
+      pd.ErrMsg = "The enrollment cannot be started right now; try again later."
+    }
+  } else if req.Method == http.MethodGet {
+    if pd.Secret, pd.Uri, err = startEnrollment(req, userName, correlationId); errors.Is(err, bank.ErrTotpAlreadyEnrolled) {
+      pd.ErrMsg = err.Error()
+    } else if err != nil {
+      pd.ErrMsg = "The enrollment cannot be started right now; try again later."
+    }
+  } else {
+    auditCtx := authz.AuditContext(req, userName)
+    codes, err := bank.DbConfirmTotp(req.Context(), userName, req.PostFormValue("code"), correlationId)
+    if err == nil {
+      bank.DbUseTotpEnrollmentLink(req.Context(), tokenHash, correlationId)
+      bank.DbWriteAuditEvent(auditCtx, bank.AuditTwoFactorEnrollLink, "", bank.AuditSuccess, "enrolled", correlationId)
+      pd.RecoveryCodes = codes
+      renderTwoFactor(res, "recovery.html", pd)
+      logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+      return nil
+    }
+    bank.DbWriteAuditEvent(auditCtx, bank.AuditTwoFactorEnrollLink, "", bank.AuditFailure, err.Error(), correlationId)
+    pd.ErrMsg = "The enrollment could not be confirmed."
+    if errors.Is(err, bank.ErrInvalidTotpCode) || errors.Is(err, bank.ErrTotpAlreadyEnrolled) {
+      pd.ErrMsg = err.Error()
+    }
+    //The secret of the enrollment that was started by the link.
+    if tf, err := bank.DbGetTwoFactor(req.Context(), userName, correlationId); err == nil && !tf.Enabled() &&
+       tf.Secret != nil {
+      pd.Secret = *tf.Secret
+      pd.Uri = security.TotpProvisioningUri(totpIssuer, userName, pd.Secret)
+    }
+  }
+  renderTwoFactor(res, "link.html", pd)
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
+
+//Enroll in (or disable) two-factor authentication.
+func (p WfPages) TwoFactorPage(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering webfinances.TwoFactorPage.", correlationId)
+  sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+  if sessionToken == "" {
+    invalidSession(res, correlationId)
+    return nil
+  }
+  if req.Method != http.MethodPost && req.Method != http.MethodGet {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  userName := sessions.GetUserName(sessionToken)
+  req = req.WithContext(authz.AuditContext(req, userName))
+  tf, err := bank.DbGetTwoFactor(req.Context(), userName, correlationId)
+  if err != nil {
+    invalidSession(res, correlationId)
+    return nil
+  }
+  pd := &twoFactorData{
+    LayoutType: "standard",
+    Header: "Two-Factor Authentication",
+    Datetime: logger.DatetimeFormat(),
+    MenuPage: financesMenuPage,
+    Action: "/twofactor",
+    Back: "/welcome",
+  }
+  if tf.Is_admin {
+    pd.LayoutType = "std-wo-nav-menu"
+    pd.MenuPage = ""
+    pd.Back = "/admin/settings/security?db=rhs-ui2"
+  }
+  if req.Method == http.MethodPost {
+    switch req.PostFormValue("action") {
+    case "start":
+      if pd.Secret, pd.Uri, err = startEnrollment(req, userName, correlationId); err != nil {
+        pd.ErrMsg = "The enrollment could not be started."
+        if errors.Is(err, bank.ErrTotpAlreadyEnrolled) {
+          pd.ErrMsg = err.Error()
+        }
+      }
+    case "confirm":
+      codes, err := bank.DbConfirmTotp(req.Context(), userName, req.PostFormValue("code"), correlationId)
+      if err == nil {
+        pd.RecoveryCodes = codes
+      } else if errors.Is(err, bank.ErrInvalidTotpCode) {
+        pd.ErrMsg = "Invalid authentication code."
+      } else {
+        pd.ErrMsg = "The enrollment could not be confirmed."
+      }
+    case "disable":
+      //The current code (or a recovery code) is required; a stolen session is not enough.
+      if tf.Required {
+        pd.ErrMsg = "Two-factor authentication is required for admin accounts."
+      } else if !bank.DbVerifySecondFactor(req.Context(), userName, req.PostFormValue("code"), correlationId) {
+        pd.ErrMsg = "Invalid authentication code."
+      } else if bank.DbResetTwoFactor(req.Context(), userName, correlationId) {
+        pd.ErrMsg = "Two-factor authentication has been disabled."
+      }
+    }
+    if tf, err = bank.DbGetTwoFactor(req.Context(), userName, correlationId); err != nil {
+      invalidSession(res, correlationId)
+      return nil
+    }
+  }
+  pd.Enabled = tf.Enabled()
+  pd.Required = tf.Required
+  pd.RecoveryLeft = tf.Recovery_left
+  //An enrollment that was started but not confirmed.
+  if !pd.Enabled && tf.Secret != nil {
+    pd.Pending = true
+    pd.Secret = *tf.Secret
+    pd.Uri = security.TotpProvisioningUri(totpIssuer, userName, pd.Secret)
+  }
+  newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+  cookie := sessions.CreateCookie(newSessionToken)
+  http.SetCookie(res, cookie)
+  pd.CsrfToken = newSession.CsrfToken
+  renderTwoFactor(res, "twofactor.html", pd)
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
@@ -11,7 +11,8 @@ document.addEventListener('DOMContentLoaded', () => {
     '1': 'A - G',
     '2': 'H - N',
     '3': 'O - T',
-    '4': 'U - Z'
+    '4': 'U - Z',
+    '5': 'Other'
   };
   slider.addEventListener('input', (event) => {
     const selectedValue = event.target.value;
@@ -0,0 +1,205 @@
+package wfadmin
+
+import (
+  "encoding/csv"
+  bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
+  "finance/renderer"
+  "fmt"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gplogger"
+  "github.com/juan-carlos-trimino/gpsessions"
+  "net/http"
+  "net/url"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const auditPageSize = 50
+
+type AuditRow struct {
+  When string
+  Type string
+  Actor string
+  Target string
+  ClientIp string
+  Outcome string
+  CorrelationId string
+  Details string
+}
+
+func auditRow(e bank.AuditEvent) AuditRow {
+  return AuditRow{
+    When: e.Occurred_at.UTC().Format("2006-01-02 15:04:05"),
+    Type: e.Event_type,
+    Actor: e.Actor,
+    Target: bank.PtrString(e.Target),
+    ClientIp: bank.PtrString(e.Client_ip),
+    Outcome: e.Outcome,
+    CorrelationId: bank.PtrString(e.Correlation_id),
+    Details: bank.PtrString(e.Details),
+  }
+}
+
+/***
+The filter comes in the query string (GET), so a search can be bookmarked and exported with the same
+parameters. The dates are days (yyyy-mm-dd, UTC); the "to" day is included.
+***/
+func auditFilterFromQuery(q url.Values) (bank.AuditFilter, string) {
+  f := bank.AuditFilter{
+    EventType: q.Get("type"),
+    Outcome: q.Get("outcome"),
+    Search: strings.TrimSpace(q.Get("search")),
+  }
+  if from := q.Get("from"); from != "" {
+    if t, err := time.Parse("2006-01-02", from); err != nil {
+      return f, "Invalid from date."
+    } else {
+      f.From = &t
+    }
+  }
+  if to := q.Get("to"); to != "" {
+    if t, err := time.Parse("2006-01-02", to); err != nil {
+      return f, "Invalid to date."
+    } else {
+      t = t.AddDate(0, 0, 1)
+      f.To = &t
+    }
+  }
+  return f, ""
+}
+
+//A link to the audit page with the same filter; the key is "page" or "export".
+func auditLink(q url.Values, key, value string) string {
+  v := url.Values{}
+  for _, k := range []string{ "from", "to", "type", "outcome", "search" } {
+    if q.Get(k) != "" {
+      v.Set(k, q.Get(k))
+    }
+  }
+  v.Set(key, value)
+  return "/admin/audit?" + v.Encode()
+}
+
+//The usernames of failed logins are whatever was typed; a spreadsheet must not run them as formulas.
+func csvCell(s string) string {
+  if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+    return "'" + s
+  }
+  return s
+}
+
+//Write the events as CSV; the export is not paged.
+func exportAuditEvents(res http.ResponseWriter, events []bank.AuditEvent, correlationId string) {
+  res.Header().Set("Content-Type", "text/csv; charset=utf-8")
+  res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"audit-%s.csv\"",
+    time.Now().UTC().Format("20060102-150405")))
+  w := csv.NewWriter(res)
+  w.Write([]string{ "id", "occurred_at", "event_type", "actor", "target", "client_ip", "outcome", "correlation_id",
+    "details" })
+  for _, e := range events {
+    r := auditRow(e)
+    w.Write([]string{ strconv.FormatInt(e.Id, 10), e.Occurred_at.UTC().Format(time.RFC3339), r.Type, csvCell(r.Actor),
+      csvCell(r.Target), csvCell(r.ClientIp), r.Outcome, csvCell(r.CorrelationId), csvCell(r.Details) })
+  }
+  w.Flush()
+  if err := w.Error(); err != nil {
+    logger.LogError(fmt.Sprintf("Error exporting the audit events: %v", err), correlationId)
+  }
+}
+
+//Search and export the security audit log.
+func (s WfAdminPages) AuditPage(res http.ResponseWriter, req *http.Request) {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering wfadmin.AuditPage.", correlationId)
+  sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+  if sessionToken == "" {
+    invalidSession(res, correlationId)
+    return
+  }
+  if req.Method != http.MethodGet {
+    logger.LogInfo("Method not allowed.", correlationId)
+    http.Error(res, "Method not allowed.", http.StatusMethodNotAllowed)
+    return
+  }
+  q := req.URL.Query()
+  filter, errMsg := auditFilterFromQuery(q)
+  if errMsg == "" && q.Get("export") == "csv" {
+    if events, _, err := bank.DbSearchAuditEvents(req.Context(), filter, correlationId); err != nil {
+      http.Error(res, "Unable to export the audit events.", http.StatusInternalServerError)
+    } else {
+      logger.LogInfo(fmt.Sprintf("Exporting %d audit event(s).", len(events)), correlationId)
+      exportAuditEvents(res, events, correlationId)
+    }
+    logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+    return
+  }
+  page := 1
+  if p, err := strconv.Atoi(q.Get("page")); err == nil && p > 0 {
+    page = p
+  }
+  var rows []AuditRow
+  total := 0
+  if errMsg == "" {
+    filter.Limit = auditPageSize
+    filter.Offset = (page - 1) * auditPageSize
+    events, n, err := bank.DbSearchAuditEvents(req.Context(), filter, correlationId)
+    if err != nil {
+      errMsg = "Unable to retrieve the audit events."
+    }
+    total = n
+    for _, e := range events {
+      rows = append(rows, auditRow(e))
+    }
+  }
+  pages := (total + auditPageSize - 1) / auditPageSize
+  if pages == 0 {
+    pages = 1
+  }
+  prevLink, nextLink := "", ""
+  if page > 1 {
+    prevLink = auditLink(q, "page", strconv.Itoa(page - 1))
+  }
+  if page < pages {
+    nextLink = auditLink(q, "page", strconv.Itoa(page + 1))
+  }
+  newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+  cookie := sessions.CreateCookie(newSessionToken)
+  http.SetCookie(res, cookie)
+  templatesNeeded := []string{
+    "webfinances/templates/layout.html",
+    "webfinances/templates/admin/audit.html",
+    "webfinances/templates/helpers/table-container.html",
+    "webfinances/templates/title.html",
+    "webfinances/templates/datetime.html",
+    "webfinances/templates/footer.html",
+  }
+  renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+    Data: struct{
+      LayoutType string
+      Header string
+      Datetime string
+      CsrfToken string
+      From string
+      To string
+      Type string
+      Outcome string
+      Search string
+      EventTypes []string
+      Total int
+      Page int
+      Pages int
+      PrevLink string
+      NextLink string
+      ExportLink string
+      Events []AuditRow
+      ErrMsg string
+    } { "std-wo-nav-menu", "Audit Log - Admin", logger.DatetimeFormat(), newSession.CsrfToken, q.Get("from"), q.Get("to"),
+        filter.EventType, filter.Outcome, filter.Search, bank.AuditEventTypes, total, page, pages, prevLink, nextLink,
+        auditLink(q, "export", "csv"), rows, errMsg },
+  })
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+}
@@ -1,6 +1,7 @@
 package wfadmin
 
 import (
+  bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
   "finance/renderer"
   "fmt"
   "github.com/juan-carlos-trimino/go-middlewares"
@@ -94,3 +95,60 @@ func (s WfAdminPages) AdminSettingsPage(res http.ResponseWriter, req *http.Reque
   })
   logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
 }
+
+type LedgerRow struct {  //Rows for the transfers that violate the ledger invariant.
+  TransferId string
+  Username string
+  Problem string
+}
+
+//Run the consistency checks of the database and show the results.
+func (s WfAdminPages) DiagnosticsPage(res http.ResponseWriter, req *http.Request) {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering wfadmin.DiagnosticsPage.", correlationId)
+  sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+  if sessionToken == "" {
+    invalidSession(res, correlationId)
+    return
+  }
+  var summary string
+  var rows []LedgerRow
+  checked, failed, err := bank.DbCheckLedgerInvariant(req.Context(), correlationId)
+  if err != nil {
+    summary = "Unable to check the ledger."
+  } else {
+    summary = fmt.Sprintf("Ledger: %d transfer(s) checked, %d violation(s).", checked, len(failed))
+    for idx := range failed {
+      rows = append(rows, LedgerRow{
+        TransferId: failed[idx].Transfer_id,
+        Username: failed[idx].Username,
+        Problem: failed[idx].Problem(),
+      })
+    }
+  }
+  newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+  cookie := sessions.CreateCookie(newSessionToken)
+  http.SetCookie(res, cookie)
+  templatesNeeded := []string{
+    "webfinances/templates/layout.html",
+    "webfinances/templates/admin/diagnostics.html",
+    "webfinances/templates/helpers/table-container.html",
+    "webfinances/templates/title.html",
+    "webfinances/templates/datetime.html",
+    "webfinances/templates/footer.html",
+  }
+  renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+    Data: struct{
+      LayoutType string
+      Header string
+      Datetime string
+      CsrfToken string
+      Summary string
+      Violations []LedgerRow
+    } { "std-wo-nav-menu", "Diagnostics - Admin", logger.DatetimeFormat(), newSession.CsrfToken, summary, rows },
+  })
+  logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+}
@@ -2,16 +2,16 @@ package wfadmin
 
 import (
   "context"
-  "encoding/json"
+  "errors"
+  "finance/authz"
   "finance/databases/banking"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/go-middlewares"
   "github.com/juan-carlos-trimino/gplogger"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strings"
   "time"
 )
@@ -21,44 +21,24 @@ type settingsFields struct{
   CurrentButton string `json:"currentButton"`
 }
 
-func newSettingsFields(dir1, dir2, correlationId string) *settingsFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newSettingsFields() *settingsFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := settingsFields {
     CurrentButton: "lhs-button1",
     CurrentPage: "rhs-ui1",
   }
-  obj, err := readFields(dir + "settings.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "settings.txt"), correlationId)
-  }
   return &m
 }
 
-func getSettingsFields(userName string) *settingsFields {
-  return currentFields[userName].settings
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getSettingsFields(ctx context.Context, userName, correlationId string) (*settingsFields, func()) {
+  m := newSettingsFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "settings", m, correlationId)
 }
 
 type WfAdminSettingsPages struct{}
 
-func (s WfAdminSettingsPages) AdminSettingsPages(res http.ResponseWriter, req *http.Request) {
+func (s WfAdminSettingsPages) AdminSettingsPages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -67,12 +47,15 @@ func (s WfAdminSettingsPages) AdminSettingsPages(res http.ResponseWriter, req *h
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getSettingsFields(userName)
+    //The audit events written by the admin actions name this user and the client.
+    req = req.WithContext(authz.AuditContext(req, userName))
+    fields, unlock := getSettingsFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -103,22 +86,29 @@ func (s WfAdminSettingsPages) AdminSettingsPages(res http.ResponseWriter, req *h
         Old string
         New string
         Confirm string
+        PwdErrors []string  //Field-level messages for the new password.
         ErrMsg string
-      } { "std-wo-nav-menu", "Settings - Admin", logger.DatetimeFormat(), fields.CurrentButton, "", "", "", "", "", "" }
+      } { "std-wo-nav-menu", "Settings - Admin", logger.DatetimeFormat(), fields.CurrentButton, "", "", "", "", "", nil,
+          "" }
       if req.Method == http.MethodPost {
         username := req.PostFormValue("un")
         old := req.PostFormValue("oldpwd")
         new := req.PostFormValue("newpwd")
         confirm := req.PostFormValue("connewpwd")
-        if strings.EqualFold(new, confirm) {
-          ok := banking.DbChangePassword(req.Context(), username, old, new, correlationId)
-          if ok {
-            pd.ErrMsg = "Your password has been successfully updated!"
-          } else {
-            pd.ErrMsg = "Your password was NOT successfully updated!"
+        //Passwords are case sensitive.
+        if new != confirm {
+          pd.ErrMsg = "New password and confirmation password do not match."
+        } else if pd.PwdErrors = banking.ValidatePassword(new, username, correlationId); len(pd.PwdErrors) > 0 {
+          pd.Username = username
+          pd.ErrMsg = "Your password was NOT successfully updated!"
+        } else if err := banking.DbChangePassword(req.Context(), username, old, new, correlationId); err != nil {
+          if errors.Is(err, banking.ErrPasswordReused) {
+            pd.PwdErrors = []string{ err.Error() }
           }
+          pd.Username = username
+          pd.ErrMsg = "Your password was NOT successfully updated!"
         } else {
-          pd.ErrMsg = "New password and confirmation password do not match."
+          pd.ErrMsg = "Your password has been successfully updated!"
         }
         logger.LogInfo(fmt.Sprintf("%s", pd.ErrMsg), correlationId)
       }
@@ -135,28 +125,93 @@ func (s WfAdminSettingsPages) AdminSettingsPages(res http.ResponseWriter, req *h
       }
       pd.CsrfToken = newSession.CsrfToken
       renderer.Render(res, "layout", templatesNeeded, renderer.PageData{ Data: pd})
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui2") {
+      fields.CurrentButton = "lhs-button2"
+      var errMsg string
+      if req.Method == http.MethodPost && req.PostFormValue("save2fa") != "" {
+        required := req.PostFormValue("require2fa") == "on"
+        //Once it is required, an admin who has not enrolled cannot log in; the admin turning it on must not be one.
+        if tf, err := banking.DbGetTwoFactor(req.Context(), userName, correlationId); required &&
+           (err != nil || !tf.Enabled()) {
+          errMsg = "Enable your own two-factor authentication first; the setting was NOT saved."
+        } else if err := banking.DbSetRequireAdmin2fa(req.Context(), required, correlationId); err != nil {
+          errMsg = "The setting was NOT saved."
+        } else {
+          errMsg = "The setting was saved."
+          logger.LogInfo(fmt.Sprintf("Two-factor authentication required for admins: %t (by %s).", required, userName),
+            correlationId)
+        }
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/admin/settings/security/security.html",
+        "webfinances/templates/admin/settings/security/twofactor.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct{
+          LayoutType string
+          Header string
+          Datetime string
+          CurrentButton string
+          CsrfToken string
+          Require2fa bool
+          ErrMsg string
+        } { "std-wo-nav-menu", "Settings - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
+            banking.DbGetRequireAdmin2fa(req.Context(), correlationId), errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui3") {
+      fields.CurrentButton = "lhs-button3"
+      var errMsg string
+      if req.Method == http.MethodPost && req.PostFormValue("savesignup") != "" {
+        required := req.PostFormValue("requireapproval") == "on"
+        if err := banking.DbSetRequireSignupApproval(req.Context(), required, correlationId); err != nil {
+          errMsg = "The setting was NOT saved."
+        } else {
+          errMsg = "The setting was saved."
+          logger.LogInfo(fmt.Sprintf("Approval of sign-ups required: %t (by %s).", required, userName), correlationId)
+        }
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/admin/settings/security/security.html",
+        "webfinances/templates/admin/settings/security/signups.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct{
+          LayoutType string
+          Header string
+          Datetime string
+          CurrentButton string
+          CsrfToken string
+          RequireApproval bool
+          ErrMsg string
+        } { "std-wo-nav-menu", "Settings - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
+            banking.DbGetRequireSignupApproval(req.Context(), correlationId), errMsg },
+      })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
       logger.LogWarning("*** Request timeout ***", correlationId)
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/settings.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "settings", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
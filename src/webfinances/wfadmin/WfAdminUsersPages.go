@@ -3,15 +3,19 @@ package wfadmin
 import (
   bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
   "context"
-  "encoding/json"
+  "errors"
+  "finance/authz"
+  "finance/config"
   "finance/renderer"
+  "finance/security"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/go-middlewares"
   "github.com/juan-carlos-trimino/gplogger"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
+  "net/url"
+  "strconv"
   "strings"
   "time"
 )
@@ -20,47 +24,166 @@ type usersFields struct {
   CurrentButton string `json:"currentButton"`
   CurrentPage string  `json:"currentPage"`
   SelectedRange string `json:"selectedRange"`
+  Search string `json:"search"`
+  Sort string `json:"sort"`
+  Page int `json:"page"`
 }
 
-func newUsersFields(dir1, dir2, correlationId string) *usersFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newUsersFields() *usersFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := usersFields {
     CurrentButton: "lhs-button1",
     CurrentPage: "rhs-ui1",
     SelectedRange: "1",
-  }
-  obj, err := readFields(dir + "users.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "users.txt"), correlationId)
+    Sort: bank.CustomerSortName,
+    Page: 1,
   }
   return &m
 }
 
-func getUsersFields(userName string) *usersFields {
-  return currentFields[userName].users
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getUsersFields(ctx context.Context, userName, correlationId string) (*usersFields, func()) {
+  m := newUsersFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "users", m, correlationId)
 }
 
 type WfAdminUsersPages struct {}
 
-func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Request) {
+//Number of customers per page in the directory.
+const customersPageSize = 20
+
+/***
+The last-name range of the alphabet slider; the last range (otherRange) holds the last names that
+do not start with a letter A-Z.
+***/
+const otherRange = "5"
+
+func alphabetRangeLetters(selectedRange string) (string, string) {
+  switch selectedRange {
+  case "2":
+    return "H", "N"
+  case "3":
+    return "O", "T"
+  case "4":
+    return "U", "Z"
+  default:
+    return "A", "G"
+  }
+}
+
+type CustomerRow struct {  //Rows for the customer directory.
+  Id int32
+  Username string
+  Name string
+  Email string
+  Location string
+  Admin bool
+  Active bool
+  Since string
+}
+
+type InactiveRow struct {  //Rows for the unregistered customers.
+  Username string
+  Name string
+  DeactivatedAt string
+  Reason string
+  Purged bool
+}
+
+type CustomerDetailRow struct {  //Label-value pairs of the customer detail view.
+  Label string
+  Value string
+}
+
+type ThrottledRow struct {  //Rows for the users with failed login attempts.
+  Username string
+  FailedAttempts int32
+  LastAttempt string
+  LockedUntil string
+  Locked bool
+}
+
+func formatTime(t *time.Time) string {
+  if t == nil {
+    return "-"
+  }
+  return t.Format("2006-01-02 15:04:05")
+}
+
+func customerDetailRows(c *bank.CustomerDetails) []CustomerDetailRow {
+  yesNo := func(b bool) string {
+    if b {
+      return "Yes"
+    }
+    return "No"
+  }
+  name := c.First_name
+  if m := bank.PtrString(c.Middle_name); m != "" {
+    name += " " + m
+  }
+  name += " " + c.Last_name
+  birthDate := "-"
+  if c.Birth_date != nil {
+    birthDate = c.Birth_date.Format("2006-01-02")
+  }
+  address := strings.TrimSpace(bank.PtrString(c.Address1) + " " + bank.PtrString(c.Address2))
+  return []CustomerDetailRow{
+    { "Username", c.User_name },
+    { "Name", name },
+    { "Admin", yesNo(c.Is_admin) },
+    { "Birth Date", birthDate },
+    { "Gender", bank.PtrString(c.Gender) },
+    { "Address", address },
+    { "City", bank.PtrString(c.City_name) },
+    { "State", bank.PtrString(c.State_name) },
+    { "Country", bank.PtrString(c.Country_name) },
+    { "Zip Code", bank.PtrString(c.Zip_code) },
+    { "Email", bank.PtrString(c.Email) },
+    { "Phone", bank.PtrString(c.Phone) },
+    { "Marketing Consent", yesNo(c.Marketing_consent) },
+    { "Two-Factor Authentication", yesNo(c.Two_factor) },
+    { "Active", yesNo(c.Is_active) },
+    { "Deactivated", formatTime(c.Deactivated_at) },
+    { "Deactivation Reason", bank.PtrString(c.Deactivation_reason) },
+    { "Purged", formatTime(c.Purged_at) },
+    { "Failed Login Attempts", fmt.Sprint(c.Failed_attempts) },
+    { "Last Failed Attempt", formatTime(c.Last_attempt) },
+    { "Locked Until", formatTime(c.Locked_until) },
+    { "Created", formatTime(c.Created_at) },
+    { "Updated", formatTime(c.Updated_at) },
+  }
+}
+
+type RolesRow struct {  //Rows for the roles of the users.
+  Username string
+  Admin bool
+  Roles string
+}
+
+type RoleOption struct {  //A checkbox per role.
+  Name string
+  Description string
+  Permissions string
+  Checked bool
+}
+
+type SignupRow struct {  //Rows for the sign-ups waiting for approval.
+  Username string
+  Name string
+  Email string
+  Created string
+  Verified string
+}
+
+type TwoFactorRow struct {  //Rows for the users enrolled in two-factor authentication.
+  Username string
+  Admin bool
+  Status string
+  Since string
+  RecoveryLeft int32
+}
+
+func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -69,12 +192,15 @@ func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Re
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getUsersFields(userName)
+    //The audit events written by the admin actions name this user and the client.
+    req = req.WithContext(authz.AuditContext(req, userName))
+    fields, unlock := getUsersFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -117,9 +243,10 @@ func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Re
         Zip_Code string
         Email string
         Phone string
+        PwdErrors []string  //Field-level messages for the password.
         ErrMsg string
         } { "std-wo-nav-menu", "Register User - Admin", logger.DatetimeFormat(), fields.CurrentButton, "", "", "", "", "", "", "male",
-            time.Now().Format("2006-01-02"), "false", "", "", "", "", "", "", "", "", "" }
+            time.Now().Format("2006-01-02"), "false", "", "", "", "", "", "", "", "", nil, "" }
       if req.Method == http.MethodPost {
         c := bank.Customer {
           User_name: req.PostFormValue("uname"),
@@ -161,7 +288,11 @@ func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Re
           c.Birth_date = bank.TimePtr(newDate)
         } else {
           c.Birth_date = bank.TimePtr(newDate)
-          err = bank.DbAddCustomer(&c, context.Background(), correlationId)
+          if pd.PwdErrors = bank.ValidatePassword(c.Password, c.User_name, correlationId); len(pd.PwdErrors) > 0 {
+            err = bank.ErrPasswordPolicy
+          } else {
+            err = bank.DbAddCustomer(&c, req.Context(), correlationId)
+          }
         }
         //
         if err != nil {
@@ -206,33 +337,60 @@ func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Re
       renderer.Render(res, "layout", templatesNeeded, renderer.PageData{ Data: pd})
     } else if strings.EqualFold(fields.CurrentPage, "rhs-ui2") {
       fields.CurrentButton = "lhs-button2"
-      if req.Method == http.MethodPost {
-        //Go is designed to look at the request, realize the body hasn't been parsed yet, and automatically call ParseForm() for you under the hood.
-
-        fields.SelectedRange = req.PostFormValue("alphabet-range")
-
-
-        var minLetter, maxLetter string
-        switch fields.SelectedRange {
-        case "1":
-          minLetter, maxLetter = "A", "G"
-        case "2":
-          minLetter, maxLetter = "H", "N"
-        case "3":
-          minLetter, maxLetter = "O", "T"
-        case "4":
-          minLetter, maxLetter = "U", "Z"
-        default:
-          minLetter, maxLetter = "A", "G" // Fallback safety
+      var errMsg string
+      //The username can come from the customer detail view.
+      un := strings.TrimSpace(req.FormValue("fd2-user"))
+      if req.Method == http.MethodPost && un != "" {
+        reason := strings.TrimSpace(req.PostFormValue("fd2-reason"))
+        switch req.PostFormValue("fd2-action") {
+        case "deactivate":
+          if reason == "" {
+            errMsg = "A reason is required to unregister a customer."
+          } else if bank.DbDeactivateCustomer(req.Context(), un, reason, userName, correlationId) {
+            //The sessions the customer may still have end; see security.IsSessionEnded.
+            errMsg = fmt.Sprintf("The customer %s was unregistered.", un)
+            un = ""
+          } else {
+            errMsg = fmt.Sprintf("The customer %s was NOT unregistered (unknown, already inactive, or an admin).", un)
+          }
+        case "reactivate":
+          if bank.DbReactivateCustomer(req.Context(), un, userName, correlationId) {
+            errMsg = fmt.Sprintf("The customer %s was reactivated.", un)
+            un = ""
+          } else {
+            errMsg = fmt.Sprintf("The customer %s was NOT reactivated (unknown, active, or purged).", un)
+          }
+        case "purge":
+          //Erasing the personal data cannot be undone; the username must be typed again.
+          if req.PostFormValue("fd2-confirm") != un {
+            errMsg = "Type the username again to confirm the purge."
+          } else if reason == "" {
+            errMsg = "A reason is required to purge a customer."
+          } else {
+            newName, err := bank.DbPurgeCustomer(req.Context(), un, reason, userName, correlationId)
+            if err != nil {
+              errMsg = fmt.Sprintf("The customer %s was NOT purged: %v", un, err)
+            } else {
+              errMsg = fmt.Sprintf("The personal data of customer %s was erased; the customer is now %s.", un, newName)
+              un = ""
+            }
+          }
         }
-        logger.LogInfo(fmt.Sprintf("min: %s,  max: %s", minLetter, maxLetter), correlationId)
-        // 4. Pass these bounded letters into your SQL query execution
-        //query := "SELECT id, username FROM users WHERE username >= ? AND username <= ?"
-
-
-
-        // alpharange := req.PostFormValue("alphabet-range")
-        // fields.SelectedRange = SelectedRange
+      }
+      customers, err := bank.DbGetInactiveCustomers(req.Context(), correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the inactive customers."
+      }
+      rows := make([]InactiveRow, 0, len(customers))
+      for _, c := range customers {
+        row := InactiveRow{
+          Username: c.User_name,
+          Name: c.Last_name + ", " + c.First_name,
+          DeactivatedAt: formatTime(c.Deactivated_at),
+          Reason: bank.PtrString(c.Deactivation_reason),
+          Purged: c.Purged_at != nil,
+        }
+        rows = append(rows, row)
       }
       newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
       cookie := sessions.CreateCookie(newSessionToken)
@@ -241,7 +399,7 @@ func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Re
         "webfinances/templates/layout.html",
         "webfinances/templates/admin/users/users.html",
         "webfinances/templates/admin/users/unregister.html",
-        "webfinances/templates/helpers/slider-alphabet-container.html",
+        "webfinances/templates/helpers/table-container.html",
         "webfinances/templates/title.html",
         "webfinances/templates/datetime.html",
         "webfinances/templates/footer.html",
@@ -256,32 +414,405 @@ func (u WfAdminUsersPages) AdminUsersPages(res http.ResponseWriter, req *http.Re
           Datetime string
           CurrentButton string
           CsrfToken string
-          SelectedRange string
+          Username string
+          Fd2Result []InactiveRow
+          ErrMsg string
         } { "std-wo-nav-menu", "Unregister User - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
-            fields.SelectedRange },
+            un, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui3") {
+      fields.CurrentButton = "lhs-button3"
+      var errMsg string
+      if un := req.PostFormValue("unlock"); req.Method == http.MethodPost && un != "" {
+        if bank.DbUnlockUser(req.Context(), un, correlationId) {
+          errMsg = fmt.Sprintf("The user %s was unlocked.", un)
+        } else {
+          errMsg = fmt.Sprintf("The user %s was NOT unlocked.", un)
+        }
+      }
+      users, err := bank.DbGetThrottledUsers(req.Context(), correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the users."
+      }
+      rows := make([]ThrottledRow, 0, len(users))
+      for _, u := range users {
+        row := ThrottledRow{
+          Username: u.User_name,
+          FailedAttempts: u.Failed_attempts,
+          Locked: u.Locked,
+        }
+        if u.Last_attempt != nil {
+          row.LastAttempt = u.Last_attempt.Format("2006-01-02 15:04:05")
+        }
+        if u.Locked && u.Locked_until != nil {
+          row.LockedUntil = u.Locked_until.Format("2006-01-02 15:04:05")
+        }
+        rows = append(rows, row)
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/admin/users/users.html",
+        "webfinances/templates/admin/users/locked.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct{
+          LayoutType string
+          Header string
+          Datetime string
+          CurrentButton string
+          CsrfToken string
+          Fd3Result []ThrottledRow
+          ErrMsg string
+        } { "std-wo-nav-menu", "Locked Users - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
+            rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui4") {
+      fields.CurrentButton = "lhs-button4"
+      var errMsg string
+      //Resetting the enrollment lets a user who lost the authenticator enroll again.
+      if un := req.PostFormValue("reset2fa"); req.Method == http.MethodPost && un != "" {
+        if bank.DbResetTwoFactor(req.Context(), un, correlationId) {
+          errMsg = fmt.Sprintf("The two-factor authentication of user %s was reset.", un)
+        } else {
+          errMsg = fmt.Sprintf("The two-factor authentication of user %s was NOT reset.", un)
+        }
+      } else if un := strings.TrimSpace(req.PostFormValue("enrolllink")); req.Method == http.MethodPost && un != "" {
+        /***
+        An admin who must use two-factor authentication cannot enroll from the login; the link is
+        handed to the user out of band.
+        ***/
+        token, tokenHash, err := security.GenerateUrlToken()
+        if err == nil && bank.DbCreateTotpEnrollmentLink(req.Context(), un, tokenHash,
+           sessions.GetUserName(sessionToken), correlationId) {
+          errMsg = fmt.Sprintf("Hand this link to user %s; it can be used once within %d hours: %s", un,
+            bank.TotpEnrollmentHours, config.GetBaseUrl(correlationId) + "/enroll_totp?token=" + url.QueryEscape(token))
+        } else {
+          errMsg = fmt.Sprintf("No enrollment link was created for user %s.", un)
+        }
+      }
+      users, err := bank.DbGetTwoFactorUsers(req.Context(), correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the users."
+      }
+      rows := make([]TwoFactorRow, 0, len(users))
+      for _, u := range users {
+        row := TwoFactorRow{
+          Username: u.User_name,
+          Admin: u.Is_admin,
+          Status: "Pending",
+          Since: u.Created_at.Format("2006-01-02 15:04:05"),
+          RecoveryLeft: u.Recovery_left,
+        }
+        if u.Confirmed && u.Confirmed_at != nil {
+          row.Status = "Enabled"
+          row.Since = u.Confirmed_at.Format("2006-01-02 15:04:05")
+        }
+        rows = append(rows, row)
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/admin/users/users.html",
+        "webfinances/templates/admin/users/twofactor.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct{
+          LayoutType string
+          Header string
+          Datetime string
+          CurrentButton string
+          CsrfToken string
+          Fd4Result []TwoFactorRow
+          ErrMsg string
+        } { "std-wo-nav-menu", "Two-Factor Authentication - Admin", logger.DatetimeFormat(), fields.CurrentButton,
+            newSession.CsrfToken, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui5") {
+      fields.CurrentButton = "lhs-button5"
+      var errMsg string
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/admin/users/users.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/footer.html",
+      }
+      //The detail view of a customer selected in the directory.
+      if id, err := strconv.ParseInt(req.FormValue("customer"), 10, 32); err == nil {
+        var rows []CustomerDetailRow
+        var customerName string
+        if c, err := bank.DbGetCustomer(req.Context(), int32(id), correlationId); err != nil {
+          errMsg = "Unable to retrieve the customer."
+        } else {
+          rows = customerDetailRows(c)
+          customerName = c.User_name
+        }
+        templatesNeeded = append(templatesNeeded, "webfinances/templates/admin/users/customer.html",
+          "webfinances/templates/helpers/table-container.html")
+        renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+          Data: struct{
+            LayoutType string
+            Header string
+            Datetime string
+            CurrentButton string
+            CsrfToken string
+            Username string
+            Fd5Detail []CustomerDetailRow
+            ErrMsg string
+          } { "std-wo-nav-menu", "Customer - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
+              customerName, rows, errMsg },
+        })
+      } else {
+        if req.Method == http.MethodPost {
+          fields.SelectedRange = req.PostFormValue("alphabet-range")
+          fields.Search = strings.TrimSpace(req.PostFormValue("fd5-search"))
+          fields.Sort = req.PostFormValue("fd5-sort")
+          //A new filter starts at the first page; the Previous/Next buttons send the page.
+          fields.Page = 1
+          if page, err := strconv.Atoi(req.PostFormValue("fd5-page")); err == nil && page > 0 {
+            fields.Page = page
+          }
+        }
+        minLetter, maxLetter := alphabetRangeLetters(fields.SelectedRange)
+        filter := bank.CustomerFilter{
+          MinLetter: minLetter,
+          MaxLetter: maxLetter,
+          OtherLetters: fields.SelectedRange == otherRange,
+          Search: fields.Search,
+          Sort: fields.Sort,
+          Limit: customersPageSize,
+          Offset: (fields.Page - 1) * customersPageSize,
+        }
+        customers, total, err := bank.DbGetCustomers(req.Context(), filter, correlationId)
+        //The filter changed since the page was selected; go back to the first page.
+        if err == nil && len(customers) == 0 && fields.Page > 1 {
+          fields.Page = 1
+          filter.Offset = 0
+          customers, total, err = bank.DbGetCustomers(req.Context(), filter, correlationId)
+        }
+        if err != nil {
+          errMsg = "Unable to retrieve the customers."
+        }
+        rows := make([]CustomerRow, 0, len(customers))
+        for _, c := range customers {
+          rows = append(rows, CustomerRow{
+            Id: c.Id,
+            Username: c.User_name,
+            Name: c.Last_name + ", " + c.First_name,
+            Email: c.Email,
+            Location: strings.Trim(c.City_name + ", " + c.Country_name, ", "),
+            Admin: c.Is_admin,
+            Active: c.Is_active,
+            Since: c.Created_at.Format("2006-01-02"),
+          })
+        }
+        pages := (total + customersPageSize - 1) / customersPageSize
+        if pages == 0 {
+          pages = 1
+        }
+        prevPage, nextPage := fields.Page - 1, fields.Page + 1
+        if nextPage > pages {
+          nextPage = 0
+        }
+        templatesNeeded = append(templatesNeeded, "webfinances/templates/admin/users/customers.html",
+          "webfinances/templates/helpers/slider-alphabet-container.html",
+          "webfinances/templates/helpers/table-container.html")
+        renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+          Data: struct{
+            LayoutType string
+            Header string
+            Datetime string
+            CurrentButton string
+            CsrfToken string
+            SelectedRange string
+            Search string
+            Sort string
+            Page int
+            Pages int
+            PrevPage int  //Zero on the first page.
+            NextPage int  //Zero on the last page.
+            Total int
+            Fd5Result []CustomerRow
+            ErrMsg string
+          } { "std-wo-nav-menu", "Customers - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
+              fields.SelectedRange, fields.Search, fields.Sort, fields.Page, pages, prevPage, nextPage, total, rows, errMsg },
+        })
+      }
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui6") {
+      fields.CurrentButton = "lhs-button6"
+      var errMsg string
+      canAssign := authz.HasPermission(req.Context(), userName, bank.PermAdminRoles, correlationId)
+      //The username comes from the Edit link (GET) or the form (POST).
+      un := strings.TrimSpace(req.FormValue("fd6-user"))
+      if req.Method == http.MethodPost && un != "" && req.PostFormValue("fd6-save") != "" {
+        if !canAssign {
+          errMsg = "You are not allowed to assign roles."
+        //FormValue parsed the form; a role is in PostForm once per checked box.
+        } else if err := bank.DbSetUserRoles(req.Context(), un, req.PostForm["fd6-role"], userName,
+            correlationId); err != nil {
+          if errors.Is(err, bank.ErrUnknownUserOrRole) || errors.Is(err, bank.ErrProtectedRole) {
+            errMsg = err.Error()
+          } else {
+            errMsg = "The roles were NOT saved."
+          }
+        } else {
+          authz.InvalidatePermissions(un)
+          errMsg = fmt.Sprintf("The roles of user %s were saved; the admin area follows at the next login.", un)
+        }
+      }
+      roles, err := bank.DbGetRoles(req.Context(), correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the roles."
+      }
+      users, err := bank.DbGetUsersRoles(req.Context(), correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the users."
+      }
+      held := map[string]bool{}
+      rows := make([]RolesRow, 0, len(users))
+      for _, u := range users {
+        rows = append(rows, RolesRow{ Username: u.User_name, Admin: u.Is_admin, Roles: strings.Join(u.Roles, ", ") })
+        if u.User_name == un {
+          for _, r := range u.Roles {
+            held[r] = true
+          }
+        }
+      }
+      options := make([]RoleOption, 0, len(roles))
+      for _, r := range roles {
+        options = append(options, RoleOption{
+          Name: r.Name,
+          Description: r.Description,
+          Permissions: strings.Join(r.Permissions, ", "),
+          Checked: held[r.Name],
+        })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/admin/users/users.html",
+        "webfinances/templates/admin/users/roles.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct{
+          LayoutType string
+          Header string
+          Datetime string
+          CurrentButton string
+          CsrfToken string
+          CanAssign bool
+          Username string
+          Roles []RoleOption
+          Fd6Result []RolesRow
+          ErrMsg string
+        } { "std-wo-nav-menu", "Roles - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
+            canAssign, un, options, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui7") {
+      fields.CurrentButton = "lhs-button7"
+      var errMsg string
+      if req.Method == http.MethodPost {
+        approve := req.PostFormValue("approve")
+        reject := req.PostFormValue("reject")
+        reason := strings.TrimSpace(req.PostFormValue("fd7-reason"))
+        if approve != "" {
+          if err := bank.DbReviewSignup(req.Context(), approve, true, "", userName, correlationId); err != nil {
+            errMsg = fmt.Sprintf("The sign-up of %s was NOT approved.", approve)
+            if errors.Is(err, bank.ErrUnknownSignup) {
+              errMsg = err.Error()
+            }
+          } else {
+            errMsg = fmt.Sprintf("The sign-up of %s was approved; the user can log in now.", approve)
+          }
+        } else if reject != "" {
+          if reason == "" {
+            errMsg = "Enter the reason for rejecting the sign-up."
+          } else if err := bank.DbReviewSignup(req.Context(), reject, false, reason, userName,
+              correlationId); err != nil {
+            errMsg = fmt.Sprintf("The sign-up of %s was NOT rejected.", reject)
+            if errors.Is(err, bank.ErrUnknownSignup) {
+              errMsg = err.Error()
+            }
+          } else {
+            errMsg = fmt.Sprintf("The sign-up of %s was rejected and the customer deactivated.", reject)
+          }
+        }
+      }
+      signups, err := bank.DbGetPendingSignups(req.Context(), correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the sign-ups."
+      }
+      rows := make([]SignupRow, 0, len(signups))
+      for _, s := range signups {
+        row := SignupRow{
+          Username: s.User_name,
+          Name: strings.TrimSpace(s.First_name + " " + s.Last_name),
+          Email: s.Email,
+          Created: s.Created_at.Format("2006-01-02 15:04:05"),
+        }
+        if s.Email_verified_at != nil {
+          row.Verified = s.Email_verified_at.Format("2006-01-02 15:04:05")
+        }
+        rows = append(rows, row)
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/admin/users/users.html",
+        "webfinances/templates/admin/users/signups.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct{
+          LayoutType string
+          Header string
+          Datetime string
+          CurrentButton string
+          CsrfToken string
+          Fd7Result []SignupRow
+          ErrMsg string
+        } { "std-wo-nav-menu", "Sign-Ups - Admin", logger.DatetimeFormat(), fields.CurrentButton, newSession.CsrfToken,
+            rows, errMsg },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
       logger.LogWarning("*** Request timeout ***", correlationId)
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/users.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "users", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
@@ -0,0 +1,404 @@
+package wfbanking
+
+import (
+  bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
+  "context"
+  "finance/finances"
+  "finance/renderer"
+  "finance/statestore"
+  "fmt"
+  "github.com/juan-carlos-trimino/gplogger"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gpsessions"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+type budgetsFields struct {
+  CurrentButton string `json:"currentButton"`
+  CurrentPage string  `json:"currentPage"`
+  Month string  `json:"month"`  //YYYY-MM
+}
+
+func newBudgetsFields() *budgetsFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
+  m := budgetsFields{
+    CurrentButton: "lhs-button1",
+    CurrentPage: "rhs-ui1",
+    Month: time.Now().Format("2006-01"),
+  }
+  return &m
+}
+
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getBudgetsFields(ctx context.Context, userName, correlationId string) (*budgetsFields, func()) {
+  m := newBudgetsFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "budgets", m, correlationId)
+}
+
+type WfBankingBudgetsPages struct {}
+
+type CategoryRow struct {  //Rows for the categories.
+  Id string
+  Path string
+  Rollover string
+}
+
+type BudgetRow struct {  //Rows for the budgets of a month.
+  Id string
+  Path string
+  Amount string
+}
+
+type CategorizeRow struct {  //Rows for the register entries of a month.
+  Id string
+  Date string
+  Account string
+  Payee string
+  Amount string
+  CategoryId string
+}
+
+type ReportRow struct {  //Rows for the budget-vs-actual report.
+  Path string
+  Budgeted string
+  CarriedIn string
+  Available string
+  Actual string
+  Remaining string
+  Overspent bool
+  YtdBudgeted string
+  YtdActual string
+  YtdRemaining string
+  YtdOverspent bool
+}
+
+func (b WfBankingBudgetsPages) BudgetsPages(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering wfbanking.BudgetsPages.", correlationId)
+  sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+  if sessionToken == "" {
+    invalidSession(res, correlationId)
+    return nil
+  }
+  //
+  if req.Method == http.MethodPost || req.Method == http.MethodGet {
+    userName := sessions.GetUserName(sessionToken)
+    fields, unlock := getBudgetsFields(req.Context(), userName, correlationId)
+    defer unlock()
+    if ui := req.FormValue("tablestyle"); ui != "" {  //Values from form and URL.
+      fields.CurrentPage = ui
+    }
+    if m := req.FormValue("fd-month"); m != "" {
+      if _, err := time.Parse("2006-01", m); err == nil {
+        fields.Month = m
+      }
+    }
+    month, err := time.Parse("2006-01", fields.Month)
+    if err != nil {
+      month = bank.FirstOfMonth(time.Now())
+      fields.Month = month.Format("2006-01")
+    }
+    //
+    if strings.EqualFold(fields.CurrentPage, "rhs-ui1") {
+      fields.CurrentButton = "lhs-button1"
+      var errMsg string
+      if req.Method == http.MethodPost {
+        if rowId := req.PostFormValue("selected_id"); rowId != "" {
+          logger.LogInfo(fmt.Sprintf("Category ID = %s", rowId), correlationId)
+          if err := bank.DbDeleteCategory(req.Context(), userName, rowId, correlationId); err != nil {
+            errMsg = "The category was NOT deleted."
+          }
+        } else if name := strings.TrimSpace(req.PostFormValue("fd1-name")); name != "" {
+          if err := bank.DbAddCategory(req.Context(), userName, req.PostFormValue("fd1-parent"), name,
+             req.PostFormValue("fd1-rollover"), correlationId); err != nil {
+            errMsg = "The category was NOT added; the name may already exist."
+          }
+        }
+      }
+      categories, err := bank.DbGetCategories(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the categories."
+      }
+      var parents []bank.Category
+      rows := make([]CategoryRow, 0, len(categories))
+      for _, c := range categories {
+        if c.Parent_id == nil {
+          parents = append(parents, c)
+        }
+        rows = append(rows, CategoryRow{ Id: c.Id, Path: c.Path, Rollover: c.Rollover })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/budgets/budgets.html",
+        "webfinances/templates/banking/budgets/categories.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Parents []bank.Category
+          Fd1Result []CategoryRow
+          ErrMsg string
+        } { "standard", "Budgets", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
+            parents, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui2") {
+      fields.CurrentButton = "lhs-button2"
+      var errMsg string
+      if req.Method == http.MethodPost && req.PostFormValue("fd2-save") != "" {
+        categories, err := bank.DbGetCategories(req.Context(), userName, correlationId)
+        if err != nil {
+          errMsg = "Unable to retrieve the categories."
+        }
+        for _, c := range categories {
+          value := strings.TrimSpace(req.PostFormValue("budget-" + c.Id))
+          if value == "" {
+            continue
+          }
+          if amount, err := strconv.ParseFloat(value, 64); err != nil || amount < 0 {
+            errMsg = fmt.Sprintf("Error: invalid budget %s for %s.", value, c.Path)
+          } else if err = bank.DbSetBudget(req.Context(), userName, c.Id, month, amount, correlationId); err != nil {
+            errMsg = fmt.Sprintf("The budget for %s was NOT saved.", c.Path)
+          }
+        }
+      }
+      categories, err := bank.DbGetCategories(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the categories."
+      }
+      rt, currency, err := getConversion(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the exchange rates."
+      }
+      actuals, err := bank.DbGetBudgetActuals(req.Context(), userName, month, month, rt, currency, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the budgets."
+      }
+      budgeted := make(map[string]float64, len(actuals))
+      for _, a := range actuals {
+        budgeted[a.Category_id] = a.Budgeted
+      }
+      rows := make([]BudgetRow, 0, len(categories))
+      for _, c := range categories {
+        rows = append(rows, BudgetRow{ Id: c.Id, Path: c.Path, Amount: fmt.Sprintf("%.2f", budgeted[c.Id]) })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/budgets/budgets.html",
+        "webfinances/templates/banking/budgets/monthly.html",
+        "webfinances/templates/banking/budgets/month.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          CurrentPage string
+          Month string
+          Currency string
+          Fd2Result []BudgetRow
+          ErrMsg string
+        } { "standard", "Budgets", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
+            fields.CurrentPage, fields.Month, currency, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui3") {
+      fields.CurrentButton = "lhs-button3"
+      var errMsg string
+      to := month.AddDate(0, 1, 0)
+      entries, err := bank.DbGetRegisterEntries(req.Context(), userName, month, to, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the register entries."
+      }
+      if req.Method == http.MethodPost && req.PostFormValue("fd3-save") != "" {
+        for idx := range entries {
+          e := &entries[idx]
+          current := bank.PtrString(e.Category_id)
+          if selected := req.PostFormValue("entry-" + e.Id); selected != current {
+            if err := bank.DbSetEntryCategory(req.Context(), userName, e.Id, selected, correlationId); err != nil {
+              errMsg = fmt.Sprintf("The category of %s was NOT saved.", e.Payee)
+            } else {
+              e.Category_id = bank.StringPtr(selected)
+            }
+          }
+        }
+      }
+      categories, err := bank.DbGetCategories(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the categories."
+      }
+      rows := make([]CategorizeRow, 0, len(entries))
+      for _, e := range entries {
+        rows = append(rows, CategorizeRow{
+          Id: e.Id,
+          Date: e.Payment_date.Format("2006-01-02"),
+          Account: e.Acct_name,
+          Payee: e.Payee,
+          Amount: fmt.Sprintf("%.2f (%s)", e.Amount, e.Tr_type),
+          CategoryId: bank.PtrString(e.Category_id),
+        })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/budgets/budgets.html",
+        "webfinances/templates/banking/budgets/categorize.html",
+        "webfinances/templates/banking/budgets/month.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          CurrentPage string
+          Month string
+          Categories []bank.Category
+          Fd3Result []CategorizeRow
+          ErrMsg string
+        } { "standard", "Budgets", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
+            fields.CurrentPage, fields.Month, categories, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui4") {
+      fields.CurrentButton = "lhs-button4"
+      var errMsg string
+      /***
+      The rollovers are computed from January; hence, the report always retrieves the year to date even when only the
+      selected month is displayed.
+      ***/
+      january := time.Date(month.Year(), time.January, 1, 0, 0, 0, 0, time.UTC)
+      categories, err := bank.DbGetCategories(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the categories."
+      }
+      rt, currency, err := getConversion(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the exchange rates."
+      }
+      actuals, err := bank.DbGetBudgetActuals(req.Context(), userName, january, month, rt, currency, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the budgets."
+      }
+      var unconverted bool = false
+      periods := map[string][]finances.BudgetPeriod{}  //key: category id
+      for _, a := range actuals {
+        unconverted = unconverted || a.Unconverted
+        periods[a.Category_id] = append(periods[a.Category_id], finances.BudgetPeriod{ Budgeted: a.Budgeted,
+          Actual: a.Actual })
+      }
+      rows := make([]ReportRow, 0, len(categories))
+      var overspent []string
+      for _, c := range categories {
+        p := periods[c.Id]
+        if len(p) == 0 {
+          continue
+        }
+        results := finances.BudgetVsActual(p, c.Rollover)
+        last := results[len(results) - 1]
+        ytd := finances.BudgetYearToDate(p)
+        rows = append(rows, ReportRow{
+          Path: c.Path,
+          Budgeted: fmt.Sprintf("%.2f", last.Budgeted),
+          CarriedIn: fmt.Sprintf("%.2f", last.CarriedIn),
+          Available: fmt.Sprintf("%.2f", last.Available),
+          Actual: fmt.Sprintf("%.2f", last.Actual),
+          Remaining: fmt.Sprintf("%.2f", last.Remaining),
+          Overspent: last.Overspent,
+          YtdBudgeted: fmt.Sprintf("%.2f", ytd.Budgeted),
+          YtdActual: fmt.Sprintf("%.2f", ytd.Actual),
+          YtdRemaining: fmt.Sprintf("%.2f", ytd.Remaining),
+          YtdOverspent: ytd.Overspent,
+        })
+        if last.Overspent {
+          overspent = append(overspent, c.Path)
+        }
+      }
+      if errMsg == "" && len(overspent) > 0 {
+        errMsg = "Overspent: " + strings.Join(overspent, ", ") + "."
+      }
+      if unconverted {
+        errMsg += fmt.Sprintf(" Some amounts could not be converted into %s; please add the missing exchange rates.",
+          currency)
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/budgets/budgets.html",
+        "webfinances/templates/banking/budgets/report.html",
+        "webfinances/templates/banking/budgets/month.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          CurrentPage string
+          Month string
+          Currency string
+          Fd4Result []ReportRow
+          ErrMsg string
+        } { "standard", "Budgets", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
+            fields.CurrentPage, fields.Month, currency, rows, errMsg },
+      })
+    } else {
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
+    }
+    //
+    if req.Context().Err() == context.DeadlineExceeded {
+      logger.LogWarning("*** Request timeout ***", correlationId)
+    }
+    //
+    statestore.Save(req.Context(), stateNamespace, userName, "budgets", fields, correlationId)
+  } else {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
@@ -0,0 +1,284 @@
+package wfbanking
+
+import (
+  bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
+  "context"
+  "finance/finances"
+  "finance/renderer"
+  "finance/statestore"
+  "fmt"
+  "github.com/juan-carlos-trimino/gplogger"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gpsessions"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+//Maximum size of an uploaded CSV file with exchange rates.
+const maxRatesFileSize int64 = 1 << 20
+
+type currenciesFields struct {
+  CurrentButton string `json:"currentButton"`
+  CurrentPage string  `json:"currentPage"`
+}
+
+func newCurrenciesFields() *currenciesFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
+  m := currenciesFields{
+    CurrentButton: "lhs-button1",
+    CurrentPage: "rhs-ui1",
+  }
+  return &m
+}
+
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getCurrenciesFields(ctx context.Context, userName, correlationId string) (*currenciesFields, func()) {
+  m := newCurrenciesFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "currencies", m, correlationId)
+}
+
+/***
+Return the exchange rates and the reporting currency of the customer. On error, the table is empty (only same-currency
+conversions succeed) and the currency is USD, so callers can still render a page.
+***/
+func getConversion(ctx context.Context, userName, correlationId string) (*finances.RateTable, string, error) {
+  currency, err := bank.DbGetReportingCurrency(ctx, userName, correlationId)
+  if err != nil {
+    return finances.NewRateTable(nil), "USD", err
+  }
+  rt, err := bank.DbGetRateTable(ctx, correlationId)
+  if err != nil {
+    return finances.NewRateTable(nil), currency, err
+  }
+  return rt, currency, nil
+}
+
+type WfBankingCurrenciesPages struct {}
+
+type BalanceRow struct {  //Rows for the account balances.
+  Account string
+  Currency string
+  Balance string
+  Converted string
+}
+
+type RateRow struct {  //Rows for the exchange rates.
+  Date string
+  Pair string
+  Rate string
+  Source string
+}
+
+func (c WfBankingCurrenciesPages) CurrenciesPages(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering wfbanking.CurrenciesPages.", correlationId)
+  sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+  if sessionToken == "" {
+    invalidSession(res, correlationId)
+    return nil
+  }
+  //
+  if req.Method == http.MethodPost || req.Method == http.MethodGet {
+    userName := sessions.GetUserName(sessionToken)
+    fields, unlock := getCurrenciesFields(req.Context(), userName, correlationId)
+    defer unlock()
+    if ui := req.FormValue("tablestyle"); ui != "" {  //Values from form and URL.
+      fields.CurrentPage = ui
+    }
+    //
+    if strings.EqualFold(fields.CurrentPage, "rhs-ui1") {
+      fields.CurrentButton = "lhs-button1"
+      var errMsg string
+      if code := strings.ToUpper(strings.TrimSpace(req.PostFormValue("fd1-currency"))); req.Method == http.MethodPost &&
+         code != "" {
+        if !finances.ValidCurrencyCode(code) {
+          errMsg = fmt.Sprintf("Error: invalid currency code %s.", code)
+        } else if err := bank.DbSetReportingCurrency(req.Context(), userName, code, correlationId); err != nil {
+          errMsg = "The reporting currency was NOT saved."
+        }
+      }
+      rt, currency, err := getConversion(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the exchange rates."
+      }
+      balances, err := bank.DbGetAccountBalances(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the balances."
+      }
+      today := time.Now()
+      var total float64 = 0.0
+      var missing []string
+      rows := make([]BalanceRow, 0, len(balances))
+      for _, b := range balances {
+        row := BalanceRow{
+          Account: b.Acct_name,
+          Currency: b.Currency_code,
+          Balance: fmt.Sprintf("%.2f", b.Balance),
+          Converted: "n/a",
+        }
+        if amount, ok := rt.Convert(b.Balance, b.Currency_code, currency, today); ok {
+          row.Converted = fmt.Sprintf("%.2f", amount)
+          total += amount
+        } else {
+          missing = append(missing, b.Currency_code + "/" + currency)
+        }
+        rows = append(rows, row)
+      }
+      if len(missing) > 0 {
+        errMsg = "Missing exchange rates: " + strings.Join(missing, ", ") + "; the total is incomplete."
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/currencies/currencies.html",
+        "webfinances/templates/banking/currencies/balances.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Currency string
+          Total string
+          Fd1Result []BalanceRow
+          ErrMsg string
+        } { "standard", "Currencies", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
+            currency, fmt.Sprintf("%.2f", total), rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui2") {
+      fields.CurrentButton = "lhs-button2"
+      var errMsg string
+      fd2Date := time.Now().Format("2006-01-02")
+      var fd2From, fd2To, fd2Rate string
+      if req.Method == http.MethodPost {
+        fd2Date = req.PostFormValue("fd2-date")
+        fd2From = strings.ToUpper(strings.TrimSpace(req.PostFormValue("fd2-from")))
+        fd2To = strings.ToUpper(strings.TrimSpace(req.PostFormValue("fd2-to")))
+        fd2Rate = req.PostFormValue("fd2-rate")
+        if date, err := time.Parse("2006-01-02", fd2Date); err != nil {
+          errMsg = fmt.Sprintf("Error: invalid date %s.", fd2Date)
+        } else if !finances.ValidCurrencyCode(fd2From) || !finances.ValidCurrencyCode(fd2To) || fd2From == fd2To {
+          errMsg = fmt.Sprintf("Error: invalid currency pair %s/%s.", fd2From, fd2To)
+        } else if rate, err := strconv.ParseFloat(fd2Rate, 64); err != nil || rate <= 0 {
+          errMsg = fmt.Sprintf("Error: invalid rate %s.", fd2Rate)
+        } else if err = bank.DbSetExchangeRate(req.Context(), finances.ExchangeRate{ Date: date, From: fd2From, To: fd2To,
+                    Rate: rate }, bank.RateSourceManual, correlationId); err != nil {
+          errMsg = "The exchange rate was NOT saved."
+        }
+      }
+      rates, err := bank.DbGetExchangeRates(req.Context(), correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the exchange rates."
+      }
+      rows := make([]RateRow, 0, len(rates))
+      for _, r := range rates {
+        rows = append(rows, RateRow{
+          Date: r.Rate_date.Format("2006-01-02"),
+          Pair: r.From_currency + "/" + r.To_currency,
+          Rate: strconv.FormatFloat(r.Rate, 'f', -1, 64),
+          Source: r.Rate_source,
+        })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/currencies/currencies.html",
+        "webfinances/templates/banking/currencies/rates.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Fd2Date string
+          Fd2From string
+          Fd2To string
+          Fd2Rate string
+          Fd2Result []RateRow
+          ErrMsg string
+        } { "standard", "Currencies", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
+            fd2Date, fd2From, fd2To, fd2Rate, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui3") {
+      fields.CurrentButton = "lhs-button3"
+      var errMsg string
+      if req.Method == http.MethodPost {
+        if file, header, err := req.FormFile("fd3-file"); err != nil {
+          errMsg = "Error: please select a CSV file."
+        } else {
+          defer file.Close()
+          if header.Size > maxRatesFileSize {
+            errMsg = "Error: the file is larger than 1 MB."
+          } else if rates, err := finances.ParseRatesCSV(file); err != nil {
+            errMsg = fmt.Sprintf("Error: %v.", err)
+          } else if n, err := bank.DbImportExchangeRates(req.Context(), rates, correlationId); err != nil {
+            errMsg = "The exchange rates were NOT imported."
+          } else {
+            errMsg = fmt.Sprintf("Imported %d exchange rates.", n)
+          }
+        }
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/currencies/currencies.html",
+        "webfinances/templates/banking/currencies/import.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          ErrMsg string
+        } { "standard", "Currencies", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
+            errMsg },
+      })
+    } else {
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
+    }
+    //
+    if req.Context().Err() == context.DeadlineExceeded {
+      logger.LogWarning("*** Request timeout ***", correlationId)
+    }
+    //
+    statestore.Save(req.Context(), stateNamespace, userName, "currencies", fields, correlationId)
+  } else {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
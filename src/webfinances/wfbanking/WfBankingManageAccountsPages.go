@@ -1,16 +1,16 @@
 package wfbanking
 
 import (
+  bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
   "context"
-  "encoding/json"
+  "finance/finances"
   "finance/renderer"
+  "finance/statestore"
   "fmt"
   "github.com/juan-carlos-trimino/gplogger"
   "github.com/juan-carlos-trimino/go-middlewares"
-  "github.com/juan-carlos-trimino/gposu"
   "github.com/juan-carlos-trimino/gpsessions"
   "net/http"
-  "os"
   "strings"
   "time"
 )
@@ -20,49 +20,39 @@ type manageAccountsFields struct {
   CurrentPage string  `json:"currentPage"`
 }
 
-func newManageAccountsFields(dir1, dir2, correlationId string) *manageAccountsFields {
-  dir, err := osu.CreateDirs(0o077, 0o777, dir1, dir2)
-  if err != nil {
-    panic("Cannot create directory '" + dir + "': " + err.Error())
-  }
-  //Default values returned if file is missing, empty, or JSON is corrupt.
+func newManageAccountsFields() *manageAccountsFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
   m := manageAccountsFields{
     CurrentButton: "lhs-button1",
     CurrentPage: "rhs-ui1",
   }
-  obj, err := readFields(dir + "manageaccounts.txt")
-  if obj != nil {
-    /***
-    When a file is empty, the readFields function successfully returns a valid slice, but it contains zero bytes. Checking the
-    length ensures parsing only files that actually contain data.
-    ***/
-    if len(obj) != 0 {  //Check if the file contains no data (empty)
-      err = json.Unmarshal(obj, &m)
-      if err != nil {
-        //Write error, but continue with default values.
-        logger.LogInfo(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
-  } else if err != nil {
-    logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-  } else {
-    logger.LogInfo(fmt.Sprintf("File %s does not exit.", dir + "manageaccounts.txt"), correlationId)
-  }
   return &m
 }
 
-func getManageAccountsFields(userName string) *manageAccountsFields {
-  return currentFields[userName].manageAccounts
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getManageAccountsFields(ctx context.Context, userName, correlationId string) (*manageAccountsFields, func()) {
+  m := newManageAccountsFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "manageaccounts", m, correlationId)
 }
 
 type WfBankingMngAcctsPages struct {}
 
 type Row struct {  //Rows for the accounts.
+  Id string
   AccountName string
-  AccountType string
+  Currency string
+  Balance string
+  Status string
+}
+
+type StatusChangeRow struct {  //Rows for the status history.
+  Account string
+  Change string
+  ChangedBy string
+  ChangedAt string
 }
 
-func (b WfBankingMngAcctsPages) ManageAccountsPages(res http.ResponseWriter, req *http.Request) {
+func (b WfBankingMngAcctsPages) ManageAccountsPages(res http.ResponseWriter, req *http.Request) error {
   ctxKey := middlewares.MwContextKey{}
   correlationId, _ := ctxKey.GetCorrelationId(req.Context())
   startTime, _ := ctxKey.GetStartTime(req.Context())
@@ -71,12 +61,13 @@ func (b WfBankingMngAcctsPages) ManageAccountsPages(res http.ResponseWriter, req
   sessionToken, _ := ctxKey.GetSessionToken(req.Context())
   if sessionToken == "" {
     invalidSession(res, correlationId)
-    return
+    return nil
   }
   //
   if req.Method == http.MethodPost || req.Method == http.MethodGet {
     userName := sessions.GetUserName(sessionToken)
-    fields := getManageAccountsFields(userName)
+    fields, unlock := getManageAccountsFields(req.Context(), userName, correlationId)
+    defer unlock()
     /***
     The functions in Request that allow to extract data from the URL and/or the body revolve around the Form, PostForm, and
     MultipartForm fields; the data are in the form of key-value pairs.
@@ -101,16 +92,27 @@ func (b WfBankingMngAcctsPages) ManageAccountsPages(res http.ResponseWriter, req
           fd1AccountType,
           fd1AccountName,
           fd1AccountNumber,
-          fd1RoutingNumber string
+          fd1RoutingNumber,
+          errMsg string
+      fd1Currency := "USD"
       if req.Method == http.MethodPost {
         fd1BankName = req.PostFormValue("fd1-bankname")
         fd1AccountType = req.PostFormValue("fd1-accounttype")
         fd1AccountName = req.PostFormValue("fd1-accountname")
         fd1AccountNumber = req.PostFormValue("fd1-accountnumber")
         fd1RoutingNumber = req.PostFormValue("fd1-routingnumber")
-        //save to db
-        logger.LogInfo(fmt.Sprintf("bankname = %s, accounttype = %s, accountname = %s, accountnumber = %s, routingnumber = %s",
-          fd1BankName, fd1AccountType, fd1AccountName, fd1AccountNumber, fd1RoutingNumber), correlationId)
+        fd1Currency = strings.ToUpper(strings.TrimSpace(req.PostFormValue("fd1-currency")))
+        logger.LogInfo(fmt.Sprintf("bankname = %s, accounttype = %s, accountname = %s, accountnumber = %s, routingnumber = %s" +
+          ", currency = %s", fd1BankName, fd1AccountType, fd1AccountName, fd1AccountNumber, fd1RoutingNumber, fd1Currency),
+          correlationId)
+        if !finances.ValidCurrencyCode(fd1Currency) {
+          errMsg = fmt.Sprintf("Error: invalid currency code %s.", fd1Currency)
+        } else if err := bank.DbAddAccount(req.Context(), userName, fd1AccountName, fd1AccountType, fd1Currency,
+                  correlationId); err != nil {
+          errMsg = "The account was NOT created; the name may already exist."
+        } else {
+          errMsg = fmt.Sprintf("The account %s was created.", fd1AccountName)
+        }
       }
       newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
       cookie := sessions.CreateCookie(newSessionToken)
@@ -137,55 +139,47 @@ func (b WfBankingMngAcctsPages) ManageAccountsPages(res http.ResponseWriter, req
           Fd1AccountName string
           Fd1AccountNumber string
           Fd1RoutingNumber string
+          Fd1Currency string
+          ErrMsg string
         } { "standard", "Manage Accounts", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, newSession.CsrfToken,
-            fd1BankName, fd1AccountType, fd1AccountName, fd1AccountNumber, fd1RoutingNumber },
+            fd1BankName, fd1AccountType, fd1AccountName, fd1AccountNumber, fd1RoutingNumber, fd1Currency, errMsg },
       })
     } else if strings.EqualFold(fields.CurrentPage, "rhs-ui2") {
       fields.CurrentButton = "lhs-button2"
-      var rows []Row
-      // if req.Method == http.MethodPost {
-
-
-
-          rowId := req.PostFormValue("selected_id")
-
-          numberOfRows := 80
-          rows = make([]Row, 0, numberOfRows + 1)
-          rows = append(rows,
-            Row {
-              AccountName: "--",
-              AccountType: "savings",
-            })
-          for idx := 0; idx < numberOfRows; idx++ {
-            rows = append(rows,
-              Row {
-                AccountName: fmt.Sprintf("account name%d", idx + 1),
-                AccountType: "checking",
-              })
-          }
-
-
-          if rowId != "" {
-            logger.LogInfo(fmt.Sprintf("Account ID = %s", rowId), correlationId);
-            for i, r := range rows {
-                if r.AccountName == rowId {
-                    // Remove the element and maintain order
-                    rows = append(rows[:i], rows[i+1:]...)
-                    break // Stop searching after the first match
-                }
-            }
-          }
-
-
-
-      // }
+      var errMsg string
+      fd2Account := req.PostFormValue("fd2-account")
+      fd2Status := req.PostFormValue("fd2-status")
+      fd2Destination := req.PostFormValue("fd2-destination")
+      if req.Method == http.MethodPost && fd2Account != "" {
+        if err := bank.DbChangeAccountStatus(req.Context(), userName, fd2Account, fd2Status, fd2Destination, userName,
+           correlationId); err != nil {
+          errMsg = fmt.Sprintf("%v", err)
+        } else {
+          errMsg = fmt.Sprintf("The status of the account was changed to %s.", fd2Status)
+        }
+        logger.LogInfo(errMsg, correlationId)
+      }
+      balances, err := bank.DbGetAccountBalances(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the accounts."
+      }
+      rows := make([]Row, 0, len(balances))
+      for _, b := range balances {
+        rows = append(rows, Row{
+          Id: b.Id,
+          AccountName: b.Acct_name,
+          Currency: b.Currency_code,
+          Balance: fmt.Sprintf("%.2f", b.Balance),
+          Status: b.Acct_status,
+        })
+      }
       newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
       cookie := sessions.CreateCookie(newSessionToken)
       http.SetCookie(res, cookie)
       templatesNeeded := []string{
         "webfinances/templates/layout.html",
         "webfinances/templates/banking/manageaccounts/manageaccounts.html",
-        "webfinances/templates/banking/manageaccounts/deleteaccount.html",
+        "webfinances/templates/banking/manageaccounts/accountstatus.html",
         "webfinances/templates/helpers/table-container.html",
         "webfinances/templates/title.html",
         "webfinances/templates/datetime.html",
@@ -200,32 +194,67 @@ func (b WfBankingMngAcctsPages) ManageAccountsPages(res http.ResponseWriter, req
           MenuPage string
           CurrentButton string
           CsrfToken string
+          Fd2Account string
+          Fd2Status string
           Fd2Result []Row
+          ErrMsg string
         } { "standard", "Manage Accounts", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton,
-            newSession.CsrfToken, rows },
+            newSession.CsrfToken, fd2Account, fd2Status, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui3") {
+      fields.CurrentButton = "lhs-button3"
+      var errMsg string
+      history, err := bank.DbGetAccountStatusHistory(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the history."
+      }
+      rows := make([]StatusChangeRow, 0, len(history))
+      for _, h := range history {
+        rows = append(rows, StatusChangeRow{
+          Account: h.Acct_name,
+          Change: h.Old_status + " -> " + h.New_status,
+          ChangedBy: h.Changed_by,
+          ChangedAt: h.Changed_at.Format("2006-01-02 15:04:05"),
+        })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/manageaccounts/manageaccounts.html",
+        "webfinances/templates/banking/manageaccounts/statushistory.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Fd3Result []StatusChangeRow
+          ErrMsg string
+        } { "standard", "Manage Accounts", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton,
+            newSession.CsrfToken, rows, errMsg },
       })
     } else {
-      errString := fmt.Sprintf("Unsupported page: %s", fields.CurrentPage)
-      logger.LogError(errString, correlationId)
-      panic(errString)
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
     }
     //
     if req.Context().Err() == context.DeadlineExceeded {
       logger.LogWarning("*** Request timeout ***", correlationId)
     }
     //
-    if data, err := json.Marshal(fields); err != nil {
-      logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-    } else {
-      filePath := fmt.Sprintf("%s/%s/manageaccounts.txt", mainDir, userName)
-      if _, err := osu.WriteAllExclusiveLock1(filePath, data, os.O_CREATE | os.O_RDWR | os.O_TRUNC, 0o600); err != nil {
-        logger.LogError(fmt.Sprintf("%+v", err), correlationId)
-      }
-    }
+    statestore.Save(req.Context(), stateNamespace, userName, "manageaccounts", fields, correlationId)
   } else {
-    errString := fmt.Sprintf("Unsupported method: %s", req.Method)
-    logger.LogError(errString, correlationId)
-    panic(errString)
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
   }
   logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
 }
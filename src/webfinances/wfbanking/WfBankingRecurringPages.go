@@ -0,0 +1,406 @@
+package wfbanking
+
+import (
+  bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
+  "context"
+  "finance/finances"
+  "finance/renderer"
+  "finance/statestore"
+  "fmt"
+  "github.com/juan-carlos-trimino/gplogger"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gpsessions"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+const previewOccurrences int = 12
+
+type recurringFields struct {
+  CurrentButton string `json:"currentButton"`
+  CurrentPage string  `json:"currentPage"`
+  SelectedTemplate string `json:"selectedTemplate"`
+}
+
+func newRecurringFields() *recurringFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
+  m := recurringFields{
+    CurrentButton: "lhs-button1",
+    CurrentPage: "rhs-ui1",
+    SelectedTemplate: "",
+  }
+  return &m
+}
+
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getRecurringFields(ctx context.Context, userName, correlationId string) (*recurringFields, func()) {
+  m := newRecurringFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "recurring", m, correlationId)
+}
+
+type WfBankingRecurringPages struct {}
+
+type TemplateRow struct {  //Rows for the recurring templates.
+  Id string
+  Payee string
+  Account string
+  Amount string
+  Schedule string
+  NextDue string
+  PostMode string
+}
+
+type OccurrenceRow struct {  //Rows for the upcoming occurrences.
+  Date string
+  Skipped bool
+}
+
+type ReminderRow struct {  //Rows for the reminders.
+  Id string
+  DueDate string
+  Payee string
+  Account string
+  Amount string
+}
+
+//Human-readable description of a schedule.
+func describeSchedule(t *bank.RecurringTemplate) string {
+  switch t.Frequency {
+  case finances.EveryNDays:
+    return fmt.Sprintf("Every %d day(s)", t.Interval_count)
+  case finances.EveryNWeeks:
+    return fmt.Sprintf("Every %d week(s)", t.Interval_count)
+  case finances.MonthlyOnDay:
+    if t.Day_of_month == 0 {
+      return fmt.Sprintf("Monthly on day %d", t.Start_date.Day())
+    }
+    return fmt.Sprintf("Monthly on day %d", t.Day_of_month)
+  case finances.MonthlyLastBusinessDay:
+    return "Monthly on the last business day"
+  case finances.SemiMonthly:
+    r := t.Recurrence()
+    return fmt.Sprintf("Semi-monthly (days %d and %d)", r.DayOfMonth, r.SecondDay)
+  case finances.Yearly:
+    return fmt.Sprintf("Yearly on %s", t.Start_date.Format("January 2"))
+  default:
+    return t.Frequency
+  }
+}
+
+func (r WfBankingRecurringPages) RecurringPages(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering wfbanking.RecurringPages.", correlationId)
+  sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+  if sessionToken == "" {
+    invalidSession(res, correlationId)
+    return nil
+  }
+  //
+  if req.Method == http.MethodPost || req.Method == http.MethodGet {
+    userName := sessions.GetUserName(sessionToken)
+    fields, unlock := getRecurringFields(req.Context(), userName, correlationId)
+    defer unlock()
+    if ui := req.FormValue("tablestyle"); ui != "" {  //Values from form and URL.
+      fields.CurrentPage = ui
+    }
+    //
+    if strings.EqualFold(fields.CurrentPage, "rhs-ui1") {
+      fields.CurrentButton = "lhs-button1"
+      pd := struct {
+        LayoutType string
+        Header string
+        Datetime string
+        MenuPage string
+        CurrentButton string
+        CsrfToken string
+        Accounts []bank.Account
+        Fd1Account string
+        Fd1Payee string
+        Fd1Type string
+        Fd1Amount string
+        Fd1Description string
+        Fd1Frequency string
+        Fd1Interval string
+        Fd1Day string
+        Fd1SecondDay string
+        Fd1Start string
+        Fd1End string
+        Fd1PostMode string
+        ErrMsg string
+      } { "standard", "Recurring Transactions", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, "", nil,
+          "", "", "debit", "", "", finances.MonthlyOnDay, "1", "1", "15", time.Now().Format("2006-01-02"), "",
+          bank.PostModePost, "" }
+      if req.Method == http.MethodPost {
+        pd.Fd1Account = req.PostFormValue("fd1-account")
+        pd.Fd1Payee = strings.TrimSpace(req.PostFormValue("fd1-payee"))
+        pd.Fd1Type = req.PostFormValue("fd1-type")
+        pd.Fd1Amount = req.PostFormValue("fd1-amount")
+        pd.Fd1Description = req.PostFormValue("fd1-description")
+        pd.Fd1Frequency = req.PostFormValue("fd1-frequency")
+        pd.Fd1Interval = req.PostFormValue("fd1-interval")
+        pd.Fd1Day = req.PostFormValue("fd1-day")
+        pd.Fd1SecondDay = req.PostFormValue("fd1-secondday")
+        pd.Fd1Start = req.PostFormValue("fd1-start")
+        pd.Fd1End = req.PostFormValue("fd1-end")
+        pd.Fd1PostMode = req.PostFormValue("fd1-postmode")
+        t := bank.RecurringTemplate{
+          Acct_id: pd.Fd1Account,
+          Payee: pd.Fd1Payee,
+          Tr_type: pd.Fd1Type,
+          Tr_description: pd.Fd1Description,
+          Frequency: pd.Fd1Frequency,
+          Post_mode: pd.Fd1PostMode,
+        }
+        var amount float64
+        var interval, day, secondDay int
+        var start, end time.Time
+        var err error
+        if amount, err = strconv.ParseFloat(pd.Fd1Amount, 64); err != nil || amount <= 0 {
+          pd.ErrMsg = fmt.Sprintf("Error: invalid amount %s.", pd.Fd1Amount)
+        } else if interval, err = strconv.Atoi(pd.Fd1Interval); err != nil || interval < 1 {
+          pd.ErrMsg = fmt.Sprintf("Error: invalid interval %s.", pd.Fd1Interval)
+        } else if day, err = strconv.Atoi(pd.Fd1Day); err != nil {
+          pd.ErrMsg = fmt.Sprintf("Error: invalid day of the month %s.", pd.Fd1Day)
+        } else if secondDay, err = strconv.Atoi(pd.Fd1SecondDay); err != nil {
+          pd.ErrMsg = fmt.Sprintf("Error: invalid second day %s.", pd.Fd1SecondDay)
+        } else if start, err = time.Parse("2006-01-02", pd.Fd1Start); err != nil {
+          pd.ErrMsg = fmt.Sprintf("Error: invalid start date %s.", pd.Fd1Start)
+        } else if pd.Fd1End != "" {
+          if end, err = time.Parse("2006-01-02", pd.Fd1End); err != nil {
+            pd.ErrMsg = fmt.Sprintf("Error: invalid end date %s.", pd.Fd1End)
+          } else {
+            t.End_date = bank.TimePtr(end)
+          }
+        }
+        //
+        if pd.ErrMsg == "" {
+          t.Amount = amount
+          t.Interval_count = int32(interval)
+          t.Day_of_month = int32(day)
+          t.Second_day = int32(secondDay)
+          t.Start_date = start
+          if err = bank.DbAddRecurringTemplate(req.Context(), userName, &t, correlationId); err != nil {
+            pd.ErrMsg = fmt.Sprintf("%v", err)
+          } else {
+            pd.ErrMsg = fmt.Sprintf("The template was saved; next occurrence: %s.", t.Next_due.Format("2006-01-02"))
+          }
+        }
+        logger.LogInfo(pd.ErrMsg, correlationId)
+      }
+      accounts, err := bank.DbGetAccounts(req.Context(), userName, correlationId)
+      if err != nil {
+        pd.ErrMsg = "Unable to retrieve the accounts."
+      }
+      pd.Accounts = accounts
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/recurring/recurring.html",
+        "webfinances/templates/banking/recurring/newtemplate.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      pd.CsrfToken = newSession.CsrfToken
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{ Data: pd })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui2") {
+      fields.CurrentButton = "lhs-button2"
+      var errMsg string
+      if rowId := req.PostFormValue("selected_id"); req.Method == http.MethodPost && rowId != "" {
+        logger.LogInfo(fmt.Sprintf("Template ID = %s", rowId), correlationId)
+        if err := bank.DbDeleteRecurringTemplate(req.Context(), userName, rowId, correlationId); err != nil {
+          errMsg = "The template was NOT deleted."
+        }
+      }
+      templates, err := bank.DbGetRecurringTemplates(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the templates."
+      }
+      rows := make([]TemplateRow, 0, len(templates))
+      for idx := range templates {
+        t := &templates[idx]
+        row := TemplateRow{
+          Id: t.Id,
+          Payee: t.Payee,
+          Account: t.Acct_name,
+          Amount: fmt.Sprintf("%.2f (%s)", t.Amount, t.Tr_type),
+          Schedule: describeSchedule(t),
+          NextDue: "ended",
+          PostMode: t.Post_mode,
+        }
+        if t.Next_due != nil {
+          row.NextDue = t.Next_due.Format("2006-01-02")
+        }
+        rows = append(rows, row)
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/recurring/recurring.html",
+        "webfinances/templates/banking/recurring/templates.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Fd2Result []TemplateRow
+          ErrMsg string
+        } { "standard", "Recurring Transactions", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton,
+            newSession.CsrfToken, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui3") {
+      fields.CurrentButton = "lhs-button3"
+      var errMsg string
+      if tid := req.FormValue("fd3-template"); tid != "" {
+        fields.SelectedTemplate = tid
+      }
+      if req.Method == http.MethodPost {
+        if d := req.PostFormValue("fd3-skip"); d != "" {
+          if date, err := time.Parse("2006-01-02", d); err == nil {
+            bank.DbSkipRecurringOccurrence(req.Context(), userName, fields.SelectedTemplate, date, correlationId)
+          }
+        } else if d := req.PostFormValue("fd3-restore"); d != "" {
+          if date, err := time.Parse("2006-01-02", d); err == nil {
+            bank.DbRestoreRecurringOccurrence(req.Context(), userName, fields.SelectedTemplate, date, correlationId)
+          }
+        }
+      }
+      templates, err := bank.DbGetRecurringTemplates(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the templates."
+      }
+      var selected *bank.RecurringTemplate = nil
+      for idx := range templates {
+        if templates[idx].Id == fields.SelectedTemplate {
+          selected = &templates[idx]
+        }
+      }
+      if selected == nil && len(templates) > 0 {
+        selected = &templates[0]
+        fields.SelectedTemplate = selected.Id
+      }
+      var rows []OccurrenceRow
+      if selected != nil && selected.Next_due != nil {
+        skips, _ := bank.DbGetRecurringSkips(req.Context(), selected.Id, correlationId)
+        /***
+        Skipped dates are listed (and can be restored) but they do not count towards the number of occurrences shown.
+        ***/
+        rec := selected.Recurrence()
+        for _, d := range rec.Occurrences(*selected.Next_due, previewOccurrences + len(skips), nil) {
+          key := d.Format("2006-01-02")
+          rows = append(rows, OccurrenceRow{ Date: key, Skipped: skips[key] })
+          if len(rows) - len(skips) >= previewOccurrences {
+            break
+          }
+        }
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/recurring/recurring.html",
+        "webfinances/templates/banking/recurring/upcoming.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Templates []bank.RecurringTemplate
+          SelectedTemplate string
+          Fd3Result []OccurrenceRow
+          ErrMsg string
+        } { "standard", "Recurring Transactions", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton,
+            newSession.CsrfToken, templates, fields.SelectedTemplate, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui4") {
+      fields.CurrentButton = "lhs-button4"
+      var errMsg string
+      if id := req.PostFormValue("fd4-dismiss"); req.Method == http.MethodPost && id != "" {
+        if err := bank.DbDismissReminder(req.Context(), userName, id, correlationId); err != nil {
+          errMsg = "The reminder was NOT dismissed."
+        }
+      }
+      reminders, err := bank.DbGetReminders(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the reminders."
+      }
+      rows := make([]ReminderRow, 0, len(reminders))
+      for _, rm := range reminders {
+        rows = append(rows, ReminderRow{
+          Id: rm.Id,
+          DueDate: rm.Due_date.Format("2006-01-02"),
+          Payee: rm.Payee,
+          Account: rm.Acct_name,
+          Amount: fmt.Sprintf("%.2f (%s)", rm.Amount, rm.Tr_type),
+        })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/recurring/recurring.html",
+        "webfinances/templates/banking/recurring/reminders.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Fd4Result []ReminderRow
+          ErrMsg string
+        } { "standard", "Recurring Transactions", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton,
+            newSession.CsrfToken, rows, errMsg },
+      })
+    } else {
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
+    }
+    //
+    if req.Context().Err() == context.DeadlineExceeded {
+      logger.LogWarning("*** Request timeout ***", correlationId)
+    }
+    //
+    statestore.Save(req.Context(), stateNamespace, userName, "recurring", fields, correlationId)
+  } else {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}
@@ -0,0 +1,320 @@
+package wfbanking
+
+import (
+  bank "finance/databases/banking"  //Importing a package and assigning it a local alias.
+  "context"
+  "crypto/rand"
+  "encoding/hex"
+  "errors"
+  "finance/renderer"
+  "finance/statestore"
+  "fmt"
+  "github.com/juan-carlos-trimino/gplogger"
+  "github.com/juan-carlos-trimino/go-middlewares"
+  "github.com/juan-carlos-trimino/gpsessions"
+  "net/http"
+  "strconv"
+  "strings"
+  "time"
+)
+
+type transfersFields struct {
+  CurrentButton string `json:"currentButton"`
+  CurrentPage string  `json:"currentPage"`
+  SelectedTransfer string `json:"selectedTransfer"`
+}
+
+func newTransfersFields() *transfersFields {
+  //Default values used if the saved state is missing, empty, or corrupt.
+  m := transfersFields{
+    CurrentButton: "lhs-button1",
+    CurrentPage: "rhs-ui1",
+    SelectedTransfer: "",
+  }
+  return &m
+}
+
+//Lock the page of the user and load its state; the returned function unlocks it.
+func getTransfersFields(ctx context.Context, userName, correlationId string) (*transfersFields, func()) {
+  m := newTransfersFields()
+  return m, statestore.Open(ctx, stateNamespace, userName, "transfers", m, correlationId)
+}
+
+/***
+Every rendering of the transfer form carries a new token; submitting the same form twice (e.g., a double click or the browser
+resending the POST) sends the same token, and the second transfer is ignored.
+***/
+func newClientToken() string {
+  b := make([]byte, 16)
+  if _, err := rand.Read(b); err != nil {
+    panic("Cannot generate a client token: " + err.Error())
+  }
+  return hex.EncodeToString(b)
+}
+
+type WfBankingTransfersPages struct {}
+
+type TransferRow struct {  //Rows for the transfers.
+  Id string
+  Date string
+  From string
+  To string
+  Sent string
+  Received string
+  Description string
+}
+
+//Parse the amounts of a transfer; an empty amount received means the same as the amount sent.
+func parseTransferAmounts(sent, received string) (float64, float64, error) {
+  amountSent, err := strconv.ParseFloat(sent, 64)
+  if err != nil || amountSent <= 0 {
+    return 0, 0, fmt.Errorf("Error: invalid amount sent %s.", sent)
+  }
+  if strings.TrimSpace(received) == "" {
+    return amountSent, amountSent, nil
+  }
+  amountReceived, err := strconv.ParseFloat(received, 64)
+  if err != nil || amountReceived <= 0 {
+    return 0, 0, fmt.Errorf("Error: invalid amount received %s.", received)
+  }
+  return amountSent, amountReceived, nil
+}
+
+func (t WfBankingTransfersPages) TransfersPages(res http.ResponseWriter, req *http.Request) error {
+  ctxKey := middlewares.MwContextKey{}
+  correlationId, _ := ctxKey.GetCorrelationId(req.Context())
+  startTime, _ := ctxKey.GetStartTime(req.Context())
+  logger.LogInfo(fmt.Sprintf("Created correlationId at %s.", startTime.UTC().Format(time.RFC3339Nano)), correlationId)
+  logger.LogInfo("Entering wfbanking.TransfersPages.", correlationId)
+  sessionToken, _ := ctxKey.GetSessionToken(req.Context())
+  if sessionToken == "" {
+    invalidSession(res, correlationId)
+    return nil
+  }
+  //
+  if req.Method == http.MethodPost || req.Method == http.MethodGet {
+    userName := sessions.GetUserName(sessionToken)
+    fields, unlock := getTransfersFields(req.Context(), userName, correlationId)
+    defer unlock()
+    if ui := req.FormValue("tablestyle"); ui != "" {  //Values from form and URL.
+      fields.CurrentPage = ui
+    }
+    //
+    if strings.EqualFold(fields.CurrentPage, "rhs-ui1") {
+      fields.CurrentButton = "lhs-button1"
+      pd := struct {
+        LayoutType string
+        Header string
+        Datetime string
+        MenuPage string
+        CurrentButton string
+        CsrfToken string
+        Accounts []bank.Account
+        Fd1Token string
+        Fd1From string
+        Fd1To string
+        Fd1Date string
+        Fd1Sent string
+        Fd1Received string
+        Fd1Description string
+        ErrMsg string
+      } { "standard", "Transfers", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton, "", nil,
+          newClientToken(), "", "", time.Now().Format("2006-01-02"), "", "", "", "" }
+      if req.Method == http.MethodPost {
+        pd.Fd1Token = req.PostFormValue("fd1-token")
+        pd.Fd1From = req.PostFormValue("fd1-from")
+        pd.Fd1To = req.PostFormValue("fd1-to")
+        pd.Fd1Date = req.PostFormValue("fd1-date")
+        pd.Fd1Sent = req.PostFormValue("fd1-sent")
+        pd.Fd1Received = req.PostFormValue("fd1-received")
+        pd.Fd1Description = strings.TrimSpace(req.PostFormValue("fd1-description"))
+        tr := bank.Transfer{
+          From_acct_id: pd.Fd1From,
+          To_acct_id: pd.Fd1To,
+          Tr_description: pd.Fd1Description,
+        }
+        var err error
+        if pd.Fd1From == pd.Fd1To {
+          pd.ErrMsg = "Error: the accounts must be different."
+        } else if tr.Transfer_date, err = time.Parse("2006-01-02", pd.Fd1Date); err != nil {
+          pd.ErrMsg = fmt.Sprintf("Error: invalid date %s.", pd.Fd1Date)
+        } else if tr.Amount_sent, tr.Amount_received, err = parseTransferAmounts(pd.Fd1Sent, pd.Fd1Received);
+                  err != nil {
+          pd.ErrMsg = fmt.Sprintf("%v", err)
+        } else if err = bank.DbCreateTransfer(req.Context(), userName, pd.Fd1Token, &tr, correlationId); err != nil {
+          pd.ErrMsg = fmt.Sprintf("%v", err)
+          if errors.Is(err, bank.ErrDuplicateTransfer) {
+            pd.Fd1Token = newClientToken()
+          }
+        } else {
+          pd.ErrMsg = fmt.Sprintf("Transferred %.2f %s from %s to %s.", tr.Amount_sent, tr.From_currency,
+            tr.From_acct_name, tr.To_acct_name)
+          //The next submission is a new transfer.
+          pd.Fd1Token = newClientToken()
+          pd.Fd1Sent = ""
+          pd.Fd1Received = ""
+          pd.Fd1Description = ""
+        }
+        logger.LogInfo(pd.ErrMsg, correlationId)
+      }
+      accounts, err := bank.DbGetAccounts(req.Context(), userName, correlationId)
+      if err != nil {
+        pd.ErrMsg = "Unable to retrieve the accounts."
+      }
+      pd.Accounts = accounts
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/transfers/transfers.html",
+        "webfinances/templates/banking/transfers/newtransfer.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      pd.CsrfToken = newSession.CsrfToken
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{ Data: pd })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui2") {
+      fields.CurrentButton = "lhs-button2"
+      var errMsg string
+      if rowId := req.PostFormValue("selected_id"); req.Method == http.MethodPost && rowId != "" {
+        logger.LogInfo(fmt.Sprintf("Transfer ID = %s", rowId), correlationId)
+        if err := bank.DbDeleteTransfer(req.Context(), userName, rowId, correlationId); err != nil {
+          errMsg = fmt.Sprintf("The transfer was NOT deleted. %v", err)
+        }
+      }
+      transfers, err := bank.DbGetTransfers(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the transfers."
+      }
+      rows := make([]TransferRow, 0, len(transfers))
+      for _, tr := range transfers {
+        rows = append(rows, TransferRow{
+          Id: tr.Id,
+          Date: tr.Transfer_date.Format("2006-01-02"),
+          From: tr.From_acct_name,
+          To: tr.To_acct_name,
+          Sent: fmt.Sprintf("%.2f %s", tr.Amount_sent, tr.From_currency),
+          Received: fmt.Sprintf("%.2f %s", tr.Amount_received, tr.To_currency),
+          Description: tr.Tr_description,
+        })
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/transfers/transfers.html",
+        "webfinances/templates/banking/transfers/list.html",
+        "webfinances/templates/helpers/table-container.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Fd2Result []TransferRow
+          ErrMsg string
+        } { "standard", "Transfers", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton,
+            newSession.CsrfToken, rows, errMsg },
+      })
+    } else if strings.EqualFold(fields.CurrentPage, "rhs-ui3") {
+      fields.CurrentButton = "lhs-button3"
+      var errMsg string
+      if tid := req.FormValue("fd3-transfer"); tid != "" {
+        fields.SelectedTransfer = tid
+      }
+      if req.Method == http.MethodPost && req.PostFormValue("fd3-date") != "" {
+        tr := bank.Transfer{
+          Id: fields.SelectedTransfer,
+          Tr_description: strings.TrimSpace(req.PostFormValue("fd3-description")),
+        }
+        var err error
+        if tr.Transfer_date, err = time.Parse("2006-01-02", req.PostFormValue("fd3-date")); err != nil {
+          errMsg = fmt.Sprintf("Error: invalid date %s.", req.PostFormValue("fd3-date"))
+        } else if tr.Amount_sent, tr.Amount_received, err = parseTransferAmounts(req.PostFormValue("fd3-sent"),
+                  req.PostFormValue("fd3-received")); err != nil {
+          errMsg = fmt.Sprintf("%v", err)
+        } else if err = bank.DbUpdateTransfer(req.Context(), userName, &tr, correlationId); err != nil {
+          errMsg = fmt.Sprintf("%v", err)
+        } else {
+          errMsg = "The transfer and both of its entries were updated."
+        }
+        logger.LogInfo(errMsg, correlationId)
+      }
+      transfers, err := bank.DbGetTransfers(req.Context(), userName, correlationId)
+      if err != nil {
+        errMsg = "Unable to retrieve the transfers."
+      }
+      var selected *bank.Transfer = nil
+      for idx := range transfers {
+        if transfers[idx].Id == fields.SelectedTransfer {
+          selected = &transfers[idx]
+        }
+      }
+      if selected == nil && len(transfers) > 0 {
+        selected = &transfers[0]
+        fields.SelectedTransfer = selected.Id
+      }
+      var fd3Date, fd3Sent, fd3Received, fd3Description string
+      if selected != nil {
+        fd3Date = selected.Transfer_date.Format("2006-01-02")
+        fd3Sent = fmt.Sprintf("%.2f", selected.Amount_sent)
+        fd3Received = fmt.Sprintf("%.2f", selected.Amount_received)
+        fd3Description = selected.Tr_description
+      }
+      newSessionToken, newSession := sessions.UpdateEntryInSessions(sessionToken)
+      cookie := sessions.CreateCookie(newSessionToken)
+      http.SetCookie(res, cookie)
+      templatesNeeded := []string{
+        "webfinances/templates/layout.html",
+        "webfinances/templates/banking/transfers/transfers.html",
+        "webfinances/templates/banking/transfers/edit.html",
+        "webfinances/templates/title.html",
+        "webfinances/templates/datetime.html",
+        "webfinances/templates/navbar.html",
+        "webfinances/templates/footer.html",
+      }
+      renderer.Render(res, "layout", templatesNeeded, renderer.PageData{
+        Data: struct {
+          LayoutType string
+          Header string
+          Datetime string
+          MenuPage string
+          CurrentButton string
+          CsrfToken string
+          Transfers []bank.Transfer
+          SelectedTransfer string
+          Fd3Date string
+          Fd3Sent string
+          Fd3Received string
+          Fd3Description string
+          ErrMsg string
+        } { "standard", "Transfers", logger.DatetimeFormat(), bankingMenuPage, fields.CurrentButton,
+            newSession.CsrfToken, transfers, fields.SelectedTransfer, fd3Date, fd3Sent, fd3Received, fd3Description,
+            errMsg },
+      })
+    } else {
+      return renderer.PageError(http.StatusNotFound, "Unsupported page: %s", fields.CurrentPage)
+    }
+    //
+    if req.Context().Err() == context.DeadlineExceeded {
+      logger.LogWarning("*** Request timeout ***", correlationId)
+    }
+    //
+    statestore.Save(req.Context(), stateNamespace, userName, "transfers", fields, correlationId)
+  } else {
+    return renderer.PageError(http.StatusMethodNotAllowed, "Unsupported method: %s", req.Method)
+  }
+  logger.LogInfo(fmt.Sprintf("Request took %vms\n", time.Since(startTime).Microseconds()), correlationId)
+  return nil
+}